@@ -81,6 +81,12 @@ func UpdateUserRoleHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
+	var before models.Profile
+	if err := db.First(&before, "id = ?", userUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
 	// Update user role in profiles table
 	result := db.Model(&models.Profile{}).
 		Where("id = ?", userUUID).
@@ -95,6 +101,10 @@ func UpdateUserRoleHandler(c *gin.Context, db *gorm.DB) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
+
+	after := before
+	after.UserRole = req.Role
+	recordAudit(c, db, models.AuditActionUserRoleChanged, models.AuditEntityProfile, userUUID.String(), before, after)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"message": "role updated successfully",
@@ -124,14 +134,14 @@ func GetBookingsByPackageHandler(c *gin.Context, db *gorm.DB) {
 
 func GetCommissionsHandler(c *gin.Context, db *gorm.DB) {
 	type CommissionResult struct {
-		ID                   string    `gorm:"column:id"`
-		BookingID            string    `gorm:"column:booking_id"`
-		CommissionAmount     float64   `gorm:"column:commission_amount"`
-		CommissionPercentage float64   `gorm:"column:commission_percentage"`
-		Status               string    `gorm:"column:status"`
-		CreatedAt            time.Time `gorm:"column:created_at"`
-		AdvertiserID         string    `gorm:"column:advertiser_id"`
-		PackageID            string    `gorm:"column:package_id"`
+		ID                   string         `gorm:"column:id"`
+		BookingID            string         `gorm:"column:booking_id"`
+		CommissionAmount     models.Money   `gorm:"column:commission_amount"`
+		CommissionPercentage models.Decimal `gorm:"column:commission_percentage"`
+		Status               string         `gorm:"column:status"`
+		CreatedAt            time.Time      `gorm:"column:created_at"`
+		AdvertiserID         string         `gorm:"column:advertiser_id"`
+		PackageID            string         `gorm:"column:package_id"`
 	}
 
 	var commissions []CommissionResult
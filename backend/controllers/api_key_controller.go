@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyAuthMiddleware authenticates requests carrying X-API-Key as the
+// advertiser who owns the key, provided the key was granted scope. Requests
+// without the header fall through to AuthMiddleware, so the same routes
+// keep working for signed-in users.
+func APIKeyAuthMiddleware(scope string) gin.HandlerFunc {
+	userAuth := AuthMiddleware()
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			userAuth(c)
+			return
+		}
+
+		apiKeys := &services.APIKeyService{DB: c.MustGet("db").(*gorm.DB)}
+		apiKey, profile, err := apiKeys.Authenticate(key, c.ClientIP())
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidAPIKey) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify API key"})
+			}
+			c.Abort()
+			return
+		}
+		if !apiKey.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "API key is missing the " + scope + " scope",
+				"code":  "insufficient_scope",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", profile.ID.String())
+		c.Set("email", profile.Email)
+		c.Set("role", profile.UserRole)
+		c.Set("api_key_id", apiKey.ID.String())
+		c.Next()
+	}
+}
+
+func ListAPIKeysHandler(c *gin.Context, db *gorm.DB) {
+	advertiserUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	apiKeys := &services.APIKeyService{DB: db}
+	keys, err := apiKeys.List(advertiserUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, apiKeyResponse(key))
+	}
+	c.JSON(http.StatusOK, gin.H{"api_keys": result, "available_scopes": models.APIKeyScopes()})
+}
+
+func CreateAPIKeyHandler(c *gin.Context, db *gorm.DB) {
+	advertiserUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	apiKeys := &services.APIKeyService{DB: db}
+	apiKey, key, err := apiKeys.Create(advertiserUUID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionAPIKeyCreated, models.AuditEntityAPIKey, apiKey.ID.String(), nil, apiKeyResponse(*apiKey))
+
+	response := apiKeyResponse(*apiKey)
+	// The key is only ever shown here
+	response["key"] = key
+	c.JSON(http.StatusCreated, response)
+}
+
+func RevokeAPIKeyHandler(c *gin.Context, db *gorm.DB) {
+	advertiserUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	keyUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID format"})
+		return
+	}
+
+	apiKeys := &services.APIKeyService{DB: db}
+	apiKey, err := apiKeys.Revoke(advertiserUUID, keyUUID)
+	if err != nil {
+		respondAPIKeyError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionAPIKeyRevoked, models.AuditEntityAPIKey, apiKey.ID.String(),
+		gin.H{"revoked_at": nil}, gin.H{"revoked_at": apiKey.RevokedAt})
+
+	c.JSON(http.StatusOK, apiKeyResponse(*apiKey))
+}
+
+func apiKeyResponse(key models.APIKey) gin.H {
+	return gin.H{
+		"id":           key.ID,
+		"name":         key.Name,
+		"prefix":       key.Prefix,
+		"scopes":       key.ScopeList(),
+		"expires_at":   key.ExpiresAt,
+		"last_used_at": key.LastUsedAt,
+		"last_used_ip": key.LastUsedIP,
+		"revoked_at":   key.RevokedAt,
+		"created_at":   key.CreatedAt,
+	}
+}
+
+func respondAPIKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidAPIKeyScopes):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "valid_scopes": models.APIKeyScopes()})
+	case errors.Is(err, services.ErrAPIKeyExpiryInPast):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyAPIKeys):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to manage API key", "details": err.Error()})
+	}
+}
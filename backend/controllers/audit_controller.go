@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAuditLogsLimit = 50
+	maxAuditLogsLimit     = 100
+)
+
+// recordAudit writes an audit log entry for a privileged change made by the
+// caller. before and after are snapshots of the entity, either may be nil
+// for creates and deletes. The change has already happened, so a failure
+// to write the entry is logged rather than failing the request.
+func recordAudit(c *gin.Context, db *gorm.DB, action, entityType, entityID string, before, after interface{}) {
+	changedBefore, changedAfter, err := services.AuditDiff(before, after)
+	if err != nil {
+		log.Printf("audit: failed to diff %s %s: %v", entityType, entityID, err)
+		return
+	}
+
+	entry := &models.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     changedBefore,
+		After:      changedAfter,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	entry.ActorRole, entry.ActorID = requestActor(c)
+
+	audit := &services.AuditService{DB: db}
+	if err := audit.Record(entry); err != nil {
+		log.Printf("audit: failed to record %s on %s %s: %v", action, entityType, entityID, err)
+	}
+}
+
+// GetAuditLogsHandler lists audit log entries for managers.
+// Query: actor_id, action, entity_type, entity_id, from, to (YYYY-MM-DD),
+// page, limit.
+func GetAuditLogsHandler(c *gin.Context, db *gorm.DB) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultAuditLogsLimit)))
+	if err != nil || limit < 1 || limit > maxAuditLogsLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	filter := services.AuditLogFilter{
+		Action:     c.Query("action"),
+		EntityType: c.Query("entity_type"),
+		EntityID:   c.Query("entity_id"),
+		From:       c.Query("from"),
+		To:         c.Query("to"),
+		Limit:      limit,
+		Offset:     (page - 1) * limit,
+	}
+	if actorID := c.Query("actor_id"); actorID != "" {
+		actorUUID, err := uuid.Parse(actorID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor ID format"})
+			return
+		}
+		filter.ActorID = &actorUUID
+	}
+	for _, date := range []string{filter.From, filter.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+
+	audit := &services.AuditService{DB: db}
+	entries, total, err := audit.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit logs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"audit_logs":  entries,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
@@ -1,44 +1,69 @@
 package controllers
 
 import (
+	"errors"
+	"log"
 	"net/http"
-	"os"
 	"time"
 	"trip-trader-backend/models"
+	"trip-trader-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 // JWT Claims structure
 type JWTClaims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	// MFA is true when the login passed a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// Act names the manager behind an impersonation token (RFC 8693)
+	Act *ActorClaim `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Generate JWT token
-func generateToken(userID, email, role string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "default-secret-key-please-change-in-production"
+type ActorClaim struct {
+	Subject string `json:"sub"`
+}
+
+// Generate JWT token. The token carries the session family it belongs to,
+// so revoking the session also rejects its access tokens.
+func generateToken(profile models.Profile, session *models.Session) (string, error) {
+	keys, err := services.JWTKeys()
+	if err != nil {
+		return "", err
 	}
 
 	claims := JWTClaims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
+		UserID:    profile.ID.String(),
+		Email:     profile.Email,
+		Role:      profile.UserRole,
+		SessionID: session.FamilyID.String(),
+		MFA:       session.MFAVerified,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hours
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(services.AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtSecret))
+	return keys.Sign(claims)
+}
+
+// JWKSHandler publishes the public keys that verify our access tokens.
+func JWKSHandler(c *gin.Context) {
+	keys, err := services.JWTKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "signing keys unavailable"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys.JWKS()})
 }
 
 func SignupHandler(c *gin.Context) {
@@ -46,25 +71,12 @@ func SignupHandler(c *gin.Context) {
 		Email       string `json:"email" binding:"required"`
 		Password    string `json:"password" binding:"required"`
 		DisplayName string `json:"display_name"`
-		Role        string `json:"role"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
 		return
 	}
 
-	// Set default role
-	if req.Role == "" {
-		req.Role = models.RoleCustomer
-	}
-	if !models.IsValidRole(req.Role) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":       "invalid role",
-			"valid_roles": models.ValidRoles(),
-		})
-		return
-	}
-
 	db := c.MustGet("db").(*gorm.DB)
 
 	// Check if email already exists
@@ -86,7 +98,8 @@ func SignupHandler(c *gin.Context) {
 		Email:       req.Email,
 		Password:    string(hashedPassword),
 		DisplayName: req.DisplayName,
-		UserRole:    req.Role,
+		// Everyone signs up as a customer; managers grant other roles
+		UserRole: models.RoleCustomer,
 	}
 
 	if err := db.Create(&profile).Error; err != nil {
@@ -94,26 +107,34 @@ func SignupHandler(c *gin.Context) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := generateToken(profile.ID.String(), profile.Email, profile.UserRole)
+	// The account works right away; a failed email can be sent again later
+	accounts := &services.AccountService{DB: db, Mailer: services.DefaultMailer()}
+	if err := accounts.SendVerificationEmail(profile); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", profile.Email, err)
+	}
+
+	tokens, err := startSession(c, db, profile, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
 	// Return user data with token
-	c.JSON(http.StatusOK, gin.H{
-		"message":      "user created successfully",
-		"access_token": token,
-		"token":        token, // For backward compatibility
+	response := gin.H{
+		"message": "user created successfully",
 		"user": gin.H{
-			"id":           profile.ID,
-			"email":        profile.Email,
-			"display_name": profile.DisplayName,
-			"role":         profile.UserRole,
-			"created_at":   profile.CreatedAt,
+			"id":             profile.ID,
+			"email":          profile.Email,
+			"display_name":   profile.DisplayName,
+			"role":           profile.UserRole,
+			"email_verified": false,
+			"created_at":     profile.CreatedAt,
 		},
-	})
+	}
+	for key, value := range tokens {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 func LoginHandler(c *gin.Context) {
@@ -128,10 +149,17 @@ func LoginHandler(c *gin.Context) {
 
 	db := c.MustGet("db").(*gorm.DB)
 
+	// Locked or slowed down keys are refused before the password is checked
+	throttleKeys := loginThrottleKeys(c, req.Email)
+	if !checkLoginThrottle(c, db, throttleKeys) {
+		return
+	}
+
 	// Find user by email
 	var profile models.Profile
 	if err := db.Where("email = ?", req.Email).First(&profile).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
+			recordLoginFailure(db, throttleKeys, nil)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
 			return
 		}
@@ -141,41 +169,290 @@ func LoginHandler(c *gin.Context) {
 
 	// Verify password
 	if err := bcrypt.CompareHashAndPassword([]byte(profile.Password), []byte(req.Password)); err != nil {
+		recordLoginFailure(db, throttleKeys, &profile)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid email or password"})
 		return
 	}
+	resetLoginThrottle(db, throttleKeys[0])
 
-	// Generate JWT token
-	token, err := generateToken(profile.ID.String(), profile.Email, profile.UserRole)
+	// Accounts with 2FA get a short-lived token for POST /api/login/mfa
+	// instead of a session
+	mfa := &services.MFAService{DB: db}
+	mfaEnabled, err := mfa.Enabled(profile.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	if mfaEnabled {
+		mfaToken, err := generateMFAPendingToken(profile)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "two-factor authentication required",
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(mfaPendingTokenTTL.Seconds()),
+		})
+		return
+	}
+
+	respondLogin(c, db, profile, false)
+}
+
+// respondLogin starts a session and writes the login response.
+func respondLogin(c *gin.Context, db *gorm.DB, profile models.Profile, mfaVerified bool) {
+	tokens, err := startSession(c, db, profile, mfaVerified)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
 	// Return user data with token
-	c.JSON(http.StatusOK, gin.H{
-		"message":      "login successful",
-		"access_token": token,
-		"token":        token, // For backward compatibility
+	response := gin.H{
+		"message": "login successful",
 		"user": gin.H{
-			"id":           profile.ID,
-			"email":        profile.Email,
-			"display_name": profile.DisplayName,
-			"phone":        profile.Phone,
-			"address":      profile.Address,
-			"role":         profile.UserRole,
-			"display_id":   profile.DisplayID,
+			"id":             profile.ID,
+			"email":          profile.Email,
+			"display_name":   profile.DisplayName,
+			"phone":          profile.Phone,
+			"address":        profile.Address,
+			"role":           profile.UserRole,
+			"display_id":     profile.DisplayID,
+			"email_verified": profile.EmailVerifiedAt != nil,
+			// Managers must set up 2FA before they can use the API
+			"mfa_enrollment_required": profile.UserRole == models.RoleManager && !mfaVerified,
 		},
-	})
+	}
+	for key, value := range tokens {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
 }
 
+// RefreshTokenHandler trades a refresh token for a new access token and a
+// new refresh token. The old refresh token stops working.
+func RefreshTokenHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	sessions := &services.SessionService{DB: db}
+	session, refreshToken, err := sessions.Rotate(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh session"})
+		return
+	}
+
+	// Load the profile again so a changed role takes effect on refresh
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", session.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user no longer exists"})
+		return
+	}
+
+	token, err := generateToken(profile, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokenResponse(token, refreshToken))
+}
+
+// LogoutHandler revokes the session of the calling access token, which
+// also invalidates its refresh token.
 func LogoutHandler(c *gin.Context) {
-	// Since we're not using JWT tokens anymore, logout is client-side only
+	db := c.MustGet("db").(*gorm.DB)
+
+	sessionID, _ := c.Get("session_id")
+	familyID, err := uuid.Parse(toString(sessionID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	sessions := &services.SessionService{DB: db}
+	if err := sessions.Revoke(familyID, "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "logged out successfully",
 	})
 }
 
+// LogoutAllHandler revokes every session of the caller, on all devices.
+func LogoutAllHandler(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	sessions := &services.SessionService{DB: db}
+	if err := sessions.RevokeAllForUser(userUUID, "logout all devices"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "logged out of all devices",
+	})
+}
+
+// ForgotPasswordHandler mails a password reset link. It answers the same
+// whether or not the email has an account.
+func ForgotPasswordHandler(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	accounts := &services.AccountService{DB: db, Mailer: services.DefaultMailer()}
+	if err := accounts.RequestPasswordReset(req.Email); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "if the email has an account, a reset link has been sent",
+	})
+}
+
+// ResetPasswordHandler sets a new password from a reset link and signs the
+// user out everywhere.
+func ResetPasswordHandler(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=8"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	accounts := &services.AccountService{DB: db, Mailer: services.DefaultMailer()}
+	if err := accounts.ResetPassword(req.Token, req.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "password has been reset, please log in again",
+	})
+}
+
+func VerifyEmailHandler(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	accounts := &services.AccountService{DB: db, Mailer: services.DefaultMailer()}
+	profile, err := accounts.VerifyEmail(req.Token)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "email verified",
+		"email":             profile.Email,
+		"email_verified_at": profile.EmailVerifiedAt,
+	})
+}
+
+// ResendVerificationEmailHandler mails the caller a new verification link.
+func ResendVerificationEmailHandler(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", userUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if profile.EmailVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "email is already verified"})
+		return
+	}
+
+	accounts := &services.AccountService{DB: db, Mailer: services.DefaultMailer()}
+	if err := accounts.SendVerificationEmail(profile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "verification email sent",
+	})
+}
+
+// startSession opens a new session for a login and returns the token fields
+// of the login response.
+func startSession(c *gin.Context, db *gorm.DB, profile models.Profile, mfaVerified bool) (gin.H, error) {
+	sessions := &services.SessionService{DB: db}
+	session, refreshToken, err := sessions.Create(profile.ID, c.Request.UserAgent(), c.ClientIP(), mfaVerified)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := generateToken(profile, session)
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(token, refreshToken), nil
+}
+
+func tokenResponse(accessToken, refreshToken string) gin.H {
+	return gin.H{
+		"access_token":  accessToken,
+		"token":         accessToken, // For backward compatibility
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(services.AccessTokenTTL().Seconds()),
+	}
+}
+
 func GetCurrentUserRoleHandler(c *gin.Context, db *gorm.DB) {
 	c.JSON(http.StatusNotImplemented, gin.H{
 		"message": "This endpoint is deprecated. Use session storage from login response instead.",
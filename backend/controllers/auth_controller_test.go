@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignupIgnoresRequestedRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	services.SetMailer(&services.MemoryMailer{})
+	db, fake := fakesql.New(t)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("db", db) })
+	router.POST("/api/signup", SignupHandler)
+
+	for _, role := range []string{models.RoleManager, models.RoleAdvertiser} {
+		body := `{"email":"new-` + role + `@example.com","password":"correct horse","role":"` + role + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/signup", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("signup as %s: status = %d: %s", role, rec.Code, rec.Body)
+		}
+
+		var response struct {
+			User struct {
+				Role string `json:"role"`
+			} `json:"user"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		if response.User.Role != models.RoleCustomer {
+			t.Errorf("signup asking for %s got role %q, want %q", role, response.User.Role, models.RoleCustomer)
+		}
+	}
+	inserts := fake.Statements(`INSERT INTO "public"."profiles"`)
+	if len(inserts) != 2 {
+		t.Fatalf("profile inserts = %d, want 2", len(inserts))
+	}
+	for _, insert := range inserts {
+		if containsValue(insert.Args, models.RoleManager) || containsValue(insert.Args, models.RoleAdvertiser) {
+			t.Errorf("profile inserted with a requested role: %v", insert.Args)
+		}
+	}
+}
@@ -2,15 +2,29 @@ package controllers
 
 import (
 	"net/http"
-	"os"
 	"strings"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
-// AuthMiddleware validates JWT token
+// AuthMiddleware validates JWT token. Managers must have passed 2FA.
 func AuthMiddleware() gin.HandlerFunc {
+	return authMiddleware(true)
+}
+
+// MFAEnrollmentAuthMiddleware validates the token like AuthMiddleware but
+// also admits managers who have not set up 2FA yet, for the routes they
+// need to do so.
+func MFAEnrollmentAuthMiddleware() gin.HandlerFunc {
+	return authMiddleware(false)
+}
+
+func authMiddleware(requireManagerMFA bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -28,15 +42,15 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			jwtSecret = "default-secret-key-please-change-in-production"
+		keys, err := services.JWTKeys()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "signing keys unavailable"})
+			c.Abort()
+			return
 		}
 
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtSecret), nil
-		})
+		// Parse and validate token; the kid header picks the verification key
+		token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, keys.Keyfunc, jwt.WithValidMethods(keys.ValidMethods()))
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
@@ -45,16 +59,64 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Extract claims
-		if claims, ok := token.Claims.(*JWTClaims); ok {
-			c.Set("user_id", claims.UserID)
-			c.Set("email", claims.Email)
-			c.Set("role", claims.Role)
-		} else {
+		claims, ok := token.Claims.(*JWTClaims)
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token claims"})
 			c.Abort()
 			return
 		}
 
+		// Logged out sessions must not keep working until the token expires
+		familyID, err := uuid.Parse(claims.SessionID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
+			c.Abort()
+			return
+		}
+		sessions := &services.SessionService{DB: c.MustGet("db").(*gorm.DB)}
+		active, err := sessions.IsActive(familyID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify session"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session has been revoked"})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", claims.UserID)
+		c.Set("email", claims.Email)
+		c.Set("role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+		c.Set("mfa", claims.MFA)
+
+		// Impersonation is read-only and every request is logged
+		if claims.Act != nil {
+			c.Set("impersonator_id", claims.Act.Subject)
+			c.Header("X-Impersonated-By", claims.Act.Subject)
+			defer logImpersonatedRequest(c, claims)
+
+			if !isReadOnlyMethod(c.Request.Method) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "changes are not allowed while impersonating a user",
+					"code":  "impersonation_read_only",
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		if requireManagerMFA && claims.Role == models.RoleManager && !claims.MFA {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "managers must set up two-factor authentication",
+				"code":  "mfa_enrollment_required",
+			})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
@@ -80,4 +142,132 @@ func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
 		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
 		c.Abort()
 	}
-}
\ No newline at end of file
+}
+// currentUserID returns the authenticated user's ID set by AuthMiddleware.
+func currentUserID(c *gin.Context) (uuid.UUID, bool) {
+	userID, _ := c.Get("user_id")
+	userUUID, err := uuid.Parse(toString(userID))
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return userUUID, true
+}
+
+// requestActor identifies the authenticated caller for booking history.
+func requestActor(c *gin.Context) (string, *uuid.UUID) {
+	role, _ := c.Get("role")
+	actor := toString(role)
+	if actor == "" {
+		actor = models.ActorCustomer
+	}
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		return actor, nil
+	}
+	return actor, &userUUID
+}
+
+func toString(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func isManager(c *gin.Context) bool {
+	role, _ := c.Get("role")
+	return toString(role) == models.RoleManager
+}
+
+// ownsBooking reports whether the caller booked it, or is a manager.
+func ownsBooking(c *gin.Context, booking models.Booking) bool {
+	userUUID, ok := currentUserID(c)
+	return isManager(c) || (ok && booking.CustomerID == userUUID)
+}
+
+// SelfOrManagerMiddleware only lets users reach routes about themselves,
+// identified by the given path parameter. Managers may reach any user.
+func SelfOrManagerMiddleware(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isManager(c) {
+			c.Next()
+			return
+		}
+
+		userUUID, ok := currentUserID(c)
+		targetUUID, err := uuid.Parse(c.Param(param))
+		if !ok || err != nil || userUUID != targetUUID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// PackageOwnerMiddleware only lets advertisers reach routes for packages
+// they are assigned to, identified by the given path parameter. Managers
+// may reach any package.
+func PackageOwnerMiddleware(db *gorm.DB, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorizePackageAccess(c, db, c.Param(param)) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// authorizePackageAccess writes the error response and returns false when
+// the caller may not manage the package.
+func authorizePackageAccess(c *gin.Context, db *gorm.DB, packageID string) bool {
+	if isManager(c) {
+		return true
+	}
+
+	packageUUID, err := uuid.Parse(packageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID format"})
+		return false
+	}
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+		return false
+	}
+
+	owns, err := canManagePackage(db, userUUID, packageUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !owns {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only manage your own packages"})
+		return false
+	}
+	return true
+}
+
+// canManagePackage reports whether an advertiser owns a package or has been
+// assigned to it.
+func canManagePackage(db *gorm.DB, advertiserID, packageID uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.TravelPackage{}).
+		Where("id = ? AND (advertiser_id = ? OR EXISTS (SELECT 1 FROM package_advertisers pa WHERE pa.travel_package_id = travel_packages.id AND pa.advertiser_id = ?))",
+			packageID, advertiserID, advertiserID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// WebSocketTokenMiddleware lets browsers, which cannot set headers on a
+// WebSocket handshake, pass their token as ?token= instead.
+func WebSocketTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		c.Next()
+	}
+}
@@ -1,12 +1,16 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 	"trip-trader-backend/models"
+	"trip-trader-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -16,16 +20,103 @@ import (
 )
 
 type CreateBookingPaymentRequest struct {
-	PackageID        uuid.UUID  `json:"packageId" binding:"required"`
-	GuestCount       int        `json:"guestCount" binding:"required,min=1"`
-	TotalAmount      float64    `json:"totalAmount" binding:"required,min=0"`
-	FinalAmount      float64    `json:"finalAmount" binding:"required,min=0"`
-	DiscountCodeID   *uuid.UUID `json:"discount_code_id,omitempty"`
-	GlobalCodeID     *uuid.UUID `json:"global_code_id,omitempty"`
-	ContactName      string     `json:"contact_name" binding:"required"`
-	ContactPhone     string     `json:"contact_phone" binding:"required"`
-	ContactEmail     string     `json:"contact_email" binding:"required,email"`
-	SpecialRequests  *string    `json:"special_requests"`
+	PackageID        uuid.UUID    `json:"packageId" binding:"required"`
+	DepartureID      *uuid.UUID   `json:"departure_id,omitempty"`
+	GuestCount       int          `json:"guestCount" binding:"required,min=1"`
+	TotalAmount      models.Money `json:"totalAmount"`
+	FinalAmount      models.Money `json:"finalAmount"`
+	DiscountCodeID   *uuid.UUID   `json:"discount_code_id,omitempty"`
+	GlobalCodeID     *uuid.UUID   `json:"global_code_id,omitempty"`
+	Currency         string       `json:"currency,omitempty"`
+	ContactName      string       `json:"contact_name" binding:"required"`
+	ContactPhone     string       `json:"contact_phone" binding:"required"`
+	ContactEmail     string       `json:"contact_email" binding:"required,email"`
+	SpecialRequests  *string      `json:"special_requests"`
+}
+
+type BookingQuoteRequest struct {
+	PackageID      uuid.UUID  `json:"packageId" binding:"required"`
+	DepartureID    *uuid.UUID `json:"departure_id,omitempty"`
+	GuestCount     int        `json:"guestCount" binding:"required,min=1"`
+	DiscountCode   string     `json:"discount_code,omitempty"`
+	DiscountCodeID *uuid.UUID `json:"discount_code_id,omitempty"`
+	GlobalCodeID   *uuid.UUID `json:"global_code_id,omitempty"`
+	Currency       string     `json:"currency,omitempty"`
+}
+
+// Clients price in floating point, so their amounts may be a satang off
+// ours, never more
+const priceTolerance = 1
+
+func GetBookingQuoteHandler(c *gin.Context, db *gorm.DB) {
+	var req BookingQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	pricing := &services.PricingService{DB: db}
+	quote, err := pricing.Quote(services.QuoteRequest{
+		PackageID:      req.PackageID,
+		DepartureID:    req.DepartureID,
+		GuestCount:     req.GuestCount,
+		DiscountCode:   req.DiscountCode,
+		DiscountCodeID: req.DiscountCodeID,
+		GlobalCodeID:   req.GlobalCodeID,
+		Currency:       models.Currency(req.Currency),
+	})
+	if err != nil {
+		respondPricingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+func respondPricingError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPackageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+	case errors.Is(err, services.ErrPackageInactive),
+		errors.Is(err, services.ErrInvalidGuestCount),
+		errors.Is(err, services.ErrDiscountCodeNotFound),
+		errors.Is(err, services.ErrDiscountCodeInactive),
+		errors.Is(err, services.ErrDiscountCodeNotAllowed),
+		errors.Is(err, services.ErrMultipleDiscountCodes),
+		errors.Is(err, services.ErrDiscountCodeCustomerLimit),
+		errors.Is(err, services.ErrMinimumSpendNotMet),
+		errors.Is(err, services.ErrDepartureNotOpen),
+		errors.Is(err, services.ErrUnsupportedCurrency),
+		errors.Is(err, services.ErrExchangeRateNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDiscountCodeUsedUp):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDepartureNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price booking", "details": err.Error()})
+	}
+}
+
+func respondReservationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInsufficientCapacity):
+		c.JSON(http.StatusConflict, gin.H{"error": "Not enough seats left for this package"})
+	case errors.Is(err, services.ErrPackageNotFound), errors.Is(err, services.ErrDepartureNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDepartureNotOpen), errors.Is(err, services.ErrDepartureRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Taking a use of the discount code happens in the same transaction
+	case errors.Is(err, services.ErrDiscountCodeNotFound),
+		errors.Is(err, services.ErrDiscountCodeInactive),
+		errors.Is(err, services.ErrDiscountCodeCustomerLimit),
+		errors.Is(err, services.ErrMinimumSpendNotMet):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrDiscountCodeUsedUp):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking"})
+	}
 }
 
 func CreateBookingPaymentHandler(c *gin.Context, db *gorm.DB) {
@@ -56,23 +147,72 @@ func CreateBookingPaymentHandler(c *gin.Context, db *gorm.DB) {
 		}
 	}
 
+	accounts := &services.AccountService{DB: db}
+	if err := accounts.CheckCanBook(userUUID); err != nil {
+		if errors.Is(err, services.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "email_not_verified"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check account"})
+		return
+	}
+
 	var travelPackage models.TravelPackage
 	if err := db.First(&travelPackage, "id = ?", req.PackageID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
 		return
 	}
 
-	expiresAt := time.Now().Add(10 * time.Minute)
+	pricing := &services.PricingService{DB: db}
+	quote, err := pricing.Quote(services.QuoteRequest{
+		PackageID:      req.PackageID,
+		DepartureID:    req.DepartureID,
+		GuestCount:     req.GuestCount,
+		DiscountCodeID: req.DiscountCodeID,
+		GlobalCodeID:   req.GlobalCodeID,
+		CustomerID:     &userUUID,
+		Currency:       models.Currency(req.Currency),
+	})
+	if err != nil {
+		respondPricingError(c, err)
+		return
+	}
+
+	// The client's amounts are in the base currency, like the quote's
+	totalDifference, err := quote.TotalAmount.CheckedSub(req.TotalAmount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	finalDifference, err := quote.FinalAmount.CheckedSub(req.FinalAmount)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if totalDifference.Abs().Minor() > priceTolerance || finalDifference.Abs().Minor() > priceTolerance {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Booking amounts do not match the current price",
+			"quote": quote,
+		})
+		return
+	}
+
+	expiresAt := time.Now().Add(services.BookingTTL())
 	booking := models.Booking{
 		CustomerID:      userUUID,
 		PackageID:       req.PackageID,
+		DepartureID:     req.DepartureID,
 		GuestCount:      req.GuestCount,
 		BookingDate:     time.Now().Format("2006-01-02"),
-		TotalAmount:     req.TotalAmount,
-		DiscountAmount:  req.TotalAmount - req.FinalAmount,
-		FinalAmount:     req.FinalAmount,
-		DiscountCodeID:  req.DiscountCodeID,
-		GlobalCodeID:    req.GlobalCodeID,
+		TotalAmount:     quote.TotalAmount,
+		DiscountAmount:  quote.DiscountAmount,
+		FinalAmount:     quote.FinalAmount,
+		DiscountCodeID:  quote.DiscountCodeID,
+		GlobalCodeID:    quote.GlobalCodeID,
+		Currency:        quote.Currency,
+		ExchangeRate:    quote.ExchangeRate,
+		ExchangeRateID:  quote.ExchangeRateID,
+		ChargedAmount:   quote.ChargeAmount.Decimal(),
 		Status:          "pending",
 		PaymentStatus:   "pending",
 		ExpiresAt:       &expiresAt,
@@ -82,24 +222,30 @@ func CreateBookingPaymentHandler(c *gin.Context, db *gorm.DB) {
 		SpecialRequests: req.SpecialRequests,
 	}
 
-	fmt.Printf("🎫 Creating booking with discount info:\n")
-	fmt.Printf("   - Discount Code ID: %v\n", req.DiscountCodeID)
-	fmt.Printf("   - Global Code ID: %v\n", req.GlobalCodeID)
-	fmt.Printf("   - Discount Amount: %.2f\n", req.TotalAmount - req.FinalAmount)
-
-	if err := db.Create(&booking).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create booking"})
+	reservations := &services.ReservationService{DB: db}
+	err = reservations.Reserve(req.PackageID, req.DepartureID, req.GuestCount, func(tx *gorm.DB) error {
+		if err := tx.Create(&booking).Error; err != nil {
+			return err
+		}
+		redemptions := &services.RedemptionService{DB: tx}
+		return redemptions.Reserve(booking)
+	})
+	if err != nil {
+		respondReservationError(c, err)
 		return
 	}
 
-	fmt.Printf("Booking created successfully with ID: %s\n", booking.ID)
+	log.Printf("Booking %s created", booking.ID)
 
-	stripeKey := os.Getenv("STRIPE_SECRET_KEY")
-	if stripeKey == "" || stripeKey == "sk_test_51234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef12" {
+	stripeKey, ok := services.StripeSecretKey()
+	if !ok {
 		mockSessionID := "cs_test_mock_" + booking.ID.String()
 		
-		booking.StripePaymentIntentID = &mockSessionID
-		db.Save(&booking)
+		if err := db.Model(&booking).Update("stripe_payment_intent_id", mockSessionID).Error; err != nil {
+			abandonBooking(db, booking)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment session"})
+			return
+		}
 
 		mockSuccessURL := fmt.Sprintf("%s/payment/success?session_id=%s&booking_id=%s", 
 			os.Getenv("FRONTEND_URL"), mockSessionID, booking.ID.String())
@@ -121,12 +267,12 @@ func CreateBookingPaymentHandler(c *gin.Context, db *gorm.DB) {
 		LineItems: []*stripe.CheckoutSessionLineItemParams{
 			{
 				PriceData: &stripe.CheckoutSessionLineItemPriceDataParams{
-					Currency: stripe.String("thb"),
+					Currency: stripe.String(strings.ToLower(string(quote.Currency))),
 					ProductData: &stripe.CheckoutSessionLineItemPriceDataProductDataParams{
 						Name:        stripe.String(travelPackage.Title),
 						Description: stripe.String(fmt.Sprintf("ทริป %s สำหรับ %d ท่าน", travelPackage.Title, req.GuestCount)),
 					},
-					  UnitAmount: stripe.Int64(int64(req.FinalAmount * 100)),
+					  UnitAmount: stripe.Int64(quote.ChargeAmount.Minor()),
 				},
 				Quantity: stripe.Int64(1),
 			},
@@ -142,16 +288,29 @@ func CreateBookingPaymentHandler(c *gin.Context, db *gorm.DB) {
 			"guest_count": strconv.Itoa(req.GuestCount),
 		},
 	}
+	// Stripe only accepts expiries at least 30 minutes out, shorter holds
+	// are closed by the auto-cancel scheduler instead
+	if services.BookingTTL() >= 30*time.Minute {
+		params.ExpiresAt = stripe.Int64(expiresAt.Unix())
+	}
 
 	stripeSession, err := session.New(params)
 	if err != nil {
-		db.Delete(&booking)
+		abandonBooking(db, booking)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment session"})
 		return
 	}
 
-	booking.StripePaymentIntentID = &stripeSession.ID
-	db.Save(&booking)
+	if err := db.Model(&booking).Update("stripe_payment_intent_id", stripeSession.ID).Error; err != nil {
+		// Without its ID on the booking the session could never be matched
+		// to it, so nobody may pay it
+		if _, expireErr := session.Expire(stripeSession.ID, nil); expireErr != nil {
+			log.Printf("Failed to expire checkout session %s of booking %s: %v", stripeSession.ID, booking.ID, expireErr)
+		}
+		abandonBooking(db, booking)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment session"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"url":        stripeSession.URL,
@@ -160,6 +319,27 @@ func CreateBookingPaymentHandler(c *gin.Context, db *gorm.DB) {
 	})
 }
 
+// abandonBooking removes a booking that never got a checkout session, with
+// its seats and discount code use, in one transaction. Should that fail the
+// booking stays pending and the auto-cancel scheduler releases it once its
+// hold runs out.
+func abandonBooking(db *gorm.DB, booking models.Booking) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		redemptions := &services.RedemptionService{DB: tx}
+		if err := redemptions.Release(booking.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&booking).Error; err != nil {
+			return err
+		}
+		reservations := &services.ReservationService{DB: tx}
+		return reservations.ReleaseBooking(tx, booking)
+	})
+	if err != nil {
+		log.Printf("Failed to remove booking %s without a checkout session, leaving it to expire: %v", booking.ID, err)
+	}
+}
+
 func GetBookingsByPackageQueryHandler(c *gin.Context, db *gorm.DB) {
 	packageID := c.Query("package_id")
 	if packageID == "" {
@@ -168,7 +348,7 @@ func GetBookingsByPackageQueryHandler(c *gin.Context, db *gorm.DB) {
 	}
 
 	var bookings []models.Booking
-	result := db.Select("id, customer_id, package_id, booking_date, guest_count, status, payment_status, contact_name, contact_phone, contact_email, special_requests, total_amount, final_amount, expires_at, created_at, updated_at").
+	result := db.Select("id, customer_id, package_id, departure_id, booking_date, guest_count, status, payment_status, contact_name, contact_phone, contact_email, special_requests, total_amount, final_amount, expires_at, created_at, updated_at").
 		Where("package_id = ?", packageID).
 		Order("booking_date DESC").
 		Find(&bookings)
@@ -197,74 +377,68 @@ func ConfirmPaymentHandler(c *gin.Context, db *gorm.DB) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
 		return
 	}
-
-	var pkg models.TravelPackage
-	if err := db.First(&pkg, booking.PackageID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+	if !ownsBooking(c, booking) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only access your own bookings"})
 		return
 	}
 
-	result := db.Model(&models.Booking{}).
-		Where("id = ?", bookingID).
-		Updates(map[string]interface{}{
-			"payment_status": "paid",
-			"status":         "confirmed",
-			"expires_at":     nil,
+	if booking.PaymentStatus == "paid" {
+		// The Stripe webhook usually gets here first
+		c.JSON(http.StatusOK, gin.H{
+			"message":        "Payment already confirmed",
+			"booking_id":     bookingID,
+			"status":         booking.Status,
+			"payment_status": booking.PaymentStatus,
 		})
+		return
+	}
 
-	if result.Error != nil {
+	var paymentIntentID *string
+	if stripeKey, ok := services.StripeSecretKey(); ok {
+		// Never trust the client: ask Stripe whether the checkout session was paid
+		if booking.StripePaymentIntentID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Booking has no payment session"})
+			return
+		}
+		stripe.Key = stripeKey
+		stripeSession, err := session.Get(*booking.StripePaymentIntentID, nil)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify payment with Stripe"})
+			return
+		}
+		if stripeSession.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":          "Payment has not been completed",
+				"payment_status": stripeSession.PaymentStatus,
+			})
+			return
+		}
+		if mismatch := checkoutChargeMismatch(booking, stripeSession); mismatch != "" {
+			// The webhook cancels the booking and refunds the payment
+			c.JSON(http.StatusConflict, gin.H{"error": "Payment does not match the booking's price"})
+			return
+		}
+		if stripeSession.PaymentIntent != nil {
+			paymentIntentID = &stripeSession.PaymentIntent.ID
+		}
+	}
+
+	actor, actorID := requestActor(c)
+	confirmed, err := markBookingPaid(db, booking.ID, paymentIntentID, actor, actorID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to update booking status",
-			"details": result.Error.Error(),
+			"details": err.Error(),
 		})
 		return
 	}
 
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+	if !confirmed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Booking is no longer awaiting payment"})
 		return
 	}
 
-	go SendPaymentSuccessNotification(booking, db)
-
-	go SendNewBookingNotificationToAdvertiser(booking, pkg, db)
-
-	// Commission calculation: commissionAmount = finalAmount * (commissionRate / 100)
-	// Only send commission notification to advertiser, not admin
-	if booking.DiscountCodeID != nil {
-		var discountCode models.DiscountCode
-		if err := db.Preload("Package").First(&discountCode, *booking.DiscountCodeID).Error; err == nil {
-			var advertiserProfile models.Profile
-			if err := db.First(&advertiserProfile, "id = ?", discountCode.AdvertiserID).Error; err == nil {
-				if advertiserProfile.UserRole == "advertiser" {
-					// Calculate usage percentage
-					var currentUses int64
-					var maxGuests int64 = 1
-					db.Model(&models.Booking{}).
-						Where("discount_code_id = ? AND payment_status IN (?)", discountCode.ID, []string{"paid", "confirmed", "completed"}).
-						Count(&currentUses)
-					if discountCode.MaxUses != nil && *discountCode.MaxUses > 0 {
-						maxGuests = int64(*discountCode.MaxUses)
-					} else if discountCode.Package.MaxGuests > 0 {
-						maxGuests = int64(discountCode.Package.MaxGuests)
-					}
-					usagePercentage := float64(currentUses) / float64(maxGuests) * 100
-					commissionRate := 0.0
-					if usagePercentage > 50 && usagePercentage < 75 {
-						commissionRate = 3.0
-					} else if usagePercentage >= 75 && usagePercentage < 100 {
-						commissionRate = 5.0
-					} else if usagePercentage >= 100 {
-						commissionRate = 10.0
-					}
-					if commissionRate > 0 {
-						go CreateCommission(booking.ID, discountCode.AdvertiserID, *booking.DiscountCodeID, 
-										   booking.FinalAmount, commissionRate, db)
-					}
-				}
-			}
-		}
-	}
+	onBookingConfirmed(booking, db)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Payment confirmed successfully",
@@ -272,4 +446,68 @@ func ConfirmPaymentHandler(c *gin.Context, db *gorm.DB) {
 		"status": "confirmed",
 		"payment_status": "paid",
 	})
-}
\ No newline at end of file
+}
+
+// markBookingPaid moves a pending booking to paid/confirmed. It reports false
+// when the booking was not pending anymore, so callers can stay idempotent.
+func markBookingPaid(db *gorm.DB, bookingID uuid.UUID, paymentIntentID *string, actor string, actorID *uuid.UUID) (bool, error) {
+	updates := map[string]interface{}{"expires_at": nil}
+	if paymentIntentID != nil {
+		updates["stripe_payment_intent_id"] = *paymentIntentID
+	}
+
+	bookingStates := &services.BookingStateService{DB: db}
+	_, event, err := bookingStates.Transition(bookingID, services.BookingTransition{
+		Status:        models.BookingStatusConfirmed,
+		PaymentStatus: models.PaymentStatusPaid,
+		Updates:       updates,
+		Actor:         actor,
+		ActorID:       actorID,
+		Reason:        "Payment received",
+	})
+	if errors.Is(err, services.ErrIllegalTransition) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return event != nil, nil
+}
+
+// bookingConfirmed is onBookingConfirmed, replaced in tests.
+var bookingConfirmed = onBookingConfirmed
+
+// onBookingConfirmed sends the payment notifications and records the
+// advertiser commission for a booking that has just been paid.
+func onBookingConfirmed(booking models.Booking, db *gorm.DB) {
+	var pkg models.TravelPackage
+	if err := db.First(&pkg, "id = ?", booking.PackageID).Error; err != nil {
+		log.Printf("Package %s not found for confirmed booking %s", booking.PackageID, booking.ID)
+		return
+	}
+
+	go SendPaymentSuccessNotification(booking, db)
+
+	go SendNewBookingNotificationToAdvertiser(booking, pkg, db)
+
+	// The commission rules decide what the advertiser earns, if anything
+	commissions := &services.CommissionService{DB: db}
+	if _, err := commissions.RecordForBooking(booking); err != nil {
+		log.Printf("Failed to record commission for booking %s: %v", booking.ID, err)
+	}
+}
+
+func GetBookingEventsHandler(c *gin.Context, db *gorm.DB) {
+	booking, _, ok := findOwnedBooking(c, db)
+	if !ok {
+		return
+	}
+
+	bookingStates := &services.BookingStateService{DB: db}
+	events, err := bookingStates.History(booking.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
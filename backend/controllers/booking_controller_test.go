@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCreateBookingPaymentRemovesBookingWithoutSession(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "")
+	gin.SetMode(gin.TestMode)
+	db, fake := fakesql.New(t)
+	customerID, packageID := uuid.New(), uuid.New()
+	fake.On(`FROM "public"."profiles"`).Return(
+		[]string{"id", "email_verified_at"},
+		[]driver.Value{customerID.String(), time.Now()},
+	)
+	fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "title", "price", "max_guests", "is_active"},
+		[]driver.Value{packageID.String(), "Krabi kayaking", "1000.00", int64(20), true},
+	)
+	fake.On(`COALESCE\(SUM`).Return([]string{"sum"}, []driver.Value{"0"})
+	fake.On(`UPDATE "bookings" SET "stripe_payment_intent_id"`).Fail(errors.New("connection reset"))
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", customerID.String()) })
+	router.POST("/api/bookings/payment", func(c *gin.Context) { CreateBookingPaymentHandler(c, db) })
+
+	body := `{"packageId":"` + packageID.String() + `","guestCount":2,"totalAmount":2000,"finalAmount":2000,` +
+		`"contact_name":"Somchai","contact_phone":"0812345678","contact_email":"somchai@example.com"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bookings/payment", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusInternalServerError, rec.Body)
+	}
+	if got := fake.Statements(`DELETE FROM "bookings"`); len(got) != 1 {
+		t.Errorf("booking deletes = %v, want the booking removed", got)
+	}
+	if got := fake.Statements(`UPDATE "travel_packages"`); len(got) != 2 {
+		t.Errorf("package updates = %v, want its seats held and given back", got)
+	}
+}
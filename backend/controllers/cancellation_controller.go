@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CancelBookingRequest struct {
+	Reason string `json:"reason"`
+}
+
+type RefundPolicyRequest struct {
+	Rules []struct {
+		MinDaysBeforeDeparture int            `json:"min_days_before_departure"`
+		RefundPercentage       models.Decimal `json:"refund_percentage"`
+	} `json:"rules"`
+}
+
+func CancelBookingHandler(c *gin.Context, db *gorm.DB) {
+	booking, _, ok := findOwnedBooking(c, db)
+	if !ok {
+		return
+	}
+
+	var req CancelBookingRequest
+	// The body is optional, a bare POST cancels without a reason
+	_ = c.ShouldBindJSON(&req)
+
+	cancellations := &services.CancellationService{DB: db}
+	actor, actorID := requestActor(c)
+	result, err := cancellations.Cancel(booking.ID, actor, actorID, req.Reason)
+	if err != nil {
+		respondCancellationError(c, err)
+		return
+	}
+
+	SendBookingCancelledNotifications(result.Booking, result.Refund, db)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Booking cancelled",
+		"booking": result.Booking,
+		"quote":   result.Quote,
+		"refund":  result.Refund,
+	})
+}
+
+func GetCancellationPreviewHandler(c *gin.Context, db *gorm.DB) {
+	booking, _, ok := findOwnedBooking(c, db)
+	if !ok {
+		return
+	}
+
+	cancellations := &services.CancellationService{DB: db}
+	quote, err := cancellations.Preview(booking.ID)
+	if err != nil {
+		respondCancellationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, quote)
+}
+
+func GetPackageRefundPolicyHandler(c *gin.Context, db *gorm.DB) {
+	packageUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID format"})
+		return
+	}
+
+	cancellations := &services.CancellationService{DB: db}
+	rules, err := cancellations.RefundPolicy(packageUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"package_id": packageUUID, "rules": rules})
+}
+
+func UpdatePackageRefundPolicyHandler(c *gin.Context, db *gorm.DB) {
+	packageUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID format"})
+		return
+	}
+
+	var req RefundPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	var pkg models.TravelPackage
+	if err := db.First(&pkg, "id = ?", packageUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
+
+	rules := make([]models.RefundPolicyRule, 0, len(req.Rules))
+	for _, rule := range req.Rules {
+		rules = append(rules, models.RefundPolicyRule{
+			MinDaysBeforeDeparture: rule.MinDaysBeforeDeparture,
+			RefundPercentage:       rule.RefundPercentage,
+		})
+	}
+
+	cancellations := &services.CancellationService{DB: db}
+	if _, err := cancellations.ReplaceRefundPolicy(packageUUID, rules); err != nil {
+		if errors.Is(err, services.ErrInvalidRefundPolicy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	GetPackageRefundPolicyHandler(c, db)
+}
+
+// findOwnedBooking loads the booking in the URL and checks the caller
+// owns it. Managers may act on any booking.
+func findOwnedBooking(c *gin.Context, db *gorm.DB) (*models.Booking, uuid.UUID, bool) {
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, uuid.Nil, false
+	}
+
+	bookingUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID format"})
+		return nil, uuid.Nil, false
+	}
+
+	var booking models.Booking
+	if err := db.First(&booking, "id = ?", bookingUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+		return nil, uuid.Nil, false
+	}
+
+	if !ownsBooking(c, booking) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only access your own bookings"})
+		return nil, uuid.Nil, false
+	}
+	return &booking, userUUID, true
+}
+
+func respondCancellationError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrBookingNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBookingNotCancellable),
+		errors.Is(err, services.ErrTripAlreadyDeparted),
+		errors.Is(err, services.ErrIllegalTransition):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel booking", "details": err.Error()})
+	}
+}
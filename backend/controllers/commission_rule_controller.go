@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CommissionRuleRequest struct {
+	Name         string                 `json:"name" binding:"required"`
+	AdvertiserID *uuid.UUID             `json:"advertiser_id"`
+	PackageID    *uuid.UUID             `json:"package_id"`
+	Type         string                 `json:"type" binding:"required"`
+	Rate         models.Decimal         `json:"rate"`
+	FixedAmount  models.Money           `json:"fixed_amount"`
+	Tiers        models.CommissionTiers `json:"tiers"`
+	IsActive     *bool                  `json:"is_active"`
+}
+
+func (req CommissionRuleRequest) applyTo(rule *models.CommissionRule) {
+	rule.Name = req.Name
+	rule.AdvertiserID = req.AdvertiserID
+	rule.PackageID = req.PackageID
+	rule.Type = req.Type
+	rule.Rate = req.Rate
+	rule.FixedAmount = req.FixedAmount
+	rule.Tiers = req.Tiers
+	rule.IsActive = req.IsActive == nil || *req.IsActive
+}
+
+func GetCommissionRulesHandler(c *gin.Context, db *gorm.DB) {
+	commissions := &services.CommissionService{DB: db}
+	rules, err := commissions.ListRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch commission rules"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, commissionRuleResponse(rule))
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": result, "types": models.CommissionRuleTypes()})
+}
+
+func CreateCommissionRuleHandler(c *gin.Context, db *gorm.DB) {
+	var req CommissionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	rule := &models.CommissionRule{}
+	req.applyTo(rule)
+	_, rule.CreatedBy = requestActor(c)
+
+	commissions := &services.CommissionService{DB: db}
+	if err := commissions.SaveRule(rule); err != nil {
+		respondCommissionRuleError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionCommissionRuleCreated, models.AuditEntityCommissionRule, rule.ID.String(), nil, rule)
+	c.JSON(http.StatusCreated, commissionRuleResponse(*rule))
+}
+
+func UpdateCommissionRuleHandler(c *gin.Context, db *gorm.DB) {
+	ruleUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid commission rule ID format"})
+		return
+	}
+
+	var req CommissionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	commissions := &services.CommissionService{DB: db}
+	rule, err := commissions.GetRule(ruleUUID)
+	if err != nil {
+		respondCommissionRuleError(c, err)
+		return
+	}
+	before := *rule
+	req.applyTo(rule)
+	if err := commissions.SaveRule(rule); err != nil {
+		respondCommissionRuleError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionCommissionRuleUpdated, models.AuditEntityCommissionRule, rule.ID.String(), before, rule)
+	c.JSON(http.StatusOK, commissionRuleResponse(*rule))
+}
+
+func DeleteCommissionRuleHandler(c *gin.Context, db *gorm.DB) {
+	ruleUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid commission rule ID format"})
+		return
+	}
+
+	commissions := &services.CommissionService{DB: db}
+	rule, err := commissions.DeleteRule(ruleUUID)
+	if err != nil {
+		respondCommissionRuleError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionCommissionRuleDeleted, models.AuditEntityCommissionRule, rule.ID.String(), rule, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Commission rule deleted"})
+}
+
+// PreviewCommissionHandler shows what the advertiser in :advertiser_id
+// would earn on their next booking of a package.
+// Query: package_id, amount (defaults to the package price).
+func PreviewCommissionHandler(c *gin.Context, db *gorm.DB) {
+	advertiserUUID, err := uuid.Parse(c.Param("advertiser_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertiser ID format"})
+		return
+	}
+	packageUUID, err := uuid.Parse(c.Query("package_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "package_id is required"})
+		return
+	}
+
+	var pkg models.TravelPackage
+	if err := db.First(&pkg, "id = ?", packageUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
+
+	amount := pkg.Price
+	if raw := c.Query("amount"); raw != "" {
+		amount, err = models.ParseMoney(raw, pkg.Price.Currency())
+		if err != nil || amount.IsNegative() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be a positive number"})
+			return
+		}
+	}
+
+	commissions := &services.CommissionService{DB: db}
+	quote, err := commissions.Evaluate(services.CommissionInput{
+		AdvertiserID:  advertiserUUID,
+		PackageID:     packageUUID,
+		BookingAmount: amount,
+		Prospective:   true,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview commission"})
+		return
+	}
+	c.JSON(http.StatusOK, quote)
+}
+
+func commissionRuleResponse(rule models.CommissionRule) gin.H {
+	return gin.H{
+		"id":            rule.ID,
+		"name":          rule.Name,
+		"scope":         rule.Scope(),
+		"advertiser_id": rule.AdvertiserID,
+		"package_id":    rule.PackageID,
+		"type":          rule.Type,
+		"rate":          rule.Rate,
+		"fixed_amount":  rule.FixedAmount,
+		"tiers":         rule.Tiers,
+		"is_active":     rule.IsActive,
+		"created_by":    rule.CreatedBy,
+		"created_at":    rule.CreatedAt,
+		"updated_at":    rule.UpdatedAt,
+	}
+}
+
+func respondCommissionRuleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrCommissionRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCommissionRule):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCommissionRuleConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save commission rule", "details": err.Error()})
+	}
+}
@@ -0,0 +1,246 @@
+package controllers
+
+import (
+	"errors"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var errInvalidDepartureRequest = errors.New("invalid departure request")
+
+type DepartureRequest struct {
+	DepartureDate *string       `json:"departure_date"`
+	Capacity      *int          `json:"capacity"`
+	PriceOverride *models.Money `json:"price_override"`
+	Status        *string       `json:"status"`
+	ClearPrice    bool          `json:"clear_price_override"`
+}
+
+func GetPackageDeparturesHandler(c *gin.Context, db *gorm.DB) {
+	packageUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid package ID format"})
+		return
+	}
+
+	query := db.Where("package_id = ?", packageUUID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if from := c.Query("from"); from != "" {
+		query = query.Where("departure_date >= ?", from)
+	}
+	if to := c.Query("to"); to != "" {
+		query = query.Where("departure_date <= ?", to)
+	}
+
+	var departures []models.PackageDeparture
+	if err := query.Order("departure_date ASC").Find(&departures).Error; err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(departures))
+	for _, departure := range departures {
+		result = append(result, departureResponse(departure))
+	}
+	c.JSON(200, result)
+}
+
+func GetPackageDepartureHandler(c *gin.Context, db *gorm.DB) {
+	departure, ok := findPackageDeparture(c, db)
+	if !ok {
+		return
+	}
+	c.JSON(200, departureResponse(*departure))
+}
+
+func CreatePackageDepartureHandler(c *gin.Context, db *gorm.DB) {
+	packageUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid package ID format"})
+		return
+	}
+
+	var req DepartureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	if req.DepartureDate == nil || req.Capacity == nil {
+		c.JSON(400, gin.H{"error": "departure_date and capacity are required"})
+		return
+	}
+
+	var pkg models.TravelPackage
+	if err := db.First(&pkg, "id = ?", packageUUID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Package not found"})
+		return
+	}
+
+	departure := models.PackageDeparture{
+		PackageID: packageUUID,
+		Status:    models.DepartureStatusOpen,
+	}
+	if msg := applyDepartureRequest(&departure, req); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	var existing int64
+	db.Model(&models.PackageDeparture{}).
+		Where("package_id = ? AND departure_date = ?", packageUUID, departure.DepartureDate).
+		Count(&existing)
+	if existing > 0 {
+		c.JSON(409, gin.H{"error": "This package already departs on that date"})
+		return
+	}
+
+	if err := db.Create(&departure).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create departure", "details": err.Error()})
+		return
+	}
+
+	c.JSON(201, departureResponse(departure))
+}
+
+func UpdatePackageDepartureHandler(c *gin.Context, db *gorm.DB) {
+	departure, ok := findPackageDeparture(c, db)
+	if !ok {
+		return
+	}
+
+	var req DepartureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	var invalid string
+	reservations := &services.ReservationService{DB: db}
+	updated, held, err := reservations.UpdateDeparture(departure.PackageID, departure.ID, func(locked *models.PackageDeparture) error {
+		if invalid = applyDepartureRequest(locked, req); invalid != "" {
+			return errInvalidDepartureRequest
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errInvalidDepartureRequest):
+		c.JSON(400, gin.H{"error": invalid})
+		return
+	case errors.Is(err, services.ErrDepartureNotFound):
+		c.JSON(404, gin.H{"error": "Departure not found"})
+		return
+	case errors.Is(err, services.ErrDepartureHasBookings):
+		c.JSON(409, gin.H{"error": "Cannot move a departure that already has bookings"})
+		return
+	case errors.Is(err, services.ErrCapacityBelowHeld):
+		c.JSON(409, gin.H{
+			"error":        "Capacity cannot be lower than the seats already booked",
+			"booked_seats": held,
+		})
+		return
+	case err != nil:
+		c.JSON(500, gin.H{"error": "Failed to update departure", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, departureResponse(*updated))
+}
+
+func DeletePackageDepartureHandler(c *gin.Context, db *gorm.DB) {
+	departure, ok := findPackageDeparture(c, db)
+	if !ok {
+		return
+	}
+
+	var bookings int64
+	db.Model(&models.Booking{}).Where("departure_id = ?", departure.ID).Count(&bookings)
+	if bookings > 0 {
+		c.JSON(409, gin.H{"error": "Departure has bookings, set its status to cancelled instead"})
+		return
+	}
+
+	if err := db.Delete(departure).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete departure"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Departure deleted"})
+}
+
+func findPackageDeparture(c *gin.Context, db *gorm.DB) (*models.PackageDeparture, bool) {
+	packageUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid package ID format"})
+		return nil, false
+	}
+	departureUUID, err := uuid.Parse(c.Param("departureId"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid departure ID format"})
+		return nil, false
+	}
+
+	var departure models.PackageDeparture
+	if err := db.First(&departure, "id = ? AND package_id = ?", departureUUID, packageUUID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Departure not found"})
+		return nil, false
+	}
+	return &departure, true
+}
+
+// applyDepartureRequest copies the supplied fields onto departure and
+// returns a validation message, or "" when everything is acceptable.
+func applyDepartureRequest(departure *models.PackageDeparture, req DepartureRequest) string {
+	if req.DepartureDate != nil {
+		date, err := time.Parse("2006-01-02", *req.DepartureDate)
+		if err != nil {
+			return "departure_date must be in YYYY-MM-DD format"
+		}
+		today := time.Now().Truncate(24 * time.Hour)
+		if date.Before(today) {
+			return "departure_date cannot be in the past"
+		}
+		departure.DepartureDate = date.Format("2006-01-02")
+	}
+	if req.Capacity != nil {
+		if *req.Capacity < 0 {
+			return "capacity cannot be negative"
+		}
+		departure.Capacity = *req.Capacity
+	}
+	if req.PriceOverride != nil {
+		if req.PriceOverride.IsNegative() {
+			return "price_override cannot be negative"
+		}
+		departure.PriceOverride = req.PriceOverride
+	} else if req.ClearPrice {
+		departure.PriceOverride = nil
+	}
+	if req.Status != nil {
+		if !models.IsValidDepartureStatus(*req.Status) {
+			return "status must be one of open, closed, cancelled"
+		}
+		departure.Status = *req.Status
+	}
+	return ""
+}
+
+func departureResponse(departure models.PackageDeparture) gin.H {
+	return gin.H{
+		"id":              departure.ID,
+		"package_id":      departure.PackageID,
+		"departure_date":  departure.DepartureDate,
+		"capacity":        departure.Capacity,
+		"booked_seats":    departure.BookedSeats,
+		"available_seats": departure.AvailableSeats(),
+		"price_override":  departure.PriceOverride,
+		"status":          departure.Status,
+		"created_at":      departure.CreatedAt,
+		"updated_at":      departure.UpdatedAt,
+	}
+}
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trip-trader-backend/internal/fakesql"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestGetPackageDeparturesWithoutDeparturesIsEmptyList(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, _ := fakesql.New(t)
+	router := gin.New()
+	router.GET("/api/packages/:id/departures", func(c *gin.Context) { GetPackageDeparturesHandler(c, db) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/packages/"+uuid.NewString()+"/departures", nil))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("status = %d, body = %s, want 200 and []", rec.Code, rec.Body)
+	}
+}
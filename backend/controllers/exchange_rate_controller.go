@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultExchangeRateHistoryLimit = 50
+	maxExchangeRateHistoryLimit     = 500
+	maxExchangeRateImportSize       = 1 << 20
+)
+
+type ExchangeRateRequest struct {
+	Currency    string      `json:"currency" binding:"required"`
+	Rate        models.Rate `json:"rate"`
+	EffectiveAt *time.Time  `json:"effective_at"`
+}
+
+type AddExchangeRatesRequest struct {
+	Rates []ExchangeRateRequest `json:"rates" binding:"required,min=1,dive"`
+}
+
+// GetExchangeRatesHandler lists the rates customers are charged at now and
+// the currencies they can pay in.
+func GetExchangeRatesHandler(c *gin.Context, db *gorm.DB) {
+	exchangeRates := &services.ExchangeRateService{DB: db}
+	rates, err := exchangeRates.CurrentRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"base_currency":        models.DefaultCurrency,
+		"supported_currencies": models.SupportedCurrencies(),
+		"rates":                rates,
+	})
+}
+
+// GetExchangeRateHistoryHandler lists the rates added for one currency.
+// Query: currency (required), limit.
+func GetExchangeRateHistoryHandler(c *gin.Context, db *gorm.DB) {
+	currency, err := models.ParseCurrency(c.Query("currency"))
+	if err != nil || c.Query("currency") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a supported currency code"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultExchangeRateHistoryLimit)))
+	if err != nil || limit < 1 || limit > maxExchangeRateHistoryLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	exchangeRates := &services.ExchangeRateService{DB: db}
+	rates, err := exchangeRates.History(currency, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"currency": currency, "rates": rates})
+}
+
+// AddExchangeRatesHandler enters rates by hand.
+// Body: {"rates": [{"currency": "USD", "rate": 0.0275, "effective_at": "2026-10-01T00:00:00Z"}]}
+func AddExchangeRatesHandler(c *gin.Context, db *gorm.DB) {
+	var req AddExchangeRatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	inputs := make([]services.ExchangeRateInput, 0, len(req.Rates))
+	for _, rate := range req.Rates {
+		input := services.ExchangeRateInput{Currency: rate.Currency, Rate: rate.Rate}
+		if rate.EffectiveAt != nil {
+			input.EffectiveAt = *rate.EffectiveAt
+		}
+		inputs = append(inputs, input)
+	}
+
+	_, createdBy := requestActor(c)
+	exchangeRates := &services.ExchangeRateService{DB: db}
+	rates, err := exchangeRates.AddRates(inputs, models.ExchangeRateSourceManual, createdBy)
+	if err != nil {
+		respondExchangeRateError(c, err)
+		return
+	}
+
+	auditExchangeRates(c, db, rates)
+	c.JSON(http.StatusCreated, gin.H{"rates": rates})
+}
+
+// ImportExchangeRatesHandler adds rates from a CSV file of
+// currency,rate[,effective_at], sent as the multipart field "file" or as
+// the request body.
+func ImportExchangeRatesHandler(c *gin.Context, db *gorm.DB) {
+	var file io.Reader
+	if upload, err := c.FormFile("file"); err == nil {
+		if upload.Size > maxExchangeRateImportSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "CSV file is too large"})
+			return
+		}
+		opened, err := upload.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read CSV file"})
+			return
+		}
+		defer opened.Close()
+		file = opened
+	} else {
+		file = http.MaxBytesReader(c.Writer, c.Request.Body, maxExchangeRateImportSize)
+	}
+
+	_, createdBy := requestActor(c)
+	exchangeRates := &services.ExchangeRateService{DB: db}
+	rates, err := exchangeRates.ImportCSV(file, createdBy)
+	if err != nil {
+		respondExchangeRateError(c, err)
+		return
+	}
+
+	auditExchangeRates(c, db, rates)
+	c.JSON(http.StatusCreated, gin.H{"imported": len(rates), "rates": rates})
+}
+
+func auditExchangeRates(c *gin.Context, db *gorm.DB, rates []models.ExchangeRate) {
+	for _, rate := range rates {
+		recordAudit(c, db, models.AuditActionExchangeRateAdded, models.AuditEntityExchangeRate, rate.ID.String(), nil, rate)
+	}
+}
+
+func respondExchangeRateError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidExchangeRate), errors.Is(err, services.ErrUnsupportedCurrency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exchange rates", "details": err.Error()})
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ImpersonateUserHandler gives a manager a short-lived, read-only token
+// that sees the API as the user in :userId. The token names the manager in
+// its act claim and shares the manager's session, so logging the manager
+// out ends the impersonation too.
+func ImpersonateUserHandler(c *gin.Context, db *gorm.DB) {
+	managerUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+	targetUUID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	impersonation := &services.ImpersonationService{DB: db}
+	target, err := impersonation.Target(managerUUID, targetUUID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrImpersonationTargetNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrCannotImpersonate):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load user"})
+		}
+		return
+	}
+
+	sessionID, _ := c.Get("session_id")
+	keys, err := services.JWTKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+	expiresAt := time.Now().Add(services.ImpersonationTTL())
+	token, err := keys.Sign(JWTClaims{
+		UserID:    target.ID.String(),
+		Email:     target.Email,
+		Role:      target.UserRole,
+		SessionID: toString(sessionID),
+		Act:       &ActorClaim{Subject: managerUUID.String()},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	if err := impersonation.Log(&models.ImpersonationLog{
+		ManagerID:    managerUUID,
+		TargetUserID: target.ID,
+		Method:       c.Request.Method,
+		Path:         c.Request.URL.Path,
+		StatusCode:   http.StatusOK,
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record impersonation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "impersonation started, this token is read-only",
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   int(services.ImpersonationTTL().Seconds()),
+		"expires_at":   expiresAt,
+		"impersonating": gin.H{
+			"id":           target.ID,
+			"email":        target.Email,
+			"display_name": target.DisplayName,
+			"role":         target.UserRole,
+		},
+		"impersonator_id": managerUUID,
+	})
+}
+
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// logImpersonatedRequest writes the audit entry once the request is done,
+// so the status code is known.
+func logImpersonatedRequest(c *gin.Context, claims *JWTClaims) {
+	managerUUID, err := uuid.Parse(claims.Act.Subject)
+	if err != nil {
+		return
+	}
+	targetUUID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return
+	}
+
+	impersonation := &services.ImpersonationService{DB: c.MustGet("db").(*gorm.DB)}
+	if err := impersonation.Log(&models.ImpersonationLog{
+		ManagerID:    managerUUID,
+		TargetUserID: targetUUID,
+		Method:       c.Request.Method,
+		Path:         c.Request.URL.Path,
+		StatusCode:   c.Writer.Status(),
+		IPAddress:    c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to log impersonated request %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+}
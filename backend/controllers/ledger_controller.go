@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLedgerEntriesLimit = 50
+	maxLedgerEntriesLimit     = 100
+)
+
+// GetTrialBalanceHandler totals every ledger account.
+// Query: as_of (YYYY-MM-DD, inclusive, defaults to now).
+func GetTrialBalanceHandler(c *gin.Context, db *gorm.DB) {
+	asOf := time.Now()
+	if raw := c.Query("as_of"); raw != "" {
+		date, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "as_of must be in YYYY-MM-DD format"})
+			return
+		}
+		asOf = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	ledger := &services.LedgerService{DB: db}
+	trial, err := ledger.TrialBalance(asOf)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build trial balance", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, trial)
+}
+
+// GetIncomeStatementHandler reports revenue and commission expense.
+// Query: from, to (YYYY-MM-DD, inclusive, default to the current month).
+func GetIncomeStatementHandler(c *gin.Context, db *gorm.DB) {
+	from, to, ok := ledgerPeriod(c)
+	if !ok {
+		return
+	}
+	if from == nil {
+		now := time.Now()
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+		from = &start
+	}
+	if to == nil {
+		end := from.AddDate(0, 1, 0)
+		to = &end
+	}
+
+	ledger := &services.LedgerService{DB: db}
+	statement, err := ledger.IncomeStatement(*from, *to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build income statement", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
+
+// GetLedgerEntriesHandler lists journal entries with their lines.
+// Query: event_type, account, reference_id, booking_id, from, to, page, limit.
+func GetLedgerEntriesHandler(c *gin.Context, db *gorm.DB) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultLedgerEntriesLimit)))
+	if err != nil || limit < 1 || limit > maxLedgerEntriesLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	from, to, ok := ledgerPeriod(c)
+	if !ok {
+		return
+	}
+
+	filter := services.LedgerEntryFilter{
+		EventType: c.Query("event_type"),
+		Account:   c.Query("account"),
+		From:      from,
+		To:        to,
+		Limit:     limit,
+		Offset:    (page - 1) * limit,
+	}
+	for param, target := range map[string]**uuid.UUID{"reference_id": &filter.ReferenceID, "booking_id": &filter.BookingID} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param + " format"})
+			return
+		}
+		*target = &id
+	}
+
+	ledger := &services.LedgerService{DB: db}
+	entries, total, err := ledger.ListEntries(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ledger entries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries":     entries,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// ledgerPeriod reads the optional from and to dates of a ledger report.
+// to is inclusive, so it is returned as the start of the following day.
+func ledgerPeriod(c *gin.Context) (*time.Time, *time.Time, bool) {
+	var bounds [2]*time.Time
+	for i, param := range []string{"from", "to"} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		date, err := time.ParseInLocation("2006-01-02", raw, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be in YYYY-MM-DD format"})
+			return nil, nil, false
+		}
+		if param == "to" {
+			date = date.AddDate(0, 0, 1)
+		}
+		bounds[i] = &date
+	}
+	if bounds[0] != nil && bounds[1] != nil && !bounds[0].Before(*bounds[1]) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return nil, nil, false
+	}
+	return bounds[0], bounds[1], true
+}
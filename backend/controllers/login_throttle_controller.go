@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// throttleKey is one counter a sign-in attempt is charged to.
+type throttleKey struct {
+	Scope string
+	Key   string
+}
+
+func loginThrottleKeys(c *gin.Context, email string) []throttleKey {
+	return []throttleKey{
+		{Scope: models.ThrottleScopeEmail, Key: strings.ToLower(strings.TrimSpace(email))},
+		{Scope: models.ThrottleScopeIP, Key: c.ClientIP()},
+	}
+}
+
+// checkLoginThrottle answers 429 and returns false when any of the keys has
+// to wait before trying again.
+func checkLoginThrottle(c *gin.Context, db *gorm.DB, keys []throttleKey) bool {
+	throttles := &services.LoginThrottleService{DB: db}
+	for _, key := range keys {
+		err := throttles.Check(key.Scope, key.Key)
+		if err == nil {
+			continue
+		}
+
+		var throttleErr *services.ThrottleError
+		if !errors.As(err, &throttleErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return false
+		}
+
+		retryAfter := int(math.Ceil(throttleErr.RetryAfter.Seconds()))
+		code := "too_many_attempts"
+		if throttleErr.Locked {
+			code = "account_locked"
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       throttleErr.Error(),
+			"code":        code,
+			"retry_after": retryAfter,
+		})
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure charges a failed attempt to every key. When the
+// account's own counter locks, its owner is notified.
+func recordLoginFailure(db *gorm.DB, keys []throttleKey, profile *models.Profile) {
+	throttles := &services.LoginThrottleService{DB: db}
+	for _, key := range keys {
+		lockedUntil, err := throttles.RecordFailure(key.Scope, key.Key)
+		if err != nil {
+			log.Printf("Failed to record failed sign-in for %s: %v", key.Scope, err)
+			continue
+		}
+		if lockedUntil != nil && profile != nil && key.Scope != models.ThrottleScopeIP {
+			SendAccountLockedNotification(profile.ID, *lockedUntil, db)
+		}
+	}
+}
+
+func resetLoginThrottle(db *gorm.DB, key throttleKey) {
+	throttles := &services.LoginThrottleService{DB: db}
+	if err := throttles.Reset(key.Scope, key.Key); err != nil {
+		log.Printf("Failed to reset sign-in throttle for %s: %v", key.Scope, err)
+	}
+}
+
+// UnlockUserHandler lets a manager lift a lockout from an account, for both
+// password and 2FA attempts.
+func UnlockUserHandler(c *gin.Context, db *gorm.DB) {
+	targetUUID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", targetUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	throttles := &services.LoginThrottleService{DB: db}
+	if err := throttles.Reset(models.ThrottleScopeEmail, strings.ToLower(strings.TrimSpace(profile.Email))); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+	if err := throttles.Reset(models.ThrottleScopeMFA, profile.ID.String()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionUserUnlocked, models.AuditEntityProfile, profile.ID.String(),
+		nil, gin.H{"throttles_reset": []string{models.ThrottleScopeEmail, models.ThrottleScopeMFA}})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "account unlocked",
+		"user_id": profile.ID,
+	})
+}
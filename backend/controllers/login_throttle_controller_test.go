@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestUnlockUserRecordsAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fake := fakesql.New(t)
+	userID, managerID := uuid.New(), uuid.New()
+	fake.On(`FROM "public"."profiles"`).Return([]string{"id", "email"}, []driver.Value{userID.String(), "locked-out@example.com"})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", managerID.String()); c.Set("role", models.RoleManager) })
+	router.DELETE("/api/user/:userId/lockout", func(c *gin.Context) { UnlockUserHandler(c, db) })
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/user/"+userID.String()+"/lockout", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	entries := fake.Statements(`INSERT INTO "audit_logs"`)
+	if len(entries) != 1 || !containsValue(entries[0].Args, models.AuditActionUserUnlocked) ||
+		!containsValue(entries[0].Args, userID.String()) || !containsValue(entries[0].Args, managerID.String()) {
+		t.Errorf("audit entries = %v, want one %s of %s by %s", entries, models.AuditActionUserUnlocked, userID, managerID)
+	}
+}
@@ -17,12 +17,12 @@ type ManagerController struct {
 
 type DashboardStats struct {
 	TotalUsers        int64   `json:"totalUsers"`
-	TotalAdvertisers  int64   `json:"totalAdvertisers"`
-	TotalPackages     int64   `json:"totalPackages"`
-	ActivePackages    int64   `json:"activePackages"`
-	TotalBookings     int64   `json:"totalBookings"`
-	ThisMonthBookings int64   `json:"thisMonthBookings"`
-	TotalRevenue      float64 `json:"totalRevenue"`
+	TotalAdvertisers  int64        `json:"totalAdvertisers"`
+	TotalPackages     int64        `json:"totalPackages"`
+	ActivePackages    int64        `json:"activePackages"`
+	TotalBookings     int64        `json:"totalBookings"`
+	ThisMonthBookings int64        `json:"thisMonthBookings"`
+	TotalRevenue      models.Money `json:"totalRevenue"`
 }
 
 type PerformanceAnalysisResponse struct {
@@ -33,21 +33,21 @@ type PerformanceAnalysisResponse struct {
 }
 
 type RecentBooking struct {
-	ID           string  `json:"id"`
-	PackageTitle string  `json:"package_title"`
-	UserName     string  `json:"user_name"`
-	BookingDate  string  `json:"booking_date"`
-	TotalPrice   float64 `json:"total_price"`
-	Status       string  `json:"status"`
+	ID           string       `json:"id"`
+	PackageTitle string       `json:"package_title"`
+	UserName     string       `json:"user_name"`
+	BookingDate  string       `json:"booking_date"`
+	TotalPrice   models.Money `json:"total_price"`
+	Status       string       `json:"status"`
 }
 
 type RecentPackage struct {
-	ID             string  `json:"id"`
-	Title          string  `json:"title"`
-	Location       string  `json:"location"`
-	Price          float64 `json:"price"`
-	CreatedAt      string  `json:"created_at"`
-	AdvertiserName string  `json:"advertiser_name"`
+	ID             string       `json:"id"`
+	Title          string       `json:"title"`
+	Location       string       `json:"location"`
+	Price          models.Money `json:"price"`
+	CreatedAt      string       `json:"created_at"`
+	AdvertiserName string       `json:"advertiser_name"`
 }
 
 func (mc *ManagerController) GetDashboardStats(c *gin.Context) {
@@ -71,15 +71,14 @@ func (mc *ManagerController) GetDashboardStats(c *gin.Context) {
 		Where("created_at >= ?", firstOfMonth).
 		Count(&stats.ThisMonthBookings)
 
-	type RevenueResult struct {
-		Total float64 `gorm:"column:total"`
+	// Net of discounts and refunds, straight from the ledger
+	ledger := &services.LedgerService{DB: mc.DB}
+	revenue, err := ledger.TotalNetRevenue()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate revenue", "details": err.Error()})
+		return
 	}
-	var revenue RevenueResult
-	mc.DB.Model(&models.Booking{}).
-		Select("COALESCE(SUM(final_amount), 0) as total").
-		Where("status = ?", "confirmed").
-		Scan(&revenue)
-	stats.TotalRevenue = revenue.Total
+	stats.TotalRevenue = revenue
 
 	c.JSON(http.StatusOK, stats)
 }
@@ -88,12 +87,12 @@ func (mc *ManagerController) GetRecentBookings(c *gin.Context) {
 	var bookings []RecentBooking
 
 	type BookingResult struct {
-		ID           string  `gorm:"column:id"`
-		PackageTitle string  `gorm:"column:package_title"`
-		UserName     string  `gorm:"column:user_name"`
-		BookingDate  string  `gorm:"column:booking_date"`
-		TotalPrice   float64 `gorm:"column:total_price"`
-		Status       string  `gorm:"column:status"`
+		ID           string       `gorm:"column:id"`
+		PackageTitle string       `gorm:"column:package_title"`
+		UserName     string       `gorm:"column:user_name"`
+		BookingDate  string       `gorm:"column:booking_date"`
+		TotalPrice   models.Money `gorm:"column:total_price"`
+		Status       string       `gorm:"column:status"`
 	}
 
 	var results []BookingResult
@@ -210,11 +209,11 @@ func (mc *ManagerController) GetUserStatistics(c *gin.Context) {
 
 func (mc *ManagerController) GetPackageStatistics(c *gin.Context) {
 	type PackageStats struct {
-		TotalPackages    int64   `json:"total_packages"`
-		ActivePackages   int64   `json:"active_packages"`
-		InactivePackages int64   `json:"inactive_packages"`
-		AvgPrice         float64 `json:"avg_price"`
-		TopLocation      string  `json:"top_location"`
+		TotalPackages    int64        `json:"total_packages"`
+		ActivePackages   int64        `json:"active_packages"`
+		InactivePackages int64        `json:"inactive_packages"`
+		AvgPrice         models.Money `json:"avg_price"`
+		TopLocation      string       `json:"top_location"`
 	}
 
 	var stats PackageStats
@@ -224,7 +223,7 @@ func (mc *ManagerController) GetPackageStatistics(c *gin.Context) {
 	mc.DB.Model(&models.TravelPackage{}).Where("is_active = ?", false).Count(&stats.InactivePackages)
 
 	type AvgPriceResult struct {
-		Average float64 `gorm:"column:average"`
+		Average models.Money `gorm:"column:average"`
 	}
 	var avgPrice AvgPriceResult
 	mc.DB.Model(&models.TravelPackage{}).
@@ -251,9 +250,9 @@ func (mc *ManagerController) GetPackageStatistics(c *gin.Context) {
 
 func (mc *ManagerController) GetMonthlyBookingStats(c *gin.Context) {
 	type MonthlyStats struct {
-		Month    string  `json:"month"`
-		Bookings int64   `json:"bookings"`
-		Revenue  float64 `json:"revenue"`
+		Month    string       `json:"month"`
+		Bookings int64        `json:"bookings"`
+		Revenue  models.Money `json:"revenue"`
 	}
 
 	var monthlyStats []MonthlyStats
@@ -263,15 +262,13 @@ func (mc *ManagerController) GetMonthlyBookingStats(c *gin.Context) {
 	type MonthlyResult struct {
 		Month    string  `gorm:"column:month"`
 		Bookings int64   `gorm:"column:bookings"`
-		Revenue  float64 `gorm:"column:revenue"`
 	}
 
 	var results []MonthlyResult
 	
 	err := mc.DB.Model(&models.Booking{}).
 		Select(`TO_CHAR(created_at, 'YYYY-MM') as month,
-				COUNT(*) as bookings,
-				COALESCE(SUM(final_amount), 0) as revenue`).
+				COUNT(*) as bookings`).
 		Where("created_at >= ? AND status = ?", twelveMonthsAgo, "confirmed").
 		Group("TO_CHAR(created_at, 'YYYY-MM')").
 		Order("month DESC").
@@ -286,11 +283,21 @@ func (mc *ManagerController) GetMonthlyBookingStats(c *gin.Context) {
 		return
 	}
 
+	ledger := &services.LedgerService{DB: mc.DB}
+	revenue, err := ledger.NetRevenueByMonth(twelveMonthsAgo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to fetch monthly booking statistics",
+			"details": err.Error(),
+		})
+		return
+	}
+
 	for _, result := range results {
 		monthlyStats = append(monthlyStats, MonthlyStats{
 			Month:    result.Month,
 			Bookings: result.Bookings,
-			Revenue:  result.Revenue,
+			Revenue:  revenue[result.Month],
 		})
 	}
 
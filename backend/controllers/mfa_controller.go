@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaPendingTokenTTL = 5 * time.Minute
+	mfaPendingPurpose  = "mfa_pending"
+)
+
+// MFAPendingClaims identify a user who passed the password check and still
+// owes a second factor. They carry no session, so AuthMiddleware rejects
+// them.
+type MFAPendingClaims struct {
+	UserID  string `json:"user_id"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func generateMFAPendingToken(profile models.Profile) (string, error) {
+	keys, err := services.JWTKeys()
+	if err != nil {
+		return "", err
+	}
+	return keys.Sign(MFAPendingClaims{
+		UserID:  profile.ID.String(),
+		Purpose: mfaPendingPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaPendingTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+}
+
+func parseMFAPendingToken(tokenString string) (uuid.UUID, error) {
+	keys, err := services.JWTKeys()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	claims := &MFAPendingClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc, jwt.WithValidMethods(keys.ValidMethods()))
+	if err != nil || !token.Valid || claims.Purpose != mfaPendingPurpose {
+		return uuid.Nil, errors.New("invalid or expired mfa token")
+	}
+	return uuid.Parse(claims.UserID)
+}
+
+// LoginMFAHandler finishes a login with a TOTP or recovery code.
+func LoginMFAHandler(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	userUUID, err := parseMFAPendingToken(req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", userUUID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user no longer exists"})
+		return
+	}
+
+	// Six digits are easy to guess, so codes are throttled per user as well
+	throttleKeys := []throttleKey{
+		{Scope: models.ThrottleScopeMFA, Key: userUUID.String()},
+		{Scope: models.ThrottleScopeIP, Key: c.ClientIP()},
+	}
+	if !checkLoginThrottle(c, db, throttleKeys) {
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	if err := mfa.Verify(userUUID, req.Code); err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			recordLoginFailure(db, throttleKeys, &profile)
+		}
+		respondMFAError(c, err)
+		return
+	}
+	resetLoginThrottle(db, throttleKeys[0])
+
+	respondLogin(c, db, profile, true)
+}
+
+func GetMFAStatusHandler(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	enabled, err := mfa.Enabled(userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load 2FA status"})
+		return
+	}
+
+	mfaClaim, _ := c.Get("mfa")
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":     enabled,
+		"required":    isManager(c),
+		"session_mfa": mfaClaim == true,
+	})
+}
+
+// BeginMFAEnrollmentHandler creates a TOTP secret for the caller. The
+// otpauth URI is meant to be shown as a QR code.
+func BeginMFAEnrollmentHandler(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", userUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	secret, uri, err := mfa.BeginEnrollment(profile)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// ConfirmMFAEnrollmentHandler enables 2FA with a first code from the
+// authenticator. The current session counts as verified from then on, so
+// a new access token is returned along with the recovery codes.
+func ConfirmMFAEnrollmentHandler(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+	sessionID, _ := c.Get("session_id")
+	familyID, err := uuid.Parse(toString(sessionID))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	recoveryCodes, err := mfa.ConfirmEnrollment(userUUID, req.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	sessions := &services.SessionService{DB: db}
+	if err := sessions.MarkMFAVerified(familyID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update session"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", userUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	token, err := generateToken(profile, &models.Session{FamilyID: familyID, MFAVerified: true})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "two-factor authentication enabled",
+		"recovery_codes": recoveryCodes,
+		"access_token":   token,
+		"token":          token,
+		"token_type":     "Bearer",
+		"expires_in":     int(services.AccessTokenTTL().Seconds()),
+	})
+}
+
+// DisableMFAHandler turns off 2FA after checking a current code. Managers
+// cannot turn it off.
+func DisableMFAHandler(c *gin.Context) {
+	if isManager(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "two-factor authentication is mandatory for managers"})
+		return
+	}
+
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	if err := mfa.Verify(userUUID, req.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	if err := mfa.Reset(userUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable 2FA"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "two-factor authentication disabled",
+	})
+}
+
+// RegenerateRecoveryCodesHandler replaces the caller's recovery codes after
+// checking a current code.
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	db := c.MustGet("db").(*gorm.DB)
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	if err := mfa.Verify(userUUID, req.Code); err != nil {
+		respondMFAError(c, err)
+		return
+	}
+	recoveryCodes, err := mfa.RegenerateRecoveryCodes(userUUID)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// ResetUserMFAHandler lets a manager remove another user's 2FA, e.g. after
+// they lost both their phone and recovery codes. The user is signed out
+// everywhere and must enrol again.
+func ResetUserMFAHandler(c *gin.Context, db *gorm.DB) {
+	targetUUID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID format"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", targetUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	mfa := &services.MFAService{DB: db}
+	enabled, err := mfa.Enabled(targetUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load 2FA status"})
+		return
+	}
+	if err := mfa.Reset(targetUUID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset 2FA"})
+		return
+	}
+
+	sessions := &services.SessionService{DB: db}
+	if err := sessions.RevokeAllForUser(targetUUID, "2FA reset by manager"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionUserMFAReset, models.AuditEntityProfile, targetUUID.String(),
+		gin.H{"mfa_enabled": enabled}, gin.H{"mfa_enabled": false})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "two-factor authentication reset",
+		"user_id": targetUUID,
+	})
+}
+
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnrolled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor authentication failed"})
+	}
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestResetUserMFARecordsAudit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fake := fakesql.New(t)
+	userID, managerID := uuid.New(), uuid.New()
+	fake.On(`FROM "public"."profiles"`).Return([]string{"id", "email"}, []driver.Value{userID.String(), "locked-out@example.com"})
+	fake.On(`SELECT count\(\*\) FROM "mfa_credentials"`).Return([]string{"count"}, []driver.Value{int64(1)})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", managerID.String()); c.Set("role", models.RoleManager) })
+	router.DELETE("/api/user/:userId/mfa", func(c *gin.Context) { ResetUserMFAHandler(c, db) })
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/api/user/"+userID.String()+"/mfa", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	entries := fake.Statements(`INSERT INTO "audit_logs"`)
+	if len(entries) != 1 || !containsValue(entries[0].Args, models.AuditActionUserMFAReset) ||
+		!containsValue(entries[0].Args, userID.String()) || !containsValue(entries[0].Args, managerID.String()) {
+		t.Errorf("audit entries = %v, want one %s of %s by %s", entries, models.AuditActionUserMFAReset, userID, managerID)
+	}
+}
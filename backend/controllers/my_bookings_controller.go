@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMyBookingsLimit = 20
+	maxMyBookingsLimit     = 100
+)
+
+// GetMyBookingsHandler lists the signed-in customer's bookings.
+// Query: status, payment_status (comma separated), from, to (YYYY-MM-DD),
+// page, limit.
+func GetMyBookingsHandler(c *gin.Context, db *gorm.DB) {
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultMyBookingsLimit)))
+	if err != nil || limit < 1 || limit > maxMyBookingsLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	filter := services.CustomerBookingFilter{
+		Statuses:        splitQueryList(c.Query("status")),
+		PaymentStatuses: splitQueryList(c.Query("payment_status")),
+		From:            c.Query("from"),
+		To:              c.Query("to"),
+		Limit:           limit,
+		Offset:          (page - 1) * limit,
+	}
+	for _, date := range []string{filter.From, filter.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be in YYYY-MM-DD format"})
+			return
+		}
+	}
+
+	customerBookings := &services.CustomerBookingService{DB: db}
+	bookings, total, err := customerBookings.ListBookings(userUUID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookings"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(bookings))
+	for _, booking := range bookings {
+		result = append(result, myBookingResponse(booking))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bookings":    result,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+func GetMyBookingHandler(c *gin.Context, db *gorm.DB) {
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	bookingUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid booking ID format"})
+		return
+	}
+
+	customerBookings := &services.CustomerBookingService{DB: db}
+	booking, err := customerBookings.GetBooking(userUUID, bookingUUID)
+	if err != nil {
+		if errors.Is(err, services.ErrBookingNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Booking not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch booking"})
+		return
+	}
+
+	c.JSON(http.StatusOK, myBookingResponse(*booking))
+}
+
+func splitQueryList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// myBookingResponse shapes a booking for its customer, leaving out the
+// advertiser and usage details of the discount code.
+func myBookingResponse(booking models.Booking) gin.H {
+	response := gin.H{
+		"id":               booking.ID,
+		"booking_date":     booking.BookingDate,
+		"guest_count":      booking.GuestCount,
+		"status":           booking.Status,
+		"contact_name":     booking.ContactName,
+		"contact_phone":    booking.ContactPhone,
+		"contact_email":    booking.ContactEmail,
+		"special_requests": booking.SpecialRequests,
+		"expires_at":       booking.ExpiresAt,
+		"created_at":       booking.CreatedAt,
+		"updated_at":       booking.UpdatedAt,
+		"package":          nil,
+		"departure":        nil,
+		"discount":         nil,
+	}
+
+	if pkg := booking.TravelPackages; pkg != nil {
+		response["package"] = gin.H{
+			"id":        pkg.ID,
+			"title":     pkg.Title,
+			"image_url": pkg.ImageURL,
+			"location":  pkg.Location,
+			"duration":  pkg.Duration,
+			"price":     pkg.Price,
+		}
+	}
+
+	if departure := booking.Departure; departure != nil {
+		response["departure"] = gin.H{
+			"id":             departure.ID,
+			"departure_date": departure.DepartureDate,
+			"status":         departure.Status,
+		}
+	}
+
+	if code := booking.DiscountCode; code != nil {
+		response["discount"] = gin.H{
+			"code":           code.Code,
+			"discount_type":  code.DiscountType,
+			"discount_value": code.DiscountValue,
+		}
+	} else if code := booking.GlobalCode; code != nil {
+		response["discount"] = gin.H{
+			"code":           code.Code,
+			"discount_type":  code.DiscountType,
+			"discount_value": code.DiscountValue,
+		}
+	}
+
+	refunds := make([]gin.H, 0, len(booking.Refunds))
+	refunded := models.NewMoney(0, booking.FinalAmount.Currency())
+	for _, refund := range booking.Refunds {
+		// Pending refunds haven't been accepted by Stripe yet
+		if refund.Status == models.RefundStatusSucceeded {
+			refunded = refunded.Add(refund.Amount)
+		}
+		refunds = append(refunds, gin.H{
+			"id":                refund.ID,
+			"amount":            refund.Amount,
+			"status":            refund.Status,
+			"refund_percentage": refund.RefundPercentage,
+			"reason":            refund.Reason,
+			"created_at":        refund.CreatedAt,
+		})
+	}
+
+	response["payment"] = gin.H{
+		"status":          booking.PaymentStatus,
+		"total_amount":    booking.TotalAmount,
+		"discount_amount": booking.DiscountAmount,
+		"final_amount":    booking.FinalAmount,
+		"refunded_amount": refunded,
+		"refunds":         refunds,
+	}
+	return response
+}
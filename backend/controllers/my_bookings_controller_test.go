@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"testing"
+	"trip-trader-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestMyBookingResponseOnlyCountsSucceededRefunds(t *testing.T) {
+	booking := models.Booking{
+		ID:          uuid.New(),
+		FinalAmount: models.NewMoney(100000, models.DefaultCurrency),
+		Refunds: []models.BookingRefund{
+			{ID: uuid.New(), Amount: models.NewMoney(50000, models.DefaultCurrency), Status: models.RefundStatusSucceeded},
+			{ID: uuid.New(), Amount: models.NewMoney(25000, models.DefaultCurrency), Status: models.RefundStatusPending},
+		},
+	}
+
+	payment := myBookingResponse(booking)["payment"].(gin.H)
+	if refunded := payment["refunded_amount"].(models.Money); refunded.Minor() != 50000 {
+		t.Errorf("refunded amount = %s, want 500.00 from the succeeded refund only", refunded)
+	}
+	refunds := payment["refunds"].([]gin.H)
+	if len(refunds) != 2 || refunds[0]["status"] != models.RefundStatusSucceeded || refunds[1]["status"] != models.RefundStatusPending {
+		t.Errorf("refunds = %v, want both listed with their status", refunds)
+	}
+}
@@ -195,12 +195,17 @@ func (nc *NotificationController) MarkAsRead(c *gin.Context) {
 		return
 	}
 
-	if err := nc.db.Model(&models.Notification{}).
+	result := ownNotifications(c, nc.db.Model(&models.Notification{})).
 		Where("id = ?", notificationUUID).
-		Update("is_read", true).Error; err != nil {
+		Update("is_read", true)
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification as read"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
 }
@@ -233,10 +238,15 @@ func (nc *NotificationController) DeleteNotification(c *gin.Context) {
 		return
 	}
 
-	if err := nc.db.Where("id = ?", notificationUUID).Delete(&models.Notification{}).Error; err != nil {
+	result := ownNotifications(c, nc.db).Where("id = ?", notificationUUID).Delete(&models.Notification{})
+	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notification"})
 		return
 	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Notification deleted successfully"})
 }
@@ -299,4 +309,13 @@ func (nc *NotificationController) TestNotification(c *gin.Context) {
 		"user_id": req.UserID,
 		"title":   req.Title,
 	})
-}
\ No newline at end of file
+}
+// ownNotifications limits a query to the caller's own notifications, unless
+// the caller is a manager.
+func ownNotifications(c *gin.Context, query *gorm.DB) *gorm.DB {
+	if isManager(c) {
+		return query
+	}
+	userUUID, _ := currentUserID(c)
+	return query.Where("user_id = ?", userUUID)
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"fmt"
+	"time"
 	"trip-trader-backend/models"
 
 	"github.com/google/uuid"
@@ -11,9 +12,9 @@ import (
 func SendNotificationToAdvertiser(advertiserID uuid.UUID, discountCode models.DiscountCode, db *gorm.DB) {
 	discountText := ""
 	if discountCode.DiscountType == "percentage" {
-		discountText = fmt.Sprintf("%.0f%%", discountCode.DiscountValue)
+		discountText = fmt.Sprintf("%.0f%%", discountCode.DiscountValue.Float64())
 	} else {
-		discountText = fmt.Sprintf("฿%.0f", discountCode.DiscountValue)
+		discountText = fmt.Sprintf("฿%.0f", discountCode.DiscountValue.Float64())
 	}
 
 	notification := models.Notification{
@@ -94,35 +95,13 @@ func SendPaymentSuccessNotification(booking models.Booking, db *gorm.DB) {
 }
 
  
-func CreateCommission(bookingID, advertiserID, discountCodeID uuid.UUID, amount, commissionRate float64, db *gorm.DB) error {
-	commissionAmount := amount * (commissionRate / 100)
-	
-	commission := models.Commission{
-		BookingID:            bookingID,
-		AdvertiserID:         advertiserID,
-		DiscountCodeID:       &discountCodeID,
-		CommissionAmount:     commissionAmount,
-		CommissionPercentage: commissionRate,
-		Status:               "pending",
-	}
-
-	if err := db.Create(&commission).Error; err != nil {
-		return err
-	}
-
- 
-
-	return nil
-}
-
- 
 func SendGlobalDiscountCodeNotification(globalCode models.GlobalDiscountCode, db *gorm.DB) {
  
 	discountText := ""
 	if globalCode.DiscountType == "percentage" {
-		discountText = fmt.Sprintf("%.0f%%", globalCode.DiscountValue)
+		discountText = fmt.Sprintf("%.0f%%", globalCode.DiscountValue.Float64())
 	} else {
-		discountText = fmt.Sprintf("฿%.0f", globalCode.DiscountValue)
+		discountText = fmt.Sprintf("฿%.0f", globalCode.DiscountValue.Float64())
 	}
 
 	var users []models.Profile
@@ -154,4 +133,98 @@ func SendGlobalDiscountCodeNotification(globalCode models.GlobalDiscountCode, db
 		db.Create(&notifications)
 		fmt.Printf("Sent %d global discount code notifications for code: %s\n", len(notifications), globalCode.Code)
 	}
-}
\ No newline at end of file
+}
+// SendBookingCancelledNotifications tells the customer what was refunded and
+// lets the package owner and any referring advertiser know the seats are free.
+func SendBookingCancelledNotifications(booking models.Booking, refund *models.BookingRefund, db *gorm.DB) {
+	var pkg models.TravelPackage
+	db.First(&pkg, "id = ?", booking.PackageID)
+
+	refundAmount := models.NewMoney(0, booking.FinalAmount.Currency())
+	if refund != nil {
+		refundAmount = refund.Amount
+	}
+
+	customerNotification := models.Notification{
+		UserID:    booking.CustomerID,
+		Title:     "ยกเลิกการจองแล้ว",
+		Message:   fmt.Sprintf("การจอง %s ของคุณถูกยกเลิกแล้ว ยอดคืนเงิน %s บาท", pkg.Title, refundAmount),
+		Type:      "booking_cancelled",
+		Category:  "important",
+		Priority:  1,
+		ActionURL: "/profile",
+		Data: models.JSONMap{
+			"booking_id":    booking.ID,
+			"package_title": pkg.Title,
+			"refund_amount": refundAmount,
+		},
+	}
+	db.Create(&customerNotification)
+
+	advertiserIDs := []uuid.UUID{}
+	if pkg.AdvertiserID != nil {
+		advertiserIDs = append(advertiserIDs, *pkg.AdvertiserID)
+	}
+	if booking.DiscountCodeID != nil {
+		var discountCode models.DiscountCode
+		if err := db.First(&discountCode, "id = ?", *booking.DiscountCodeID).Error; err == nil &&
+			(pkg.AdvertiserID == nil || discountCode.AdvertiserID != *pkg.AdvertiserID) {
+			advertiserIDs = append(advertiserIDs, discountCode.AdvertiserID)
+		}
+	}
+
+	for _, advertiserID := range advertiserIDs {
+		notification := models.Notification{
+			UserID:    advertiserID,
+			Title:     "มีการยกเลิกการจอง",
+			Message:   fmt.Sprintf("การจองแพคเกจ %s จำนวน %d คน ถูกยกเลิกแล้ว", pkg.Title, booking.GuestCount),
+			Type:      "booking_cancelled",
+			Category:  "important",
+			Priority:  1,
+			ActionURL: "/advertiser",
+			Data: models.JSONMap{
+				"package_id":  pkg.ID,
+				"booking_id":  booking.ID,
+				"guest_count": booking.GuestCount,
+			},
+		}
+		db.Create(&notification)
+	}
+}
+
+// SendAccountLockedNotification warns a user that repeated failed sign-ins
+// locked their account, in case it was not them.
+func SendAccountLockedNotification(userID uuid.UUID, lockedUntil time.Time, db *gorm.DB) {
+	notification := models.Notification{
+		UserID:    userID,
+		Title:     "บัญชีของคุณถูกล็อกชั่วคราว",
+		Message:   fmt.Sprintf("มีการพยายามเข้าสู่ระบบผิดหลายครั้ง บัญชีของคุณถูกล็อกจนถึง %s หากไม่ใช่คุณ กรุณาเปลี่ยนรหัสผ่าน", lockedUntil.Format("02/01/2006 15:04")),
+		Type:      "account_locked",
+		Category:  "important",
+		Priority:  1,
+		ActionURL: "/profile",
+		Data: models.JSONMap{
+			"locked_until": lockedUntil,
+		},
+	}
+	db.Create(&notification)
+}
+
+func SendPayoutPaidNotification(payout models.Payout, db *gorm.DB) {
+	notification := models.Notification{
+		UserID:    payout.AdvertiserID,
+		Title:     "โอนค่าคอมมิชชั่นแล้ว",
+		Message:   fmt.Sprintf("ค่าคอมมิชชั่นของคุณจำนวน ฿%s ได้รับการโอนแล้ว เลขอ้างอิง: %s", payout.Amount.String(), *payout.PaymentReference),
+		Type:      "payout_paid",
+		Category:  "info",
+		Priority:  2,
+		ActionURL: "/advertiser",
+		Data: models.JSONMap{
+			"payout_id":         payout.ID,
+			"batch_id":          payout.BatchID,
+			"amount":            payout.Amount,
+			"payment_reference": payout.PaymentReference,
+		},
+	}
+	db.Create(&notification)
+}
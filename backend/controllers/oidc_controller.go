@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetOIDCProvidersHandler lists the external login providers, so the
+// frontend knows which buttons to show.
+func GetOIDCProvidersHandler(c *gin.Context) {
+	names := make([]string, 0)
+	for name := range services.OIDCProviders() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	c.JSON(http.StatusOK, gin.H{"providers": names})
+}
+
+// BeginOIDCLoginHandler redirects the browser to the provider. After
+// signing in the user comes back to ?redirect=, a path in the frontend.
+func BeginOIDCLoginHandler(c *gin.Context, db *gorm.DB) {
+	provider, err := services.OIDCProviderByName(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	logins := &services.OIDCLoginService{DB: db}
+	authURL, err := logins.Begin(c.Request.Context(), provider, safeRedirectPath(c.Query("redirect")), nil)
+	if err != nil {
+		log.Printf("Failed to start %s login: %v", provider.Config.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "login provider is unavailable"})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// LinkOIDCProviderHandler returns the provider URL that links an external
+// login to the caller's profile. It is fetched with the access token, so
+// the frontend navigates to the returned URL itself.
+func LinkOIDCProviderHandler(c *gin.Context, db *gorm.DB) {
+	provider, err := services.OIDCProviderByName(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	logins := &services.OIDCLoginService{DB: db}
+	authURL, err := logins.Begin(c.Request.Context(), provider, safeRedirectPath(c.Query("redirect")), &userUUID)
+	if err != nil {
+		log.Printf("Failed to start %s link: %v", provider.Config.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "login provider is unavailable"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// OIDCCallbackHandler finishes a provider login and hands the result to
+// the frontend's /auth/callback page in the URL fragment, which browsers
+// do not send to servers or in Referer headers.
+func OIDCCallbackHandler(c *gin.Context, db *gorm.DB) {
+	provider, err := services.OIDCProviderByName(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		redirectToAuthCallback(c, url.Values{"error": {providerError}})
+		return
+	}
+
+	logins := &services.OIDCLoginService{DB: db}
+	login, err := logins.Complete(c.Request.Context(), provider, c.Query("state"), c.Query("code"))
+	if err != nil {
+		message := err.Error()
+		if !errors.Is(err, services.ErrInvalidOIDCState) &&
+			!errors.Is(err, services.ErrOIDCEmailRequired) &&
+			!errors.Is(err, services.ErrOIDCEmailInUse) &&
+			!errors.Is(err, services.ErrOIDCIdentityInUse) {
+			log.Printf("%s login failed: %v", provider.Config.Name, err)
+			message = "login failed"
+		}
+		redirectToAuthCallback(c, url.Values{"error": {message}})
+		return
+	}
+
+	fragment := url.Values{"redirect": {login.RedirectPath}}
+	if login.Linked {
+		fragment.Set("linked", provider.Config.Name)
+		redirectToAuthCallback(c, fragment)
+		return
+	}
+
+	// A second factor is still needed, exactly as for password logins
+	mfa := &services.MFAService{DB: db}
+	mfaEnabled, err := mfa.Enabled(login.Profile.ID)
+	if err != nil {
+		redirectToAuthCallback(c, url.Values{"error": {"login failed"}})
+		return
+	}
+	if mfaEnabled {
+		mfaToken, err := generateMFAPendingToken(login.Profile)
+		if err != nil {
+			redirectToAuthCallback(c, url.Values{"error": {"login failed"}})
+			return
+		}
+		fragment.Set("mfa_token", mfaToken)
+		redirectToAuthCallback(c, fragment)
+		return
+	}
+
+	tokens, err := startSession(c, db, login.Profile, false)
+	if err != nil {
+		redirectToAuthCallback(c, url.Values{"error": {"login failed"}})
+		return
+	}
+	for key, value := range tokens {
+		fragment.Set(key, toFragmentValue(value))
+	}
+	fragment.Set("user_id", login.Profile.ID.String())
+	fragment.Set("role", login.Profile.UserRole)
+	redirectToAuthCallback(c, fragment)
+}
+
+// GetMyIdentitiesHandler lists the external logins linked to the caller.
+func GetMyIdentitiesHandler(c *gin.Context, db *gorm.DB) {
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	logins := &services.OIDCLoginService{DB: db}
+	identities, err := logins.Identities(userUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load linked logins"})
+		return
+	}
+	if identities == nil {
+		identities = []models.ProfileIdentity{}
+	}
+	c.JSON(http.StatusOK, gin.H{"identities": identities})
+}
+
+func redirectToAuthCallback(c *gin.Context, fragment url.Values) {
+	target := strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + "/auth/callback#" + fragment.Encode()
+	c.Redirect(http.StatusFound, target)
+}
+
+func toFragmentValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return ""
+	}
+}
+
+// safeRedirectPath only allows paths within the frontend, so the login
+// cannot be used to bounce users to another site.
+func safeRedirectPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.Contains(path, "\\") {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CreatePayoutBatchRequest struct {
+	Period string `json:"period" binding:"required"` // YYYY-MM
+}
+
+type MarkPayoutPaidRequest struct {
+	PaymentReference string `json:"payment_reference" binding:"required"`
+}
+
+func GetPayoutBatchesHandler(c *gin.Context, db *gorm.DB) {
+	payouts := &services.PayoutService{DB: db}
+	batches, err := payouts.ListBatches()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payout batches"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"batches": batches})
+}
+
+func GetPayoutBatchHandler(c *gin.Context, db *gorm.DB) {
+	batchUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout batch ID format"})
+		return
+	}
+
+	payouts := &services.PayoutService{DB: db}
+	batch, err := payouts.GetBatch(batchUUID)
+	if err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// CreatePayoutBatchHandler settles a month that has ended.
+// Body: {"period": "2026-09"}
+func CreatePayoutBatchHandler(c *gin.Context, db *gorm.DB) {
+	var req CreatePayoutBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+	period, err := time.Parse("2006-01", req.Period)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be a month in the form YYYY-MM"})
+		return
+	}
+
+	_, createdBy := requestActor(c)
+	payouts := &services.PayoutService{DB: db}
+	batch, err := payouts.CreateBatch(period.Year(), period.Month(), createdBy)
+	if err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionPayoutBatchCreated, models.AuditEntityPayoutBatch, batch.ID.String(), nil, batch)
+	c.JSON(http.StatusCreated, batch)
+}
+
+func ApprovePayoutBatchHandler(c *gin.Context, db *gorm.DB) {
+	batchUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout batch ID format"})
+		return
+	}
+
+	_, approvedBy := requestActor(c)
+	payouts := &services.PayoutService{DB: db}
+	batch, err := payouts.Approve(batchUUID, approvedBy)
+	if err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionPayoutBatchApproved, models.AuditEntityPayoutBatch, batch.ID.String(),
+		gin.H{"status": models.PayoutBatchStatusDraft}, gin.H{"status": batch.Status, "total_amount": batch.TotalAmount})
+	c.JSON(http.StatusOK, batch)
+}
+
+func MarkPayoutPaidHandler(c *gin.Context, db *gorm.DB) {
+	batchUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout batch ID format"})
+		return
+	}
+	payoutUUID, err := uuid.Parse(c.Param("payout_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout ID format"})
+		return
+	}
+
+	var req MarkPayoutPaidRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	_, paidBy := requestActor(c)
+	payouts := &services.PayoutService{DB: db}
+	payout, err := payouts.MarkPaid(batchUUID, payoutUUID, req.PaymentReference, paidBy)
+	if err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionPayoutPaid, models.AuditEntityPayout, payout.ID.String(),
+		gin.H{"status": models.PayoutStatusPending}, gin.H{"status": payout.Status, "amount": payout.Amount, "payment_reference": payout.PaymentReference})
+	SendPayoutPaidNotification(*payout, db)
+	c.JSON(http.StatusOK, payout)
+}
+
+func CancelPayoutBatchHandler(c *gin.Context, db *gorm.DB) {
+	batchUUID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout batch ID format"})
+		return
+	}
+
+	payouts := &services.PayoutService{DB: db}
+	batch, err := payouts.CancelBatch(batchUUID)
+	if err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+
+	recordAudit(c, db, models.AuditActionPayoutBatchCancelled, models.AuditEntityPayoutBatch, batch.ID.String(),
+		nil, gin.H{"status": batch.Status})
+	c.JSON(http.StatusOK, gin.H{"message": "Payout batch cancelled"})
+}
+
+// GetAdvertiserPayoutsHandler lists the payouts of the advertiser in
+// :advertiser_id and what they have earned since the last one.
+func GetAdvertiserPayoutsHandler(c *gin.Context, db *gorm.DB) {
+	advertiserUUID, err := uuid.Parse(c.Param("advertiser_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertiser ID format"})
+		return
+	}
+
+	payouts := &services.PayoutService{DB: db}
+	list, err := payouts.ListPayouts(advertiserUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payouts"})
+		return
+	}
+	balance, err := payouts.UnsettledBalance(advertiserUUID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payouts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"payouts": list, "unsettled_balance": balance})
+}
+
+func GetPayoutStatementHandler(c *gin.Context, db *gorm.DB) {
+	advertiserUUID, err := uuid.Parse(c.Param("advertiser_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertiser ID format"})
+		return
+	}
+	payoutUUID, err := uuid.Parse(c.Param("payout_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payout ID format"})
+		return
+	}
+
+	payouts := &services.PayoutService{DB: db}
+	statement, err := payouts.Statement(advertiserUUID, payoutUUID)
+	if err != nil {
+		respondPayoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
+
+func respondPayoutError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPayoutBatchNotFound), errors.Is(err, services.ErrPayoutNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPayoutPeriodOpen), errors.Is(err, services.ErrPaymentReferenceRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNothingToSettle):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPayoutBatchExists),
+		errors.Is(err, services.ErrPayoutBatchStatus),
+		errors.Is(err, services.ErrPayoutAlreadyPaid):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process payout", "details": err.Error()})
+	}
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// ExportMyDataHandler gives the caller a copy of their personal data, as a
+// ZIP of JSON files or, with ?format=json, a single JSON document.
+func ExportMyDataHandler(c *gin.Context, db *gorm.DB) {
+	// A support session must not walk off with a customer's data
+	if _, impersonating := c.Get("impersonator_id"); impersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": "data export is not available while impersonating"})
+		return
+	}
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	privacy := &services.PrivacyService{DB: db}
+	export, err := privacy.Export(userUUID)
+	if err != nil {
+		if errors.Is(err, services.ErrAccountNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export data"})
+		return
+	}
+
+	filename := fmt.Sprintf("trip-trader-data-%s", export.GeneratedAt.Format("2006-01-02"))
+	if c.Query("format") == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".json"))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	archive, err := zipDataExport(export)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export data"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".zip"))
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// DeleteMyAccountHandler anonymises the caller's account after they confirm
+// with their password.
+func DeleteMyAccountHandler(c *gin.Context, db *gorm.DB) {
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request", "details": err.Error()})
+		return
+	}
+
+	userUUID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.First(&profile, "id = ?", userUUID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "account not found"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(profile.Password), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "incorrect password"})
+		return
+	}
+
+	privacy := &services.PrivacyService{DB: db}
+	if err := privacy.DeleteAccount(userUUID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrAccountHasActiveBookings):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrAccountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete account"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "your account has been deleted",
+	})
+}
+
+// zipDataExport writes the profile and each section as its own JSON file.
+func zipDataExport(export *services.DataExport) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	files := map[string]interface{}{
+		"profile.json": gin.H{"generated_at": export.GeneratedAt, "profile": export.Profile},
+	}
+	for name, rows := range export.Sections {
+		files[name+".json"] = rows
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		data, err := json.MarshalIndent(files[name], "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		file, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
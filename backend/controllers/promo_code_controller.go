@@ -5,10 +5,12 @@ import (
 	"strconv"
 	"time"
 	"trip-trader-backend/models"
+	"trip-trader-backend/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type DiscountCodeController struct {
@@ -70,6 +72,12 @@ func (dc *DiscountCodeController) ToggleDiscountCodeStatus(c *gin.Context) {
 		return
 	}
 
+	var before models.DiscountCode
+	if err := dc.DB.First(&before, "id = ?", codeID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Discount code not found"})
+		return
+	}
+
 	if err := dc.DB.Model(&models.DiscountCode{}).
 		Where("id = ?", codeID).
 		Update("is_active", req.IsActive).Error; err != nil {
@@ -77,6 +85,11 @@ func (dc *DiscountCodeController) ToggleDiscountCodeStatus(c *gin.Context) {
 		return
 	}
 
+	var after models.DiscountCode
+	if err := dc.DB.First(&after, "id = ?", codeID).Error; err == nil {
+		recordAudit(c, dc.DB, models.AuditActionDiscountCodeStatusChanged, models.AuditEntityDiscountCode, codeID, before, after)
+	}
+
 	c.JSON(200, gin.H{"message": "Status updated successfully"})
 }
 
@@ -92,6 +105,12 @@ func (dc *DiscountCodeController) ToggleGlobalDiscountCodeStatus(c *gin.Context)
 		return
 	}
 
+	var before models.GlobalDiscountCode
+	if err := dc.DB.First(&before, "id = ?", codeID).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Global discount code not found"})
+		return
+	}
+
 	if err := dc.DB.Model(&models.GlobalDiscountCode{}).
 		Where("id = ?", codeID).
 		Update("is_active", req.IsActive).Error; err != nil {
@@ -99,21 +118,33 @@ func (dc *DiscountCodeController) ToggleGlobalDiscountCodeStatus(c *gin.Context)
 		return
 	}
 
+	var after models.GlobalDiscountCode
+	if err := dc.DB.First(&after, "id = ?", codeID).Error; err == nil {
+		recordAudit(c, dc.DB, models.AuditActionGlobalDiscountStatusChanged, models.AuditEntityGlobalDiscountCode, codeID, before, after)
+	}
+
 	c.JSON(200, gin.H{"message": "Status updated successfully"})
 }
 
 func (dc *DiscountCodeController) CreateDiscountCodeForAdvertiser(c *gin.Context) {
 	var req struct {
-		AdvertiserID    string  `json:"advertiser_id" binding:"required"`
-		PackageID       string  `json:"package_id" binding:"required"`
-		DiscountValue   float64 `json:"discount_value" binding:"required"`
-		DiscountType    string  `json:"discount_type" binding:"required,oneof=percentage fixed"`
+		AdvertiserID    string         `json:"advertiser_id" binding:"required"`
+		PackageID       string         `json:"package_id" binding:"required"`
+		DiscountValue   models.Decimal `json:"discount_value" binding:"required"`
+		DiscountType    string         `json:"discount_type" binding:"required,oneof=percentage fixed"`
+		MaxUses            *int          `json:"max_uses" binding:"omitempty,min=1"`
+		MaxUsesPerCustomer *int          `json:"max_uses_per_customer" binding:"omitempty,min=1"`
+		MinSpend           *models.Money `json:"min_spend"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if req.MinSpend != nil && req.MinSpend.IsNegative() {
+		c.JSON(400, gin.H{"error": "min_spend cannot be negative"})
+		return
+	}
 
 	advertiserID, err := uuid.Parse(req.AdvertiserID)
 	if err != nil {
@@ -173,7 +204,9 @@ func (dc *DiscountCodeController) CreateDiscountCodeForAdvertiser(c *gin.Context
 		   PackageID:      packageID,
 		   DiscountValue:  req.DiscountValue,
 		   DiscountType:   req.DiscountType,
-		   MaxUses:        nil,
+		   MaxUses:        req.MaxUses,
+		   MaxUsesPerCustomer: req.MaxUsesPerCustomer,
+		   MinSpend:       req.MinSpend,
 		   IsActive:       &[]bool{true}[0],
 		   ExpiresAt:      nil,
 	   }
@@ -199,9 +232,11 @@ func (dc *DiscountCodeController) CreateDiscountCodeForAdvertiser(c *gin.Context
 
 func (dc *DiscountCodeController) CreateGlobalDiscountCode(c *gin.Context) {
 	var req struct {
-		DiscountValue float64 `json:"discount_value" binding:"required,min=1"`
-		DiscountType  string  `json:"discount_type" binding:"required,oneof=percentage fixed"`
-		MaxUses      *int    `json:"max_uses"`
+		DiscountValue models.Decimal `json:"discount_value" binding:"required"`
+		DiscountType  string         `json:"discount_type" binding:"required,oneof=percentage fixed"`
+		MaxUses      *int    `json:"max_uses" binding:"omitempty,min=1"`
+		MaxUsesPerCustomer *int          `json:"max_uses_per_customer" binding:"omitempty,min=1"`
+		MinSpend           *models.Money `json:"min_spend"`
 		ExpiresAt    *string `json:"expires_at"`
 	}
 
@@ -209,6 +244,14 @@ func (dc *DiscountCodeController) CreateGlobalDiscountCode(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
+	if req.DiscountValue < models.NewDecimal(1) {
+		c.JSON(400, gin.H{"error": "discount_value must be at least 1"})
+		return
+	}
+	if req.MinSpend != nil && req.MinSpend.IsNegative() {
+		c.JSON(400, gin.H{"error": "min_spend cannot be negative"})
+		return
+	}
 
 	var expiresAt *time.Time
 	if req.ExpiresAt != nil && *req.ExpiresAt != "" {
@@ -239,6 +282,8 @@ func (dc *DiscountCodeController) CreateGlobalDiscountCode(c *gin.Context) {
 		DiscountValue: req.DiscountValue,
 		DiscountType:  req.DiscountType,
 		MaxUses:      req.MaxUses,
+		MaxUsesPerCustomer: req.MaxUsesPerCustomer,
+		MinSpend:     req.MinSpend,
 		CurrentUses:  0,
 		IsActive:     true,
 		ExpiresAt:    expiresAt,
@@ -273,14 +318,6 @@ func (dc *DiscountCodeController) GetDiscountCodesByAdvertiser(c *gin.Context) {
 		return
 	}
 
-	for i := range discountCodes {
-		var currentUses int64
-		dc.DB.Model(&models.Booking{}).
-			Where("discount_code_id = ? AND payment_status IN (?)", discountCodes[i].ID, []string{"paid", "confirmed", "completed"}).
-			Count(&currentUses)
-		discountCodes[i].CurrentUses = int(currentUses)
-	}
-
 	c.JSON(200, discountCodes)
 }
 
@@ -301,6 +338,10 @@ func (dc *DiscountCodeController) ValidateDiscountCode(c *gin.Context) {
 				c.JSON(400, gin.H{"error": "Discount code is inactive"})
 				return
 			}
+			if discountCode.IsUsedUp() {
+				c.JSON(409, gin.H{"error": "Discount code usage limit reached"})
+				return
+			}
 
 			if req.PackageID != nil {
 				packageID, err := uuid.Parse(*req.PackageID)
@@ -326,6 +367,8 @@ func (dc *DiscountCodeController) ValidateDiscountCode(c *gin.Context) {
 				"discount_type":  discountCode.DiscountType,
 				"discount_code_id": discountCode.ID,
 				"advertiser_id":  discountCode.AdvertiserID,
+				"min_spend":      discountCode.MinSpend,
+				"max_uses_per_customer": discountCode.MaxUsesPerCustomer,
 			})
 			return
 		}
@@ -336,6 +379,10 @@ func (dc *DiscountCodeController) ValidateDiscountCode(c *gin.Context) {
 			c.JSON(400, gin.H{"error": "Global discount code is inactive"})
 			return
 		}
+		if globalCode.IsUsedUp() {
+			c.JSON(409, gin.H{"error": "Global discount code usage limit reached"})
+			return
+		}
 
 		c.JSON(200, gin.H{
 			"valid":          true,
@@ -343,6 +390,8 @@ func (dc *DiscountCodeController) ValidateDiscountCode(c *gin.Context) {
 			"discount_value": globalCode.DiscountValue,
 			"discount_type":  globalCode.DiscountType,
 			"global_code_id": globalCode.ID,
+			"min_spend":      globalCode.MinSpend,
+			"max_uses_per_customer": globalCode.MaxUsesPerCustomer,
 		})
 		return
 	}
@@ -355,7 +404,6 @@ func (dc *DiscountCodeController) UseDiscountCode(c *gin.Context) {
 		DiscountCodeID *string `json:"discount_code_id"`
 		GlobalCodeID   *string `json:"global_code_id"`
 		BookingID      string  `json:"booking_id" binding:"required"`
-		OriginalAmount float64 `json:"original_amount" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -363,103 +411,132 @@ func (dc *DiscountCodeController) UseDiscountCode(c *gin.Context) {
 		return
 	}
 
-	tx := dc.DB.Begin()
+	if req.DiscountCodeID == nil && req.GlobalCodeID == nil {
+		c.JSON(400, gin.H{"error": "Either discount_code_id or global_code_id is required"})
+		return
+	}
 
-	var discountAmount float64
-	var advertiserID *uuid.UUID
+	bookingID, err := uuid.Parse(req.BookingID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid booking ID"})
+		return
+	}
 
+	quoteReq := services.QuoteRequest{}
 	if req.DiscountCodeID != nil {
-		var discountCode models.DiscountCode
-		if err := tx.Where("id = ?", *req.DiscountCodeID).First(&discountCode).Error; err != nil {
-			tx.Rollback()
-			c.JSON(404, gin.H{"error": "Discount code not found"})
+		discountCodeID, err := uuid.Parse(*req.DiscountCodeID)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid discount code ID"})
 			return
 		}
-		
-		if discountCode.DiscountType == "percentage" {
-			discountAmount = req.OriginalAmount * (discountCode.DiscountValue / 100)
-		} else { // fixed
-			discountAmount = discountCode.DiscountValue
-		}
-		advertiserID = &discountCode.AdvertiserID
-	} else if req.GlobalCodeID != nil {
-		var globalCode models.GlobalDiscountCode
-		if err := tx.Where("id = ?", *req.GlobalCodeID).First(&globalCode).Error; err != nil {
-			tx.Rollback()
-			c.JSON(404, gin.H{"error": "Global discount code not found"})
+		quoteReq.DiscountCodeID = &discountCodeID
+	}
+	if req.GlobalCodeID != nil {
+		globalCodeID, err := uuid.Parse(*req.GlobalCodeID)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid global code ID"})
 			return
 		}
-		if globalCode.DiscountType == "percentage" {
-			discountAmount = req.OriginalAmount * (globalCode.DiscountValue / 100)
-		} else { // fixed
-			discountAmount = globalCode.DiscountValue
-		}
-	} else {
+		quoteReq.GlobalCodeID = &globalCodeID
+	}
+
+	tx := dc.DB.Begin()
+
+	var booking models.Booking
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&booking, "id = ?", bookingID).Error; err != nil {
 		tx.Rollback()
-		c.JSON(400, gin.H{"error": "Either discount_code_id or global_code_id is required"})
+		c.JSON(404, gin.H{"error": "Booking not found"})
+		return
+	}
+	if !ownsBooking(c, booking) {
+		tx.Rollback()
+		c.JSON(403, gin.H{"error": "You can only access your own bookings"})
+		return
+	}
+	if booking.PaymentStatus != models.PaymentStatusPending {
+		tx.Rollback()
+		c.JSON(409, gin.H{"error": "Discount codes can only be applied to unpaid bookings"})
+		return
+	}
+	// The checkout session charges the amount it was created with, so the
+	// booking's price can't change underneath it
+	if booking.StripePaymentIntentID != nil {
+		tx.Rollback()
+		c.JSON(409, gin.H{"error": "Discount codes must be applied before checkout starts"})
 		return
 	}
-	finalAmount := req.OriginalAmount - discountAmount
 
-	bookingID, _ := uuid.Parse(req.BookingID)
-	
-	updateData := map[string]interface{}{
-		"discount_amount": discountAmount,
-		"final_amount":    finalAmount,
+	// The old code's use is given back before the new one is checked
+	redemptions := &services.RedemptionService{DB: tx}
+	if err := redemptions.Release(booking.ID); err != nil {
+		tx.Rollback()
+		c.JSON(500, gin.H{"error": "Failed to update booking"})
+		return
 	}
-	
-	if req.DiscountCodeID != nil {
-		discountCodeID, _ := uuid.Parse(*req.DiscountCodeID)
-		updateData["discount_code_id"] = discountCodeID
+
+	// The amounts always come from the pricing service, never from the client
+	quoteReq.PackageID = booking.PackageID
+	quoteReq.DepartureID = booking.DepartureID
+	quoteReq.GuestCount = booking.GuestCount
+	quoteReq.CustomerID = &booking.CustomerID
+	quoteReq.Currency = booking.Currency
+	pricing := &services.PricingService{DB: tx}
+	quote, err := pricing.Quote(quoteReq)
+	if err != nil {
+		tx.Rollback()
+		respondPricingError(c, err)
+		return
 	}
-	
+
+	updateData := map[string]interface{}{
+		"total_amount":     quote.TotalAmount,
+		"discount_amount":  quote.DiscountAmount,
+		"final_amount":     quote.FinalAmount,
+		"discount_code_id": quote.DiscountCodeID,
+		"global_code_id":   quote.GlobalCodeID,
+		"exchange_rate":    quote.ExchangeRate,
+		"exchange_rate_id": quote.ExchangeRateID,
+		"charged_amount":   quote.ChargeAmount.Decimal(),
+	}
+
 	if err := tx.Model(&models.Booking{}).Where("id = ?", bookingID).Updates(updateData).Error; err != nil {
 		tx.Rollback()
 		c.JSON(500, gin.H{"error": "Failed to update booking"})
 		return
 	}
 
-	if advertiserID != nil {
-		dc.CreateCommission(tx, *advertiserID, bookingID, finalAmount, req.DiscountCodeID)
+	booking.TotalAmount = quote.TotalAmount
+	booking.DiscountCodeID = quote.DiscountCodeID
+	booking.GlobalCodeID = quote.GlobalCodeID
+	if err := redemptions.Reserve(booking); err != nil {
+		tx.Rollback()
+		respondPricingError(c, err)
+		return
 	}
 
-	tx.Commit()
+	// The commission is recorded once the booking is paid
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update booking"})
+		return
+	}
 
 	c.JSON(200, gin.H{
-		"discount_amount": discountAmount,
-		"final_amount":   finalAmount,
+		"discount_amount": quote.DiscountAmount,
+		"final_amount":   quote.FinalAmount,
+		"currency":       quote.Currency,
+		"charge_amount":  quote.ChargeAmount,
 	})
 }
 
-func (dc *DiscountCodeController) CreateCommission(tx *gorm.DB, advertiserID uuid.UUID, bookingID uuid.UUID, finalAmount float64, discountCodeID *string) {
-	// commissionRate and commissionAmount should be calculated in booking_controller.go, not here
-	// This function should only create commission record with provided values
-	commission := models.Commission{
-		ID:                   uuid.New(),
-		BookingID:            bookingID,
-		AdvertiserID:         advertiserID,
-		CommissionAmount:     finalAmount, // Pass calculated commissionAmount here
-		CommissionPercentage: 0, // Pass calculated commissionRate here if needed
-		Status:               "pending",
-	}
-	
-	if discountCodeID != nil {
-		discountCodeUUID, _ := uuid.Parse(*discountCodeID)
-		commission.DiscountCodeID = &discountCodeUUID
-	}
-	
-	tx.Create(&commission)
-}
-
 type DiscountCommissionData struct {
-	PackageID        string  `json:"package_id"`
-	PackageName      string  `json:"package_name"`
-	TotalRevenue     float64 `json:"total_revenue"`
-	DiscountCodeID   string  `json:"discount_code_id"`
-	DiscountCode     string  `json:"discount_code"`
-	UsagePercentage  float64 `json:"usage_percentage"`
-	CommissionRate   float64 `json:"commission_rate"`
-	CommissionAmount float64 `json:"commission_amount"`
+	PackageID        string         `json:"package_id"`
+	PackageName      string         `json:"package_name"`
+	TotalRevenue     models.Money   `json:"total_revenue"`
+	DiscountCodeID   string         `json:"discount_code_id"`
+	DiscountCode     string         `json:"discount_code"`
+	UsagePercentage  models.Decimal `json:"usage_percentage"`
+	CommissionRate   models.Decimal `json:"commission_rate"`
+	CommissionAmount models.Money   `json:"commission_amount"`
 }
 
 func (dc *DiscountCodeController) GetCommissionsByAdvertiser(c *gin.Context) {
@@ -486,12 +563,14 @@ func (dc *DiscountCodeController) GetCommissionsByAdvertiser(c *gin.Context) {
 	var commissions []models.Commission
 	if err := dc.DB.Where("advertiser_id = ? AND created_at >= ? AND created_at <= ?", 
 		advertiserID, startDate, endDate).
+		Preload("Booking").
 		Find(&commissions).Error; err != nil {
 		c.JSON(500, gin.H{"error": "Failed to fetch commissions", "details": err.Error()})
 		return
 	}
 	
 	packageRevenue := make(map[string]*DiscountCommissionData)
+	commissionRules := &services.CommissionService{DB: dc.DB}
 	
 	for _, commission := range commissions {
 		if commission.DiscountCodeID == nil {
@@ -503,26 +582,17 @@ func (dc *DiscountCodeController) GetCommissionsByAdvertiser(c *gin.Context) {
 			if err := dc.DB.Preload("Package").First(&discountCode, *commission.DiscountCodeID).Error; err != nil {
 				continue
 			}
-			var currentUses int64
-			dc.DB.Model(&models.Booking{}).
-				Where("discount_code_id = ? AND payment_status IN (?)", discountCode.ID, []string{"paid", "confirmed", "completed"}).
-				Count(&currentUses)
-			usagePercentage := float64(0)
-			maxUses := 1
-			if discountCode.MaxUses != nil {
-				maxUses = *discountCode.MaxUses
-				if maxUses > 0 {
-					usagePercentage = (float64(currentUses) / float64(maxUses)) * 100
-				}
-			}
-			commissionRate := float64(0)
-			if usagePercentage >= 50 && usagePercentage < 75 {
-				commissionRate = 3
-			} else if usagePercentage >= 75 && usagePercentage < 100 {
-				commissionRate = 5
-			} else if usagePercentage >= 100 {
-				commissionRate = 10
+			// The current rate under the commission rules, for the month shown
+			quote, err := commissionRules.Evaluate(services.CommissionInput{
+				AdvertiserID: discountCode.AdvertiserID,
+				PackageID:    discountCode.PackageID,
+				At:           startDate,
+			})
+			if err != nil {
+				continue
 			}
+			usagePercentage := quote.UsagePercentage
+			commissionRate := quote.Rate
 			packageName := "ไม่พบแพ็กเกจ"
 			packageID := discountCode.PackageID.String()
 			if discountCode.Package.Title != "" {
@@ -531,14 +601,17 @@ func (dc *DiscountCodeController) GetCommissionsByAdvertiser(c *gin.Context) {
 			packageRevenue[discountCodeIDStr] = &DiscountCommissionData{
 				PackageID:        packageID,
 				PackageName:      packageName,
-				TotalRevenue:     0,
 				DiscountCodeID:   discountCode.ID.String(),
 				DiscountCode:     discountCode.Code,
 				UsagePercentage:  usagePercentage,
 				CommissionRate:   commissionRate,
-				CommissionAmount: 0,
 			}
 		}
+		if commission.Status != models.CommissionStatusReversed {
+			data := packageRevenue[discountCodeIDStr]
+			data.TotalRevenue = data.TotalRevenue.Add(commission.Booking.FinalAmount)
+			data.CommissionAmount = data.CommissionAmount.Add(commission.CommissionAmount)
+		}
 	}
 	// result is a map, convert to slice for response
 	var response []DiscountCommissionData
@@ -605,6 +678,8 @@ func (dc *DiscountCodeController) DeleteDiscountCode(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, dc.DB, models.AuditActionDiscountCodeDeleted, models.AuditEntityDiscountCode, codeID, discountCode, nil)
+
 	c.JSON(200, gin.H{"message": "Discount code deleted successfully"})
 }
 
@@ -626,5 +701,7 @@ func (dc *DiscountCodeController) DeleteGlobalDiscountCode(c *gin.Context) {
 		return
 	}
 
+	recordAudit(c, dc.DB, models.AuditActionGlobalDiscountCodeDeleted, models.AuditEntityGlobalDiscountCode, codeID, globalCode, nil)
+
 	c.JSON(200, gin.H{"message": "Global discount code deleted successfully"})
 }
\ No newline at end of file
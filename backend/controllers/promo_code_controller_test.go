@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestUseDiscountCodeRefusesBookingInCheckout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fake := fakesql.New(t)
+	bookingID, customerID := uuid.New(), uuid.New()
+	fake.On(`SELECT \* FROM "bookings"`).Return(
+		[]string{"id", "customer_id", "package_id", "guest_count", "final_amount", "status", "payment_status", "stripe_payment_intent_id"},
+		[]driver.Value{bookingID.String(), customerID.String(), uuid.NewString(), int64(2), "1000.00",
+			models.BookingStatusPending, models.PaymentStatusPending, "cs_test_open"},
+	)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", customerID.String()); c.Set("role", models.RoleCustomer) })
+	router.POST("/api/discount-codes/use", NewDiscountCodeController(db).UseDiscountCode)
+
+	body := `{"booking_id":"` + bookingID.String() + `","global_code_id":"` + uuid.NewString() + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/discount-codes/use", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusConflict, rec.Body)
+	}
+	if got := fake.Statements(`UPDATE "bookings"`); len(got) != 0 {
+		t.Errorf("booking updates = %v, want none once checkout has started", got)
+	}
+}
+
+func TestValidateDiscountCodeReportsUsageLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fake := fakesql.New(t)
+	fake.On(`FROM "global_discount_codes"`).Return(
+		[]string{"id", "code", "discount_type", "discount_value", "is_active", "max_uses", "current_uses"},
+		[]driver.Value{uuid.NewString(), "LIMITED", "percentage", "10", true, int64(5), int64(5)},
+	)
+
+	router := gin.New()
+	router.POST("/api/discount-codes/validate", NewDiscountCodeController(db).ValidateDiscountCode)
+	req := httptest.NewRequest(http.MethodPost, "/api/discount-codes/validate", strings.NewReader(`{"code":"LIMITED"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "usage limit reached") {
+		t.Fatalf("status = %d, body %s, want %d with the usage limit", rec.Code, rec.Body, http.StatusConflict)
+	}
+}
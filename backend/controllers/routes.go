@@ -3,12 +3,33 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"trip-trader-backend/models"
 	"trip-trader-backend/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+// SetupRoutes registers every route under one of these access policies:
+//
+//	r                      - anyone
+//	authenticated          - any signed-in user; handlers check ownership of
+//	                         the booking or notification they act on.
+//	                         Managers must have passed 2FA
+//	mfaEnrollment          - any signed-in user, including managers still
+//	                         without 2FA
+//	advertisersAndManagers - advertisers and managers
+//	packageEditors         - advertisers assigned to the package in :id, and
+//	                         managers
+//	managerOnly            - managers
+//	advertisersOnly        - advertisers
+//	advertiserAPI(scope)   - like advertisersAndManagers, and also
+//	                         advertiser API keys sent as X-API-Key that were
+//	                         granted scope
+//	packageEditorsAPI      - like packageEditors, and also API keys with the
+//	                         packages:write scope
+//
+// plus a few routes with path-specific checks of their own.
 func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *utils.Hub) {
 	// Get all packages for a given advertiser
 	r.GET("/api/advertiser/:advertiser_id/packages", func(c *gin.Context) {
@@ -19,6 +40,17 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *utils.Hub) {
 		c.Next()
 	})
 
+	authenticated := r.Group("/", AuthMiddleware())
+	mfaEnrollment := r.Group("/", MFAEnrollmentAuthMiddleware())
+	advertisersAndManagers := r.Group("/", AuthMiddleware(), RoleMiddleware(models.RoleAdvertiser, models.RoleManager))
+	packageEditors := advertisersAndManagers.Group("/", PackageOwnerMiddleware(db, "id"))
+	managerOnly := r.Group("/", AuthMiddleware(), RoleMiddleware(models.RoleManager))
+	advertisersOnly := r.Group("/", AuthMiddleware(), RoleMiddleware(models.RoleAdvertiser))
+	advertiserAPI := func(scope string) *gin.RouterGroup {
+		return r.Group("/", APIKeyAuthMiddleware(scope), RoleMiddleware(models.RoleAdvertiser, models.RoleManager))
+	}
+	packageEditorsAPI := advertiserAPI(models.APIKeyScopePackagesWrite).Group("/", PackageOwnerMiddleware(db, "id"))
+
 	r.GET("/allPackages", func(c *gin.Context) {
 		GetAllPackagesHandler(c, db)
 	})
@@ -31,105 +63,194 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *utils.Hub) {
 	r.GET("/api/packages/tags", func(c *gin.Context) {
 		GetAllTagsHandler(c, db)
 	})
-	
+
 	// Inclusions routes
 	r.GET("/api/inclusions", func(c *gin.Context) {
 		GetAllInclusionsHandler(c, db)
 	})
-	r.POST("/api/inclusions", func(c *gin.Context) {
+	advertisersAndManagers.POST("/api/inclusions", func(c *gin.Context) {
 		CreateInclusionHandler(c, db)
 	})
 	r.GET("/api/packages/:id/inclusions", func(c *gin.Context) {
 		GetPackageInclusionsHandler(c, db)
 	})
-	r.PUT("/api/packages/:id/inclusions", func(c *gin.Context) {
+	packageEditorsAPI.PUT("/api/packages/:id/inclusions", func(c *gin.Context) {
 		UpdatePackageInclusionsHandler(c, db)
 	})
-	
-	r.POST("/api/travel-packages", func(c *gin.Context) {
+
+	// Departure dates (per-date inventory)
+	r.GET("/api/packages/:id/departures", func(c *gin.Context) {
+		GetPackageDeparturesHandler(c, db)
+	})
+	packageEditorsAPI.POST("/api/packages/:id/departures", func(c *gin.Context) {
+		CreatePackageDepartureHandler(c, db)
+	})
+	r.GET("/api/packages/:id/departures/:departureId", func(c *gin.Context) {
+		GetPackageDepartureHandler(c, db)
+	})
+	packageEditorsAPI.PUT("/api/packages/:id/departures/:departureId", func(c *gin.Context) {
+		UpdatePackageDepartureHandler(c, db)
+	})
+	packageEditorsAPI.DELETE("/api/packages/:id/departures/:departureId", func(c *gin.Context) {
+		DeletePackageDepartureHandler(c, db)
+	})
+	r.GET("/api/packages/:id/refund-policy", func(c *gin.Context) {
+		GetPackageRefundPolicyHandler(c, db)
+	})
+	packageEditors.PUT("/api/packages/:id/refund-policy", func(c *gin.Context) {
+		UpdatePackageRefundPolicyHandler(c, db)
+	})
+
+	advertisersAndManagers.POST("/api/travel-packages", func(c *gin.Context) {
 		CreatePackageHandler(c, db)
 	})
-	r.POST("/api/packages", func(c *gin.Context) {
+	advertisersAndManagers.POST("/api/packages", func(c *gin.Context) {
 		CreatePackageHandler(c, db)
 	})
-	r.PUT("/api/travel-packages/:id", func(c *gin.Context) {
+	packageEditors.PUT("/api/travel-packages/:id", func(c *gin.Context) {
 		GetAllPackagesHandler(c, db)
 	})
-	r.PUT("/api/packages/:id", func(c *gin.Context) {
+	packageEditorsAPI.PUT("/api/packages/:id", func(c *gin.Context) {
 		UpdatePackageHandler(c, db)
 	})
 	r.GET("/package/:id", func(c *gin.Context) {
 		GetPackageByIDHandler(c, db)
 	})
-	r.POST("/meow", func(c *gin.Context) {
+	advertisersAndManagers.POST("/meow", func(c *gin.Context) {
 		CreatePackageHandler(c, db)
 	})
-	r.PUT("/package/:id/bookings", func(c *gin.Context) {
+	// Only re-syncs the seat count, so any customer may trigger it
+	authenticated.PUT("/package/:id/bookings", func(c *gin.Context) {
 		UpdateCurrentBookingsHandler(c, db)
 	})
-	r.GET("/package/userList/:packageId", func(c *gin.Context) {
+	advertiserAPI(models.APIKeyScopeBookingsRead).GET("/package/userList/:packageId", PackageOwnerMiddleware(db, "packageId"), func(c *gin.Context) {
 		GetPackageConfirmedUsersHandler(c, db)
 	})
-	
-	r.PUT("/api/package/:id/advertisers", func(c *gin.Context) {
+
+	managerOnly.PUT("/api/package/:id/advertisers", func(c *gin.Context) {
 		UpdatePackageAdvertisersHandler(c, db)
 	})
 	r.GET("/api/package/:id/advertisers", func(c *gin.Context) {
 		GetPackageAdvertisersHandler(c, db)
 	})
-	r.DELETE("/api/travel-packages/:id", func(c *gin.Context) {
+	packageEditors.DELETE("/api/travel-packages/:id", func(c *gin.Context) {
 		DeletePackageHandler(c, db)
 	})
 
-	r.GET("/api/profiles", func(c *gin.Context) {
+	managerOnly.GET("/api/profiles", func(c *gin.Context) {
 		GetAllProfilesHandler(c, db)
 	})
-	r.GET("/api/profile/:userId", func(c *gin.Context) {
+	authenticated.GET("/api/profile/:userId", SelfOrManagerMiddleware("userId"), func(c *gin.Context) {
 		GetProfileByUserIdHandler(c, db)
 	})
-	r.PUT("/api/profile/:userId", func(c *gin.Context) {
+	authenticated.PUT("/api/profile/:userId", SelfOrManagerMiddleware("userId"), func(c *gin.Context) {
 		UpsertProfileHandler(c, db)
 	})
 
-	r.GET("/api/users", func(c *gin.Context) {
+	managerOnly.GET("/api/users", func(c *gin.Context) {
 		GetAllUsersHandler(c, db)
 	})
-	r.GET("/api/user/current/role", func(c *gin.Context) {
+	authenticated.GET("/api/user/current/role", func(c *gin.Context) {
 		GetCurrentUserRoleHandler(c, db)
 	})
 
-	r.PUT("/api/user/:userId/role", func(c *gin.Context) {
+	managerOnly.PUT("/api/user/:userId/role", func(c *gin.Context) {
 		UpdateUserRoleHandler(c, db)
 	})
 
-
-	r.GET("/api/bookings", func(c *gin.Context) {
+	advertisersAndManagers.GET("/api/bookings", func(c *gin.Context) {
 		packageID := c.Query("package_id")
 		if packageID != "" {
+			if !authorizePackageAccess(c, db, packageID) {
+				return
+			}
 			GetBookingsByPackageQueryHandler(c, db)
-		} else {
+		} else if isManager(c) {
 			GetAllBookingsHandler(c, db)
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
 		}
 	})
-	r.GET("/api/bookings/package/:packageId", func(c *gin.Context) {
+	advertiserAPI(models.APIKeyScopeBookingsRead).GET("/api/bookings/package/:packageId", PackageOwnerMiddleware(db, "packageId"), func(c *gin.Context) {
 		GetBookingsByPackageHandler(c, db)
 	})
-	
-	authorized := r.Group("/")
-	authorized.Use(AuthMiddleware())
+	r.POST("/api/bookings/quote", func(c *gin.Context) {
+		GetBookingQuoteHandler(c, db)
+	})
+
+	// Stripe authenticates itself with the Stripe-Signature header
+	r.POST("/api/webhooks/stripe", func(c *gin.Context) {
+		StripeWebhookHandler(c, db)
+	})
+
 	{
-		authorized.POST("/api/booking/payment", func(c *gin.Context) {
+		authenticated.POST("/api/booking/payment", func(c *gin.Context) {
 			CreateBookingPaymentHandler(c, db)
 		})
-		authorized.PUT("/api/booking/:bookingId/confirm-payment", func(c *gin.Context) {
+		authenticated.PUT("/api/booking/:bookingId/confirm-payment", func(c *gin.Context) {
 			ConfirmPaymentHandler(c, db)
 		})
+		authenticated.GET("/api/me/bookings", func(c *gin.Context) {
+			GetMyBookingsHandler(c, db)
+		})
+		authenticated.GET("/api/me/bookings/:id", func(c *gin.Context) {
+			GetMyBookingHandler(c, db)
+		})
+		authenticated.GET("/api/bookings/:id/events", func(c *gin.Context) {
+			GetBookingEventsHandler(c, db)
+		})
+		authenticated.GET("/api/bookings/:id/cancellation-preview", func(c *gin.Context) {
+			GetCancellationPreviewHandler(c, db)
+		})
+		authenticated.POST("/api/bookings/:id/cancel", func(c *gin.Context) {
+			CancelBookingHandler(c, db)
+		})
 	}
 
-
+	r.GET("/.well-known/jwks.json", JWKSHandler)
 	r.POST("/api/signup", SignupHandler)
 	r.POST("/api/login", LoginHandler)
-	r.POST("/api/logout", LogoutHandler)
+	r.POST("/api/token/refresh", RefreshTokenHandler)
+	r.POST("/api/login/mfa", LoginMFAHandler)
+
+	r.GET("/api/auth/oidc/providers", GetOIDCProvidersHandler)
+	r.GET("/api/auth/oidc/:provider/login", func(c *gin.Context) {
+		BeginOIDCLoginHandler(c, db)
+	})
+	r.GET("/api/auth/oidc/:provider/callback", func(c *gin.Context) {
+		OIDCCallbackHandler(c, db)
+	})
+	authenticated.POST("/api/auth/oidc/:provider/link", func(c *gin.Context) {
+		LinkOIDCProviderHandler(c, db)
+	})
+	authenticated.GET("/api/me/identities", func(c *gin.Context) {
+		GetMyIdentitiesHandler(c, db)
+	})
+	authenticated.GET("/api/me/export", func(c *gin.Context) {
+		ExportMyDataHandler(c, db)
+	})
+	authenticated.DELETE("/api/me", func(c *gin.Context) {
+		DeleteMyAccountHandler(c, db)
+	})
+
+	mfaEnrollment.POST("/api/logout", LogoutHandler)
+	mfaEnrollment.POST("/api/logout-all", LogoutAllHandler)
+	r.POST("/api/password/forgot", ForgotPasswordHandler)
+	r.POST("/api/password/reset", ResetPasswordHandler)
+	r.POST("/api/email/verify", VerifyEmailHandler)
+	authenticated.POST("/api/email/verify/resend", ResendVerificationEmailHandler)
+
+	mfaEnrollment.GET("/api/mfa", GetMFAStatusHandler)
+	mfaEnrollment.POST("/api/mfa/enroll", BeginMFAEnrollmentHandler)
+	mfaEnrollment.POST("/api/mfa/enroll/confirm", ConfirmMFAEnrollmentHandler)
+	authenticated.POST("/api/mfa/disable", DisableMFAHandler)
+	authenticated.POST("/api/mfa/recovery-codes", RegenerateRecoveryCodesHandler)
+	managerOnly.DELETE("/api/user/:userId/mfa", func(c *gin.Context) {
+		ResetUserMFAHandler(c, db)
+	})
+	managerOnly.DELETE("/api/user/:userId/lockout", func(c *gin.Context) {
+		UnlockUserHandler(c, db)
+	})
 
 	r.GET("/meow", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"data": "a"})
@@ -140,51 +261,131 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *utils.Hub) {
 	})
 
 	managerController := &ManagerController{DB: db}
-	r.GET("/api/manager/dashboard/stats", managerController.GetDashboardStats)
-	r.GET("/api/manager/recent-bookings", managerController.GetRecentBookings)
-	r.GET("/api/manager/recent-packages", managerController.GetRecentPackages)
-	r.GET("/api/manager/user-statistics", managerController.GetUserStatistics)
-	r.GET("/api/manager/package-statistics", managerController.GetPackageStatistics)
-	r.GET("/api/manager/monthly-booking-stats", managerController.GetMonthlyBookingStats)
+	managerOnly.GET("/api/manager/dashboard/stats", managerController.GetDashboardStats)
+	managerOnly.GET("/api/manager/recent-bookings", managerController.GetRecentBookings)
+	managerOnly.GET("/api/manager/recent-packages", managerController.GetRecentPackages)
+	managerOnly.GET("/api/manager/user-statistics", managerController.GetUserStatistics)
+	managerOnly.GET("/api/manager/package-statistics", managerController.GetPackageStatistics)
+	managerOnly.GET("/api/manager/monthly-booking-stats", managerController.GetMonthlyBookingStats)
+	managerOnly.POST("/api/manager/impersonate/:userId", func(c *gin.Context) {
+		ImpersonateUserHandler(c, db)
+	})
+	managerOnly.GET("/api/manager/audit-logs", func(c *gin.Context) {
+		GetAuditLogsHandler(c, db)
+	})
+	managerOnly.GET("/api/manager/ledger/entries", func(c *gin.Context) {
+		GetLedgerEntriesHandler(c, db)
+	})
+	managerOnly.GET("/api/manager/ledger/trial-balance", func(c *gin.Context) {
+		GetTrialBalanceHandler(c, db)
+	})
+	managerOnly.GET("/api/manager/ledger/income-statement", func(c *gin.Context) {
+		GetIncomeStatementHandler(c, db)
+	})
 
 	discountCodeController := NewDiscountCodeController(db)
-	
-	r.GET("/api/manager/discount-codes", discountCodeController.GetAllDiscountCodes)
-	r.GET("/api/manager/global-discount-codes", discountCodeController.GetAllGlobalDiscountCodes)
-	r.GET("/api/manager/advertisers", discountCodeController.GetAllAdvertisers)
-	r.GET("/api/manager/packages", discountCodeController.GetAllPackages)
-	r.PUT("/api/discount-codes/:id/toggle", discountCodeController.ToggleDiscountCodeStatus)
-	r.PUT("/api/global-discount-codes/:id/toggle", discountCodeController.ToggleGlobalDiscountCodeStatus)
-	
-	r.POST("/api/discount-codes/advertiser", discountCodeController.CreateDiscountCodeForAdvertiser)
-	r.POST("/api/global-discount-codes", discountCodeController.CreateGlobalDiscountCode)
-	
-	r.GET("/api/advertiser/:advertiser_id/discount-codes", discountCodeController.GetDiscountCodesByAdvertiser)
-	
-	r.GET("/api/advertiser/:advertiser_id/commissions", discountCodeController.GetCommissionsByAdvertiser)
-	
-	r.POST("/api/discount-codes/validate", discountCodeController.ValidateDiscountCode)
-	
-	r.POST("/api/discount-codes/use", discountCodeController.UseDiscountCode)
-	
-	r.DELETE("/api/discount-codes/:id", discountCodeController.DeleteDiscountCode)
-	r.DELETE("/api/global-discount-codes/:id", discountCodeController.DeleteGlobalDiscountCode)
+
+	managerOnly.GET("/api/manager/discount-codes", discountCodeController.GetAllDiscountCodes)
+	managerOnly.GET("/api/manager/global-discount-codes", discountCodeController.GetAllGlobalDiscountCodes)
+	managerOnly.GET("/api/manager/advertisers", discountCodeController.GetAllAdvertisers)
+	managerOnly.GET("/api/manager/packages", discountCodeController.GetAllPackages)
+	managerOnly.PUT("/api/discount-codes/:id/toggle", discountCodeController.ToggleDiscountCodeStatus)
+	managerOnly.PUT("/api/global-discount-codes/:id/toggle", discountCodeController.ToggleGlobalDiscountCodeStatus)
+
+	managerOnly.POST("/api/discount-codes/advertiser", discountCodeController.CreateDiscountCodeForAdvertiser)
+	managerOnly.POST("/api/global-discount-codes", discountCodeController.CreateGlobalDiscountCode)
+
+	advertiserAPI(models.APIKeyScopeDiscountCodesRead).GET("/api/advertiser/:advertiser_id/discount-codes", SelfOrManagerMiddleware("advertiser_id"), discountCodeController.GetDiscountCodesByAdvertiser)
+
+	advertiserAPI(models.APIKeyScopeCommissionsRead).GET("/api/advertiser/:advertiser_id/commissions", SelfOrManagerMiddleware("advertiser_id"), discountCodeController.GetCommissionsByAdvertiser)
+	advertiserAPI(models.APIKeyScopeCommissionsRead).GET("/api/advertiser/:advertiser_id/commissions/preview", SelfOrManagerMiddleware("advertiser_id"), func(c *gin.Context) {
+		PreviewCommissionHandler(c, db)
+	})
+
+	managerOnly.GET("/api/manager/commission-rules", func(c *gin.Context) {
+		GetCommissionRulesHandler(c, db)
+	})
+	managerOnly.POST("/api/manager/commission-rules", func(c *gin.Context) {
+		CreateCommissionRuleHandler(c, db)
+	})
+	managerOnly.PUT("/api/manager/commission-rules/:id", func(c *gin.Context) {
+		UpdateCommissionRuleHandler(c, db)
+	})
+	managerOnly.DELETE("/api/manager/commission-rules/:id", func(c *gin.Context) {
+		DeleteCommissionRuleHandler(c, db)
+	})
+
+	managerOnly.GET("/api/manager/payout-batches", func(c *gin.Context) {
+		GetPayoutBatchesHandler(c, db)
+	})
+	managerOnly.POST("/api/manager/payout-batches", func(c *gin.Context) {
+		CreatePayoutBatchHandler(c, db)
+	})
+	managerOnly.GET("/api/manager/payout-batches/:id", func(c *gin.Context) {
+		GetPayoutBatchHandler(c, db)
+	})
+	managerOnly.POST("/api/manager/payout-batches/:id/approve", func(c *gin.Context) {
+		ApprovePayoutBatchHandler(c, db)
+	})
+	managerOnly.POST("/api/manager/payout-batches/:id/payouts/:payout_id/paid", func(c *gin.Context) {
+		MarkPayoutPaidHandler(c, db)
+	})
+	managerOnly.DELETE("/api/manager/payout-batches/:id", func(c *gin.Context) {
+		CancelPayoutBatchHandler(c, db)
+	})
+
+	r.GET("/api/exchange-rates", func(c *gin.Context) {
+		GetExchangeRatesHandler(c, db)
+	})
+	managerOnly.GET("/api/manager/exchange-rates", func(c *gin.Context) {
+		GetExchangeRateHistoryHandler(c, db)
+	})
+	managerOnly.POST("/api/manager/exchange-rates", func(c *gin.Context) {
+		AddExchangeRatesHandler(c, db)
+	})
+	managerOnly.POST("/api/manager/exchange-rates/import", func(c *gin.Context) {
+		ImportExchangeRatesHandler(c, db)
+	})
+
+	advertiserAPI(models.APIKeyScopeCommissionsRead).GET("/api/advertiser/:advertiser_id/payouts", SelfOrManagerMiddleware("advertiser_id"), func(c *gin.Context) {
+		GetAdvertiserPayoutsHandler(c, db)
+	})
+	advertiserAPI(models.APIKeyScopeCommissionsRead).GET("/api/advertiser/:advertiser_id/payouts/:payout_id", SelfOrManagerMiddleware("advertiser_id"), func(c *gin.Context) {
+		GetPayoutStatementHandler(c, db)
+	})
+
+	authenticated.POST("/api/discount-codes/validate", discountCodeController.ValidateDiscountCode)
+
+	authenticated.POST("/api/discount-codes/use", discountCodeController.UseDiscountCode)
+
+	advertisersOnly.GET("/api/advertiser/api-keys", func(c *gin.Context) {
+		ListAPIKeysHandler(c, db)
+	})
+	advertisersOnly.POST("/api/advertiser/api-keys", func(c *gin.Context) {
+		CreateAPIKeyHandler(c, db)
+	})
+	advertisersOnly.DELETE("/api/advertiser/api-keys/:id", func(c *gin.Context) {
+		RevokeAPIKeyHandler(c, db)
+	})
+
+	managerOnly.DELETE("/api/discount-codes/:id", discountCodeController.DeleteDiscountCode)
+	managerOnly.DELETE("/api/global-discount-codes/:id", discountCodeController.DeleteGlobalDiscountCode)
 
 	notificationController := NewNotificationController(db, hub)
-	
-	r.GET("/ws", notificationController.WebSocketHandler)
-	
-	r.POST("/api/notifications", notificationController.CreateNotification)
-	
-	r.POST("/api/notifications/broadcast", notificationController.BroadcastNotification)
-	
-	r.PUT("/api/notifications/:id/read", notificationController.MarkAsRead)
-	
-	r.PUT("/api/notifications/user/:user_id/read-all", notificationController.MarkAllAsRead)
-	
-	r.DELETE("/api/notifications/:id", notificationController.DeleteNotification)
-	
-	r.POST("/api/test-notification", notificationController.TestNotification)
+
+	r.GET("/ws", WebSocketTokenMiddleware(), AuthMiddleware(), webSocketUserMiddleware(), notificationController.WebSocketHandler)
+
+	managerOnly.POST("/api/notifications", notificationController.CreateNotification)
+
+	managerOnly.POST("/api/notifications/broadcast", notificationController.BroadcastNotification)
+
+	authenticated.PUT("/api/notifications/:id/read", notificationController.MarkAsRead)
+
+	authenticated.PUT("/api/notifications/user/:user_id/read-all", SelfOrManagerMiddleware("user_id"), notificationController.MarkAllAsRead)
+
+	authenticated.DELETE("/api/notifications/:id", notificationController.DeleteNotification)
+
+	managerOnly.POST("/api/test-notification", notificationController.TestNotification)
 
 	r.GET("/greet", func(c *gin.Context) {
 		greeting := "Hello from Supabase!"
@@ -192,4 +393,22 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, hub *utils.Hub) {
 	})
 
 	fmt.Println("All routes setup completed!")
-}
\ No newline at end of file
+}
+
+// webSocketUserMiddleware stops a signed-in user from subscribing to
+// somebody else's notifications with ?userID=.
+func webSocketUserMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested := c.Query("userID")
+		if requested == "" {
+			requested = c.Query("user_id")
+		}
+		userUUID, ok := currentUserID(c)
+		if !ok || requested != userUUID.String() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,473 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+	"trip-trader-backend/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Callers of the access matrix. Impersonated is a manager acting as a
+// customer; apiKey is an advertiser's key granted every scope.
+const (
+	anonymous    = "anonymous"
+	customer     = "customer"
+	advertiser   = "advertiser"
+	manager      = "manager"
+	managerNoMFA = "manager without 2FA"
+	impersonated = "impersonated"
+	apiKey       = "API key"
+	testAPIKey   = "tt_access_matrix"
+)
+
+var accessCallers = []string{anonymous, customer, advertiser, manager, managerNoMFA, impersonated, apiKey}
+
+type accessTest struct {
+	router       *gin.Engine
+	customerID   uuid.UUID
+	advertiserID uuid.UUID
+	managerID    uuid.UUID
+	packageID    uuid.UUID
+}
+
+func newAccessTest(t *testing.T) *accessTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	services.SetMailer(&services.MemoryMailer{})
+	a := &accessTest{customerID: uuid.New(), advertiserID: uuid.New(), managerID: uuid.New(), packageID: uuid.New()}
+
+	db, fake := fakesql.New(t)
+	fake.On(`SELECT count\(\*\) FROM "sessions"`).Return([]string{"count"}, []driver.Value{int64(1)})
+	fake.On(`FROM "api_keys"`).Return(
+		[]string{"id", "advertiser_id", "scopes", "last_used_at", "last_used_ip"},
+		[]driver.Value{uuid.NewString(), a.advertiserID.String(), strings.Join(models.APIKeyScopes(), " "), time.Now(), "192.0.2.1"},
+	)
+	fake.On(`COALESCE\(SUM`).Return([]string{"sum"}, []driver.Value{"0"})
+	fake.On(`FROM "public"."profiles"`).Return(
+		[]string{"id", "email", "user_role"},
+		[]driver.Value{a.advertiserID.String(), "advertiser@example.com", models.RoleAdvertiser},
+	)
+	fake.On(`SELECT \* FROM "travel_packages"`).Return([]string{"id", "title"}, []driver.Value{a.packageID.String(), "Access test"})
+	// The advertiser is assigned to the package, and to no other
+	fake.On(`SELECT count\(\*\) FROM "travel_packages"`).Return([]string{"count"}, []driver.Value{int64(0)})
+	fake.On(`SELECT count\(\*\) FROM "travel_packages"`).When(func(args []driver.Value) bool {
+		return containsValue(args, a.packageID.String()) && containsValue(args, a.advertiserID.String())
+	}).Return([]string{"count"}, []driver.Value{int64(1)})
+
+	a.router = gin.New()
+	SetupRoutes(a.router, db, utils.NewHub(db))
+	return a
+}
+
+// token signs an access token for one of the callers.
+func (a *accessTest) token(t *testing.T, caller string) string {
+	t.Helper()
+	claims := JWTClaims{SessionID: uuid.NewString(), RegisteredClaims: jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+	}}
+	switch caller {
+	case customer:
+		claims.UserID, claims.Role = a.customerID.String(), models.RoleCustomer
+	case advertiser:
+		claims.UserID, claims.Role = a.advertiserID.String(), models.RoleAdvertiser
+	case manager, managerNoMFA:
+		claims.UserID, claims.Role, claims.MFA = a.managerID.String(), models.RoleManager, caller == manager
+	case impersonated:
+		claims.UserID, claims.Role = a.customerID.String(), models.RoleCustomer
+		claims.Act = &ActorClaim{Subject: a.managerID.String()}
+	}
+	keys, err := services.JWTKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := keys.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (a *accessTest) do(t *testing.T, caller, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	switch caller {
+	case anonymous:
+	case apiKey:
+		req.Header.Set("X-API-Key", testAPIKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+a.token(t, caller))
+	}
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestRouteAccessMatrix sends one request to a route of every access group
+// as every kind of caller. Allowed requests reach the handler, which answers
+// the empty test database and body with its own status.
+func TestRouteAccessMatrix(t *testing.T) {
+	a := newAccessTest(t)
+	const (
+		unauthorized = http.StatusUnauthorized
+		forbidden    = http.StatusForbidden
+	)
+
+	routes := []struct {
+		group  string
+		method string
+		path   func(a *accessTest) string
+		want   map[string]int
+	}{
+		{
+			group: "r", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/ping" },
+			want: map[string]int{anonymous: 200, customer: 200, advertiser: 200, manager: 200, managerNoMFA: 200, impersonated: 200, apiKey: 200},
+		},
+		{
+			group: "authenticated", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/me/bookings" },
+			want: map[string]int{anonymous: unauthorized, customer: 200, advertiser: 200, manager: 200, managerNoMFA: forbidden, impersonated: 200, apiKey: unauthorized},
+		},
+		{
+			group: "authenticated write", method: http.MethodPost,
+			path: func(a *accessTest) string { return "/api/discount-codes/validate" },
+			want: map[string]int{anonymous: unauthorized, customer: 400, advertiser: 400, manager: 400, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "authenticated, someone else's profile", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/profile/" + a.advertiserID.String() },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: 200, manager: 200, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "mfaEnrollment", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/mfa" },
+			want: map[string]int{anonymous: unauthorized, customer: 200, advertiser: 200, manager: 200, managerNoMFA: 200, impersonated: 200, apiKey: unauthorized},
+		},
+		{
+			group: "advertisersAndManagers", method: http.MethodPost,
+			path: func(a *accessTest) string { return "/api/inclusions" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: 400, manager: 400, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "packageEditors, own package", method: http.MethodPut,
+			path: func(a *accessTest) string { return "/api/packages/" + a.packageID.String() + "/refund-policy" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: 200, manager: 200, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "packageEditors, other package", method: http.MethodPut,
+			path: func(a *accessTest) string { return "/api/packages/" + uuid.NewString() + "/refund-policy" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: forbidden, manager: 200, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "managerOnly", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/manager/audit-logs" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: forbidden, manager: 200, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "advertisersOnly", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/advertiser/api-keys" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: 200, manager: forbidden, managerNoMFA: forbidden, impersonated: forbidden, apiKey: unauthorized},
+		},
+		{
+			group: "advertiserAPI, own statements", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/advertiser/" + a.advertiserID.String() + "/payouts" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: 200, manager: 200, managerNoMFA: forbidden, impersonated: forbidden, apiKey: 200},
+		},
+		{
+			group: "advertiserAPI, someone else's statements", method: http.MethodGet,
+			path: func(a *accessTest) string { return "/api/advertiser/" + uuid.NewString() + "/payouts" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: forbidden, manager: 200, managerNoMFA: forbidden, impersonated: forbidden, apiKey: forbidden},
+		},
+		{
+			group: "packageEditorsAPI, own package", method: http.MethodPost,
+			path: func(a *accessTest) string { return "/api/packages/" + a.packageID.String() + "/departures" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: 400, manager: 400, managerNoMFA: forbidden, impersonated: forbidden, apiKey: 400},
+		},
+		{
+			group: "packageEditorsAPI, other package", method: http.MethodPost,
+			path: func(a *accessTest) string { return "/api/packages/" + uuid.NewString() + "/departures" },
+			want: map[string]int{anonymous: unauthorized, customer: forbidden, advertiser: forbidden, manager: 400, managerNoMFA: forbidden, impersonated: forbidden, apiKey: forbidden},
+		},
+	}
+
+	for _, route := range routes {
+		for _, caller := range accessCallers {
+			want, ok := route.want[caller]
+			if !ok {
+				t.Fatalf("%s: no expectation for %s", route.group, caller)
+			}
+			path := route.path(a)
+			rec := a.do(t, caller, route.method, path)
+			if rec.Code != want {
+				t.Errorf("%s %s (%s) as %s: status = %d, want %d: %s", route.method, path, route.group, caller, rec.Code, want, rec.Body)
+			}
+		}
+	}
+}
+
+// Access groups of SetupRoutes. TestEveryRouteHasAccessExpectations names
+// the advertiser's own profile and package in the path, so ownership checks
+// pass and advertisersAndManagers also covers packageEditors and
+// SelfOrManagerMiddleware routes.
+const (
+	public                 = "public"
+	authenticated          = "authenticated"
+	mfaEnrollment          = "mfaEnrollment"
+	advertisersAndManagers = "advertisersAndManagers"
+	advertiserAPI          = "advertiserAPI"
+	managerOnly            = "managerOnly"
+	advertisersOnly        = "advertisersOnly"
+)
+
+// routeAccess is the expected outcome of a route: the access group decides
+// who is turned away, and code is what the handler answers everyone else.
+// Overrides replace the status of single callers the handler itself refuses.
+type routeAccess struct {
+	group     string
+	code      int
+	overrides map[string]int
+}
+
+func (r routeAccess) want(method string) map[string]int {
+	const (
+		unauthorized = http.StatusUnauthorized
+		forbidden    = http.StatusForbidden
+	)
+	want := map[string]int{anonymous: unauthorized, apiKey: unauthorized}
+	for _, caller := range []string{customer, advertiser, manager, managerNoMFA, impersonated} {
+		want[caller] = forbidden
+	}
+	readOnly := isReadOnlyMethod(method)
+
+	switch r.group {
+	case public:
+		for _, caller := range accessCallers {
+			want[caller] = r.code
+		}
+	case authenticated, mfaEnrollment:
+		want[customer], want[advertiser], want[manager] = r.code, r.code, r.code
+		if r.group == mfaEnrollment {
+			want[managerNoMFA] = r.code
+		}
+		if readOnly {
+			want[impersonated] = r.code
+		}
+	case advertisersAndManagers:
+		want[advertiser], want[manager] = r.code, r.code
+	case advertiserAPI:
+		want[advertiser], want[manager], want[apiKey] = r.code, r.code, r.code
+	case managerOnly:
+		want[manager] = r.code
+	case advertisersOnly:
+		want[advertiser] = r.code
+	}
+	for caller, code := range r.overrides {
+		want[caller] = code
+	}
+	return want
+}
+
+// accessMatrix has an entry for every route SetupRoutes registers, keyed by
+// method and path template.
+var accessMatrix = map[string]routeAccess{
+	"GET /.well-known/jwks.json":                    {group: public, code: 200},
+	"GET /allPackages":                              {group: public, code: 200},
+	"GET /api/advertiser/:advertiser_id/packages":   {group: public, code: 200},
+	"GET /api/auth/oidc/:provider/callback":         {group: public, code: 404},
+	"GET /api/auth/oidc/:provider/login":            {group: public, code: 404},
+	"GET /api/auth/oidc/providers":                  {group: public, code: 200},
+	"POST /api/bookings/quote":                      {group: public, code: 400},
+	"POST /api/email/verify":                        {group: public, code: 400},
+	"GET /api/exchange-rates":                       {group: public, code: 200},
+	"GET /api/inclusions":                           {group: public, code: 200},
+	"POST /api/login":                               {group: public, code: 400},
+	"POST /api/login/mfa":                           {group: public, code: 400},
+	"GET /api/package/:id/advertisers":              {group: public, code: 404},
+	"GET /api/packages":                             {group: public, code: 200},
+	"GET /api/packages/tags":                        {group: public, code: 200},
+	"GET /api/packages/:id/departures":              {group: public, code: 200},
+	"GET /api/packages/:id/departures/:departureId": {group: public, code: 404},
+	"GET /api/packages/:id/inclusions":              {group: public, code: 200},
+	"GET /api/packages/:id/refund-policy":           {group: public, code: 200},
+	"POST /api/password/forgot":                     {group: public, code: 400},
+	"POST /api/password/reset":                      {group: public, code: 400},
+	"POST /api/signup":                              {group: public, code: 400},
+	"POST /api/token/refresh":                       {group: public, code: 400},
+	"GET /api/travel-packages":                      {group: public, code: 200},
+	"POST /api/webhooks/stripe":                     {group: public, code: 503},
+	"GET /greet":                                    {group: public, code: 200},
+	"GET /meow":                                     {group: public, code: 200},
+	"GET /package/:id":                              {group: public, code: 200},
+	"GET /ping":                                     {group: public, code: 200},
+
+	// The socket only accepts the user named in the query, the advertiser
+	// here, and a plain request then fails the upgrade
+	"GET /ws": {group: advertisersOnly, code: 400},
+
+	"POST /api/auth/oidc/:provider/link":          {group: authenticated, code: 404},
+	"PUT /api/booking/:bookingId/confirm-payment": {group: authenticated, code: 404},
+	"POST /api/booking/payment":                   {group: authenticated, code: 400},
+	"POST /api/bookings/:id/cancel":               {group: authenticated, code: 404},
+	"GET /api/bookings/:id/cancellation-preview":  {group: authenticated, code: 404},
+	"GET /api/bookings/:id/events":                {group: authenticated, code: 404},
+	"POST /api/discount-codes/use":                {group: authenticated, code: 400},
+	"POST /api/discount-codes/validate":           {group: authenticated, code: 400},
+	"POST /api/email/verify/resend":               {group: authenticated, code: 200},
+	"DELETE /api/me":                              {group: authenticated, code: 400},
+	"GET /api/me/bookings":                        {group: authenticated, code: 200},
+	"GET /api/me/bookings/:id":                    {group: authenticated, code: 404},
+	"GET /api/me/export":                          {group: authenticated, code: 200, overrides: map[string]int{impersonated: 403}},
+	"GET /api/me/identities":                      {group: authenticated, code: 200},
+	"POST /api/mfa/disable":                       {group: authenticated, code: 400, overrides: map[string]int{manager: 403}},
+	"POST /api/mfa/recovery-codes":                {group: authenticated, code: 400},
+	"DELETE /api/notifications/:id":               {group: authenticated, code: 200},
+	"PUT /api/notifications/:id/read":             {group: authenticated, code: 200},
+	"GET /api/user/current/role":                  {group: authenticated, code: 501},
+	"PUT /package/:id/bookings":                   {group: authenticated, code: 404},
+
+	"POST /api/logout":             {group: mfaEnrollment, code: 200},
+	"POST /api/logout-all":         {group: mfaEnrollment, code: 200},
+	"GET /api/mfa":                 {group: mfaEnrollment, code: 200},
+	"POST /api/mfa/enroll":         {group: mfaEnrollment, code: 200},
+	"POST /api/mfa/enroll/confirm": {group: mfaEnrollment, code: 400},
+
+	"POST /api/inclusions":                          {group: advertisersAndManagers, code: 400},
+	"POST /api/packages":                            {group: advertisersAndManagers, code: 200},
+	"PUT /api/packages/:id/refund-policy":           {group: advertisersAndManagers, code: 200},
+	"GET /api/profile/:userId":                      {group: advertisersAndManagers, code: 200},
+	"PUT /api/profile/:userId":                      {group: advertisersAndManagers, code: 200},
+	"PUT /api/notifications/user/:user_id/read-all": {group: advertisersAndManagers, code: 200},
+	"POST /api/travel-packages":                     {group: advertisersAndManagers, code: 200},
+	"PUT /api/travel-packages/:id":                  {group: advertisersAndManagers, code: 200},
+	"DELETE /api/travel-packages/:id":               {group: advertisersAndManagers, code: 200},
+	"POST /meow":                                    {group: advertisersAndManagers, code: 200},
+	"GET /api/bookings":                             {group: advertisersAndManagers, code: 200, overrides: map[string]int{advertiser: 403}},
+
+	"GET /api/advertiser/:advertiser_id/commissions":         {group: advertiserAPI, code: 200},
+	"GET /api/advertiser/:advertiser_id/commissions/preview": {group: advertiserAPI, code: 400},
+	"GET /api/advertiser/:advertiser_id/discount-codes":      {group: advertiserAPI, code: 200},
+	"GET /api/advertiser/:advertiser_id/payouts":             {group: advertiserAPI, code: 200},
+	"GET /api/advertiser/:advertiser_id/payouts/:payout_id":  {group: advertiserAPI, code: 404},
+	"GET /api/bookings/package/:packageId":                   {group: advertiserAPI, code: 200},
+	"GET /package/userList/:packageId":                       {group: advertiserAPI, code: 200},
+	"PUT /api/packages/:id":                                  {group: advertiserAPI, code: 200},
+	"POST /api/packages/:id/departures":                      {group: advertiserAPI, code: 400},
+	"PUT /api/packages/:id/departures/:departureId":          {group: advertiserAPI, code: 404},
+	"DELETE /api/packages/:id/departures/:departureId":       {group: advertiserAPI, code: 404},
+	"PUT /api/packages/:id/inclusions":                       {group: advertiserAPI, code: 200},
+
+	"GET /api/advertiser/api-keys":        {group: advertisersOnly, code: 200},
+	"POST /api/advertiser/api-keys":       {group: advertisersOnly, code: 400},
+	"DELETE /api/advertiser/api-keys/:id": {group: advertisersOnly, code: 200},
+
+	"DELETE /api/discount-codes/:id":                               {group: managerOnly, code: 404},
+	"POST /api/discount-codes/advertiser":                          {group: managerOnly, code: 400},
+	"PUT /api/discount-codes/:id/toggle":                           {group: managerOnly, code: 404},
+	"POST /api/global-discount-codes":                              {group: managerOnly, code: 400},
+	"DELETE /api/global-discount-codes/:id":                        {group: managerOnly, code: 404},
+	"PUT /api/global-discount-codes/:id/toggle":                    {group: managerOnly, code: 404},
+	"GET /api/manager/advertisers":                                 {group: managerOnly, code: 200},
+	"GET /api/manager/audit-logs":                                  {group: managerOnly, code: 200},
+	"GET /api/manager/commission-rules":                            {group: managerOnly, code: 200},
+	"POST /api/manager/commission-rules":                           {group: managerOnly, code: 400},
+	"PUT /api/manager/commission-rules/:id":                        {group: managerOnly, code: 400},
+	"DELETE /api/manager/commission-rules/:id":                     {group: managerOnly, code: 404},
+	"GET /api/manager/dashboard/stats":                             {group: managerOnly, code: 200},
+	"GET /api/manager/discount-codes":                              {group: managerOnly, code: 200},
+	"GET /api/manager/exchange-rates":                              {group: managerOnly, code: 400},
+	"POST /api/manager/exchange-rates":                             {group: managerOnly, code: 400},
+	"POST /api/manager/exchange-rates/import":                      {group: managerOnly, code: 400},
+	"GET /api/manager/global-discount-codes":                       {group: managerOnly, code: 200},
+	"POST /api/manager/impersonate/:userId":                        {group: managerOnly, code: 200},
+	"GET /api/manager/ledger/entries":                              {group: managerOnly, code: 200},
+	"GET /api/manager/ledger/income-statement":                     {group: managerOnly, code: 500},
+	"GET /api/manager/ledger/trial-balance":                        {group: managerOnly, code: 500},
+	"GET /api/manager/monthly-booking-stats":                       {group: managerOnly, code: 500},
+	"GET /api/manager/package-statistics":                          {group: managerOnly, code: 200},
+	"GET /api/manager/packages":                                    {group: managerOnly, code: 200},
+	"GET /api/manager/payout-batches":                              {group: managerOnly, code: 200},
+	"POST /api/manager/payout-batches":                             {group: managerOnly, code: 400},
+	"GET /api/manager/payout-batches/:id":                          {group: managerOnly, code: 404},
+	"DELETE /api/manager/payout-batches/:id":                       {group: managerOnly, code: 404},
+	"POST /api/manager/payout-batches/:id/approve":                 {group: managerOnly, code: 404},
+	"POST /api/manager/payout-batches/:id/payouts/:payout_id/paid": {group: managerOnly, code: 400},
+	"GET /api/manager/recent-bookings":                             {group: managerOnly, code: 200},
+	"GET /api/manager/recent-packages":                             {group: managerOnly, code: 200},
+	"GET /api/manager/user-statistics":                             {group: managerOnly, code: 200},
+	"POST /api/notifications":                                      {group: managerOnly, code: 400},
+	"POST /api/notifications/broadcast":                            {group: managerOnly, code: 400},
+	"PUT /api/package/:id/advertisers":                             {group: managerOnly, code: 200},
+	"GET /api/profiles":                                            {group: managerOnly, code: 200},
+	"POST /api/test-notification":                                  {group: managerOnly, code: 400},
+	"DELETE /api/user/:userId/lockout":                             {group: managerOnly, code: 200},
+	"DELETE /api/user/:userId/mfa":                                 {group: managerOnly, code: 200},
+	"PUT /api/user/:userId/role":                                   {group: managerOnly, code: 400},
+	"GET /api/users":                                               {group: managerOnly, code: 200},
+}
+
+// accessPath fills the parameters of a route template, naming the
+// advertiser's own profile and package where a route checks ownership.
+func (a *accessTest) accessPath(template string) string {
+	parts := strings.Split(template, "/")
+	for i, part := range parts {
+		switch part {
+		case ":advertiser_id", ":userId", ":user_id":
+			parts[i] = a.advertiserID.String()
+		case ":id", ":packageId":
+			parts[i] = a.packageID.String()
+		default:
+			if strings.HasPrefix(part, ":") {
+				parts[i] = uuid.NewString()
+			}
+		}
+	}
+	path := strings.Join(parts, "/")
+	if template == "/ws" {
+		path += "?user_id=" + a.advertiserID.String()
+	}
+	return path
+}
+
+// TestEveryRouteHasAccessExpectations walks the routes SetupRoutes
+// registers, so a new route fails until it is added to accessMatrix.
+func TestEveryRouteHasAccessExpectations(t *testing.T) {
+	a := newAccessTest(t)
+	routes := a.router.Routes()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path+routes[i].Method < routes[j].Path+routes[j].Method })
+
+	registered := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		registered[key] = true
+		access, ok := accessMatrix[key]
+		if !ok {
+			t.Errorf("%s: no access expectations; add the route to accessMatrix", key)
+			continue
+		}
+		path := a.accessPath(route.Path)
+		for caller, want := range access.want(route.Method) {
+			rec := a.do(t, caller, route.Method, path)
+			if rec.Code != want {
+				t.Errorf("%s (%s) as %s: status = %d, want %d: %s", key, access.group, caller, rec.Code, want, rec.Body)
+			}
+		}
+	}
+	for key := range accessMatrix {
+		if !registered[key] {
+			t.Errorf("%s: in accessMatrix but not registered", key)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"trip-trader-backend/models"
+	"trip-trader-backend/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/webhook"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stripe caps webhook payloads well below this, anything bigger is not Stripe
+const maxStripeWebhookBody = int64(65536)
+
+var errStripeEventBookingNotFound = errors.New("no booking matches this stripe event")
+
+// StripeWebhookHandler receives signed events from Stripe and drives the
+// booking lifecycle from them. Each event ID is processed at most once.
+func StripeWebhookHandler(c *gin.Context, db *gorm.DB) {
+	webhookSecret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Stripe webhook secret is not configured"})
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxStripeWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	event, err := webhook.ConstructEventWithOptions(payload, c.GetHeader("Stripe-Signature"), webhookSecret,
+		webhook.ConstructEventOptions{IgnoreAPIVersionMismatch: true})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Stripe signature", "details": err.Error()})
+		return
+	}
+
+	var booking *models.Booking
+	confirmed := false
+	tx := db.Begin()
+
+	insert := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.StripeEvent{
+		ID:   event.ID,
+		Type: string(event.Type),
+	})
+	if insert.Error != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record Stripe event"})
+		return
+	}
+	if insert.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusOK, gin.H{"received": true, "duplicate": true})
+		return
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		booking, confirmed, err = handleCheckoutSessionCompleted(tx, event)
+	case "checkout.session.expired":
+		booking, err = handleCheckoutSessionExpired(tx, event)
+	case "charge.refunded":
+		booking, err = handleChargeRefunded(tx, event)
+	default:
+		log.Printf("Ignoring Stripe event %s of type %s", event.ID, event.Type)
+	}
+
+	if errors.Is(err, errStripeEventBookingNotFound) {
+		// Nothing we can do on retry either, so acknowledge it
+		log.Printf("Stripe event %s (%s): %v", event.ID, event.Type, err)
+		err = nil
+	}
+	if err != nil {
+		// Roll back the event record too, so Stripe's retry gets processed
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process Stripe event", "details": err.Error()})
+		return
+	}
+
+	if booking != nil {
+		tx.Model(&models.StripeEvent{}).Where("id = ?", event.ID).Update("booking_id", booking.ID)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit Stripe event"})
+		return
+	}
+
+	// Only the event that confirmed the booking announces it; confirm-payment
+	// may have got there first
+	if confirmed {
+		bookingConfirmed(*booking, db)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// handleCheckoutSessionCompleted confirms the booking a checkout paid for.
+// It reports whether this event did the confirming.
+func handleCheckoutSessionCompleted(tx *gorm.DB, event stripe.Event) (*models.Booking, bool, error) {
+	var checkoutSession stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
+		return nil, false, fmt.Errorf("failed to parse checkout session: %v", err)
+	}
+
+	booking, err := findBookingForCheckoutSession(tx, &checkoutSession)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if checkoutSession.PaymentStatus != stripe.CheckoutSessionPaymentStatusPaid {
+		// Delayed payment methods complete the session before the money arrives
+		log.Printf("Checkout session %s completed with payment status %s", checkoutSession.ID, checkoutSession.PaymentStatus)
+		return booking, false, nil
+	}
+
+	var paymentIntentID *string
+	if checkoutSession.PaymentIntent != nil {
+		paymentIntentID = &checkoutSession.PaymentIntent.ID
+	}
+
+	awaitingPayment := booking.PaymentStatus == models.PaymentStatusPending
+	if mismatch := checkoutChargeMismatch(*booking, &checkoutSession); awaitingPayment && mismatch != "" {
+		return booking, false, rejectMismatchedPayment(tx, *booking, paymentIntentID, mismatch)
+	}
+
+	confirmed, err := markBookingPaid(tx, booking.ID, paymentIntentID, models.ActorStripe, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if !confirmed {
+		if err := tx.First(booking, "id = ?", booking.ID).Error; err != nil {
+			return nil, false, err
+		}
+		if booking.Status == models.BookingStatusCancelled && booking.PaymentStatus != models.PaymentStatusPaid {
+			return booking, false, refundLatePayment(tx, *booking, paymentIntentID)
+		}
+		log.Printf("Booking %s was already %s/%s when checkout completed", booking.ID, booking.Status, booking.PaymentStatus)
+		return booking, false, nil
+	}
+
+	booking.Status = models.BookingStatusConfirmed
+	booking.PaymentStatus = models.PaymentStatusPaid
+	booking.StripePaymentIntentID = paymentIntentID
+	return booking, true, nil
+}
+
+// refundLatePayment gives the money back for a checkout completed after its
+// booking was cancelled, so the customer is not charged for seats they no
+// longer hold. A failed refund fails the event, and Stripe's retry tries
+// again with the same idempotency key.
+func refundLatePayment(tx *gorm.DB, booking models.Booking, paymentIntentID *string) error {
+	if paymentIntentID == nil {
+		return fmt.Errorf("booking %s was paid after it was cancelled, but the checkout has no payment intent to refund", booking.ID)
+	}
+	refund, err := services.RefundLatePayment(tx, booking, *paymentIntentID)
+	if err != nil {
+		return err
+	}
+	log.Printf("Booking %s was paid after it was cancelled, refunded as %s", booking.ID, *refund.StripeRefundID)
+	return nil
+}
+
+// checkoutChargeMismatch explains how what a checkout collected differs
+// from the booking's charge, or is empty when they match.
+func checkoutChargeMismatch(booking models.Booking, checkoutSession *stripe.CheckoutSession) string {
+	charge := booking.Charge()
+	if !strings.EqualFold(string(checkoutSession.Currency), string(charge.Currency())) ||
+		checkoutSession.AmountTotal != charge.Minor() {
+		return fmt.Sprintf("Checkout %s collected %d %s, the booking charges %s %s",
+			checkoutSession.ID, checkoutSession.AmountTotal, strings.ToUpper(string(checkoutSession.Currency)), charge, charge.Currency())
+	}
+	return ""
+}
+
+// rejectMismatchedPayment cancels a booking whose checkout collected a
+// different amount than it charges and gives the payment back, rather than
+// posting revenue the booking never collected. A failed refund fails the
+// event, and Stripe's retry tries again.
+func rejectMismatchedPayment(tx *gorm.DB, booking models.Booking, paymentIntentID *string, mismatch string) error {
+	log.Printf("Booking %s: %s", booking.ID, mismatch)
+	if paymentIntentID == nil {
+		return fmt.Errorf("booking %s: %s, and the checkout has no payment intent to refund", booking.ID, mismatch)
+	}
+
+	bookingStates := &services.BookingStateService{DB: tx}
+	_, event, err := bookingStates.Transition(booking.ID, services.BookingTransition{
+		Status:        models.BookingStatusCancelled,
+		PaymentStatus: models.PaymentStatusFailed,
+		Actor:         models.ActorStripe,
+		Reason:        mismatch,
+	})
+	if err != nil && !errors.Is(err, services.ErrIllegalTransition) {
+		return err
+	}
+	if err == nil && event != nil {
+		reservations := &services.ReservationService{DB: tx}
+		if err := reservations.ReleaseBooking(tx, booking); err != nil {
+			return err
+		}
+	}
+
+	refund, err := services.RefundMismatchedPayment(tx, booking, *paymentIntentID, mismatch)
+	if err != nil {
+		return err
+	}
+	log.Printf("Booking %s was cancelled and its payment refunded as %s", booking.ID, *refund.StripeRefundID)
+	return nil
+}
+
+func handleCheckoutSessionExpired(tx *gorm.DB, event stripe.Event) (*models.Booking, error) {
+	var checkoutSession stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &checkoutSession); err != nil {
+		return nil, fmt.Errorf("failed to parse checkout session: %v", err)
+	}
+
+	booking, err := findBookingForCheckoutSession(tx, &checkoutSession)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := services.CancelExpiredBooking(tx, *booking); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+func handleChargeRefunded(tx *gorm.DB, event stripe.Event) (*models.Booking, error) {
+	var charge stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+		return nil, fmt.Errorf("failed to parse charge: %v", err)
+	}
+	if charge.PaymentIntent == nil {
+		return nil, errStripeEventBookingNotFound
+	}
+
+	var booking models.Booking
+	if err := tx.Where("stripe_payment_intent_id = ?", charge.PaymentIntent.ID).First(&booking).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errStripeEventBookingNotFound
+		}
+		return nil, err
+	}
+
+	// A cancellation refund whose result we failed to record is settled here
+	if err := settlePendingRefunds(tx, booking, &charge); err != nil {
+		return nil, err
+	}
+
+	// The money has left either way, even if the booking can't move
+	ledger := &services.LedgerService{DB: tx}
+	if err := ledger.PostRefund(booking.ID, booking.BaseAmount(models.NewMoney(charge.AmountRefunded, booking.Currency))); err != nil {
+		return nil, err
+	}
+
+	transition := services.BookingTransition{
+		PaymentStatus: models.PaymentStatusPartiallyRefunded,
+		Actor:         models.ActorStripe,
+		Reason:        fmt.Sprintf("Charge %s refunded", charge.ID),
+	}
+	if charge.Refunded {
+		transition.PaymentStatus = models.PaymentStatusRefunded
+		// Customer cancellations already moved the booking to cancelled
+		if booking.Status != models.BookingStatusCancelled {
+			transition.Status = models.BookingStatusRefunded
+		}
+	}
+
+	bookingStates := &services.BookingStateService{DB: tx}
+	if _, _, err := bookingStates.Transition(booking.ID, transition); err != nil {
+		if errors.Is(err, services.ErrIllegalTransition) {
+			log.Printf("Ignoring refund of charge %s: %v", charge.ID, err)
+			return &booking, nil
+		}
+		return nil, err
+	}
+
+	if charge.Refunded {
+		reservations := &services.ReservationService{DB: tx}
+		if err := reservations.ReleaseBooking(tx, booking); err != nil {
+			return nil, err
+		}
+	}
+	return &booking, nil
+}
+
+// settlePendingRefunds marks as succeeded the pending cancellation refunds
+// that one of the charge's refunds accounts for, matched by Stripe refund ID
+// or else by amount, and records that ID. Refunds that match nothing, for
+// instance when the event doesn't list the charge's refunds, stay pending
+// for RetryPendingRefunds, whose idempotency key finds the same refund.
+func settlePendingRefunds(tx *gorm.DB, booking models.Booking, charge *stripe.Charge) error {
+	if charge.Refunds == nil {
+		return nil
+	}
+	var records []models.BookingRefund
+	if err := tx.Where("booking_id = ?", booking.ID).Order("created_at ASC").Find(&records).Error; err != nil {
+		return err
+	}
+
+	claimed := map[string]bool{}
+	for _, record := range records {
+		if record.Status != models.RefundStatusPending && record.StripeRefundID != nil {
+			claimed[*record.StripeRefundID] = true
+		}
+	}
+	for _, record := range records {
+		if record.Status != models.RefundStatusPending {
+			continue
+		}
+		amount := booking.Charge().Percent(record.RefundPercentage).Minor()
+		for _, stripeRefund := range charge.Refunds.Data {
+			if stripeRefund == nil || claimed[stripeRefund.ID] || stripeRefund.Status != stripe.RefundStatusSucceeded {
+				continue
+			}
+			sameID := record.StripeRefundID != nil && *record.StripeRefundID == stripeRefund.ID
+			if !sameID && stripeRefund.Amount != amount {
+				continue
+			}
+			claimed[stripeRefund.ID] = true
+			if err := tx.Model(&models.BookingRefund{}).
+				Where("id = ? AND status = ?", record.ID, models.RefundStatusPending).
+				Updates(map[string]interface{}{
+					"status":           models.RefundStatusSucceeded,
+					"stripe_refund_id": stripeRefund.ID,
+				}).Error; err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// findBookingForCheckoutSession resolves the booking we attached to a
+// checkout session, preferring the client reference we set on creation.
+func findBookingForCheckoutSession(tx *gorm.DB, checkoutSession *stripe.CheckoutSession) (*models.Booking, error) {
+	var booking models.Booking
+
+	if bookingID, err := uuid.Parse(checkoutSession.ClientReferenceID); err == nil {
+		err := tx.First(&booking, "id = ?", bookingID).Error
+		if err == nil {
+			return &booking, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	err := tx.Where("stripe_payment_intent_id = ?", checkoutSession.ID).First(&booking).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errStripeEventBookingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
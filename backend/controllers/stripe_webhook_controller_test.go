@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v78/webhook"
+	"gorm.io/gorm"
+)
+
+const testWebhookSecret = "whsec_test"
+
+type webhookTest struct {
+	db        *gorm.DB
+	fake      *fakesql.DB
+	router    *gin.Engine
+	confirmed []models.Booking
+}
+
+func newWebhookTest(t *testing.T) *webhookTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+	t.Setenv("STRIPE_SECRET_KEY", "")
+
+	test := &webhookTest{}
+	test.db, test.fake = fakesql.New(t)
+	test.fake.On(`COALESCE\(SUM`).Return([]string{"sum"}, []driver.Value{"0"})
+
+	previous := bookingConfirmed
+	bookingConfirmed = func(booking models.Booking, db *gorm.DB) {
+		test.confirmed = append(test.confirmed, booking)
+	}
+	t.Cleanup(func() { bookingConfirmed = previous })
+
+	test.router = gin.New()
+	test.router.POST("/api/webhooks/stripe", func(c *gin.Context) {
+		StripeWebhookHandler(c, test.db)
+	})
+	return test
+}
+
+// withBooking makes every lookup of a booking return this one.
+func (w *webhookTest) withBooking(booking models.Booking) {
+	intent := ""
+	if booking.StripePaymentIntentID != nil {
+		intent = *booking.StripePaymentIntentID
+	}
+	w.fake.On(`SELECT \* FROM "bookings"`).Return(
+		[]string{"id", "customer_id", "package_id", "guest_count", "total_amount", "discount_amount", "final_amount",
+			"currency", "exchange_rate", "charged_amount", "status", "payment_status", "stripe_payment_intent_id"},
+		[]driver.Value{booking.ID.String(), booking.CustomerID.String(), booking.PackageID.String(), int64(booking.GuestCount),
+			"1000.00", "0", "1000.00", "THB", "1", "1000.00", booking.Status, booking.PaymentStatus, intent},
+	)
+	w.fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "max_guests", "current_bookings"},
+		[]driver.Value{booking.PackageID.String(), int64(20), int64(booking.GuestCount)},
+	)
+}
+
+func (w *webhookTest) send(t *testing.T, eventType string, object interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return w.sendEvent(t, "evt_"+uuid.NewString(), eventType, object, testWebhookSecret)
+}
+
+func (w *webhookTest) sendEvent(t *testing.T, id, eventType string, object interface{}, secret string) *httptest.ResponseRecorder {
+	t.Helper()
+	raw, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":          id,
+		"object":      "event",
+		"type":        eventType,
+		"api_version": "2024-04-10",
+		"created":     time.Now().Unix(),
+		"data":        map[string]json.RawMessage{"object": raw},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{Payload: payload, Secret: secret})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks/stripe", bytes.NewReader(signed.Payload))
+	req.Header.Set("Stripe-Signature", signed.Header)
+	rec := httptest.NewRecorder()
+	w.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func pendingBooking() models.Booking {
+	sessionID := "cs_test_" + uuid.NewString()
+	return models.Booking{
+		ID:                    uuid.New(),
+		CustomerID:            uuid.New(),
+		PackageID:             uuid.New(),
+		GuestCount:            2,
+		Status:                models.BookingStatusPending,
+		PaymentStatus:         models.PaymentStatusPending,
+		StripePaymentIntentID: &sessionID,
+	}
+}
+
+func paidSession(booking models.Booking) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                  *booking.StripePaymentIntentID,
+		"object":              "checkout.session",
+		"client_reference_id": booking.ID.String(),
+		"payment_status":      "paid",
+		"payment_intent":      "pi_test_123",
+		"amount_total":        100000,
+		"currency":            "thb",
+	}
+}
+
+func TestStripeWebhookRejectsBadSignature(t *testing.T) {
+	w := newWebhookTest(t)
+
+	rec := w.sendEvent(t, "evt_forged", "checkout.session.completed", paidSession(pendingBooking()), "whsec_wrong")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got := w.fake.Statements(`.`); len(got) != 0 {
+		t.Errorf("forged event reached the database: %v", got)
+	}
+}
+
+func TestStripeWebhookRejectsMissingSignature(t *testing.T) {
+	w := newWebhookTest(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks/stripe", bytes.NewReader([]byte(`{"id":"evt_1"}`)))
+	rec := httptest.NewRecorder()
+	w.router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestStripeWebhookSkipsDuplicateEvent(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	w.withBooking(booking)
+	// The event ID is already recorded, so ON CONFLICT DO NOTHING inserts nothing
+	w.fake.On(`INSERT INTO "stripe_events"`).Return([]string{"processed_at"})
+
+	rec := w.send(t, "checkout.session.completed", paidSession(booking))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["duplicate"] != true {
+		t.Errorf("body = %s, want duplicate", rec.Body)
+	}
+	if got := w.fake.Statements(`UPDATE "bookings"`); len(got) != 0 {
+		t.Errorf("duplicate event changed the booking: %v", got)
+	}
+	if len(w.confirmed) != 0 {
+		t.Errorf("duplicate event announced the booking %d times", len(w.confirmed))
+	}
+}
+
+func TestStripeWebhookCheckoutCompletedConfirmsBooking(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	w.withBooking(booking)
+
+	rec := w.send(t, "checkout.session.completed", paidSession(booking))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	updates := w.fake.Statements(`UPDATE "bookings" SET .*"status"`)
+	if len(updates) != 1 || !containsValue(updates[0].Args, models.BookingStatusConfirmed) || !containsValue(updates[0].Args, "pi_test_123") {
+		t.Fatalf("booking updates = %v, want one confirming it with the payment intent", updates)
+	}
+	if len(w.confirmed) != 1 || w.confirmed[0].ID != booking.ID {
+		t.Errorf("booking announced %d times, want once", len(w.confirmed))
+	}
+}
+
+func TestStripeWebhookCheckoutCompletedWithWrongAmountRefunds(t *testing.T) {
+	for name, change := range map[string]func(session map[string]interface{}){
+		"amount":   func(session map[string]interface{}) { session["amount_total"] = 90000 },
+		"currency": func(session map[string]interface{}) { session["currency"] = "usd" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := newWebhookTest(t)
+			booking := pendingBooking()
+			w.withBooking(booking)
+			session := paidSession(booking)
+			change(session)
+
+			rec := w.send(t, "checkout.session.completed", session)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+			}
+			updates := w.fake.Statements(`UPDATE "bookings" SET .*"status"`)
+			if len(updates) != 1 || !containsValue(updates[0].Args, models.BookingStatusCancelled) {
+				t.Fatalf("booking updates = %v, want one cancelling it", updates)
+			}
+			if refunds := w.fake.Statements(`INSERT INTO "booking_refunds"`); len(refunds) != 1 {
+				t.Errorf("refunds = %v, want the payment given back", refunds)
+			}
+			if entries := w.fake.Statements(`INSERT INTO "ledger_entries"`); len(entries) != 0 {
+				t.Errorf("ledger entries = %v, want no revenue posted", entries)
+			}
+			if len(w.confirmed) != 0 {
+				t.Errorf("booking announced %d times, want never", len(w.confirmed))
+			}
+		})
+	}
+}
+
+func TestStripeWebhookCheckoutCompletedForConfirmedBooking(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	booking.Status = models.BookingStatusConfirmed
+	booking.PaymentStatus = models.PaymentStatusPaid
+	w.withBooking(booking)
+
+	rec := w.send(t, "checkout.session.completed", paidSession(booking))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if len(w.confirmed) != 0 {
+		t.Errorf("already confirmed booking announced again %d times", len(w.confirmed))
+	}
+	if got := w.fake.Statements(`INSERT INTO "booking_refunds"`); len(got) != 0 {
+		t.Errorf("paid booking was refunded: %v", got)
+	}
+}
+
+func TestStripeWebhookCheckoutCompletedForCancelledBookingRefunds(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	booking.Status = models.BookingStatusCancelled
+	booking.PaymentStatus = models.PaymentStatusFailed
+	w.withBooking(booking)
+
+	rec := w.send(t, "checkout.session.completed", paidSession(booking))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	refunds := w.fake.Statements(`INSERT INTO "booking_refunds"`)
+	if len(refunds) != 1 || !containsValue(refunds[0].Args, booking.ID.String()) {
+		t.Fatalf("refunds = %v, want one for the cancelled booking", refunds)
+	}
+	if len(w.confirmed) != 0 {
+		t.Errorf("cancelled booking announced %d times", len(w.confirmed))
+	}
+}
+
+func TestStripeWebhookCheckoutCompletedUnpaid(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	w.withBooking(booking)
+	session := paidSession(booking)
+	session["payment_status"] = "unpaid"
+
+	rec := w.send(t, "checkout.session.completed", session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := w.fake.Statements(`UPDATE "bookings"`); len(got) != 0 {
+		t.Errorf("unpaid checkout changed the booking: %v", got)
+	}
+}
+
+func TestStripeWebhookCheckoutExpiredCancelsBooking(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	w.withBooking(booking)
+
+	rec := w.send(t, "checkout.session.expired", map[string]interface{}{
+		"id":                  *booking.StripePaymentIntentID,
+		"object":              "checkout.session",
+		"client_reference_id": booking.ID.String(),
+		"payment_status":      "unpaid",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	updates := w.fake.Statements(`UPDATE "bookings" SET .*"status"`)
+	if len(updates) != 1 || !containsValue(updates[0].Args, models.BookingStatusCancelled) {
+		t.Fatalf("booking updates = %v, want one cancelling it", updates)
+	}
+}
+
+func TestStripeWebhookChargeRefunded(t *testing.T) {
+	w := newWebhookTest(t)
+	booking := pendingBooking()
+	intent := "pi_test_123"
+	booking.StripePaymentIntentID = &intent
+	booking.Status = models.BookingStatusConfirmed
+	booking.PaymentStatus = models.PaymentStatusPaid
+	w.withBooking(booking)
+	// Only the half refund matches what Stripe refunded
+	halfRefund, otherRefund := uuid.New(), uuid.New()
+	w.fake.On(`SELECT \* FROM "booking_refunds"`).Return(
+		[]string{"id", "booking_id", "refund_percentage", "status"},
+		[]driver.Value{otherRefund.String(), booking.ID.String(), "100", models.RefundStatusPending},
+		[]driver.Value{halfRefund.String(), booking.ID.String(), "50", models.RefundStatusPending},
+	)
+
+	rec := w.send(t, "charge.refunded", map[string]interface{}{
+		"id":              "ch_test_123",
+		"object":          "charge",
+		"payment_intent":  intent,
+		"amount_refunded": 100000,
+		"refunded":        true,
+		"refunds": map[string]interface{}{
+			"object": "list",
+			"data": []map[string]interface{}{
+				{"id": "re_test_half", "object": "refund", "amount": 50000, "status": "succeeded"},
+				{"id": "re_test_failed", "object": "refund", "amount": 100000, "status": "failed"},
+			},
+		},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	updates := w.fake.Statements(`UPDATE "bookings" SET .*"status"`)
+	if len(updates) != 1 || !containsValue(updates[0].Args, models.BookingStatusRefunded) || !containsValue(updates[0].Args, models.PaymentStatusRefunded) {
+		t.Fatalf("booking updates = %v, want one refunding it", updates)
+	}
+	if got := w.fake.Statements(`INSERT INTO "ledger_entries"`); len(got) != 1 {
+		t.Errorf("ledger entries = %d, want the refund posted once", len(got))
+	}
+	got := w.fake.Statements(`UPDATE "booking_refunds"`)
+	if len(got) != 1 || !containsValue(got[0].Args, halfRefund.String()) || !containsValue(got[0].Args, "re_test_half") ||
+		!containsValue(got[0].Args, models.RefundStatusSucceeded) {
+		t.Errorf("refund updates = %v, want the half refund settled as re_test_half", got)
+	}
+}
+
+func TestStripeWebhookChargeRefundedForUnknownPayment(t *testing.T) {
+	w := newWebhookTest(t)
+
+	rec := w.send(t, "charge.refunded", map[string]interface{}{
+		"id":             "ch_test_123",
+		"object":         "charge",
+		"payment_intent": "pi_unknown",
+		"refunded":       true,
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := w.fake.Statements(`UPDATE "bookings"`); len(got) != 0 {
+		t.Errorf("unknown payment changed a booking: %v", got)
+	}
+}
+
+func TestStripeWebhookIgnoresOtherEvents(t *testing.T) {
+	w := newWebhookTest(t)
+
+	rec := w.send(t, "customer.created", map[string]interface{}{"id": "cus_123", "object": "customer"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if got := w.fake.Statements(`INSERT INTO "stripe_events"`); len(got) != 1 {
+		t.Errorf("event recorded %d times, want once", len(got))
+	}
+}
+
+func containsValue(args []driver.Value, want string) bool {
+	for _, arg := range args {
+		if text, ok := arg.(string); ok && text == want {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,10 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"strings"
 	"trip-trader-backend/models"
 	"trip-trader-backend/services"
@@ -61,6 +64,45 @@ func convertAllPackagesTags(packages []models.TravelPackage) []models.TravelPack
 	return packages
 }
 
+// packageWithPrice is a package as read by customers. DisplayPrice is its
+// price in the currency asked for with ?currency=, and the rate used.
+type packageWithPrice struct {
+	models.TravelPackage
+	DisplayPrice *services.Conversion `json:"display_price,omitempty"`
+}
+
+// withDisplayPrices converts the packages' prices, which are in the base
+// currency, at the rate in effect now for the currency in the query. It
+// answers the request itself when the currency can't be converted to.
+func withDisplayPrices(c *gin.Context, db *gorm.DB, packages []models.TravelPackage) ([]packageWithPrice, bool) {
+	result := make([]packageWithPrice, len(packages))
+	for i := range packages {
+		result[i].TravelPackage = packages[i]
+	}
+	if c.Query("currency") == "" {
+		return result, true
+	}
+
+	prices := make([]models.Money, len(packages))
+	for i := range packages {
+		prices[i] = packages[i].Price
+	}
+	exchangeRates := &services.ExchangeRateService{DB: db}
+	conversions, err := exchangeRates.ConvertAll(prices, models.Currency(c.Query("currency")))
+	if err != nil {
+		if errors.Is(err, services.ErrUnsupportedCurrency) || errors.Is(err, services.ErrExchangeRateNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert prices"})
+		}
+		return nil, false
+	}
+	for i := range result {
+		result[i].DisplayPrice = &conversions[i]
+	}
+	return result, true
+}
+
 func GetAllPackagesHandler(c *gin.Context, db *gorm.DB) {
 	println("Fetching all active packages with advertisers")
 	
@@ -73,7 +115,11 @@ func GetAllPackagesHandler(c *gin.Context, db *gorm.DB) {
 	
 	packages = convertAllPackagesTags(packages)
 	
-	c.JSON(200, packages)
+	priced, ok := withDisplayPrices(c, db, packages)
+	if !ok {
+		return
+	}
+	c.JSON(200, priced)
 }
 
 func GetPackageByIDHandler(c *gin.Context, db *gorm.DB) {
@@ -108,7 +154,11 @@ func GetPackageByIDHandler(c *gin.Context, db *gorm.DB) {
 	
 	convertTagsToArray(pkg)
 	
-	c.JSON(200, *pkg)
+	priced, ok := withDisplayPrices(c, db, []models.TravelPackage{*pkg})
+	if !ok {
+		return
+	}
+	c.JSON(200, priced[0])
 }
 
 func CreatePackageHandler(c *gin.Context, db *gorm.DB) {
@@ -133,7 +183,7 @@ func CreatePackageHandler(c *gin.Context, db *gorm.DB) {
 		pkg.ImageURL = imageURL
 	}
 	if price, ok := requestData["price"].(float64); ok {
-		pkg.Price = price
+		pkg.Price = jsonMoney(price)
 	}
 	if duration, ok := requestData["duration"].(float64); ok {
 		pkg.Duration = int(duration)
@@ -142,7 +192,7 @@ func CreatePackageHandler(c *gin.Context, db *gorm.DB) {
 		pkg.MaxGuests = int(maxGuests)
 	}
 	if discountPercentage, ok := requestData["discount_percentage"].(float64); ok {
-		pkg.DiscountPercentage = discountPercentage
+		pkg.DiscountPercentage = jsonDecimal(discountPercentage)
 	}
 	
 	if availableFrom, ok := requestData["available_from"].(string); ok && availableFrom != "" {
@@ -161,6 +211,13 @@ func CreatePackageHandler(c *gin.Context, db *gorm.DB) {
 	trueValue := true
 	pkg.IsActive = &trueValue
 
+	// Packages created by an advertiser belong to that advertiser
+	if role, _ := c.Get("role"); toString(role) == models.RoleAdvertiser {
+		if advertiserID, ok := currentUserID(c); ok {
+			pkg.AdvertiserID = &advertiserID
+		}
+	}
+
 	// Generate unique display_id (auto-increment style)
 	var maxDisplayID int
 	db.Model(&models.TravelPackage{}).Select("COALESCE(MAX(display_id), 0)").Scan(&maxDisplayID)
@@ -171,6 +228,9 @@ func CreatePackageHandler(c *gin.Context, db *gorm.DB) {
 		c.JSON(500, gin.H{"error": result.Error.Error()})
 		return
 	}
+	if pkg.AdvertiserID != nil {
+		db.Create(&models.PackageAdvertiser{TravelPackageID: pkg.ID, AdvertiserID: *pkg.AdvertiserID})
+	}
 	
 	convertTagsToArray(&pkg)
 	
@@ -213,7 +273,7 @@ func UpdatePackageHandler(c *gin.Context, db *gorm.DB) {
 		updateData["image_url"] = imageURL
 	}
 	if price, ok := requestData["price"].(float64); ok {
-		updateData["price"] = price
+		updateData["price"] = jsonMoney(price)
 	}
 	if duration, ok := requestData["duration"].(float64); ok {
 		updateData["duration"] = int(duration)
@@ -222,7 +282,7 @@ func UpdatePackageHandler(c *gin.Context, db *gorm.DB) {
 		updateData["max_guests"] = int(maxGuests)
 	}
 	if discountPercentage, ok := requestData["discount_percentage"].(float64); ok {
-		updateData["discount_percentage"] = discountPercentage
+		updateData["discount_percentage"] = jsonDecimal(discountPercentage)
 	}
 	
 	// Handle is_active boolean field
@@ -267,7 +327,15 @@ func UpdatePackageHandler(c *gin.Context, db *gorm.DB) {
 			   fmt.Printf("Total advertiser IDs parsed: %d\n", len(advertiserIds))
 			
 			if len(advertiserIds) > 0 {
-				if err := db.Where("travel_package_id = ?", packageUUID).Delete(&models.PackageAdvertiser{}).Error; err != nil {
+				previousIDs := []string{}
+	if err := db.Model(&models.PackageAdvertiser{}).
+		Where("travel_package_id = ?", packageUUID).
+		Pluck("advertiser_id", &previousIDs).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load current advertisers"})
+		return
+	}
+
+	if err := db.Where("travel_package_id = ?", packageUUID).Delete(&models.PackageAdvertiser{}).Error; err != nil {
 					c.JSON(500, gin.H{"error": "Failed to clear old relationships"})
 					return
 				}
@@ -326,30 +394,25 @@ func UpdatePackageHandler(c *gin.Context, db *gorm.DB) {
 	c.JSON(200, updatedPkg)
 }
 
+// UpdateCurrentBookingsHandler used to add guest_count to current_bookings.
+// Seats are now held by ReservationService when the booking is created, so
+// this only re-syncs the counter and returns the package.
 func UpdateCurrentBookingsHandler(c *gin.Context, db *gorm.DB) {
 	packageID := c.Param("id")
 	
-	type BookingUpdate struct {
-		GuestCount int `json:"guest_count"`
-	}
-	
-	var updateData BookingUpdate
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(400, gin.H{"error": "invalid request"})
-		return
-	}
-	
-	result := db.Model(&models.TravelPackage{}).
-		Where("id = ?", packageID).
-		Update("current_bookings", gorm.Expr("current_bookings + ?", updateData.GuestCount))
-	
-	if result.Error != nil {
-		c.JSON(500, gin.H{"error": result.Error.Error()})
+	packageUUID, err := uuid.Parse(packageID)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid package ID format"})
 		return
 	}
 	
-	if result.RowsAffected == 0 {
-		c.JSON(404, gin.H{"error": "Package not found"})
+	reservations := &services.ReservationService{DB: db}
+	if err := reservations.Release(nil, packageUUID, nil); err != nil {
+		if err == services.ErrPackageNotFound {
+			c.JSON(404, gin.H{"error": "Package not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 	
@@ -406,6 +469,14 @@ func UpdatePackageAdvertisersHandler(c *gin.Context, db *gorm.DB) {
 		return
 	}
 	
+	previousIDs := []string{}
+	if err := db.Model(&models.PackageAdvertiser{}).
+		Where("travel_package_id = ?", packageUUID).
+		Pluck("advertiser_id", &previousIDs).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load current advertisers"})
+		return
+	}
+
 	if err := db.Where("travel_package_id = ?", packageUUID).Delete(&models.PackageAdvertiser{}).Error; err != nil {
 		c.JSON(500, gin.H{"error": "Failed to clear old relationships"})
 		return
@@ -435,6 +506,10 @@ func UpdatePackageAdvertisersHandler(c *gin.Context, db *gorm.DB) {
 		}
 	}
 	
+	recordAudit(c, db, models.AuditActionPackageAdvertisersChanged, models.AuditEntityTravelPackage, packageUUID.String(),
+		gin.H{"advertiser_ids": previousIDs},
+		gin.H{"advertiser_ids": updateData.AdvertiserIds})
+
 	c.JSON(200, gin.H{"message": "Package advertisers updated successfully"})
 }
 
@@ -467,6 +542,7 @@ func DeletePackageHandler(c *gin.Context, db *gorm.DB) {
         c.JSON(500, gin.H{"error": "Failed to delete package"})
         return
     }
+    recordAudit(c, db, models.AuditActionPackageDeleted, models.AuditEntityTravelPackage, pkg.ID.String(), pkg, nil)
     c.JSON(200, gin.H{"message": "Package deleted"})
 }
 
@@ -509,4 +585,15 @@ func GetAllTravelPackages(db *gorm.DB) ([]models.TravelPackage, error) {
 	var packages []models.TravelPackage
 	result := db.Find(&packages)
 	return packages, result.Error
-}
\ No newline at end of file
+}
+// jsonMoney reads a baht amount decoded into a map. The shortest form of
+// the float is what the client sent, so no rounding error is carried over.
+func jsonMoney(value float64) models.Money {
+	amount, _ := models.ParseMoney(strconv.FormatFloat(value, 'f', -1, 64), models.DefaultCurrency)
+	return amount
+}
+
+func jsonDecimal(value float64) models.Decimal {
+	decimal, _ := models.ParseDecimal(strconv.FormatFloat(value, 'f', -1, 64))
+	return decimal
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestPackageReadsConvertPriceWithRateTimestamp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, fake := fakesql.New(t)
+	packageID, rateID := uuid.New(), uuid.New()
+	effectiveAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "title", "price", "is_active"},
+		[]driver.Value{packageID.String(), "Phi Phi day trip", "1999.00", true},
+	)
+	fake.On(`FROM "exchange_rates"`).Return(
+		[]string{"id", "base_currency", "currency", "rate", "effective_at"},
+		[]driver.Value{rateID.String(), "THB", "USD", "0.0275", effectiveAt},
+	)
+	router := gin.New()
+	router.GET("/api/packages", func(c *gin.Context) { GetAllPackagesHandler(c, db) })
+	router.GET("/package/:id", func(c *gin.Context) { GetPackageByIDHandler(c, db) })
+
+	type displayPrice struct {
+		Currency        string     `json:"currency"`
+		Amount          float64    `json:"amount"`
+		Converted       float64    `json:"converted"`
+		RateID          *string    `json:"rate_id"`
+		RateEffectiveAt *time.Time `json:"rate_effective_at"`
+	}
+	type pkg struct {
+		Price        float64       `json:"price"`
+		DisplayPrice *displayPrice `json:"display_price"`
+	}
+	get := func(path string, out interface{}) int {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+				t.Fatalf("GET %s: %v: %s", path, err, rec.Body)
+			}
+		}
+		return rec.Code
+	}
+	check := func(path string, got pkg) {
+		t.Helper()
+		price := got.DisplayPrice
+		if got.Price != 1999 || price == nil {
+			t.Fatalf("GET %s: package = %+v, want the THB price with a display price", path, got)
+		}
+		// 1999 baht at 0.0275 is 54.9725 dollars
+		if price.Currency != "USD" || price.Amount != 1999 || price.Converted != 54.97 {
+			t.Errorf("GET %s: display price = %+v, want 1999 THB as 54.97 USD", path, price)
+		}
+		if price.RateID == nil || *price.RateID != rateID.String() || price.RateEffectiveAt == nil || !price.RateEffectiveAt.Equal(effectiveAt) {
+			t.Errorf("GET %s: display price = %+v, want rate %s effective %s", path, price, rateID, effectiveAt)
+		}
+	}
+
+	var list []pkg
+	if code := get("/api/packages?currency=USD", &list); code != http.StatusOK || len(list) != 1 {
+		t.Fatalf("list: status %d, %d packages", code, len(list))
+	}
+	check("/api/packages?currency=USD", list[0])
+
+	var detail pkg
+	if code := get("/package/"+packageID.String()+"?currency=usd", &detail); code != http.StatusOK {
+		t.Fatalf("detail: status %d", code)
+	}
+	check("/package/"+packageID.String()+"?currency=usd", detail)
+
+	var plain []pkg
+	if code := get("/api/packages", &plain); code != http.StatusOK || len(plain) != 1 || plain[0].DisplayPrice != nil {
+		t.Errorf("list without a currency: status %d, packages %+v, want no display price", code, plain)
+	}
+	if code := get("/api/packages?currency=XYZ", &plain); code != http.StatusBadRequest {
+		t.Errorf("unsupported currency: status %d, want 400", code)
+	}
+}
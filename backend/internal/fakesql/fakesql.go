@@ -0,0 +1,241 @@
+// Package fakesql is a database/sql driver for tests that answers queries
+// from rules instead of a database. It lets handlers and services run
+// against gorm without Postgres; behaviour that depends on Postgres itself,
+// such as row locking, still needs a real database.
+//
+// A statement is answered by the last rule whose pattern matches it.
+// Unmatched SELECTs return no rows, unmatched INSERT ... RETURNING returns
+// one row with a fresh id, and other unmatched statements affect one row.
+package fakesql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// DB holds the rules and records every statement run against it.
+type DB struct {
+	mu         sync.Mutex
+	rules      []*Rule
+	statements []Statement
+}
+
+// Statement is one query or exec with its arguments.
+type Statement struct {
+	SQL  string
+	Args []driver.Value
+}
+
+// Rule answers the statements its pattern matches.
+type Rule struct {
+	pattern  *regexp.Regexp
+	when     func(args []driver.Value) bool
+	columns  []string
+	rows     [][]driver.Value
+	affected int64
+	err      error
+}
+
+// New returns a gorm connection backed by a fresh fake database.
+func New(t testing.TB) (*gorm.DB, *DB) {
+	t.Helper()
+	fake := &DB{}
+	conn := sql.OpenDB(connector{fake})
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open fake database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return db, fake
+}
+
+// On adds a rule for statements matching pattern, a case-insensitive
+// regular expression.
+func (d *DB) On(pattern string) *Rule {
+	rule := &Rule{pattern: regexp.MustCompile("(?is)" + pattern), affected: 1}
+	d.mu.Lock()
+	d.rules = append(d.rules, rule)
+	d.mu.Unlock()
+	return rule
+}
+
+// When restricts the rule to statements whose arguments satisfy match.
+func (r *Rule) When(match func(args []driver.Value) bool) *Rule {
+	r.when = match
+	return r
+}
+
+// Return makes the rule answer with rows of the given columns.
+func (r *Rule) Return(columns []string, rows ...[]driver.Value) *Rule {
+	r.columns, r.rows = columns, rows
+	return r
+}
+
+// Affect sets how many rows an exec reports changing.
+func (r *Rule) Affect(n int64) *Rule {
+	r.affected = n
+	return r
+}
+
+// Fail makes the rule return err.
+func (r *Rule) Fail(err error) *Rule {
+	r.err = err
+	return r
+}
+
+// Statements returns the statements run so far whose SQL matches pattern.
+func (d *DB) Statements(pattern string) []Statement {
+	re := regexp.MustCompile("(?is)" + pattern)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var matched []Statement
+	for _, statement := range d.statements {
+		if re.MatchString(statement.SQL) {
+			matched = append(matched, statement)
+		}
+	}
+	return matched
+}
+
+func (d *DB) answer(query string, args []driver.Value) *Rule {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, Statement{SQL: query, Args: args})
+	for i := len(d.rules) - 1; i >= 0; i-- {
+		rule := d.rules[i]
+		if rule.pattern.MatchString(query) && (rule.when == nil || rule.when(args)) {
+			return rule
+		}
+	}
+	return nil
+}
+
+var returningClause = regexp.MustCompile(`(?is)\bRETURNING\s+(.+)$`)
+
+func (d *DB) query(query string, args []driver.Value) (driver.Rows, error) {
+	rule := d.answer(query, args)
+	if rule != nil {
+		if rule.err != nil {
+			return nil, rule.err
+		}
+		return &rows{columns: rule.columns, values: rule.rows}, nil
+	}
+	if match := returningClause.FindStringSubmatch(query); match != nil && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "INSERT") {
+		var columns []string
+		var row []driver.Value
+		for _, column := range strings.Split(match[1], ",") {
+			column = strings.Trim(strings.TrimSpace(column), `"`)
+			columns = append(columns, column)
+			switch {
+			case column == "id":
+				row = append(row, uuid.New().String())
+			case strings.HasSuffix(column, "_at"):
+				row = append(row, time.Now())
+			default:
+				row = append(row, nil)
+			}
+		}
+		return &rows{columns: columns, values: [][]driver.Value{row}}, nil
+	}
+	return &rows{}, nil
+}
+
+func (d *DB) exec(query string, args []driver.Value) (driver.Result, error) {
+	rule := d.answer(query, args)
+	if rule == nil {
+		return driver.RowsAffected(1), nil
+	}
+	if rule.err != nil {
+		return nil, rule.err
+	}
+	return driver.RowsAffected(rule.affected), nil
+}
+
+type connector struct{ db *DB }
+
+func (c connector) Connect(context.Context) (driver.Conn, error) { return &conn{db: c.db}, nil }
+func (c connector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("fakesql: open with fakesql.New")
+}
+
+type conn struct{ db *DB }
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) { return &stmt{conn: c, query: query}, nil }
+func (c *conn) Close() error                              { return nil }
+func (c *conn) Begin() (driver.Tx, error)                 { return tx{}, nil }
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) { return tx{}, nil }
+
+func (c *conn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.db.query(query, values(args))
+}
+
+func (c *conn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.db.exec(query, values(args))
+}
+
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type stmt struct {
+	conn  *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) { return s.conn.db.exec(s.query, args) }
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error)  { return s.conn.db.query(s.query, args) }
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+	next    int
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	row := r.values[r.next]
+	if len(row) != len(dest) {
+		return fmt.Errorf("fakesql: row has %d values for %d columns", len(row), len(dest))
+	}
+	copy(dest, row)
+	r.next++
+	return nil
+}
+
+func values(named []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	return args
+}
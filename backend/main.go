@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"trip-trader-backend/controllers"
+	"trip-trader-backend/services"
 
 	// "trip-trader-backend/models"
 	"trip-trader-backend/utils"
@@ -19,8 +26,18 @@ func main() {
 	// Load .env for local dev
 	_ = godotenv.Load(".env")
 
-	// Set Gin mode
-	gin.SetMode(gin.ReleaseMode)
+	// Set Gin mode, release unless GIN_MODE says otherwise
+	if os.Getenv("GIN_MODE") == "" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// Real signing keys and secrets are mandatory in release mode
+	if _, err := services.InitJWTKeys(gin.Mode() == gin.ReleaseMode); err != nil {
+		log.Fatal("Failed to load JWT signing keys:", err)
+	}
+	if err := services.InitServerSecrets(gin.Mode() == gin.ReleaseMode); err != nil {
+		log.Fatal("Failed to load server secrets:", err)
+	}
 
 	db, err := initDatabase()
 	if err != nil {
@@ -68,7 +85,32 @@ func main() {
 	log.Println("Frontend: http://localhost:8080")
 	log.Printf("Backend API: http://localhost:%s", port)
 
-	router.Run(":" + port)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	schedulerDone := services.StartAutoCancelScheduler(ctx, db, services.AutoCancelInterval())
+
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Server failed:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+	<-schedulerDone
+	log.Println("Server stopped")
 }
 
 func initDatabase() (*gorm.DB, error) {
@@ -0,0 +1,21 @@
+-- Stripe Webhooks: Processed Event Log
+-- Description: Stores handled Stripe event IDs so webhook retries are idempotent
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS stripe_events (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    booking_id UUID REFERENCES bookings(id) ON DELETE SET NULL,
+    processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stripe_events_booking_id
+ON stripe_events(booking_id);
+
+-- Lookups from webhooks go through the Stripe session / payment intent ID
+CREATE INDEX IF NOT EXISTS idx_bookings_stripe_payment_intent_id
+ON bookings(stripe_payment_intent_id)
+WHERE stripe_payment_intent_id IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,21 @@
+-- Seat Reservations: Held Seat Lookups
+-- Description: ReservationService sums guest_count of seat-holding bookings
+-- on every booking attempt while the package row is locked, keep it fast
+
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS idx_bookings_package_id_held
+ON bookings(package_id, status, expires_at)
+WHERE status IN ('pending', 'confirmed', 'completed');
+
+-- Re-sync the counter with the seats actually held
+UPDATE travel_packages tp
+SET current_bookings = COALESCE((
+    SELECT SUM(b.guest_count)
+    FROM bookings b
+    WHERE b.package_id = tp.id
+      AND (b.status IN ('confirmed', 'completed')
+           OR (b.status = 'pending' AND (b.expires_at IS NULL OR b.expires_at > NOW())))
+), 0);
+
+COMMIT;
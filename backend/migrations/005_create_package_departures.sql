@@ -0,0 +1,30 @@
+-- Package Departures: Per-Date Inventory
+-- Description: A package can run on many dates, each with its own capacity
+-- and optional price; bookings point at the departure they hold seats on
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS package_departures (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    package_id UUID NOT NULL REFERENCES travel_packages(id) ON DELETE CASCADE,
+    departure_date DATE NOT NULL,
+    capacity INTEGER NOT NULL CHECK (capacity >= 0),
+    booked_seats INTEGER NOT NULL DEFAULT 0,
+    price_override NUMERIC(10,2),
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed', 'cancelled')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (package_id, departure_date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_package_departures_package_date
+ON package_departures(package_id, departure_date);
+
+ALTER TABLE bookings
+ADD COLUMN IF NOT EXISTS departure_id UUID REFERENCES package_departures(id);
+
+CREATE INDEX IF NOT EXISTS idx_bookings_departure_id_held
+ON bookings(departure_id, status, expires_at)
+WHERE departure_id IS NOT NULL AND status IN ('pending', 'confirmed', 'completed');
+
+COMMIT;
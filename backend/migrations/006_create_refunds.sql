@@ -0,0 +1,33 @@
+-- Cancellations: Refund Policies and Refund Records
+-- Description: Tiered refund rules per package (package_id NULL = platform
+-- default) and a record of every refund issued for a booking
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS refund_policy_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    package_id UUID REFERENCES travel_packages(id) ON DELETE CASCADE,
+    min_days_before_departure INTEGER NOT NULL CHECK (min_days_before_departure >= 0),
+    refund_percentage NUMERIC(5,2) NOT NULL CHECK (refund_percentage BETWEEN 0 AND 100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_refund_policy_rules_package_id
+ON refund_policy_rules(package_id);
+
+CREATE TABLE IF NOT EXISTS booking_refunds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    booking_id UUID NOT NULL REFERENCES bookings(id),
+    amount NUMERIC(10,2) NOT NULL,
+    refund_percentage NUMERIC(5,2) NOT NULL,
+    stripe_refund_id TEXT,
+    reason TEXT,
+    requested_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_booking_refunds_booking_id
+ON booking_refunds(booking_id);
+
+COMMIT;
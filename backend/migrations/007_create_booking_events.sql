@@ -0,0 +1,23 @@
+-- Booking State Machine: Transition History
+-- Description: Every status / payment status change of a booking, with the
+-- actor that caused it and why
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS booking_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    booking_id UUID NOT NULL REFERENCES bookings(id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL,
+    to_status TEXT NOT NULL,
+    from_payment_status TEXT NOT NULL,
+    to_payment_status TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    actor_id UUID,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_booking_events_booking_id
+ON booking_events(booking_id, created_at);
+
+COMMIT;
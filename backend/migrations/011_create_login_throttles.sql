@@ -0,0 +1,20 @@
+-- Auth: Login Throttling
+-- Description: Failed sign-in counters per email, client IP and 2FA user,
+-- shared by all replicas, for progressive delays and temporary lockouts
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS login_throttles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    scope TEXT NOT NULL CHECK (scope IN ('email', 'ip', 'mfa')),
+    key TEXT NOT NULL,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    last_failed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_login_throttles_scope_key ON login_throttles(scope, key);
+
+COMMIT;
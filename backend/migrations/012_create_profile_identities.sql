@@ -0,0 +1,31 @@
+-- Auth: OpenID Connect Login
+-- Description: External identities linked to profiles, and the pending
+-- authorization requests of the code + PKCE flow
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS profile_identities (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    profile_id UUID NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_profile_identities_provider_subject ON profile_identities(provider, subject);
+CREATE INDEX IF NOT EXISTS idx_profile_identities_profile_id ON profile_identities(profile_id);
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    redirect_path TEXT,
+    link_user_id UUID REFERENCES profiles(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+COMMIT;
@@ -0,0 +1,21 @@
+-- Support: Manager Impersonation Log
+-- Description: Every request a manager makes while impersonating a user
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS impersonation_logs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    manager_id UUID NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    target_user_id UUID NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    status_code INTEGER,
+    ip_address TEXT,
+    user_agent TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_impersonation_logs_manager_id ON impersonation_logs(manager_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_impersonation_logs_target_user_id ON impersonation_logs(target_user_id, created_at DESC);
+
+COMMIT;
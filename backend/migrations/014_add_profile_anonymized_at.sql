@@ -0,0 +1,9 @@
+-- Privacy: Account Deletion
+-- Description: Marks profiles anonymised at the user's request under PDPA;
+-- their bookings and commissions are kept for accounting
+
+BEGIN;
+
+ALTER TABLE profiles ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP WITH TIME ZONE;
+
+COMMIT;
@@ -0,0 +1,53 @@
+-- Promotions: Discount Code Redemptions
+-- Description: Tracks each booking's use of a discount code so usage limits
+-- can be enforced atomically, adds per-customer limits and minimum spend,
+-- and recounts current_uses, which was never maintained before
+
+BEGIN;
+
+ALTER TABLE discount_codes ADD COLUMN IF NOT EXISTS max_uses_per_customer INTEGER;
+ALTER TABLE discount_codes ADD COLUMN IF NOT EXISTS min_spend NUMERIC(10,2);
+ALTER TABLE global_discount_codes ADD COLUMN IF NOT EXISTS max_uses_per_customer INTEGER;
+ALTER TABLE global_discount_codes ADD COLUMN IF NOT EXISTS min_spend NUMERIC(10,2);
+
+CREATE TABLE IF NOT EXISTS discount_redemptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    booking_id UUID NOT NULL REFERENCES bookings(id) ON DELETE CASCADE,
+    customer_id UUID NOT NULL REFERENCES profiles(id) ON DELETE CASCADE,
+    discount_code_id UUID REFERENCES discount_codes(id) ON DELETE SET NULL,
+    global_code_id UUID REFERENCES global_discount_codes(id) ON DELETE SET NULL,
+    status TEXT NOT NULL CHECK (status IN ('reserved', 'redeemed', 'released')),
+    redeemed_at TIMESTAMP WITH TIME ZONE,
+    released_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- A booking holds at most one use at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_discount_redemptions_active_booking
+    ON discount_redemptions(booking_id) WHERE status <> 'released';
+CREATE INDEX IF NOT EXISTS idx_discount_redemptions_discount_code
+    ON discount_redemptions(discount_code_id, customer_id) WHERE status <> 'released';
+CREATE INDEX IF NOT EXISTS idx_discount_redemptions_global_code
+    ON discount_redemptions(global_code_id, customer_id) WHERE status <> 'released';
+
+-- Existing bookings that hold or used a code
+INSERT INTO discount_redemptions (booking_id, customer_id, discount_code_id, global_code_id, status, redeemed_at)
+SELECT b.id, b.customer_id, b.discount_code_id, b.global_code_id,
+       CASE WHEN b.status = 'pending' THEN 'reserved' ELSE 'redeemed' END,
+       CASE WHEN b.status = 'pending' THEN NULL ELSE b.updated_at END
+FROM bookings b
+WHERE (b.discount_code_id IS NOT NULL OR b.global_code_id IS NOT NULL)
+  AND (b.status IN ('confirmed', 'completed') OR (b.status = 'pending' AND (b.expires_at IS NULL OR b.expires_at > NOW())))
+  AND NOT EXISTS (SELECT 1 FROM discount_redemptions r WHERE r.booking_id = b.id);
+
+UPDATE discount_codes dc SET current_uses = (
+    SELECT COUNT(*) FROM discount_redemptions r
+    WHERE r.discount_code_id = dc.id AND r.status <> 'released'
+);
+UPDATE global_discount_codes gc SET current_uses = (
+    SELECT COUNT(*) FROM discount_redemptions r
+    WHERE r.global_code_id = gc.id AND r.status <> 'released'
+);
+
+COMMIT;
@@ -0,0 +1,35 @@
+-- Commissions: Configurable Commission Rules
+-- Description: Commission rules managers can set globally, per advertiser,
+-- per package or per advertiser and package. Seeds the global rule with the
+-- tiers that used to be hard-coded: 3% from 50% usage, 5% from 75%, 10% at
+-- 100%
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS commission_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    advertiser_id UUID REFERENCES profiles(id) ON DELETE CASCADE,
+    package_id UUID REFERENCES travel_packages(id) ON DELETE CASCADE,
+    type TEXT NOT NULL CHECK (type IN ('flat_percentage', 'usage_tiers', 'revenue_tiers', 'fixed_per_booking')),
+    rate NUMERIC(5,2) NOT NULL DEFAULT 0,
+    fixed_amount NUMERIC(10,2) NOT NULL DEFAULT 0,
+    tiers JSONB,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- One active rule per scope
+CREATE UNIQUE INDEX IF NOT EXISTS idx_commission_rules_active_scope ON commission_rules (
+    COALESCE(advertiser_id, '00000000-0000-0000-0000-000000000000'::uuid),
+    COALESCE(package_id, '00000000-0000-0000-0000-000000000000'::uuid)
+) WHERE is_active;
+
+INSERT INTO commission_rules (name, type, tiers)
+SELECT 'Default usage tiers', 'usage_tiers',
+       '[{"threshold": 0, "rate": 0}, {"threshold": 50, "rate": 3}, {"threshold": 75, "rate": 5}, {"threshold": 100, "rate": 10}]'::jsonb
+WHERE NOT EXISTS (SELECT 1 FROM commission_rules WHERE advertiser_id IS NULL AND package_id IS NULL AND is_active);
+
+COMMIT;
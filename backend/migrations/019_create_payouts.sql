@@ -0,0 +1,60 @@
+-- Commissions: Payout Batches
+-- Description: Monthly settlement batches that group pending commissions
+-- into one payout per advertiser, approved by a manager and marked paid
+-- with a payment reference. Commissions gain the payout they were settled
+-- in and, for clawbacks of paid commissions, the commission they reverse
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS payout_batches (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'approved', 'paid', 'cancelled')),
+    total_amount NUMERIC(12,2) NOT NULL DEFAULT 0,
+    payout_count INTEGER NOT NULL DEFAULT 0,
+    created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+    approved_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+    approved_at TIMESTAMP WITH TIME ZONE,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- One live batch per month
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payout_batches_period
+    ON payout_batches(period_start) WHERE status <> 'cancelled';
+
+CREATE TABLE IF NOT EXISTS payouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    batch_id UUID NOT NULL REFERENCES payout_batches(id) ON DELETE CASCADE,
+    advertiser_id UUID NOT NULL REFERENCES profiles(id),
+    amount NUMERIC(12,2) NOT NULL,
+    commission_count INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid')),
+    payment_reference TEXT,
+    paid_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (batch_id, advertiser_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payouts_advertiser ON payouts(advertiser_id, created_at DESC);
+
+ALTER TABLE commissions ADD COLUMN IF NOT EXISTS payout_id UUID REFERENCES payouts(id) ON DELETE SET NULL;
+ALTER TABLE commissions ADD COLUMN IF NOT EXISTS reversal_of_id UUID REFERENCES commissions(id);
+
+-- Cancellations have been writing 'reversed', which the original check
+-- did not allow
+ALTER TABLE commissions DROP CONSTRAINT IF EXISTS commissions_status_check;
+UPDATE commissions SET status = 'reversed' WHERE status = 'cancelled';
+ALTER TABLE commissions ADD CONSTRAINT commissions_status_check
+    CHECK (status IN ('pending', 'approved', 'paid', 'reversed'));
+
+CREATE INDEX IF NOT EXISTS idx_commissions_unsettled
+    ON commissions(advertiser_id, created_at) WHERE status = 'pending' AND payout_id IS NULL;
+CREATE INDEX IF NOT EXISTS idx_commissions_payout ON commissions(payout_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_commissions_reversal_of ON commissions(reversal_of_id);
+
+COMMIT;
@@ -0,0 +1,209 @@
+-- Accounting: Double-Entry Ledger
+-- Description: Append-only journal of every money movement: bookings,
+-- discounts, refunds, commissions and payouts. Entries must balance and can
+-- never be changed or deleted. Backfills the ledger from existing bookings,
+-- refunds, commissions and paid payouts
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    event_type TEXT NOT NULL CHECK (event_type IN ('booking_confirmed', 'payment_received', 'booking_refunded', 'commission_earned', 'commission_reversed', 'payout_paid')),
+    reference_id UUID NOT NULL,
+    booking_id UUID,
+    description TEXT,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Refunds are posted as they happen; everything else once per reference
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_event_reference
+    ON ledger_entries(event_type, reference_id) WHERE event_type <> 'booking_refunded';
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_reference ON ledger_entries(reference_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_booking ON ledger_entries(booking_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_occurred_at ON ledger_entries(occurred_at);
+
+CREATE TABLE IF NOT EXISTS ledger_lines (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id UUID NOT NULL REFERENCES ledger_entries(id),
+    account TEXT NOT NULL CHECK (account IN ('cash', 'customer_receivables', 'platform_revenue', 'discounts', 'refunds', 'commission_expense', 'advertiser_payable')),
+    advertiser_id UUID,
+    debit NUMERIC(12,2) NOT NULL DEFAULT 0,
+    credit NUMERIC(12,2) NOT NULL DEFAULT 0,
+    CHECK (debit >= 0 AND credit >= 0 AND (debit = 0) <> (credit = 0))
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_lines_entry ON ledger_lines(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_lines_account ON ledger_lines(account);
+CREATE INDEX IF NOT EXISTS idx_ledger_lines_advertiser ON ledger_lines(advertiser_id) WHERE advertiser_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION prevent_ledger_changes() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'the ledger is append-only, post a correcting entry instead';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_append_only ON ledger_entries;
+CREATE TRIGGER ledger_entries_append_only BEFORE UPDATE OR DELETE ON ledger_entries
+    FOR EACH ROW EXECUTE FUNCTION prevent_ledger_changes();
+DROP TRIGGER IF EXISTS ledger_lines_append_only ON ledger_lines;
+CREATE TRIGGER ledger_lines_append_only BEFORE UPDATE OR DELETE ON ledger_lines
+    FOR EACH ROW EXECUTE FUNCTION prevent_ledger_changes();
+
+-- Checked at commit, once all of an entry's lines are in
+CREATE OR REPLACE FUNCTION check_ledger_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF (SELECT SUM(debit) <> SUM(credit) FROM ledger_lines WHERE entry_id = NEW.entry_id) THEN
+        RAISE EXCEPTION 'ledger entry % does not balance', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_lines_balanced ON ledger_lines;
+CREATE CONSTRAINT TRIGGER ledger_lines_balanced AFTER INSERT ON ledger_lines
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_ledger_entry_balanced();
+
+-- Paid bookings
+INSERT INTO ledger_entries (event_type, reference_id, booking_id, description, occurred_at)
+SELECT 'booking_confirmed', b.id, b.id, 'Booking confirmed',
+       COALESCE((SELECT MIN(e.created_at) FROM booking_events e
+                 WHERE e.booking_id = b.id AND e.to_status = 'confirmed' AND e.from_status <> 'confirmed'), b.created_at)
+FROM bookings b
+WHERE b.payment_status IN ('paid', 'partially_refunded', 'refunded')
+  AND b.final_amount + COALESCE(b.discount_amount, 0) > 0
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_lines (entry_id, account, debit)
+SELECT le.id, 'customer_receivables', b.final_amount
+FROM ledger_entries le JOIN bookings b ON b.id = le.reference_id
+WHERE le.event_type = 'booking_confirmed' AND b.final_amount > 0
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id);
+
+INSERT INTO ledger_lines (entry_id, account, debit)
+SELECT le.id, 'discounts', b.discount_amount
+FROM ledger_entries le JOIN bookings b ON b.id = le.reference_id
+WHERE le.event_type = 'booking_confirmed' AND b.discount_amount > 0
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id AND ll.account = 'discounts');
+
+INSERT INTO ledger_lines (entry_id, account, credit)
+SELECT le.id, 'platform_revenue', b.final_amount + COALESCE(b.discount_amount, 0)
+FROM ledger_entries le JOIN bookings b ON b.id = le.reference_id
+WHERE le.event_type = 'booking_confirmed'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id AND ll.account = 'platform_revenue');
+
+-- Bookings were only confirmed once Stripe had taken the payment, so every
+-- paid booking's receivable was settled into cash
+INSERT INTO ledger_entries (event_type, reference_id, booking_id, description, occurred_at)
+SELECT 'payment_received', le.reference_id, le.booking_id, 'Payment received', le.occurred_at
+FROM ledger_entries le JOIN bookings b ON b.id = le.reference_id
+WHERE le.event_type = 'booking_confirmed' AND b.final_amount > 0
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_lines (entry_id, account, debit)
+SELECT le.id, 'cash', b.final_amount
+FROM ledger_entries le JOIN bookings b ON b.id = le.reference_id
+WHERE le.event_type = 'payment_received'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id);
+
+INSERT INTO ledger_lines (entry_id, account, credit)
+SELECT le.id, 'customer_receivables', b.final_amount
+FROM ledger_entries le JOIN bookings b ON b.id = le.reference_id
+WHERE le.event_type = 'payment_received'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id AND ll.account = 'customer_receivables');
+
+-- Refunds so far, one entry per booking, paid back out of cash. Refunds made from the Stripe
+-- dashboard have no booking_refunds row, so a fully refunded booking counts
+-- as its whole amount
+INSERT INTO ledger_entries (event_type, reference_id, booking_id, description, occurred_at)
+SELECT 'booking_refunded', b.id, b.id, 'Booking refunded',
+       COALESCE((SELECT MAX(r.created_at) FROM booking_refunds r WHERE r.booking_id = b.id), b.updated_at)
+FROM bookings b
+WHERE b.payment_status IN ('partially_refunded', 'refunded')
+  AND NOT EXISTS (SELECT 1 FROM ledger_entries le WHERE le.event_type = 'booking_refunded' AND le.reference_id = b.id)
+  AND GREATEST(COALESCE((SELECT SUM(r.amount) FROM booking_refunds r WHERE r.booking_id = b.id), 0),
+               CASE WHEN b.payment_status = 'refunded' THEN b.final_amount ELSE 0 END) > 0;
+
+INSERT INTO ledger_lines (entry_id, account, debit)
+SELECT le.id, 'refunds', refund.amount
+FROM ledger_entries le
+JOIN bookings b ON b.id = le.reference_id
+CROSS JOIN LATERAL (
+    SELECT GREATEST(COALESCE((SELECT SUM(r.amount) FROM booking_refunds r WHERE r.booking_id = b.id), 0),
+                    CASE WHEN b.payment_status = 'refunded' THEN b.final_amount ELSE 0 END) AS amount
+) refund
+WHERE le.event_type = 'booking_refunded'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id);
+
+INSERT INTO ledger_lines (entry_id, account, credit)
+SELECT le.id, 'cash', ll.debit
+FROM ledger_entries le
+JOIN ledger_lines ll ON ll.entry_id = le.id AND ll.account = 'refunds'
+WHERE le.event_type = 'booking_refunded'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines x WHERE x.entry_id = le.id AND x.account = 'cash');
+
+-- Commissions earned
+INSERT INTO ledger_entries (event_type, reference_id, booking_id, description, occurred_at)
+SELECT 'commission_earned', c.id, c.booking_id, 'Commission earned', c.created_at
+FROM commissions c
+WHERE c.reversal_of_id IS NULL AND c.commission_amount > 0
+ON CONFLICT DO NOTHING;
+
+-- Commissions reversed before payment, and clawbacks of paid ones
+INSERT INTO ledger_entries (event_type, reference_id, booking_id, description, occurred_at)
+SELECT 'commission_reversed', c.id, c.booking_id, 'Commission reversed', c.updated_at
+FROM commissions c
+WHERE c.reversal_of_id IS NULL AND c.status = 'reversed' AND c.commission_amount > 0
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_entries (event_type, reference_id, booking_id, description, occurred_at)
+SELECT 'commission_reversed', c.reversal_of_id, c.booking_id, 'Commission reversed', c.created_at
+FROM commissions c
+WHERE c.reversal_of_id IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_lines (entry_id, account, debit)
+SELECT le.id, 'commission_expense', c.commission_amount
+FROM ledger_entries le JOIN commissions c ON c.id = le.reference_id
+WHERE le.event_type = 'commission_earned'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id);
+
+INSERT INTO ledger_lines (entry_id, account, advertiser_id, debit)
+SELECT le.id, 'advertiser_payable', c.advertiser_id, c.commission_amount
+FROM ledger_entries le JOIN commissions c ON c.id = le.reference_id
+WHERE le.event_type = 'commission_reversed'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id);
+
+INSERT INTO ledger_lines (entry_id, account, advertiser_id, credit)
+SELECT le.id, 'advertiser_payable', c.advertiser_id, c.commission_amount
+FROM ledger_entries le JOIN commissions c ON c.id = le.reference_id
+WHERE le.event_type = 'commission_earned'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id AND ll.account = 'advertiser_payable');
+
+INSERT INTO ledger_lines (entry_id, account, credit)
+SELECT le.id, 'commission_expense', c.commission_amount
+FROM ledger_entries le JOIN commissions c ON c.id = le.reference_id
+WHERE le.event_type = 'commission_reversed'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id AND ll.account = 'commission_expense');
+
+-- Paid payouts
+INSERT INTO ledger_entries (event_type, reference_id, description, occurred_at)
+SELECT 'payout_paid', p.id, 'Payout paid, reference ' || COALESCE(p.payment_reference, ''), p.paid_at
+FROM payouts p
+WHERE p.status = 'paid' AND p.paid_at IS NOT NULL
+ON CONFLICT DO NOTHING;
+
+INSERT INTO ledger_lines (entry_id, account, advertiser_id, debit)
+SELECT le.id, 'advertiser_payable', p.advertiser_id, p.amount
+FROM ledger_entries le JOIN payouts p ON p.id = le.reference_id
+WHERE le.event_type = 'payout_paid'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id);
+
+INSERT INTO ledger_lines (entry_id, account, credit)
+SELECT le.id, 'cash', p.amount
+FROM ledger_entries le JOIN payouts p ON p.id = le.reference_id
+WHERE le.event_type = 'payout_paid'
+  AND NOT EXISTS (SELECT 1 FROM ledger_lines ll WHERE ll.entry_id = le.id AND ll.account = 'cash');
+
+COMMIT;
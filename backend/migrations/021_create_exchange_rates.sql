@@ -0,0 +1,29 @@
+-- Payments: Multi-Currency Pricing
+-- Description: Exchange rates from the base currency (THB), entered by hand
+-- or imported from CSV, and the currency, rate and amount each booking was
+-- charged in. Existing bookings were charged in baht at a rate of 1
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    base_currency TEXT NOT NULL DEFAULT 'THB',
+    currency TEXT NOT NULL CHECK (currency ~ '^[A-Z]{3}$' AND currency <> base_currency),
+    rate NUMERIC(18,8) NOT NULL CHECK (rate > 0),
+    source TEXT NOT NULL DEFAULT 'manual' CHECK (source IN ('manual', 'import')),
+    effective_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_by UUID REFERENCES profiles(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_exchange_rates_current ON exchange_rates(base_currency, currency, effective_at DESC, created_at DESC);
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB';
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC(18,8) NOT NULL DEFAULT 1 CHECK (exchange_rate > 0);
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS exchange_rate_id UUID REFERENCES exchange_rates(id) ON DELETE RESTRICT;
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS charged_amount NUMERIC(12,2);
+
+UPDATE bookings SET charged_amount = final_amount WHERE charged_amount IS NULL;
+ALTER TABLE bookings ALTER COLUMN charged_amount SET NOT NULL;
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Cancellations: Refund Status
+-- Description: A refund is recorded as pending with the cancellation and
+-- marked succeeded once Stripe has accepted it. Existing refunds were sent
+-- before they were recorded
+
+BEGIN;
+
+ALTER TABLE booking_refunds ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'succeeded'
+    CHECK (status IN ('pending', 'succeeded'));
+
+CREATE INDEX IF NOT EXISTS idx_booking_refunds_pending ON booking_refunds(created_at) WHERE status = 'pending';
+
+COMMIT;
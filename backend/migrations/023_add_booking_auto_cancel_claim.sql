@@ -0,0 +1,11 @@
+-- Bookings: Auto-cancel Claim
+-- Description: The auto-cancel scheduler claims expired bookings for a few
+-- minutes before asking Stripe to close their checkout sessions, so no row
+-- lock is held across the call and replicas don't work on the same booking.
+-- A claim that runs out, say after a crash, lets the next run try again
+
+BEGIN;
+
+ALTER TABLE bookings ADD COLUMN IF NOT EXISTS auto_cancel_claimed_until TIMESTAMP WITH TIME ZONE;
+
+COMMIT;
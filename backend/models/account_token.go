@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AccountTokenPasswordReset     = "password_reset"
+	AccountTokenEmailVerification = "email_verification"
+)
+
+// AccountToken is a single-use token mailed to a user to reset their
+// password or verify their email. Only its signature is stored.
+type AccountToken struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
+	Purpose   string     `json:"purpose" gorm:"type:text;not null"`
+	TokenHash string     `json:"-" gorm:"type:text;not null;unique"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"type:timestamp with time zone;not null"`
+	UsedAt    *time.Time `json:"used_at" gorm:"type:timestamp with time zone"`
+	CreatedAt time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (AccountToken) TableName() string {
+	return "account_tokens"
+}
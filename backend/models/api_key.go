@@ -0,0 +1,73 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// API key scopes, each opening a set of advertiser routes to key holders
+const (
+	APIKeyScopeDiscountCodesRead = "discount_codes:read"
+	APIKeyScopeCommissionsRead   = "commissions:read"
+	APIKeyScopeBookingsRead      = "bookings:read"
+	APIKeyScopePackagesWrite     = "packages:write"
+)
+
+// APIKeyScopes lists every scope a key can be granted.
+func APIKeyScopes() []string {
+	return []string{
+		APIKeyScopeDiscountCodesRead,
+		APIKeyScopeCommissionsRead,
+		APIKeyScopeBookingsRead,
+		APIKeyScopePackagesWrite,
+	}
+}
+
+// IsValidAPIKeyScope reports whether a scope exists.
+func IsValidAPIKeyScope(scope string) bool {
+	for _, valid := range APIKeyScopes() {
+		if scope == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey lets an advertiser's own systems call the API without a user
+// login. Only the SHA-256 of the key is kept; Prefix is its first part,
+// stored in clear so the advertiser can tell their keys apart.
+type APIKey struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	AdvertiserID uuid.UUID `json:"advertiser_id" gorm:"type:uuid;not null"`
+	Name         string    `json:"name" gorm:"type:text;not null"`
+	Prefix       string    `json:"prefix" gorm:"type:text;not null"`
+	KeyHash      string    `json:"-" gorm:"type:text;not null;unique"`
+	// Scopes is a space separated list of APIKeyScope values
+	Scopes     string     `json:"-" gorm:"type:text;not null"`
+	ExpiresAt  *time.Time `json:"expires_at" gorm:"type:timestamp with time zone"`
+	LastUsedAt *time.Time `json:"last_used_at" gorm:"type:timestamp with time zone"`
+	LastUsedIP string     `json:"last_used_ip" gorm:"type:text"`
+	RevokedAt  *time.Time `json:"revoked_at" gorm:"type:timestamp with time zone"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList returns the scopes granted to the key.
+func (k APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// HasScope reports whether the key was granted a scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, granted := range k.ScopeList() {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
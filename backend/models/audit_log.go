@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Audit log actions
+const (
+	AuditActionUserRoleChanged             = "user.role_changed"
+	AuditActionUserMFAReset                = "user.mfa_reset"
+	AuditActionUserUnlocked                = "user.unlocked"
+	AuditActionDiscountCodeStatusChanged   = "discount_code.status_changed"
+	AuditActionDiscountCodeDeleted         = "discount_code.deleted"
+	AuditActionGlobalDiscountStatusChanged = "global_discount_code.status_changed"
+	AuditActionGlobalDiscountCodeDeleted   = "global_discount_code.deleted"
+	AuditActionPackageDeleted              = "package.deleted"
+	AuditActionPackageAdvertisersChanged   = "package.advertisers_changed"
+	AuditActionAPIKeyCreated               = "api_key.created"
+	AuditActionAPIKeyRevoked               = "api_key.revoked"
+	AuditActionCommissionRuleCreated       = "commission_rule.created"
+	AuditActionCommissionRuleUpdated       = "commission_rule.updated"
+	AuditActionCommissionRuleDeleted       = "commission_rule.deleted"
+	AuditActionPayoutBatchCreated          = "payout_batch.created"
+	AuditActionPayoutBatchApproved         = "payout_batch.approved"
+	AuditActionPayoutBatchCancelled        = "payout_batch.cancelled"
+	AuditActionPayoutPaid                  = "payout.paid"
+	AuditActionExchangeRateAdded           = "exchange_rate.added"
+)
+
+// Audit log entity types
+const (
+	AuditEntityProfile            = "profile"
+	AuditEntityDiscountCode       = "discount_code"
+	AuditEntityGlobalDiscountCode = "global_discount_code"
+	AuditEntityTravelPackage      = "travel_package"
+	AuditEntityAPIKey             = "api_key"
+	AuditEntityCommissionRule     = "commission_rule"
+	AuditEntityPayoutBatch        = "payout_batch"
+	AuditEntityPayout             = "payout"
+	AuditEntityExchangeRate       = "exchange_rate"
+)
+
+// AuditLog records one privileged change. Before and After only hold the
+// fields that changed; a delete keeps the whole record in Before.
+type AuditLog struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ActorID    *uuid.UUID `json:"actor_id" gorm:"type:uuid"`
+	ActorRole  string     `json:"actor_role" gorm:"type:text"`
+	Action     string     `json:"action" gorm:"type:text;not null"`
+	EntityType string     `json:"entity_type" gorm:"type:text;not null"`
+	EntityID   string     `json:"entity_id" gorm:"type:text;not null"`
+	Before     JSONMap    `json:"before" gorm:"type:jsonb"`
+	After      JSONMap    `json:"after" gorm:"type:jsonb"`
+	IPAddress  string     `json:"ip_address" gorm:"type:text"`
+	UserAgent  string     `json:"user_agent" gorm:"type:text"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
@@ -10,11 +10,18 @@ type Booking struct {
 	ID                    uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
 	CustomerID            uuid.UUID `json:"customer_id" gorm:"type:uuid;not null"`
 	PackageID             uuid.UUID `json:"package_id" gorm:"type:uuid;not null"`
+	DepartureID           *uuid.UUID `json:"departure_id" gorm:"type:uuid"`
 	GuestCount            int       `json:"guest_count" gorm:"not null;default:1"`
 	BookingDate           string    `json:"booking_date" gorm:"type:date;not null"`
-	TotalAmount           float64   `json:"total_amount" gorm:"type:numeric;not null"`
-	DiscountAmount        float64   `json:"discount_amount" gorm:"type:numeric;default:0"`
-	FinalAmount           float64   `json:"final_amount" gorm:"type:numeric;not null"`
+	TotalAmount           Money     `json:"total_amount" gorm:"type:numeric;not null"`
+	DiscountAmount        Money     `json:"discount_amount" gorm:"type:numeric;default:0"`
+	FinalAmount           Money     `json:"final_amount" gorm:"type:numeric;not null"`
+	// The customer is charged FinalAmount converted into Currency at
+	// ExchangeRate; ChargedAmount is in Currency, see Charge
+	Currency              Currency   `json:"currency" gorm:"type:text;not null;default:THB"`
+	ExchangeRate          Rate       `json:"exchange_rate" gorm:"type:numeric(18,8);not null;default:1"`
+	ExchangeRateID        *uuid.UUID `json:"exchange_rate_id" gorm:"type:uuid"`
+	ChargedAmount         Decimal    `json:"charged_amount" gorm:"type:numeric(12,2);not null"`
 	DiscountCodeID        *uuid.UUID `json:"discount_code_id" gorm:"type:uuid"`
 	GlobalCodeID          *uuid.UUID `json:"global_code_id" gorm:"type:uuid"`
 	Status                string    `json:"status" gorm:"type:text;not null;default:'pending'"`
@@ -25,10 +32,31 @@ type Booking struct {
 	ContactEmail          string    `json:"contact_email" gorm:"type:text"`
 	SpecialRequests       *string   `json:"special_requests" gorm:"type:text"`
 	ExpiresAt             *time.Time `json:"expires_at" gorm:"type:timestamp with time zone"`
+	// AutoCancelClaimedUntil keeps other auto-cancel runs away from an
+	// expired booking while one of them deals with it
+	AutoCancelClaimedUntil *time.Time `json:"-" gorm:"type:timestamp with time zone"`
 	CreatedAt             time.Time `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
 	UpdatedAt             time.Time `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
 
 	// Relationships
 	TravelPackages *TravelPackage `json:"travel_packages" gorm:"foreignKey:PackageID"`
+	Departure      *PackageDeparture `json:"departure,omitempty" gorm:"foreignKey:DepartureID"`
 	Profile        *Profile       `json:"profile" gorm:"foreignKey:CustomerID;references:ID"`
+	DiscountCode   *DiscountCode       `json:"discount_code,omitempty" gorm:"foreignKey:DiscountCodeID"`
+	GlobalCode     *GlobalDiscountCode `json:"global_code,omitempty" gorm:"foreignKey:GlobalCodeID"`
+	Refunds        []BookingRefund     `json:"refunds,omitempty" gorm:"foreignKey:BookingID"`
+}
+
+// Charge is what the customer was charged, in the currency they paid in.
+func (b Booking) Charge() Money {
+	return MoneyFromDecimal(b.ChargedAmount, b.Currency)
+}
+
+// BaseAmount converts an amount in the charge currency back to the base
+// currency at the booking's rate, never more than the booking's final amount.
+func (b Booking) BaseAmount(charged Money) Money {
+	if !charged.LessThan(b.Charge()) {
+		return b.FinalAmount
+	}
+	return charged.ConvertBack(b.ExchangeRate, b.FinalAmount.Currency()).Min(b.FinalAmount)
 }
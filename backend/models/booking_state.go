@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BookingStatusPending   = "pending"
+	BookingStatusConfirmed = "confirmed"
+	BookingStatusCompleted = "completed"
+	BookingStatusCancelled = "cancelled"
+	BookingStatusRefunded  = "refunded"
+)
+
+const (
+	PaymentStatusPending           = "pending"
+	PaymentStatusPaid              = "paid"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
+	PaymentStatusRefunded          = "refunded"
+)
+
+// Who caused a booking transition
+const (
+	ActorSystem   = "system"
+	ActorStripe   = "stripe"
+	ActorCustomer = RoleCustomer
+	ActorManager  = RoleManager
+)
+
+var bookingStatusTransitions = map[string][]string{
+	BookingStatusPending:   {BookingStatusConfirmed, BookingStatusCancelled},
+	BookingStatusConfirmed: {BookingStatusCompleted, BookingStatusCancelled, BookingStatusRefunded},
+	BookingStatusCompleted: {BookingStatusRefunded},
+}
+
+var paymentStatusTransitions = map[string][]string{
+	PaymentStatusPending:           {PaymentStatusPaid, PaymentStatusFailed},
+	PaymentStatusPaid:              {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+	PaymentStatusPartiallyRefunded: {PaymentStatusPartiallyRefunded, PaymentStatusRefunded},
+}
+
+// CanTransitionBookingStatus reports whether a booking may move from one
+// status to another. Cancelled and refunded bookings are final.
+func CanTransitionBookingStatus(from, to string) bool {
+	return containsStatus(bookingStatusTransitions[from], to)
+}
+
+// CanTransitionPaymentStatus reports whether a booking's payment may move
+// from one status to another. Further partial refunds are allowed.
+func CanTransitionPaymentStatus(from, to string) bool {
+	return containsStatus(paymentStatusTransitions[from], to)
+}
+
+func containsStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// BookingEvent records one change of a booking's status or payment status.
+type BookingEvent struct {
+	ID                uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	BookingID         uuid.UUID  `json:"booking_id" gorm:"type:uuid;not null"`
+	FromStatus        string     `json:"from_status" gorm:"type:text;not null"`
+	ToStatus          string     `json:"to_status" gorm:"type:text;not null"`
+	FromPaymentStatus string     `json:"from_payment_status" gorm:"type:text;not null"`
+	ToPaymentStatus   string     `json:"to_payment_status" gorm:"type:text;not null"`
+	Actor             string     `json:"actor" gorm:"type:text;not null"`
+	ActorID           *uuid.UUID `json:"actor_id" gorm:"type:uuid"`
+	Reason            string     `json:"reason" gorm:"type:text"`
+	CreatedAt         time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (BookingEvent) TableName() string {
+	return "booking_events"
+}
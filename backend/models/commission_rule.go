@@ -0,0 +1,123 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// How a commission rule computes the advertiser's commission
+const (
+	CommissionRuleFlatPercentage  = "flat_percentage"
+	CommissionRuleUsageTiers      = "usage_tiers"
+	CommissionRuleRevenueTiers    = "revenue_tiers"
+	CommissionRuleFixedPerBooking = "fixed_per_booking"
+)
+
+// Which bookings a commission rule covers, from most to least specific
+const (
+	CommissionScopePackageAdvertiser = "package_advertiser"
+	CommissionScopePackage           = "package"
+	CommissionScopeAdvertiser        = "advertiser"
+	CommissionScopeGlobal            = "global"
+)
+
+// CommissionRuleTypes lists every rule type.
+func CommissionRuleTypes() []string {
+	return []string{
+		CommissionRuleFlatPercentage,
+		CommissionRuleUsageTiers,
+		CommissionRuleRevenueTiers,
+		CommissionRuleFixedPerBooking,
+	}
+}
+
+// CommissionTier applies Rate percent once the measured value (usage
+// percentage or monthly revenue) reaches Threshold.
+type CommissionTier struct {
+	Threshold Decimal `json:"threshold"`
+	Rate      Decimal `json:"rate"`
+}
+
+// CommissionTiers is stored as JSON, ordered by threshold.
+type CommissionTiers []CommissionTier
+
+func (t *CommissionTiers) Scan(value interface{}) error {
+	if value == nil {
+		*t = nil
+		return nil
+	}
+	var bytes []byte
+	switch v := value.(type) {
+	case []byte:
+		bytes = v
+	case string:
+		bytes = []byte(v)
+	default:
+		return errors.New("unsupported type for commission tiers")
+	}
+	if len(bytes) == 0 {
+		*t = nil
+		return nil
+	}
+	return json.Unmarshal(bytes, t)
+}
+
+func (t CommissionTiers) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	return json.Marshal(t)
+}
+
+// CommissionRule decides what an advertiser earns on a booking made with
+// their discount code. AdvertiserID and PackageID narrow the rule; a rule
+// with neither is the global default. Rate is a percentage for flat rules,
+// FixedAmount is paid per booking for fixed rules, and Tiers are used by the
+// tiered rules.
+type CommissionRule struct {
+	ID           uuid.UUID       `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Name         string          `json:"name" gorm:"type:text;not null"`
+	AdvertiserID *uuid.UUID      `json:"advertiser_id" gorm:"type:uuid"`
+	PackageID    *uuid.UUID      `json:"package_id" gorm:"type:uuid"`
+	Type         string          `json:"type" gorm:"type:text;not null"`
+	Rate         Decimal         `json:"rate" gorm:"type:numeric(5,2);not null;default:0"`
+	FixedAmount  Money           `json:"fixed_amount" gorm:"type:numeric(10,2);not null;default:0"`
+	Tiers        CommissionTiers `json:"tiers" gorm:"type:jsonb"`
+	IsActive     bool            `json:"is_active" gorm:"not null;default:true"`
+	CreatedBy    *uuid.UUID      `json:"created_by" gorm:"type:uuid"`
+	CreatedAt    time.Time       `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt    time.Time       `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+}
+
+func (CommissionRule) TableName() string {
+	return "commission_rules"
+}
+
+// Scope reports which bookings the rule covers.
+func (r CommissionRule) Scope() string {
+	switch {
+	case r.PackageID != nil && r.AdvertiserID != nil:
+		return CommissionScopePackageAdvertiser
+	case r.PackageID != nil:
+		return CommissionScopePackage
+	case r.AdvertiserID != nil:
+		return CommissionScopeAdvertiser
+	}
+	return CommissionScopeGlobal
+}
+
+// TierRate returns the rate of the highest tier the value reaches, or 0
+// when it reaches none.
+func (t CommissionTiers) TierRate(value Decimal) Decimal {
+	rate, reached := Decimal(0), Decimal(-1)
+	for _, tier := range t {
+		if value >= tier.Threshold && tier.Threshold > reached {
+			rate, reached = tier.Rate, tier.Threshold
+		}
+	}
+	return rate
+}
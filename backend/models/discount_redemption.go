@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RedemptionStatusReserved = "reserved"
+	RedemptionStatusRedeemed = "redeemed"
+	RedemptionStatusReleased = "released"
+)
+
+// DiscountRedemption is one use of a discount code by a booking. It is
+// reserved when the booking is created, redeemed when the booking is
+// confirmed and released when the booking is cancelled or expires. Reserved
+// and redeemed uses count towards the code's CurrentUses.
+type DiscountRedemption struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	BookingID      uuid.UUID  `json:"booking_id" gorm:"type:uuid;not null"`
+	CustomerID     uuid.UUID  `json:"customer_id" gorm:"type:uuid;not null"`
+	DiscountCodeID *uuid.UUID `json:"discount_code_id" gorm:"type:uuid"`
+	GlobalCodeID   *uuid.UUID `json:"global_code_id" gorm:"type:uuid"`
+	Status         string     `json:"status" gorm:"type:text;not null"`
+	RedeemedAt     *time.Time `json:"redeemed_at" gorm:"type:timestamp with time zone"`
+	ReleasedAt     *time.Time `json:"released_at" gorm:"type:timestamp with time zone"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+}
+
+func (DiscountRedemption) TableName() string {
+	return "discount_redemptions"
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Where an exchange rate came from.
+const (
+	ExchangeRateSourceManual = "manual"
+	ExchangeRateSourceImport = "import"
+)
+
+// ExchangeRate is how many units of Currency one unit of the base currency
+// buys from EffectiveAt on. Rates are never changed; a new rate is added
+// instead, so every booking's rate stays on record.
+type ExchangeRate struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	BaseCurrency Currency   `json:"base_currency" gorm:"type:text;not null"`
+	Currency     Currency   `json:"currency" gorm:"type:text;not null"`
+	Rate         Rate       `json:"rate" gorm:"type:numeric(18,8);not null"`
+	Source       string     `json:"source" gorm:"type:text;not null;default:manual"`
+	EffectiveAt  time.Time  `json:"effective_at" gorm:"type:timestamp with time zone;not null"`
+	CreatedBy    *uuid.UUID `json:"created_by" gorm:"type:uuid"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (ExchangeRate) TableName() string {
+	return "exchange_rates"
+}
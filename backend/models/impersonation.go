@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImpersonationLog records one request a manager made while impersonating
+// a user, and the request that started the impersonation.
+type ImpersonationLog struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ManagerID    uuid.UUID `json:"manager_id" gorm:"type:uuid;not null"`
+	TargetUserID uuid.UUID `json:"target_user_id" gorm:"type:uuid;not null"`
+	Method       string    `json:"method" gorm:"type:text;not null"`
+	Path         string    `json:"path" gorm:"type:text;not null"`
+	StatusCode   int       `json:"status_code"`
+	IPAddress    string    `json:"ip_address" gorm:"type:text"`
+	UserAgent    string    `json:"user_agent" gorm:"type:text"`
+	CreatedAt    time.Time `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (ImpersonationLog) TableName() string {
+	return "impersonation_logs"
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Ledger accounts
+const (
+	LedgerAccountCash                = "cash"
+	LedgerAccountCustomerReceivables = "customer_receivables"
+	LedgerAccountPlatformRevenue     = "platform_revenue"
+	LedgerAccountDiscounts           = "discounts"
+	LedgerAccountRefunds             = "refunds"
+	LedgerAccountCommissionExpense   = "commission_expense"
+	LedgerAccountAdvertiserPayable   = "advertiser_payable"
+)
+
+// Ledger account types
+const (
+	LedgerAccountTypeAsset         = "asset"
+	LedgerAccountTypeLiability     = "liability"
+	LedgerAccountTypeRevenue       = "revenue"
+	LedgerAccountTypeContraRevenue = "contra_revenue"
+	LedgerAccountTypeExpense       = "expense"
+)
+
+// Ledger event types, the business events that post entries
+const (
+	LedgerEventBookingConfirmed   = "booking_confirmed"
+	LedgerEventPaymentReceived    = "payment_received"
+	LedgerEventBookingRefunded    = "booking_refunded"
+	LedgerEventCommissionEarned   = "commission_earned"
+	LedgerEventCommissionReversed = "commission_reversed"
+	LedgerEventPayoutPaid         = "payout_paid"
+)
+
+// LedgerAccount is one account of the chart of accounts. Debit-normal
+// accounts grow with debits, the others with credits.
+type LedgerAccount struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	DebitNormal bool   `json:"debit_normal"`
+}
+
+// LedgerAccounts is the chart of accounts, in reporting order.
+func LedgerAccounts() []LedgerAccount {
+	return []LedgerAccount{
+		{Code: LedgerAccountCash, Name: "Cash", Type: LedgerAccountTypeAsset, DebitNormal: true},
+		{Code: LedgerAccountCustomerReceivables, Name: "Customer receivables", Type: LedgerAccountTypeAsset, DebitNormal: true},
+		{Code: LedgerAccountAdvertiserPayable, Name: "Advertiser payable", Type: LedgerAccountTypeLiability},
+		{Code: LedgerAccountPlatformRevenue, Name: "Platform revenue", Type: LedgerAccountTypeRevenue},
+		{Code: LedgerAccountDiscounts, Name: "Discounts", Type: LedgerAccountTypeContraRevenue, DebitNormal: true},
+		{Code: LedgerAccountRefunds, Name: "Refunds", Type: LedgerAccountTypeContraRevenue, DebitNormal: true},
+		{Code: LedgerAccountCommissionExpense, Name: "Commission expense", Type: LedgerAccountTypeExpense, DebitNormal: true},
+	}
+}
+
+// LedgerEntry is one balanced journal entry. Entries and their lines are
+// append-only; mistakes are corrected with a new entry.
+type LedgerEntry struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	EventType   string     `json:"event_type" gorm:"type:text;not null"`
+	ReferenceID uuid.UUID  `json:"reference_id" gorm:"type:uuid;not null"`
+	BookingID   *uuid.UUID `json:"booking_id" gorm:"type:uuid"`
+	Description string     `json:"description" gorm:"type:text"`
+	OccurredAt  time.Time  `json:"occurred_at" gorm:"type:timestamp with time zone;not null"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+
+	Lines []LedgerLine `json:"lines,omitempty" gorm:"foreignKey:EntryID"`
+}
+
+func (LedgerEntry) TableName() string {
+	return "ledger_entries"
+}
+
+// LedgerLine debits or credits one account. AdvertiserID is set on
+// advertiser payable lines so balances can be told apart per advertiser.
+type LedgerLine struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	EntryID      uuid.UUID  `json:"entry_id" gorm:"type:uuid;not null"`
+	Account      string     `json:"account" gorm:"type:text;not null"`
+	AdvertiserID *uuid.UUID `json:"advertiser_id" gorm:"type:uuid"`
+	Debit        Money      `json:"debit" gorm:"type:numeric(12,2);not null;default:0"`
+	Credit       Money      `json:"credit" gorm:"type:numeric(12,2);not null;default:0"`
+}
+
+func (LedgerLine) TableName() string {
+	return "ledger_lines"
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ThrottleScopeEmail = "email"
+	ThrottleScopeIP    = "ip"
+	ThrottleScopeMFA   = "mfa"
+)
+
+// LoginThrottle counts recent failed sign-in attempts for one email, client
+// IP or 2FA user. It lives in Postgres so every replica sees the same count.
+type LoginThrottle struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Scope        string     `json:"scope" gorm:"type:text;not null;uniqueIndex:idx_login_throttles_scope_key"`
+	Key          string     `json:"key" gorm:"type:text;not null;uniqueIndex:idx_login_throttles_scope_key"`
+	FailedCount  int        `json:"failed_count" gorm:"not null;default:0"`
+	LastFailedAt time.Time  `json:"last_failed_at" gorm:"type:timestamp with time zone;not null"`
+	LockedUntil  *time.Time `json:"locked_until" gorm:"type:timestamp with time zone"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt    time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+}
+
+func (LoginThrottle) TableName() string {
+	return "login_throttles"
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MFACredential is a user's TOTP secret, encrypted at rest. It only takes
+// effect once EnabledAt is set by confirming a first code.
+type MFACredential struct {
+	UserID          uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey"`
+	SecretEncrypted string     `json:"-" gorm:"type:text;not null"`
+	EnabledAt       *time.Time `json:"enabled_at" gorm:"type:timestamp with time zone"`
+	// LastUsedStep stops a code from being replayed within its window
+	LastUsedStep int64     `json:"-" gorm:"not null;default:0"`
+	CreatedAt    time.Time `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+}
+
+func (MFACredential) TableName() string {
+	return "mfa_credentials"
+}
+
+// MFARecoveryCode is a one-time code that stands in for a TOTP code when
+// the authenticator is lost. Only its hash is stored.
+type MFARecoveryCode struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
+	CodeHash  string     `json:"-" gorm:"type:text;not null"`
+	UsedAt    *time.Time `json:"used_at" gorm:"type:timestamp with time zone"`
+	CreatedAt time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (MFARecoveryCode) TableName() string {
+	return "mfa_recovery_codes"
+}
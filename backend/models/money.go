@@ -0,0 +1,546 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Currency is an ISO 4217 currency code.
+type Currency string
+
+const (
+	CurrencyTHB Currency = "THB"
+	CurrencyUSD Currency = "USD"
+	CurrencyEUR Currency = "EUR"
+	CurrencyGBP Currency = "GBP"
+	CurrencyJPY Currency = "JPY"
+	CurrencyCNY Currency = "CNY"
+	CurrencyKRW Currency = "KRW"
+	CurrencySGD Currency = "SGD"
+	CurrencyAUD Currency = "AUD"
+)
+
+// DefaultCurrency is the base currency: packages are priced in it, the
+// ledger is kept in it and amounts stored without a currency are in it.
+const DefaultCurrency = CurrencyTHB
+
+// currencyExponents is how many decimal places each supported currency's
+// minor unit has; baht are divided into 100 satang, yen and won are not
+// divided.
+var currencyExponents = map[Currency]int{
+	CurrencyTHB: 2,
+	CurrencyUSD: 2,
+	CurrencyEUR: 2,
+	CurrencyGBP: 2,
+	CurrencyJPY: 0,
+	CurrencyCNY: 2,
+	CurrencyKRW: 0,
+	CurrencySGD: 2,
+	CurrencyAUD: 2,
+}
+
+// SupportedCurrencies lists the currencies customers can be charged in.
+func SupportedCurrencies() []Currency {
+	return []Currency{CurrencyTHB, CurrencyUSD, CurrencyEUR, CurrencyGBP, CurrencyJPY, CurrencyCNY, CurrencyKRW, CurrencySGD, CurrencyAUD}
+}
+
+// ParseCurrency reads a currency code in any case. An empty code is the
+// default currency.
+func ParseCurrency(code string) (Currency, error) {
+	currency := Currency(strings.ToUpper(strings.TrimSpace(code))).orDefault()
+	if _, ok := currencyExponents[currency]; !ok {
+		return "", fmt.Errorf("%w: %q", errUnsupportedCurrency, code)
+	}
+	return currency, nil
+}
+
+// Exponent is the number of decimal places in the currency's minor unit.
+func (c Currency) Exponent() int {
+	if exponent, ok := currencyExponents[c.orDefault()]; ok {
+		return exponent
+	}
+	return 2
+}
+
+func (c Currency) orDefault() Currency {
+	if c == "" {
+		return DefaultCurrency
+	}
+	return c
+}
+
+// ErrCurrencyMismatch is returned when amounts in different currencies are
+// combined without converting one of them first.
+var ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+
+var (
+	errInvalidAmount       = errors.New("invalid amount")
+	errUnsupportedCurrency = errors.New("unsupported currency")
+)
+
+// Rounding rules: amounts are exact and are only rounded where a fraction
+// of a minor unit appears, which is when taking a percentage of an amount
+// and when reading a value with more decimals than the currency has. Both
+// round half away from zero, so 0.005 baht becomes 0.01 and -0.005 becomes
+// -0.01.
+
+// Money is an exact amount counted in the minor unit of its currency. The
+// zero value is zero in the default currency. Add, Sub and Cmp panic when
+// the currencies differ and are only for amounts that share one by
+// construction, such as a booking's own amounts; amounts from a request or
+// from different records are combined with the Checked variants.
+//
+// In the database Money is a numeric column in major units and in JSON a
+// number in major units, so stored data and API payloads are unchanged.
+type Money struct {
+	minor    int64
+	currency Currency
+}
+
+// NewMoney returns minor units (satang for baht) of a currency.
+func NewMoney(minor int64, currency Currency) Money {
+	return Money{minor: minor, currency: currency.orDefault()}
+}
+
+// ParseMoney reads a decimal amount in major units, such as "1250.50".
+func ParseMoney(value string, currency Currency) (Money, error) {
+	currency = currency.orDefault()
+	minor, err := parseScaled(value, currency.Exponent())
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{minor: minor, currency: currency}, nil
+}
+
+// MoneyFromDecimal converts a decimal amount in major units.
+func MoneyFromDecimal(d Decimal, currency Currency) Money {
+	currency = currency.orDefault()
+	return Money{minor: rescale(int64(d), decimalPlaces, currency.Exponent()), currency: currency}
+}
+
+func (m Money) Minor() int64 {
+	return m.minor
+}
+
+func (m Money) Currency() Currency {
+	return m.currency.orDefault()
+}
+
+func (m Money) IsZero() bool {
+	return m.minor == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.minor > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.minor < 0
+}
+
+func (m Money) Add(other Money) Money {
+	return must(m.CheckedAdd(other))
+}
+
+func (m Money) Sub(other Money) Money {
+	return must(m.CheckedSub(other))
+}
+
+// CheckedAdd is Add returning ErrCurrencyMismatch instead of panicking.
+func (m Money) CheckedAdd(other Money) (Money, error) {
+	currency, err := m.sameCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{minor: m.minor + other.minor, currency: currency}, nil
+}
+
+// CheckedSub is Sub returning ErrCurrencyMismatch instead of panicking.
+func (m Money) CheckedSub(other Money) (Money, error) {
+	currency, err := m.sameCurrency(other)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{minor: m.minor - other.minor, currency: currency}, nil
+}
+
+func (m Money) Neg() Money {
+	return Money{minor: -m.minor, currency: m.Currency()}
+}
+
+func (m Money) Abs() Money {
+	if m.minor < 0 {
+		return m.Neg()
+	}
+	return m
+}
+
+// Mul multiplies by a whole number, such as a guest count.
+func (m Money) Mul(n int64) Money {
+	return Money{minor: m.minor * n, currency: m.Currency()}
+}
+
+// Percent returns rate percent of the amount, rounded half away from zero.
+func (m Money) Percent(rate Decimal) Money {
+	return Money{minor: mulDivRound(m.minor, int64(rate), 100*pow10(decimalPlaces)), currency: m.Currency()}
+}
+
+// Cmp returns -1, 0 or 1 as m is less than, equal to or greater than other.
+func (m Money) Cmp(other Money) int {
+	return must(m.CheckedCmp(other))
+}
+
+// CheckedCmp is Cmp returning ErrCurrencyMismatch instead of panicking.
+func (m Money) CheckedCmp(other Money) (int, error) {
+	if _, err := m.sameCurrency(other); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.minor < other.minor:
+		return -1, nil
+	case m.minor > other.minor:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m Money) LessThan(other Money) bool {
+	return m.Cmp(other) < 0
+}
+
+func (m Money) Min(other Money) Money {
+	if other.LessThan(m) {
+		return other
+	}
+	return m
+}
+
+// Convert changes the amount into another currency at rate units of that
+// currency per unit of this one, rounded half away from zero.
+func (m Money) Convert(rate Rate, to Currency) Money {
+	to = to.orDefault()
+	numerator := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(int64(rate)))
+	numerator.Mul(numerator, big.NewInt(pow10(to.Exponent())))
+	denominator := big.NewInt(pow10(ratePlaces + m.Currency().Exponent()))
+	minor, _ := roundRat(new(big.Rat).SetFrac(numerator, denominator))
+	return Money{minor: minor, currency: to}
+}
+
+// ConvertBack undoes Convert: the amount is in the currency rate converts
+// into and comes back in the currency it converts from.
+func (m Money) ConvertBack(rate Rate, to Currency) Money {
+	to = to.orDefault()
+	if rate <= 0 {
+		return Money{currency: to}
+	}
+	numerator := new(big.Int).Mul(big.NewInt(m.minor), big.NewInt(pow10(ratePlaces+to.Exponent())))
+	denominator := new(big.Int).Mul(big.NewInt(int64(rate)), big.NewInt(pow10(m.Currency().Exponent())))
+	minor, _ := roundRat(new(big.Rat).SetFrac(numerator, denominator))
+	return Money{minor: minor, currency: to}
+}
+
+// Decimal is the amount in major units. Currencies with more than two
+// decimal places are rounded.
+func (m Money) Decimal() Decimal {
+	return Decimal(rescale(m.minor, m.Currency().Exponent(), decimalPlaces))
+}
+
+// Float64 is for display and ratios only, never for arithmetic on amounts.
+func (m Money) Float64() float64 {
+	value, _ := strconv.ParseFloat(m.String(), 64)
+	return value
+}
+
+// String formats the amount in major units, such as "1250.50".
+func (m Money) String() string {
+	return formatScaled(m.minor, m.Currency().Exponent())
+}
+
+// sameCurrency is the currency two amounts share. A zero value Money, such
+// as a fresh total, takes on the other amount's currency.
+func (m Money) sameCurrency(other Money) (Currency, error) {
+	switch {
+	case m.currency == "" && other.currency == "":
+		return DefaultCurrency, nil
+	case m.currency == "":
+		return other.currency, nil
+	case other.currency == "" || other.currency == m.currency:
+		return m.currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, other.currency)
+}
+
+func must[T any](value T, err error) T {
+	if err != nil {
+		panic("money: " + err.Error())
+	}
+	return value
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a numeric string in major units of the
+// amount's currency, read exactly rather than through a float.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value, err := jsonNumber(data)
+	if err != nil || value == "" {
+		return err
+	}
+	parsed, err := ParseMoney(value, m.currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+func (m *Money) Scan(value interface{}) error {
+	text, err := scannedNumber(value)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseMoney(text, m.currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// decimalPlaces is the precision of Decimal.
+const decimalPlaces = 2
+
+// Decimal is an exact number with two decimal places, for percentages,
+// rates and discount values. It is stored in hundredths.
+type Decimal int64
+
+// NewDecimal returns a whole number as a Decimal.
+func NewDecimal(whole int64) Decimal {
+	return Decimal(whole * pow10(decimalPlaces))
+}
+
+// ParseDecimal reads a decimal number such as "12.5".
+func ParseDecimal(value string) (Decimal, error) {
+	hundredths, err := parseScaled(value, decimalPlaces)
+	return Decimal(hundredths), err
+}
+
+// DecimalRatio returns numerator / denominator * 100 as a percentage,
+// rounded half away from zero. A zero denominator gives zero.
+func DecimalRatio(numerator, denominator int64) Decimal {
+	if denominator == 0 {
+		return 0
+	}
+	return Decimal(mulDivRound(numerator, 100*pow10(decimalPlaces), denominator))
+}
+
+func (d Decimal) IsZero() bool {
+	return d == 0
+}
+
+// Float64 is for display only, never for arithmetic.
+func (d Decimal) Float64() float64 {
+	value, _ := strconv.ParseFloat(d.String(), 64)
+	return value
+}
+
+func (d Decimal) String() string {
+	return formatScaled(int64(d), decimalPlaces)
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	value, err := jsonNumber(data)
+	if err != nil || value == "" {
+		return err
+	}
+	parsed, err := ParseDecimal(value)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	return d.String(), nil
+}
+
+func (d *Decimal) Scan(value interface{}) error {
+	text, err := scannedNumber(value)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseDecimal(text)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// ratePlaces is the precision of Rate.
+const ratePlaces = 8
+
+// Rate is an exchange rate with eight decimal places: how many units of one
+// currency a unit of another buys, such as 0.02750000 dollars per baht.
+type Rate int64
+
+// RateOne converts a currency into itself.
+const RateOne = Rate(100000000)
+
+// ParseRate reads a decimal exchange rate such as "0.0275".
+func ParseRate(value string) (Rate, error) {
+	scaled, err := parseScaled(value, ratePlaces)
+	return Rate(scaled), err
+}
+
+func (r Rate) String() string {
+	return formatScaled(int64(r), ratePlaces)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	value, err := jsonNumber(data)
+	if err != nil || value == "" {
+		return err
+	}
+	parsed, err := ParseRate(value)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) Scan(value interface{}) error {
+	text, err := scannedNumber(value)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseRate(text)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// jsonNumber returns the text of a JSON number or numeric string, or ""
+// for null.
+func jsonNumber(data []byte) (string, error) {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return "", nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		var unquoted string
+		if err := json.Unmarshal(data, &unquoted); err != nil {
+			return "", err
+		}
+		return unquoted, nil
+	}
+	return text, nil
+}
+
+func scannedNumber(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "0", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("%w: cannot scan %T", errInvalidAmount, value)
+}
+
+// parseScaled reads a decimal string as an integer number of 10^-places,
+// rounding any further digits half away from zero.
+func parseScaled(value string, places int) (int64, error) {
+	rat, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", errInvalidAmount, value)
+	}
+	rat.Mul(rat, new(big.Rat).SetInt64(pow10(places)))
+	return roundRat(rat)
+}
+
+func formatScaled(value int64, places int) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	if places == 0 {
+		return sign + strconv.FormatInt(value, 10)
+	}
+	scale := pow10(places)
+	return fmt.Sprintf("%s%d.%0*d", sign, value/scale, places, value%scale)
+}
+
+// rescale changes the number of decimal places of a scaled integer.
+func rescale(value int64, from, to int) int64 {
+	if to >= from {
+		return value * pow10(to-from)
+	}
+	return mulDivRound(value, 1, pow10(from-to))
+}
+
+// mulDivRound returns a * b / c rounded half away from zero, without
+// overflowing in between.
+func mulDivRound(a, b, c int64) int64 {
+	rat := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(a), big.NewInt(b)), big.NewInt(c))
+	rounded, _ := roundRat(rat)
+	return rounded
+}
+
+func roundRat(rat *big.Rat) (int64, error) {
+	numerator, denominator := rat.Num(), rat.Denom()
+	quotient, remainder := new(big.Int).QuoRem(numerator, denominator, new(big.Int))
+	// Round half away from zero: compare twice the remainder to the divisor
+	if new(big.Int).Abs(new(big.Int).Mul(remainder, big.NewInt(2))).Cmp(denominator) >= 0 {
+		if numerator.Sign() < 0 {
+			quotient.Sub(quotient, big.NewInt(1))
+		} else {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	if !quotient.IsInt64() {
+		return 0, fmt.Errorf("%w: out of range", errInvalidAmount)
+	}
+	return quotient.Int64(), nil
+}
+
+func pow10(n int) int64 {
+	result := int64(1)
+	for i := 0; i < n; i++ {
+		result *= 10
+	}
+	return result
+}
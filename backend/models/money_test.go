@@ -0,0 +1,87 @@
+package models
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"testing/quick"
+)
+
+// roundHalfAwayFromZero is the reference rounding of numerator/denominator.
+func roundHalfAwayFromZero(numerator, denominator *big.Int) int64 {
+	rat := new(big.Rat).SetFrac(numerator, denominator)
+	abs := new(big.Rat).Abs(rat)
+	abs.Add(abs, big.NewRat(1, 2))
+	rounded := new(big.Int).Quo(abs.Num(), abs.Denom())
+	if rat.Sign() < 0 {
+		rounded.Neg(rounded)
+	}
+	return rounded.Int64()
+}
+
+func TestPercentRoundsHalfAwayFromZero(t *testing.T) {
+	for _, tc := range []struct {
+		minor int64
+		rate  Decimal
+		want  int64
+	}{
+		{1, NewDecimal(50), 1},
+		{-1, NewDecimal(50), -1},
+		{3, NewDecimal(50), 2},
+		{-3, NewDecimal(50), -2},
+		{1, 4999, 0},
+		{1, 5000, 1},
+		{99999, NewDecimal(15), 15000},
+	} {
+		if got := NewMoney(tc.minor, CurrencyTHB).Percent(tc.rate).Minor(); got != tc.want {
+			t.Errorf("%d satang at %s%% = %d, want %d", tc.minor, tc.rate, got, tc.want)
+		}
+	}
+
+	property := func(minor int32, rate int16) bool {
+		got := NewMoney(int64(minor), CurrencyTHB).Percent(Decimal(rate)).Minor()
+		want := roundHalfAwayFromZero(new(big.Int).Mul(big.NewInt(int64(minor)), big.NewInt(int64(rate))), big.NewInt(10000))
+		return got == want
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPercentSplitAddsUpToTotal(t *testing.T) {
+	// A commission and the platform's share of a booking, or a partial
+	// refund and what is kept, always add up to the amount they split
+	property := func(minor uint32, rate uint16) bool {
+		total := NewMoney(int64(minor), CurrencyTHB)
+		share := total.Percent(Decimal(rate % 10001))
+		rest := total.Sub(share)
+		return share.Add(rest) == total && !share.IsNegative() && !rest.IsNegative()
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckedArithmeticRejectsMixedCurrencies(t *testing.T) {
+	baht := NewMoney(10000, CurrencyTHB)
+	dollars := NewMoney(300, CurrencyUSD)
+
+	if _, err := baht.CheckedAdd(dollars); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("CheckedAdd err = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if _, err := baht.CheckedSub(dollars); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("CheckedSub err = %v, want %v", err, ErrCurrencyMismatch)
+	}
+	if _, err := baht.CheckedCmp(dollars); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("CheckedCmp err = %v, want %v", err, ErrCurrencyMismatch)
+	}
+
+	// A zero value total takes on the currency of what is added to it
+	total, err := Money{}.CheckedAdd(dollars)
+	if err != nil || total != dollars {
+		t.Errorf("zero + %s = %v, %v; want %v", dollars, total, err, dollars)
+	}
+	if sum, err := baht.CheckedAdd(baht); err != nil || sum.Minor() != 20000 {
+		t.Errorf("%s + %s = %v, %v", baht, baht, sum, err)
+	}
+}
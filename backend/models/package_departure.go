@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DepartureStatusOpen      = "open"
+	DepartureStatusClosed    = "closed"
+	DepartureStatusCancelled = "cancelled"
+)
+
+// PackageDeparture is one scheduled run of a travel package. Capacity and
+// seats are tracked per departure; PriceOverride replaces the package price
+// for this date when set.
+type PackageDeparture struct {
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	PackageID     uuid.UUID `json:"package_id" gorm:"type:uuid;not null"`
+	DepartureDate string    `json:"departure_date" gorm:"type:date;not null"`
+	Capacity      int       `json:"capacity" gorm:"not null"`
+	BookedSeats   int       `json:"booked_seats" gorm:"not null;default:0"`
+	PriceOverride *Money    `json:"price_override" gorm:"type:numeric(10,2)"`
+	Status        string    `json:"status" gorm:"type:text;not null;default:'open'"`
+	CreatedAt     time.Time `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt     time.Time `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+}
+
+func (PackageDeparture) TableName() string {
+	return "package_departures"
+}
+
+func IsValidDepartureStatus(status string) bool {
+	return status == DepartureStatusOpen || status == DepartureStatusClosed || status == DepartureStatusCancelled
+}
+
+// AvailableSeats never goes below zero, even if capacity was lowered
+func (d *PackageDeparture) AvailableSeats() int {
+	if d.BookedSeats >= d.Capacity {
+		return 0
+	}
+	return d.Capacity - d.BookedSeats
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Payout batch statuses. A draft batch is reviewed and approved by a
+// manager, and becomes paid once every advertiser in it has been paid.
+const (
+	PayoutBatchStatusDraft     = "draft"
+	PayoutBatchStatusApproved  = "approved"
+	PayoutBatchStatusPaid      = "paid"
+	PayoutBatchStatusCancelled = "cancelled"
+)
+
+const (
+	PayoutStatusPending = "pending"
+	PayoutStatusPaid    = "paid"
+)
+
+// PayoutBatch settles the pending commissions of one calendar month. Every
+// commission created before PeriodEnd that has not been settled yet is
+// included, so late commissions are picked up by the next batch.
+type PayoutBatch struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	PeriodStart string     `json:"period_start" gorm:"type:date;not null"`
+	PeriodEnd   string     `json:"period_end" gorm:"type:date;not null"`
+	Status      string     `json:"status" gorm:"type:text;not null;default:draft"`
+	TotalAmount Money      `json:"total_amount" gorm:"type:numeric(12,2);not null;default:0"`
+	PayoutCount int        `json:"payout_count" gorm:"not null;default:0"`
+	CreatedBy   *uuid.UUID `json:"created_by" gorm:"type:uuid"`
+	ApprovedBy  *uuid.UUID `json:"approved_by" gorm:"type:uuid"`
+	ApprovedAt  *time.Time `json:"approved_at" gorm:"type:timestamp with time zone"`
+	PaidAt      *time.Time `json:"paid_at" gorm:"type:timestamp with time zone"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+
+	Payouts []Payout `json:"payouts,omitempty" gorm:"foreignKey:BatchID"`
+}
+
+func (PayoutBatch) TableName() string {
+	return "payout_batches"
+}
+
+// Payout is what one advertiser is paid in a batch: the net of their
+// commissions in it, clawbacks included.
+type Payout struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	BatchID          uuid.UUID  `json:"batch_id" gorm:"type:uuid;not null"`
+	AdvertiserID     uuid.UUID  `json:"advertiser_id" gorm:"type:uuid;not null"`
+	Amount           Money      `json:"amount" gorm:"type:numeric(12,2);not null"`
+	CommissionCount  int        `json:"commission_count" gorm:"not null"`
+	Status           string     `json:"status" gorm:"type:text;not null;default:pending"`
+	PaymentReference *string    `json:"payment_reference" gorm:"type:text"`
+	PaidBy           *uuid.UUID `json:"paid_by" gorm:"type:uuid"`
+	PaidAt           *time.Time `json:"paid_at" gorm:"type:timestamp with time zone"`
+	CreatedAt        time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt        time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+
+	Batch      *PayoutBatch `json:"batch,omitempty" gorm:"foreignKey:BatchID"`
+	Advertiser *Profile     `json:"advertiser,omitempty" gorm:"foreignKey:AdvertiserID;references:ID"`
+}
+
+func (Payout) TableName() string {
+	return "payouts"
+}
@@ -15,8 +15,14 @@ type Profile struct {
 	Address     string    `json:"address" gorm:"type:text"`
 	UserRole    string    `json:"user_role" gorm:"type:text;default:'customer'"`
 	DisplayID   int       `json:"display_id" gorm:"autoIncrement;unique"`
-	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
-	UpdatedAt   time.Time `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+	// EmailVerifiedAt is nil until the user follows the link in the
+	// verification email
+	EmailVerifiedAt *time.Time `json:"email_verified_at" gorm:"type:timestamp with time zone"`
+	// AnonymizedAt is set when the user deleted their account. The row stays
+	// so bookings and commissions keep their owner for accounting.
+	AnonymizedAt *time.Time `json:"anonymized_at" gorm:"type:timestamp with time zone"`
+	CreatedAt    time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt    time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
 }
 
 func (Profile) TableName() string {
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProfileIdentity links a profile to an account at an external OpenID
+// Connect provider, identified by the provider's subject.
+type ProfileIdentity struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ProfileID   uuid.UUID  `json:"profile_id" gorm:"type:uuid;not null"`
+	Provider    string     `json:"provider" gorm:"type:text;not null;uniqueIndex:idx_profile_identities_provider_subject"`
+	Subject     string     `json:"subject" gorm:"type:text;not null;uniqueIndex:idx_profile_identities_provider_subject"`
+	Email       string     `json:"email" gorm:"type:text"`
+	LastLoginAt *time.Time `json:"last_login_at" gorm:"type:timestamp with time zone"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (ProfileIdentity) TableName() string {
+	return "profile_identities"
+}
+
+// OIDCLoginState remembers an authorization request between the redirect
+// to the provider and its callback. It is deleted when the callback uses it.
+type OIDCLoginState struct {
+	State        string `json:"-" gorm:"type:text;primaryKey"`
+	Provider     string `json:"provider" gorm:"type:text;not null"`
+	Nonce        string `json:"-" gorm:"type:text;not null"`
+	CodeVerifier string `json:"-" gorm:"type:text;not null"`
+	RedirectPath string `json:"redirect_path" gorm:"type:text"`
+	// LinkUserID is set when a signed-in user links a provider to their
+	// profile rather than signing in
+	LinkUserID *uuid.UUID `json:"link_user_id" gorm:"type:uuid"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"type:timestamp with time zone;not null"`
+	CreatedAt  time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
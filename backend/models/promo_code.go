@@ -13,10 +13,13 @@ type DiscountCode struct {
 	Code         string     `json:"code" gorm:"type:text;unique;not null"`
 	AdvertiserID uuid.UUID  `json:"advertiser_id" gorm:"type:uuid;not null"`
 	PackageID    uuid.UUID  `json:"package_id" gorm:"type:uuid;not null"`
-	DiscountValue float64 `json:"discount_value" gorm:"type:numeric(10,2);not null"`
+	DiscountValue Decimal `json:"discount_value" gorm:"type:numeric(10,2);not null"`
 	DiscountType  string  `json:"discount_type" gorm:"type:text;not null;default:percentage"`
 	MaxUses        *int      `json:"max_uses" gorm:"type:integer"`
 	CurrentUses    int       `json:"current_uses" gorm:"type:integer;default:0"`
+	// MaxUsesPerCustomer and MinSpend are optional; nil means no limit
+	MaxUsesPerCustomer *int     `json:"max_uses_per_customer" gorm:"type:integer"`
+	MinSpend           *Money   `json:"min_spend" gorm:"type:numeric(10,2)"`
 	IsActive       *bool     `json:"is_active" gorm:"type:boolean;default:true"`
 	ExpiresAt      *time.Time `json:"expires_at" gorm:"type:timestamp with time zone"`
 	CreatedAt      time.Time `json:"created_at" gorm:"type:timestamp with time zone;not null;default:now()"`
@@ -33,10 +36,13 @@ func (DiscountCode) TableName() string {
 type GlobalDiscountCode struct {
 	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
 	Code          string     `json:"code" gorm:"unique;not null"`
-	DiscountValue float64    `json:"discount_value" gorm:"type:numeric(10,2);not null"`
+	DiscountValue Decimal    `json:"discount_value" gorm:"type:numeric(10,2);not null"`
 	DiscountType  string     `json:"discount_type" gorm:"type:text;not null;default:percentage"`
 	MaxUses       *int       `json:"max_uses" gorm:"type:integer"`
 	CurrentUses   int        `json:"current_uses" gorm:"type:integer;default:0"`
+	// MaxUsesPerCustomer and MinSpend are optional; nil means no limit
+	MaxUsesPerCustomer *int     `json:"max_uses_per_customer" gorm:"type:integer"`
+	MinSpend           *Money   `json:"min_spend" gorm:"type:numeric(10,2)"`
 	IsActive      bool       `json:"is_active" gorm:"default:true"`
 	ExpiresAt     *time.Time `json:"expires_at" gorm:"type:timestamp with time zone"`
 	CreatedAt     time.Time  `json:"created_at"`
@@ -47,15 +53,26 @@ func (GlobalDiscountCode) TableName() string {
 	return "global_discount_codes"
 }
 
+// Commission statuses. Pending commissions are settled into a monthly payout
+// batch, approved with the batch and paid with the advertiser's payout.
+const (
+	CommissionStatusPending  = "pending"
+	CommissionStatusApproved = "approved"
+	CommissionStatusPaid     = "paid"
+	CommissionStatusReversed = "reversed"
+)
+
 type Commission struct {
 	ID                   uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
 	BookingID            uuid.UUID  `json:"booking_id" gorm:"type:uuid;not null"`
 	AdvertiserID         uuid.UUID  `json:"advertiser_id" gorm:"type:uuid;not null"`
 	DiscountCodeID       *uuid.UUID `json:"discount_code_id" gorm:"type:uuid"`
-	CommissionAmount     float64    `json:"commission_amount" gorm:"type:numeric(10,2);not null"`
-	CommissionPercentage float64    `json:"commission_percentage" gorm:"type:numeric(5,2);not null;default:5.00"` // % ค่าคอมมิชชั่น
+	CommissionAmount     Money      `json:"commission_amount" gorm:"type:numeric(10,2);not null"`
+	CommissionPercentage Decimal    `json:"commission_percentage" gorm:"type:numeric(5,2);not null;default:5.00"` // % ค่าคอมมิชชั่น
 	Status               string     `json:"status" gorm:"type:text;not null;default:pending"`
 	PaidAt               *time.Time `json:"paid_at" gorm:"type:timestamp with time zone"`
+	PayoutID             *uuid.UUID `json:"payout_id" gorm:"type:uuid"`
+	ReversalOfID         *uuid.UUID `json:"reversal_of_id" gorm:"type:uuid"` // set on clawbacks of commissions already paid
 	CreatedAt            time.Time  `json:"created_at" gorm:"type:timestamp with time zone;not null;default:now()"`
 	UpdatedAt            time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;not null;default:now()"`
 	
@@ -68,7 +85,7 @@ func (Commission) TableName() string {
 	return "commissions"
 }
 
-func GenerateDiscountCode(advertiserName string, discountValue float64) string {
+func GenerateDiscountCode(advertiserName string, discountValue Decimal) string {
 	prefix := ""
 	if len(advertiserName) >= 3 {
 		prefix = advertiserName[:3]
@@ -79,12 +96,12 @@ func GenerateDiscountCode(advertiserName string, discountValue float64) string {
 	prefix = strings.ToUpper(strings.ReplaceAll(prefix, " ", ""))
 	
 	randomSuffix := uuid.New().String()[:4]
-	return fmt.Sprintf("%s%d%s", prefix, int(discountValue), strings.ToUpper(randomSuffix))
+	return fmt.Sprintf("%s%d%s", prefix, int(discountValue.Float64()), strings.ToUpper(randomSuffix))
 }
 
-func GenerateGlobalDiscountCode(discountValue float64) string {
+func GenerateGlobalDiscountCode(discountValue Decimal) string {
 	randomSuffix := uuid.New().String()[:6]
-	return fmt.Sprintf("GLOBAL%d%s", int(discountValue), strings.ToUpper(randomSuffix))
+	return fmt.Sprintf("GLOBAL%d%s", int(discountValue.Float64()), strings.ToUpper(randomSuffix))
 }
 
 func (dc *DiscountCode) IsValidForUse() bool {
@@ -94,17 +111,19 @@ func (dc *DiscountCode) IsValidForUse() bool {
 	if !*dc.IsActive {
 		return false
 	}
-	if dc.MaxUses != nil && dc.CurrentUses >= *dc.MaxUses {
-		return false
-	}
 	if dc.ExpiresAt != nil && time.Now().After(*dc.ExpiresAt) {
 		return false
 	}
 	return true
 }
 
+// IsUsedUp reports whether the code has reached its MaxUses; nil means no limit.
+func (dc *DiscountCode) IsUsedUp() bool {
+	return dc.MaxUses != nil && dc.CurrentUses >= *dc.MaxUses
+}
+
 func (dc *DiscountCode) IsValidForPackage(packageID uuid.UUID) bool {
-	return dc.PackageID == packageID && dc.IsValidForUse()
+	return dc.PackageID == packageID && dc.IsValidForUse() && !dc.IsUsedUp()
 }
 
 func (gdc *GlobalDiscountCode) IsValidForUse() bool {
@@ -115,4 +134,9 @@ func (gdc *GlobalDiscountCode) IsValidForUse() bool {
 		return false
 	}
 	return true
-}
\ No newline at end of file
+}
+
+// IsUsedUp reports whether the code has reached its MaxUses.
+func (gdc *GlobalDiscountCode) IsUsedUp() bool {
+	return gdc.MaxUses != nil && gdc.CurrentUses >= *gdc.MaxUses
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefundPolicyRule is one tier of a refund policy: cancelling at least
+// MinDaysBeforeDeparture days before departure refunds RefundPercentage of
+// the amount paid. Rules without a PackageID form the default policy.
+type RefundPolicyRule struct {
+	ID                     uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	PackageID              *uuid.UUID `json:"package_id" gorm:"type:uuid"`
+	MinDaysBeforeDeparture int        `json:"min_days_before_departure" gorm:"not null"`
+	RefundPercentage       Decimal    `json:"refund_percentage" gorm:"type:numeric(5,2);not null"`
+	CreatedAt              time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+	UpdatedAt              time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;autoUpdateTime"`
+}
+
+func (RefundPolicyRule) TableName() string {
+	return "refund_policy_rules"
+}
+
+// DefaultRefundPolicy applies when neither the package nor the platform
+// has rules configured.
+func DefaultRefundPolicy() []RefundPolicyRule {
+	return []RefundPolicyRule{
+		{MinDaysBeforeDeparture: 30, RefundPercentage: NewDecimal(100)},
+		{MinDaysBeforeDeparture: 7, RefundPercentage: NewDecimal(50)},
+		{MinDaysBeforeDeparture: 0, RefundPercentage: NewDecimal(0)},
+	}
+}
+
+// Refund statuses. A refund is pending until Stripe has accepted it.
+const (
+	RefundStatusPending   = "pending"
+	RefundStatusSucceeded = "succeeded"
+)
+
+// BookingRefund records money returned to a customer for a booking.
+type BookingRefund struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	BookingID        uuid.UUID  `json:"booking_id" gorm:"type:uuid;not null"`
+	Amount           Money      `json:"amount" gorm:"type:numeric(10,2);not null"`
+	RefundPercentage Decimal    `json:"refund_percentage" gorm:"type:numeric(5,2);not null"`
+	Status           string     `json:"status" gorm:"type:text;not null;default:'succeeded'"`
+	StripeRefundID   *string    `json:"stripe_refund_id" gorm:"type:text"`
+	Reason           string     `json:"reason" gorm:"type:text"`
+	RequestedBy      *uuid.UUID `json:"requested_by" gorm:"type:uuid"`
+	CreatedAt        time.Time  `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (BookingRefund) TableName() string {
+	return "booking_refunds"
+}
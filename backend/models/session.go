@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one refresh token. Every rotation adds a row to the same
+// family; a login starts a new family. Only the SHA-256 of the token is kept.
+type Session struct {
+	ID               uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	FamilyID         uuid.UUID  `json:"family_id" gorm:"type:uuid;not null"`
+	UserID           uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
+	RefreshTokenHash string     `json:"-" gorm:"type:text;not null;unique"`
+	UserAgent        string     `json:"user_agent" gorm:"type:text"`
+	IPAddress        string     `json:"ip_address" gorm:"type:text"`
+	ExpiresAt        time.Time  `json:"expires_at" gorm:"type:timestamp with time zone;not null"`
+	RotatedAt        *time.Time `json:"rotated_at" gorm:"type:timestamp with time zone"`
+	RevokedAt        *time.Time `json:"revoked_at" gorm:"type:timestamp with time zone"`
+	RevokedReason    *string    `json:"revoked_reason" gorm:"type:text"`
+	// MFAVerified is set once the login passed a second factor
+	MFAVerified bool      `json:"mfa_verified" gorm:"not null;default:false"`
+	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamp with time zone;autoCreateTime"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
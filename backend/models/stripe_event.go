@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StripeEvent records every webhook event we have already handled so that
+// Stripe retries are acknowledged without touching the booking twice.
+type StripeEvent struct {
+	ID          string     `json:"id" gorm:"type:text;primaryKey"`
+	Type        string     `json:"type" gorm:"type:text;not null"`
+	BookingID   *uuid.UUID `json:"booking_id" gorm:"type:uuid"`
+	ProcessedAt time.Time  `json:"processed_at" gorm:"type:timestamp with time zone;not null;default:now()"`
+}
+
+func (StripeEvent) TableName() string {
+	return "stripe_events"
+}
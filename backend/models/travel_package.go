@@ -6,11 +6,13 @@ import (
 	"github.com/google/uuid"
 )
 
+// TravelPackage prices are in the base currency, DefaultCurrency; packages
+// have no currency of their own and are converted for display and payment.
 type TravelPackage struct {
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primaryKey"`
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
-	Price       float64   `json:"price"`
+	Price       Money     `json:"price" gorm:"type:numeric"`
 	ImageURL    string    `json:"image_url" gorm:"column:image_url"`
 	Location    string    `json:"location"`
 	Duration    int       `json:"duration"`
@@ -20,7 +22,7 @@ type TravelPackage struct {
 	AvailableTo      *string   `json:"available_to" gorm:"column:available_to;type:date"`
 	Tags             string    `json:"-" gorm:"column:tags"`
 	TagsArray        []string  `json:"tags" gorm:"-"`
-	DiscountPercentage float64 `json:"discount_percentage" gorm:"column:discount_percentage;default:0"`
+	DiscountPercentage Decimal `json:"discount_percentage" gorm:"column:discount_percentage;default:0"`
 	AdvertiserID     *uuid.UUID `json:"advertiser_id" gorm:"column:advertiser_id;type:uuid"`
 	IsActive         *bool     `json:"is_active" gorm:"column:is_active;type:boolean;default:true"`
 	DisplayID        int       `json:"display_id" gorm:"column:display_id;uniqueIndex"`
@@ -28,6 +30,9 @@ type TravelPackage struct {
 	UpdatedAt        time.Time `json:"updated_at" gorm:"autoUpdateTime"`
 	
 	AdvertiserNames  string  `json:"advertiser_names" gorm:"-"`
+	// AvailableSeats is what can still be booked, across open departures
+	// for packages sold by date; -1 when there is no limit
+	AvailableSeats   int     `json:"available_seats" gorm:"-"`
 	
 	Advertisers []Profile       `json:"advertisers,omitempty" gorm:"-"`
 	Advertiser  *Profile        `json:"advertiser,omitempty" gorm:"-"`
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPasswordResetTTL       = time.Hour
+	defaultEmailVerificationTTL   = 48 * time.Hour
+	defaultUnverifiedBookingLimit = 1
+)
+
+var (
+	ErrInvalidAccountToken = errors.New("invalid or expired token")
+	ErrEmailNotVerified    = errors.New("please verify your email address to make more bookings")
+)
+
+// PasswordResetTTL is how long a password reset link works, set with
+// PASSWORD_RESET_TTL.
+func PasswordResetTTL() time.Duration {
+	return durationFromEnv("PASSWORD_RESET_TTL", defaultPasswordResetTTL)
+}
+
+// EmailVerificationTTL is how long an email verification link works, set
+// with EMAIL_VERIFICATION_TTL.
+func EmailVerificationTTL() time.Duration {
+	return durationFromEnv("EMAIL_VERIFICATION_TTL", defaultEmailVerificationTTL)
+}
+
+// UnverifiedBookingLimit is how many open bookings a user may hold before
+// verifying their email, set with UNVERIFIED_BOOKING_LIMIT.
+func UnverifiedBookingLimit() int {
+	return intFromEnv("UNVERIFIED_BOOKING_LIMIT", defaultUnverifiedBookingLimit)
+}
+
+// AccountService handles the emailed account flows: verifying an email
+// address and resetting a forgotten password.
+type AccountService struct {
+	DB     *gorm.DB
+	Mailer Mailer
+}
+
+// SendVerificationEmail mails a fresh verification link. Earlier links
+// keep working until they expire.
+func (s *AccountService) SendVerificationEmail(profile models.Profile) error {
+	token, err := s.issueToken(s.DB, profile.ID, models.AccountTokenEmailVerification, EmailVerificationTTL())
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(Email{
+		To:      profile.Email,
+		Subject: "Verify your Trip Trader email",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening this link:\n\n%s\n\nThe link expires in %s.\n",
+			greetingName(profile), frontendLink("/verify-email", token), EmailVerificationTTL()),
+	})
+}
+
+// VerifyEmail marks the owner of a verification token as verified.
+func (s *AccountService) VerifyEmail(token string) (*models.Profile, error) {
+	var profile models.Profile
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		accountToken, err := consumeToken(tx, token, models.AccountTokenEmailVerification)
+		if err != nil {
+			return err
+		}
+		if err := tx.First(&profile, "id = ?", accountToken.UserID).Error; err != nil {
+			return err
+		}
+		if profile.EmailVerifiedAt != nil {
+			return nil
+		}
+		now := time.Now()
+		profile.EmailVerifiedAt = &now
+		return tx.Model(&profile).Update("email_verified_at", now).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// CheckCanBook returns ErrEmailNotVerified when an unverified user already
+// holds UnverifiedBookingLimit open bookings.
+func (s *AccountService) CheckCanBook(userID uuid.UUID) error {
+	var profile models.Profile
+	if err := s.DB.Select("id, email_verified_at").First(&profile, "id = ?", userID).Error; err != nil {
+		return err
+	}
+	if profile.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	var open int64
+	if err := s.DB.Model(&models.Booking{}).
+		Where("customer_id = ? AND status IN ?", userID, []string{models.BookingStatusPending, models.BookingStatusConfirmed}).
+		Count(&open).Error; err != nil {
+		return err
+	}
+	if open >= int64(UnverifiedBookingLimit()) {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// RequestPasswordReset mails a reset link if the email belongs to an
+// account. Unknown emails are ignored so callers cannot probe for accounts.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	var profile models.Profile
+	err := s.DB.Where("email = ?", strings.TrimSpace(email)).First(&profile).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token, err := s.issueToken(s.DB, profile.ID, models.AccountTokenPasswordReset, PasswordResetTTL())
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(Email{
+		To:      profile.Email,
+		Subject: "Reset your Trip Trader password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open this link:\n\n%s\n\nThe link expires in %s. If you did not ask for this, you can ignore this email.\n",
+			greetingName(profile), frontendLink("/reset-password", token), PasswordResetTTL()),
+	})
+}
+
+// ResetPassword sets a new password using a reset token. Every other reset
+// link of the user stops working, all their sessions are revoked and a
+// sign-in lockout is lifted. Since
+// the reset link reached the inbox, the email counts as verified too.
+func (s *AccountService) ResetPassword(token, newPassword string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		accountToken, err := consumeToken(tx, token, models.AccountTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.Profile{}).Where("id = ?", accountToken.UserID).
+			Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Profile{}).Where("id = ? AND email_verified_at IS NULL", accountToken.UserID).
+			Update("email_verified_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.AccountToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", accountToken.UserID, models.AccountTokenPasswordReset).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		// Whoever locked the account out did not know the new password
+		var profile models.Profile
+		if err := tx.Select("id, email").First(&profile, "id = ?", accountToken.UserID).Error; err != nil {
+			return err
+		}
+		throttles := &LoginThrottleService{DB: tx}
+		if err := throttles.Reset(models.ThrottleScopeEmail, strings.ToLower(strings.TrimSpace(profile.Email))); err != nil {
+			return err
+		}
+
+		sessions := &SessionService{DB: tx}
+		return sessions.RevokeAllForUser(accountToken.UserID, "password reset")
+	})
+}
+
+func (s *AccountService) issueToken(db *gorm.DB, userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	tokenHash, err := signAccountToken(purpose, token)
+	if err != nil {
+		return "", err
+	}
+	accountToken := &models.AccountToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(accountToken).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeToken loads an unused, unexpired token and marks it used.
+func consumeToken(tx *gorm.DB, token, purpose string) (*models.AccountToken, error) {
+	tokenHash, err := signAccountToken(purpose, token)
+	if err != nil {
+		return nil, err
+	}
+	var accountToken models.AccountToken
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ?", tokenHash, purpose).
+		First(&accountToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAccountToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if accountToken.UsedAt != nil || time.Now().After(accountToken.ExpiresAt) {
+		return nil, ErrInvalidAccountToken
+	}
+
+	now := time.Now()
+	accountToken.UsedAt = &now
+	if err := tx.Model(&accountToken).Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	return &accountToken, nil
+}
+
+// signAccountToken is the keyed HMAC stored for a token, binding it to its
+// purpose and to the server secret.
+func signAccountToken(purpose, token string) (string, error) {
+	secret, err := serverSecret("ACCOUNT_TOKEN_SECRET")
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose + ":" + token))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func frontendLink(path, token string) string {
+	return strings.TrimRight(os.Getenv("FRONTEND_URL"), "/") + path + "?token=" + token
+}
+
+func greetingName(profile models.Profile) string {
+	if profile.DisplayName != "" {
+		return profile.DisplayName
+	}
+	return profile.Email
+}
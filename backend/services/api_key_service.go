@@ -0,0 +1,176 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix      = "tt_"
+	defaultMaxAPIKeys = 10
+	// Last use is only written this often, so busy integrations do not
+	// update the key row on every request
+	apiKeyLastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey       = errors.New("invalid, expired or revoked API key")
+	ErrAPIKeyNotFound      = errors.New("API key not found")
+	ErrInvalidAPIKeyScopes = errors.New("at least one valid scope is required")
+	ErrAPIKeyExpiryInPast  = errors.New("expires_at must be in the future")
+	ErrTooManyAPIKeys      = errors.New("too many active API keys, revoke one first")
+)
+
+// MaxAPIKeys is how many active keys an advertiser may hold, set with
+// API_KEYS_MAX.
+func MaxAPIKeys() int {
+	return intFromEnv("API_KEYS_MAX", defaultMaxAPIKeys)
+}
+
+type APIKeyService struct {
+	DB *gorm.DB
+}
+
+// Create issues a new key to an advertiser and returns it in clear. This is
+// the only time the full key is available.
+func (s *APIKeyService) Create(advertiserID uuid.UUID, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	granted, err := normalizeAPIKeyScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrAPIKeyExpiryInPast
+	}
+
+	var active int64
+	err = s.DB.Model(&models.APIKey{}).
+		Where("advertiser_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", advertiserID, time.Now()).
+		Count(&active).Error
+	if err != nil {
+		return nil, "", err
+	}
+	if active >= int64(MaxAPIKeys()) {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	idBytes := make([]byte, 4)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+	secret, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(idBytes)
+	key := prefix + "_" + secret
+
+	apiKey := &models.APIKey{
+		AdvertiserID: advertiserID,
+		Name:         strings.TrimSpace(name),
+		Prefix:       prefix,
+		KeyHash:      hashToken(key),
+		Scopes:       strings.Join(granted, " "),
+		ExpiresAt:    expiresAt,
+	}
+	if err := s.DB.Create(apiKey).Error; err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// List returns an advertiser's keys, newest first, including revoked ones.
+func (s *APIKeyService) List(advertiserID uuid.UUID) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.DB.Where("advertiser_id = ?", advertiserID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke stops one of an advertiser's keys from working.
+func (s *APIKeyService) Revoke(advertiserID, keyID uuid.UUID) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	if err := s.DB.First(&apiKey, "id = ? AND advertiser_id = ?", keyID, advertiserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	if apiKey.RevokedAt != nil {
+		return &apiKey, nil
+	}
+
+	now := time.Now()
+	if err := s.DB.Model(&apiKey).Update("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+	apiKey.RevokedAt = &now
+	return &apiKey, nil
+}
+
+// Authenticate resolves a key sent in X-API-Key to the key and the
+// advertiser who owns it. Keys stop working when their owner is no longer
+// an advertiser.
+func (s *APIKeyService) Authenticate(key, ipAddress string) (*models.APIKey, *models.Profile, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var apiKey models.APIKey
+	if err := s.DB.First(&apiKey, "key_hash = ?", hashToken(key)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var profile models.Profile
+	if err := s.DB.First(&profile, "id = ?", apiKey.AdvertiserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if profile.UserRole != models.RoleAdvertiser || profile.AnonymizedAt != nil {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedInterval || apiKey.LastUsedIP != ipAddress {
+		err := s.DB.Model(&apiKey).Updates(map[string]interface{}{
+			"last_used_at": now,
+			"last_used_ip": ipAddress,
+		}).Error
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return &apiKey, &profile, nil
+}
+
+func normalizeAPIKeyScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var granted []string
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !models.IsValidAPIKeyScope(scope) {
+			return nil, ErrInvalidAPIKeyScopes
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			granted = append(granted, scope)
+		}
+	}
+	if len(granted) == 0 {
+		return nil, ErrInvalidAPIKeyScopes
+	}
+	return granted, nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditLogFilter narrows the audit log. Dates are YYYY-MM-DD and apply to
+// when the change was made; empty fields are ignored.
+type AuditLogFilter struct {
+	ActorID    *uuid.UUID
+	Action     string
+	EntityType string
+	EntityID   string
+	From       string
+	To         string
+	Limit      int
+	Offset     int
+}
+
+type AuditService struct {
+	DB *gorm.DB
+}
+
+// Record stores one audit log entry.
+func (s *AuditService) Record(entry *models.AuditLog) error {
+	return s.DB.Create(entry).Error
+}
+
+// List returns one page of audit log entries, newest first, together with
+// the total number of entries matching the filter.
+func (s *AuditService) List(filter AuditLogFilter) ([]models.AuditLog, int64, error) {
+	query := s.DB.Model(&models.AuditLog{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.EntityType != "" {
+		query = query.Where("entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+	if filter.From != "" {
+		query = query.Where("created_at >= ?::date", filter.From)
+	}
+	if filter.To != "" {
+		query = query.Where("created_at < ?::date + 1", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.AuditLog
+	err := query.
+		Order("created_at DESC").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Find(&entries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+// AuditDiff compares two snapshots of a record by their JSON fields and
+// returns only the fields that changed. A nil snapshot stands for a record
+// that does not exist, so the other side is returned whole.
+func AuditDiff(before, after interface{}) (models.JSONMap, models.JSONMap, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if beforeFields == nil || afterFields == nil {
+		return beforeFields, afterFields, nil
+	}
+
+	changedBefore := models.JSONMap{}
+	changedAfter := models.JSONMap{}
+	for key, value := range beforeFields {
+		if other, ok := afterFields[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedBefore[key] = value
+		}
+	}
+	for key, value := range afterFields {
+		if other, ok := beforeFields[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedAfter[key] = value
+		}
+	}
+	return changedBefore, changedAfter, nil
+}
+
+func auditFields(snapshot interface{}) (models.JSONMap, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	if value := reflect.ValueOf(snapshot); value.Kind() == reflect.Ptr && value.IsNil() {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	var fields models.JSONMap
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	// Nested objects are related records, which are audited on their own
+	for key, value := range fields {
+		if _, ok := value.(map[string]interface{}); ok {
+			delete(fields, key)
+		}
+	}
+	return fields, nil
+}
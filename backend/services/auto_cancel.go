@@ -1,83 +1,250 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 	"trip-trader-backend/models"
 
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/checkout/session"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func AutoCancelExpiredBookings(db *gorm.DB) {
-	log.Printf("[AUTO-CANCEL] Starting expired bookings check at %v", time.Now())
+const (
+	defaultBookingTTL         = 10 * time.Minute
+	defaultAutoCancelInterval = 1 * time.Minute
+	autoCancelBatchSize       = 50
+	// autoCancelClaimLease is how long a run has to deal with a batch
+	// before another run may claim the same bookings
+	autoCancelClaimLease = 5 * time.Minute
+)
 
-	var expiredBookings []models.Booking
-	currentTime := time.Now()
-	log.Printf("[AUTO-CANCEL] Searching for bookings with expires_at < %v AND payment_status = 'pending' AND status = 'pending'", currentTime)
-	
-	result := db.Where("expires_at < ? AND payment_status = ? AND status = ?", 
-		currentTime, "pending", "pending").Find(&expiredBookings)
+// BookingTTL is how long an unpaid booking holds its seats, set with
+// BOOKING_TTL as a Go duration such as "15m".
+func BookingTTL() time.Duration {
+	return durationFromEnv("BOOKING_TTL", defaultBookingTTL)
+}
 
-	log.Printf("[AUTO-CANCEL] Query executed. Found %d bookings. Error: %v", len(expiredBookings), result.Error)
+// AutoCancelInterval is how often the scheduler looks for expired bookings,
+// set with AUTO_CANCEL_INTERVAL.
+func AutoCancelInterval() time.Duration {
+	return durationFromEnv("AUTO_CANCEL_INTERVAL", defaultAutoCancelInterval)
+}
 
-	if result.Error != nil {
-		log.Printf("[AUTO-CANCEL] Error fetching expired bookings: %v", result.Error)
-		return
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
 	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+func intFromEnv(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		log.Printf("Invalid %s %q, using %d", key, value, fallback)
+		return fallback
+	}
+	return number
+}
 
-	if len(expiredBookings) == 0 {
+// AutoCancelExpiredBookings cancels unpaid bookings whose hold has run out.
+// Each batch is claimed for autoCancelClaimLease in a short transaction
+// using FOR UPDATE SKIP LOCKED, so several backend replicas can run the
+// scheduler without working on the same booking, and no row lock is held
+// while Stripe is asked to close the checkout sessions. Every booking is
+// then cancelled in a transaction of its own.
+func AutoCancelExpiredBookings(db *gorm.DB) {
+	log.Printf("[AUTO-CANCEL] Starting expired bookings check at %v", time.Now())
+
+	cancelled := 0
+	for {
+		processed, batchCancelled, err := cancelExpiredBatch(db)
+		cancelled += batchCancelled
+		if err != nil {
+			log.Printf("[AUTO-CANCEL] Error processing expired bookings: %v", err)
+			break
+		}
+		// Claimed bookings that turn out to be paid stay pending until the
+		// webhook confirms them, but no later batch picks them up again
+		if processed < autoCancelBatchSize {
+			break
+		}
+	}
+
+	if cancelled == 0 {
 		log.Println("[AUTO-CANCEL] No expired bookings found")
 		return
 	}
+	log.Printf("Auto-cancel completed: %d bookings cancelled", cancelled)
+}
 
-	log.Printf("Found %d expired bookings to cancel", len(expiredBookings))
+func cancelExpiredBatch(db *gorm.DB) (int, int, error) {
+	expiredBookings, err := claimExpiredBookings(db)
+	if err != nil {
+		return 0, 0, err
+	}
 
+	cancelled := 0
 	for i, booking := range expiredBookings {
-		log.Printf("[AUTO-CANCEL] Processing booking %d/%d: ID=%s, Expires=%v, Package=%s, Guests=%d", 
+		log.Printf("[AUTO-CANCEL] Processing booking %d/%d: ID=%s, Expires=%v, Package=%s, Guests=%d",
 			i+1, len(expiredBookings), booking.ID, booking.ExpiresAt, booking.PackageID, booking.GuestCount)
-		
-		updateResult := db.Model(&booking).Updates(map[string]interface{}{
-			"status":         "cancelled",
-			"payment_status": "failed",
-			"updated_at":     time.Now(),
-		})
-
-		if updateResult.Error != nil {
-			log.Printf("[AUTO-CANCEL] Error cancelling booking %s: %v", booking.ID, updateResult.Error)
+
+		// If the session could not be closed the customer may still pay;
+		// the webhook refunds payments for cancelled bookings
+		paid, err := expireCheckoutSession(booking)
+		if err != nil {
+			log.Printf("[AUTO-CANCEL] Could not expire checkout session for booking %s, a late payment will be refunded: %v", booking.ID, err)
+		}
+		if paid {
+			// The customer paid at the last moment, the webhook will confirm it
+			log.Printf("[AUTO-CANCEL] Booking %s was paid, leaving it for confirmation", booking.ID)
 			continue
 		}
-		log.Printf("[AUTO-CANCEL] Booking %s status updated to cancelled", booking.ID)
 
-		packageUpdateResult := db.Model(&models.TravelPackage{}).
-			Where("id = ?", booking.PackageID).
-			Update("current_bookings", gorm.Expr("current_bookings - ?", booking.GuestCount))
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return CancelExpiredBooking(tx, booking)
+		}); err != nil {
+			return len(expiredBookings), cancelled, err
+		}
+		cancelled++
+		log.Printf("[AUTO-CANCEL] Successfully cancelled expired booking %s for package %s", booking.ID, booking.PackageID)
+	}
+	return len(expiredBookings), cancelled, nil
+}
+
+// claimExpiredBookings picks the next batch of expired bookings nobody else
+// has claimed and claims them, releasing the row locks straight away.
+func claimExpiredBookings(db *gorm.DB) ([]models.Booking, error) {
+	var expiredBookings []models.Booking
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("expires_at < ? AND payment_status = ? AND status = ?", now, "pending", "pending").
+			Where("auto_cancel_claimed_until IS NULL OR auto_cancel_claimed_until < ?", now).
+			Order("expires_at ASC").
+			Limit(autoCancelBatchSize).
+			Find(&expiredBookings).Error
+		if err != nil || len(expiredBookings) == 0 {
+			return err
+		}
 
-		if packageUpdateResult.Error != nil {
-			log.Printf("[AUTO-CANCEL] Error updating package bookings for booking %s: %v", booking.ID, packageUpdateResult.Error)
-		} else {
-			log.Printf("[AUTO-CANCEL] Package %s current_bookings decreased by %d", booking.PackageID, booking.GuestCount)
+		ids := make([]uuid.UUID, len(expiredBookings))
+		for i, booking := range expiredBookings {
+			ids[i] = booking.ID
 		}
+		return tx.Model(&models.Booking{}).Where("id IN ?", ids).
+			Update("auto_cancel_claimed_until", now.Add(autoCancelClaimLease)).Error
+	})
+	return expiredBookings, err
+}
 
-		log.Printf("[AUTO-CANCEL] Successfully cancelled expired booking %s for package %s", booking.ID, booking.PackageID)
+// expireCheckoutSession closes the booking's Stripe checkout session so the
+// customer cannot pay for seats that have been given back. It reports
+// whether the session turned out to be paid already.
+func expireCheckoutSession(booking models.Booking) (bool, error) {
+	stripeKey, ok := StripeSecretKey()
+	if !ok || booking.StripePaymentIntentID == nil || !strings.HasPrefix(*booking.StripePaymentIntentID, "cs_") {
+		return false, nil
+	}
+	stripe.Key = stripeKey
+
+	checkoutSession, err := session.Get(*booking.StripePaymentIntentID, nil)
+	if err != nil {
+		return false, err
+	}
+	if checkoutSession.PaymentStatus == stripe.CheckoutSessionPaymentStatusPaid {
+		return true, nil
+	}
+	if checkoutSession.Status != stripe.CheckoutSessionStatusOpen {
+		return false, nil
 	}
 
-	log.Printf("Auto-cancel completed: %d bookings cancelled", len(expiredBookings))
+	_, err = session.Expire(checkoutSession.ID, nil)
+	return false, err
 }
 
-func StartAutoCancelScheduler(db *gorm.DB) {
+// CancelExpiredBooking cancels a single unpaid booking and gives its seats
+// back to the package. It is shared by the scheduler and the Stripe webhook,
+// and db should be a transaction so the booking is never left cancelled
+// with its seats still held.
+func CancelExpiredBooking(db *gorm.DB, booking models.Booking) error {
+	if booking.Status != models.BookingStatusPending || booking.PaymentStatus != models.PaymentStatusPending {
+		log.Printf("[AUTO-CANCEL] Booking %s is no longer pending, skipping", booking.ID)
+		return nil
+	}
+
+	bookingStates := &BookingStateService{DB: db}
+	_, event, err := bookingStates.Transition(booking.ID, BookingTransition{
+		Status:        models.BookingStatusCancelled,
+		PaymentStatus: models.PaymentStatusFailed,
+		Actor:         models.ActorSystem,
+		Reason:        "Payment window expired",
+	})
+	if errors.Is(err, ErrIllegalTransition) || (err == nil && event == nil) {
+		log.Printf("[AUTO-CANCEL] Booking %s is no longer pending, skipping", booking.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("[AUTO-CANCEL] Booking %s status updated to cancelled", booking.ID)
+
+	reservations := &ReservationService{DB: db}
+	if err := reservations.ReleaseBooking(db, booking); err != nil {
+		return fmt.Errorf("release seats of booking %s: %w", booking.ID, err)
+	}
+	log.Printf("[AUTO-CANCEL] Package %s released %d seats", booking.PackageID, booking.GuestCount)
+	return nil
+}
+
+// StartAutoCancelScheduler runs AutoCancelExpiredBookings and retries
+// pending cancellation refunds every interval until ctx is cancelled. The
+// returned channel is closed once the scheduler has stopped, after any run
+// in progress has finished.
+func StartAutoCancelScheduler(ctx context.Context, db *gorm.DB, interval time.Duration) <-chan struct{} {
 	log.Println("Starting auto-cancel scheduler...")
-	
-	ticker := time.NewTicker(1 * time.Minute)
-	
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
 	go func() {
+		defer close(done)
 		defer ticker.Stop()
 		log.Println("[AUTO-CANCEL] Goroutine started successfully")
-		for range ticker.C {
-			log.Println("[AUTO-CANCEL] Ticker triggered, calling AutoCancelExpiredBookings...")
-			AutoCancelExpiredBookings(db)
-			log.Println("[AUTO-CANCEL] AutoCancelExpiredBookings completed")
+
+		cancellations := &CancellationService{DB: db}
+		AutoCancelExpiredBookings(db)
+		cancellations.RetryPendingRefunds()
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[AUTO-CANCEL] Scheduler stopped")
+				return
+			case <-ticker.C:
+				AutoCancelExpiredBookings(db)
+				cancellations.RetryPendingRefunds()
+			}
 		}
 	}()
-	
-	log.Println("Auto-cancel scheduler started (runs every 1 minute)")
-}
\ No newline at end of file
+
+	log.Printf("Auto-cancel scheduler started (runs every %v)", interval)
+	return done
+}
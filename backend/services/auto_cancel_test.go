@@ -0,0 +1,65 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+)
+
+func TestClaimExpiredBookingsSkipsClaimedAndLockedRows(t *testing.T) {
+	db, fake := fakesql.New(t)
+	first, second := uuid.New(), uuid.New()
+	fake.On(`SELECT \* FROM "bookings"`).Return([]string{"id", "status", "payment_status"},
+		[]driver.Value{first.String(), models.BookingStatusPending, models.PaymentStatusPending},
+		[]driver.Value{second.String(), models.BookingStatusPending, models.PaymentStatusPending},
+	)
+
+	claimed, err := claimExpiredBookings(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("claimed %d bookings, want 2", len(claimed))
+	}
+	selects := fake.Statements(`SELECT \* FROM "bookings"`)
+	if len(selects) != 1 || !strings.Contains(selects[0].SQL, "SKIP LOCKED") ||
+		!strings.Contains(selects[0].SQL, "(auto_cancel_claimed_until IS NULL OR auto_cancel_claimed_until < ") {
+		t.Errorf("select = %v, want unclaimed rows locked with SKIP LOCKED", selects)
+	}
+	updates := fake.Statements(`UPDATE "bookings" SET "auto_cancel_claimed_until"`)
+	if len(updates) != 1 || !containsArg(updates[0].Args, first.String()) || !containsArg(updates[0].Args, second.String()) {
+		t.Fatalf("claims = %v, want both bookings claimed", updates)
+	}
+	leased := false
+	for _, arg := range updates[0].Args {
+		if until, ok := arg.(time.Time); ok && time.Until(until) > autoCancelClaimLease-time.Minute {
+			leased = true
+		}
+	}
+	if !leased {
+		t.Errorf("claim args = %v, want a claim about %v from now", updates[0].Args, autoCancelClaimLease)
+	}
+}
+
+func TestCancelExpiredBookingReturnsReleaseFailure(t *testing.T) {
+	db, fake := fakesql.New(t)
+	booking := models.Booking{ID: uuid.New(), PackageID: uuid.New(), GuestCount: 2,
+		Status: models.BookingStatusPending, PaymentStatus: models.PaymentStatusPending}
+	fake.On(`SELECT \* FROM "bookings"`).Return([]string{"id", "package_id", "guest_count", "status", "payment_status"},
+		[]driver.Value{booking.ID.String(), booking.PackageID.String(), int64(2), booking.Status, booking.PaymentStatus})
+	fake.On(`FROM "travel_packages"`).Return([]string{"id", "max_guests"}, []driver.Value{booking.PackageID.String(), int64(10)})
+	fake.On(`COALESCE\(SUM`).Return([]string{"sum"}, []driver.Value{int64(0)})
+	releaseFailed := errors.New("connection reset")
+	fake.On(`UPDATE "travel_packages"`).Fail(releaseFailed)
+
+	err := CancelExpiredBooking(db, booking)
+	if !errors.Is(err, releaseFailed) {
+		t.Fatalf("err = %v, want the release failure", err)
+	}
+}
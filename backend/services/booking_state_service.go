@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrIllegalTransition = errors.New("illegal booking transition")
+
+// TransitionError explains which part of a requested transition is not
+// allowed. It matches ErrIllegalTransition with errors.Is.
+type TransitionError struct {
+	Field string
+	From  string
+	To    string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot change booking %s from %s to %s", e.Field, e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrIllegalTransition
+}
+
+// BookingTransition is a requested state change. An empty Status or
+// PaymentStatus leaves that field alone; Updates carries any other columns
+// that change together with the state.
+type BookingTransition struct {
+	Status        string
+	PaymentStatus string
+	Updates       map[string]interface{}
+	Actor         string
+	ActorID       *uuid.UUID
+	Reason        string
+}
+
+type BookingStateService struct {
+	DB *gorm.DB
+}
+
+// Transition is the only place booking statuses should change. It locks the
+// booking, rejects moves the state machine does not allow and records a
+// booking_events row for every change it makes. Confirming a booking
+// redeems its discount code use and posts its revenue to the ledger, and
+// cancelling or refunding it releases the use and reverses the advertiser's
+// commission. The returned event is nil when the booking was already in the
+// requested state; Updates are only written together with an actual change.
+func (s *BookingStateService) Transition(bookingID uuid.UUID, t BookingTransition) (*models.Booking, *models.BookingEvent, error) {
+	var booking models.Booking
+	var recorded *models.BookingEvent
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&booking, "id = ?", bookingID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBookingNotFound
+			}
+			return err
+		}
+
+		event := models.BookingEvent{
+			BookingID:         booking.ID,
+			FromStatus:        booking.Status,
+			ToStatus:          booking.Status,
+			FromPaymentStatus: booking.PaymentStatus,
+			ToPaymentStatus:   booking.PaymentStatus,
+			Actor:             t.Actor,
+			ActorID:           t.ActorID,
+			Reason:            t.Reason,
+		}
+		if event.Actor == "" {
+			event.Actor = models.ActorSystem
+		}
+
+		updates := map[string]interface{}{}
+		changed := false
+		if t.Status != "" && t.Status != booking.Status {
+			if !models.CanTransitionBookingStatus(booking.Status, t.Status) {
+				return &TransitionError{Field: "status", From: booking.Status, To: t.Status}
+			}
+			event.ToStatus = t.Status
+			updates["status"] = t.Status
+			changed = true
+		}
+		// Repeating a status is a no-op unless the machine allows it, as it
+		// does for a second partial refund
+		if t.PaymentStatus != "" && (t.PaymentStatus != booking.PaymentStatus || models.CanTransitionPaymentStatus(booking.PaymentStatus, t.PaymentStatus)) {
+			if !models.CanTransitionPaymentStatus(booking.PaymentStatus, t.PaymentStatus) {
+				return &TransitionError{Field: "payment status", From: booking.PaymentStatus, To: t.PaymentStatus}
+			}
+			event.ToPaymentStatus = t.PaymentStatus
+			updates["payment_status"] = t.PaymentStatus
+			changed = true
+		}
+
+		if !changed {
+			return nil
+		}
+
+		for column, value := range t.Updates {
+			updates[column] = value
+		}
+		if err := tx.Model(&models.Booking{}).Where("id = ?", booking.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		redemptions := &RedemptionService{DB: tx}
+		switch updates["status"] {
+		case models.BookingStatusConfirmed:
+			if err := redemptions.Redeem(booking); err != nil {
+				return err
+			}
+		case models.BookingStatusCancelled, models.BookingStatusRefunded:
+			if err := redemptions.Release(booking.ID); err != nil {
+				return err
+			}
+			payouts := &PayoutService{DB: tx}
+			if err := payouts.ReverseForBooking(booking.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		recorded = &event
+
+		if err := tx.First(&booking, "id = ?", booking.ID).Error; err != nil {
+			return err
+		}
+		if updates["status"] == models.BookingStatusConfirmed {
+			ledger := &LedgerService{DB: tx}
+			return ledger.PostBookingConfirmed(booking)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &booking, recorded, nil
+}
+
+// History lists a booking's transitions, oldest first.
+func (s *BookingStateService) History(bookingID uuid.UUID) ([]models.BookingEvent, error) {
+	var events []models.BookingEvent
+	err := s.DB.Where("booking_id = ?", bookingID).Order("created_at ASC").Find(&events).Error
+	return events, err
+}
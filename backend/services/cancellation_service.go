@@ -0,0 +1,446 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"github.com/stripe/stripe-go/v78"
+	"github.com/stripe/stripe-go/v78/checkout/session"
+	"github.com/stripe/stripe-go/v78/refund"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrBookingNotFound       = errors.New("booking not found")
+	ErrBookingNotCancellable = errors.New("booking can no longer be cancelled")
+	ErrTripAlreadyDeparted   = errors.New("trip has already departed")
+	ErrInvalidRefundPolicy   = errors.New("refund policy rules need unique days and percentages between 0 and 100")
+)
+
+type CancellationService struct {
+	DB *gorm.DB
+}
+
+// CancellationQuote describes what cancelling a booking right now would
+// refund. DaysBeforeDeparture is nil when the booking has no departure.
+// AmountPaid and RefundAmount are in the base currency; ChargedAmount and
+// RefundCharge are the same in the currency the customer paid in.
+type CancellationQuote struct {
+	BookingID           uuid.UUID      `json:"booking_id"`
+	DepartureDate       *string        `json:"departure_date"`
+	DaysBeforeDeparture *int           `json:"days_before_departure"`
+	AmountPaid          models.Money   `json:"amount_paid"`
+	RefundPercentage    models.Decimal `json:"refund_percentage"`
+	RefundAmount        models.Money   `json:"refund_amount"`
+	ChargedAmount       models.Money   `json:"charged_amount"`
+	RefundCharge        models.Money   `json:"refund_charge"`
+}
+
+type CancellationResult struct {
+	Booking models.Booking        `json:"booking"`
+	Quote   CancellationQuote     `json:"quote"`
+	Refund  *models.BookingRefund `json:"refund"`
+}
+
+// RefundPolicy returns the rules that apply to a package, most generous
+// first: the package's own rules, else the platform default rules, else
+// DefaultRefundPolicy.
+func (s *CancellationService) RefundPolicy(packageID uuid.UUID) ([]models.RefundPolicyRule, error) {
+	var rules []models.RefundPolicyRule
+	if err := s.DB.Where("package_id = ?", packageID).Order("min_days_before_departure DESC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		return rules, nil
+	}
+	if err := s.DB.Where("package_id IS NULL").Order("min_days_before_departure DESC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	if len(rules) > 0 {
+		return rules, nil
+	}
+	return models.DefaultRefundPolicy(), nil
+}
+
+// ReplaceRefundPolicy swaps a package's rules for the given set. An empty
+// set makes the package fall back to the default policy again.
+func (s *CancellationService) ReplaceRefundPolicy(packageID uuid.UUID, rules []models.RefundPolicyRule) ([]models.RefundPolicyRule, error) {
+	seen := make(map[int]bool)
+	for _, rule := range rules {
+		if rule.MinDaysBeforeDeparture < 0 || rule.RefundPercentage < 0 || rule.RefundPercentage > models.NewDecimal(100) || seen[rule.MinDaysBeforeDeparture] {
+			return nil, ErrInvalidRefundPolicy
+		}
+		seen[rule.MinDaysBeforeDeparture] = true
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("package_id = ?", packageID).Delete(&models.RefundPolicyRule{}).Error; err != nil {
+			return err
+		}
+		for i := range rules {
+			rules[i].ID = uuid.Nil
+			rules[i].PackageID = &packageID
+			if err := tx.Create(&rules[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].MinDaysBeforeDeparture > rules[j].MinDaysBeforeDeparture
+	})
+	return rules, nil
+}
+
+// Preview works out the refund for cancelling a booking without touching it.
+func (s *CancellationService) Preview(bookingID uuid.UUID) (*CancellationQuote, error) {
+	var booking models.Booking
+	if err := s.DB.First(&booking, "id = ?", bookingID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBookingNotFound
+		}
+		return nil, err
+	}
+	if !isCancellable(booking) {
+		return nil, ErrBookingNotCancellable
+	}
+	return s.quote(s.DB, booking, time.Now())
+}
+
+// Cancel cancels a booking on the customer's behalf. Unpaid bookings are
+// simply cancelled; paid ones are refunded according to the package's
+// refund policy. Seats are released and the commission reversed.
+//
+// The refund is recorded as pending together with the cancellation and only
+// sent to Stripe once that has committed, so a failure can never leave a
+// customer refunded for a booking that still holds its seats. A refund Stripe
+// does not take stays pending for RetryPendingRefunds.
+func (s *CancellationService) Cancel(bookingID uuid.UUID, actor string, requestedBy *uuid.UUID, reason string) (*CancellationResult, error) {
+	result := &CancellationResult{}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var booking models.Booking
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&booking, "id = ?", bookingID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBookingNotFound
+			}
+			return err
+		}
+		if !isCancellable(booking) {
+			return ErrBookingNotCancellable
+		}
+
+		quote, err := s.quote(tx, booking, time.Now())
+		if err != nil {
+			return err
+		}
+
+		transition := BookingTransition{
+			Status:  models.BookingStatusCancelled,
+			Updates: map[string]interface{}{"expires_at": nil},
+			Actor:   actor,
+			ActorID: requestedBy,
+			Reason:  reason,
+		}
+		if transition.Reason == "" {
+			transition.Reason = "Cancelled by customer"
+		}
+
+		if booking.PaymentStatus == models.PaymentStatusPaid {
+			if quote.RefundAmount.IsPositive() {
+				result.Refund = &models.BookingRefund{
+					BookingID:        booking.ID,
+					Amount:           quote.RefundAmount,
+					RefundPercentage: quote.RefundPercentage,
+					Status:           models.RefundStatusPending,
+					Reason:           reason,
+					RequestedBy:      requestedBy,
+				}
+				if err := tx.Create(result.Refund).Error; err != nil {
+					return err
+				}
+
+				transition.PaymentStatus = models.PaymentStatusPartiallyRefunded
+				if !quote.RefundAmount.LessThan(quote.AmountPaid) {
+					transition.PaymentStatus = models.PaymentStatusRefunded
+				}
+			}
+		} else {
+			transition.PaymentStatus = models.PaymentStatusFailed
+		}
+
+		bookingStates := &BookingStateService{DB: tx}
+		if _, _, err := bookingStates.Transition(booking.ID, transition); err != nil {
+			return err
+		}
+
+		reservations := &ReservationService{DB: tx}
+		if err := reservations.ReleaseBooking(tx, booking); err != nil {
+			return err
+		}
+
+		if err := tx.First(&result.Booking, "id = ?", booking.ID).Error; err != nil {
+			return err
+		}
+		result.Quote = *quote
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Refund != nil {
+		refund, err := s.SendRefund(result.Refund.ID)
+		if err != nil {
+			log.Printf("Refund %s for booking %s left pending: %v", result.Refund.ID, bookingID, err)
+		} else {
+			result.Refund = refund
+		}
+	}
+	return result, nil
+}
+
+// SendRefund sends a pending refund to Stripe and posts it to the ledger.
+// The idempotency key is the same on every attempt, so retrying a refund
+// Stripe already took does not pay it twice.
+func (s *CancellationService) SendRefund(refundID uuid.UUID) (*models.BookingRefund, error) {
+	var record models.BookingRefund
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, "id = ?", refundID).Error; err != nil {
+			return err
+		}
+		if record.Status != models.RefundStatusPending {
+			return nil
+		}
+		var booking models.Booking
+		if err := tx.First(&booking, "id = ?", record.BookingID).Error; err != nil {
+			return err
+		}
+
+		stripeRefundID, err := issueRefund(booking, booking.Charge().Percent(record.RefundPercentage))
+		if err != nil {
+			return err
+		}
+		record.Status = models.RefundStatusSucceeded
+		record.StripeRefundID = &stripeRefundID
+		if err := tx.Model(&record).Updates(map[string]interface{}{
+			"status":           record.Status,
+			"stripe_refund_id": stripeRefundID,
+		}).Error; err != nil {
+			return err
+		}
+
+		refunded := models.NewMoney(0, booking.FinalAmount.Currency())
+		if err := tx.Model(&models.BookingRefund{}).Select("COALESCE(SUM(amount), 0)").
+			Where("booking_id = ? AND status = ?", booking.ID, models.RefundStatusSucceeded).
+			Row().Scan(&refunded); err != nil {
+			return err
+		}
+		ledger := &LedgerService{DB: tx}
+		return ledger.PostRefund(booking.ID, refunded)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// RetryPendingRefunds sends the refunds that could not be sent when their
+// booking was cancelled.
+func (s *CancellationService) RetryPendingRefunds() {
+	var pending []models.BookingRefund
+	if err := s.DB.Where("status = ? AND created_at < ?", models.RefundStatusPending, time.Now().Add(-time.Minute)).
+		Order("created_at ASC").
+		Find(&pending).Error; err != nil {
+		log.Printf("[REFUNDS] Failed to load pending refunds: %v", err)
+		return
+	}
+	for _, record := range pending {
+		if _, err := s.SendRefund(record.ID); err != nil {
+			log.Printf("[REFUNDS] Refund %s for booking %s still pending: %v", record.ID, record.BookingID, err)
+			continue
+		}
+		log.Printf("[REFUNDS] Refund %s for booking %s sent", record.ID, record.BookingID)
+	}
+}
+
+func isCancellable(booking models.Booking) bool {
+	if !models.CanTransitionBookingStatus(booking.Status, models.BookingStatusCancelled) {
+		return false
+	}
+	return booking.PaymentStatus == models.PaymentStatusPending || booking.PaymentStatus == models.PaymentStatusPaid
+}
+
+func (s *CancellationService) quote(db *gorm.DB, booking models.Booking, now time.Time) (*CancellationQuote, error) {
+	quote := &CancellationQuote{
+		BookingID:     booking.ID,
+		AmountPaid:    models.NewMoney(0, booking.FinalAmount.Currency()),
+		ChargedAmount: models.NewMoney(0, booking.Currency),
+	}
+	if booking.PaymentStatus == models.PaymentStatusPaid {
+		quote.AmountPaid = booking.FinalAmount
+		quote.ChargedAmount = booking.Charge()
+	}
+
+	departureDate, err := bookingDepartureDate(db, booking)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := (&CancellationService{DB: db}).RefundPolicy(booking.PackageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if departureDate != nil {
+		date, err := time.Parse("2006-01-02", *departureDate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid departure date %q: %v", *departureDate, err)
+		}
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		days := int(date.Sub(today).Hours() / 24)
+		if days < 0 {
+			return nil, ErrTripAlreadyDeparted
+		}
+		quote.DepartureDate = departureDate
+		quote.DaysBeforeDeparture = &days
+		quote.RefundPercentage = refundPercentageFor(rules, days)
+	} else {
+		// Without a travel date the customer gets the most generous tier
+		quote.RefundPercentage = refundPercentageFor(rules, math.MaxInt32)
+	}
+
+	quote.RefundAmount = quote.AmountPaid.Percent(quote.RefundPercentage)
+	quote.RefundCharge = quote.ChargedAmount.Percent(quote.RefundPercentage)
+	return quote, nil
+}
+
+// refundPercentageFor picks the rule with the highest threshold the
+// cancellation still meets. Rules must be ordered most generous first.
+func refundPercentageFor(rules []models.RefundPolicyRule, daysBeforeDeparture int) models.Decimal {
+	for _, rule := range rules {
+		if daysBeforeDeparture >= rule.MinDaysBeforeDeparture {
+			return rule.RefundPercentage
+		}
+	}
+	return 0
+}
+
+// bookingDepartureDate is the date of the booking's departure, or nil for
+// bookings without one. A package's AvailableFrom only opens its sale
+// window and says nothing about when this customer travels.
+func bookingDepartureDate(db *gorm.DB, booking models.Booking) (*string, error) {
+	if booking.DepartureID == nil {
+		return nil, nil
+	}
+	var departure models.PackageDeparture
+	if err := db.First(&departure, "id = ?", *booking.DepartureID).Error; err != nil {
+		return nil, err
+	}
+	date := departure.DepartureDate
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	return &date, nil
+}
+
+// RefundLatePayment gives back in full a payment that arrived after its
+// booking had been cancelled, such as a checkout completed just as the hold
+// ran out, and records the refund against the booking.
+func RefundLatePayment(db *gorm.DB, booking models.Booking, paymentIntentID string) (*models.BookingRefund, error) {
+	return refundWholePayment(db, booking, "late-payment-"+paymentIntentID, paymentIntentID, "Paid after the booking was cancelled")
+}
+
+// RefundMismatchedPayment gives back in full a payment whose amount or
+// currency differs from what the booking charges, so the booking is never
+// confirmed on money that doesn't match its price.
+func RefundMismatchedPayment(db *gorm.DB, booking models.Booking, paymentIntentID, reason string) (*models.BookingRefund, error) {
+	return refundWholePayment(db, booking, "mismatched-payment-"+paymentIntentID, paymentIntentID, reason)
+}
+
+func refundWholePayment(db *gorm.DB, booking models.Booking, idempotencyKey, paymentIntentID, reason string) (*models.BookingRefund, error) {
+	stripeRefundID, err := createStripeRefund(paymentIntentID, nil, idempotencyKey, booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	record := &models.BookingRefund{
+		BookingID:        booking.ID,
+		Amount:           booking.FinalAmount,
+		RefundPercentage: models.NewDecimal(100),
+		Status:           models.RefundStatusSucceeded,
+		StripeRefundID:   &stripeRefundID,
+		Reason:           reason,
+	}
+	if err := db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// issueRefund returns money to the customer's card. amount is in the
+// currency the booking was charged in. Bookings store the checkout session
+// ID until payment is confirmed, so session IDs are resolved to their
+// payment intent first.
+func issueRefund(booking models.Booking, amount models.Money) (string, error) {
+	stripeKey, ok := StripeSecretKey()
+	if !ok {
+		return "re_mock_" + uuid.New().String(), nil
+	}
+	if booking.StripePaymentIntentID == nil {
+		return "", fmt.Errorf("booking %s has no Stripe payment to refund", booking.ID)
+	}
+	stripe.Key = stripeKey
+
+	paymentIntentID := *booking.StripePaymentIntentID
+	if strings.HasPrefix(paymentIntentID, "cs_") {
+		checkoutSession, err := session.Get(paymentIntentID, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to load checkout session: %v", err)
+		}
+		if checkoutSession.PaymentIntent == nil {
+			return "", fmt.Errorf("checkout session %s has no payment intent", paymentIntentID)
+		}
+		paymentIntentID = checkoutSession.PaymentIntent.ID
+	}
+
+	minor := amount.Minor()
+	return createStripeRefund(paymentIntentID, &minor, "cancel-"+booking.ID.String(), booking.ID)
+}
+
+// createStripeRefund refunds a payment intent, in full when amount is nil,
+// or fakes it when Stripe is not configured.
+func createStripeRefund(paymentIntentID string, amount *int64, idempotencyKey string, bookingID uuid.UUID) (string, error) {
+	stripeKey, ok := StripeSecretKey()
+	if !ok {
+		return "re_mock_" + uuid.New().String(), nil
+	}
+	stripe.Key = stripeKey
+
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntentID),
+		Reason:        stripe.String(string(stripe.RefundReasonRequestedByCustomer)),
+	}
+	if amount != nil {
+		params.Amount = stripe.Int64(*amount)
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+	params.AddMetadata("booking_id", bookingID.String())
+
+	stripeRefund, err := refund.New(params)
+	if err != nil {
+		return "", fmt.Errorf("stripe refund failed: %v", err)
+	}
+	return stripeRefund.ID, nil
+}
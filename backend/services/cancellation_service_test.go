@@ -0,0 +1,274 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// cancellationFixture answers lookups of one paid booking without a
+// departure, and of its refund once one has been recorded.
+func cancellationFixture(t *testing.T, paymentIntentID *string) (*CancellationService, *fakesql.DB, models.Booking, uuid.UUID) {
+	t.Helper()
+	db, fake := fakesql.New(t)
+	booking := models.Booking{ID: uuid.New(), CustomerID: uuid.New(), PackageID: uuid.New(), GuestCount: 2}
+	refundID := uuid.New()
+
+	var intent driver.Value
+	if paymentIntentID != nil {
+		intent = *paymentIntentID
+	}
+	fake.On(`COALESCE\(SUM`).Return([]string{"sum"}, []driver.Value{"0"})
+	fake.On(`SELECT \* FROM "bookings"`).Return(
+		[]string{"id", "customer_id", "package_id", "guest_count", "total_amount", "discount_amount", "final_amount",
+			"currency", "exchange_rate", "charged_amount", "status", "payment_status", "stripe_payment_intent_id"},
+		[]driver.Value{booking.ID.String(), booking.CustomerID.String(), booking.PackageID.String(), int64(2),
+			"1000.00", "0", "1000.00", "THB", "1", "1000.00", models.BookingStatusConfirmed, models.PaymentStatusPaid, intent},
+	)
+	fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "max_guests", "current_bookings"},
+		[]driver.Value{booking.PackageID.String(), int64(20), int64(2)},
+	)
+	fake.On(`INSERT INTO "booking_refunds"`).Return([]string{"id", "created_at"}, []driver.Value{refundID.String(), nil})
+	fake.On(`SELECT \* FROM "booking_refunds"`).Return(
+		[]string{"id", "booking_id", "amount", "refund_percentage", "status"},
+		[]driver.Value{refundID.String(), booking.ID.String(), "1000.00", "100", models.RefundStatusPending},
+	)
+	return &CancellationService{DB: db}, fake, booking, refundID
+}
+
+func TestCancelSendsRefundAfterCommit(t *testing.T) {
+	t.Setenv("STRIPE_SECRET_KEY", "")
+	intent := "pi_test_123"
+	cancellations, fake, booking, refundID := cancellationFixture(t, &intent)
+
+	result, err := cancellations.Cancel(booking.ID, models.ActorCustomer, &booking.CustomerID, "Change of plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a departure date the most generous rule applies
+	if result.Quote.RefundPercentage != models.NewDecimal(100) || result.Quote.DaysBeforeDeparture != nil {
+		t.Errorf("quote = %+v, want a full refund without a departure date", result.Quote)
+	}
+	inserts := fake.Statements(`INSERT INTO "booking_refunds"`)
+	if len(inserts) != 1 || !containsArg(inserts[0].Args, models.RefundStatusPending) {
+		t.Fatalf("refund inserts = %v, want one pending refund", inserts)
+	}
+	if result.Refund == nil || result.Refund.ID != refundID || result.Refund.Status != models.RefundStatusSucceeded {
+		t.Fatalf("refund = %+v, want refund %s succeeded", result.Refund, refundID)
+	}
+	if result.Refund.StripeRefundID == nil {
+		t.Error("refund has no Stripe refund id")
+	}
+	if got := fake.Statements(`UPDATE "booking_refunds" SET .*"status"`); len(got) != 1 || !containsArg(got[0].Args, models.RefundStatusSucceeded) {
+		t.Errorf("refund updates = %v, want one marking it succeeded", got)
+	}
+}
+
+func TestCancelLeavesRefundPendingWhenStripeFails(t *testing.T) {
+	// A booking without a payment intent cannot be refunded through Stripe
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_unreachable")
+	cancellations, fake, booking, _ := cancellationFixture(t, nil)
+
+	result, err := cancellations.Cancel(booking.ID, models.ActorCustomer, &booking.CustomerID, "")
+	if err != nil {
+		t.Fatalf("cancellation failed with the refund: %v", err)
+	}
+	if result.Refund == nil || result.Refund.Status != models.RefundStatusPending {
+		t.Fatalf("refund = %+v, want it left pending", result.Refund)
+	}
+	if got := fake.Statements(`UPDATE "bookings" SET .*"status"`); len(got) == 0 || !containsArg(got[0].Args, models.BookingStatusCancelled) {
+		t.Errorf("booking updates = %v, want the booking cancelled", got)
+	}
+	if got := fake.Statements(`UPDATE "booking_refunds"`); len(got) != 0 {
+		t.Errorf("refund updates = %v, want none", got)
+	}
+	if got := fake.Statements(`INSERT INTO "ledger_entries"`); len(got) != 0 {
+		t.Errorf("ledger entries = %v, want none for an unsent refund", got)
+	}
+}
+
+func TestBookingDepartureDateIgnoresPackageAvailability(t *testing.T) {
+	db, fake := fakesql.New(t)
+	fake.On(`FROM "travel_packages"`).Return([]string{"id", "available_from"}, []driver.Value{uuid.NewString(), "2000-01-01"})
+
+	date, err := bookingDepartureDate(db, models.Booking{ID: uuid.New(), PackageID: uuid.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if date != nil {
+		t.Errorf("departure date = %s, want none for a booking without a departure", *date)
+	}
+}
+
+func containsArg(args []driver.Value, want string) bool {
+	for _, arg := range args {
+		if text, ok := arg.(string); ok && text == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRefundPercentageForTiers(t *testing.T) {
+	tests := []struct {
+		days int
+		want models.Decimal
+	}{
+		{days: 365, want: models.NewDecimal(100)},
+		{days: 30, want: models.NewDecimal(100)},
+		{days: 29, want: models.NewDecimal(50)},
+		{days: 7, want: models.NewDecimal(50)},
+		{days: 6, want: 0},
+		{days: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := refundPercentageFor(models.DefaultRefundPolicy(), tt.days); got != tt.want {
+			t.Errorf("%d days before departure: refund %s%%, want %s%%", tt.days, got, tt.want)
+		}
+	}
+	if got := refundPercentageFor(nil, 100); got != 0 {
+		t.Errorf("without rules: refund %s%%, want 0%%", got)
+	}
+}
+
+func TestRefundPolicyFallsBackToGlobalThenDefault(t *testing.T) {
+	packageID := uuid.New()
+	ruleColumns := []string{"id", "package_id", "min_days_before_departure", "refund_percentage"}
+	tests := []struct {
+		name          string
+		packageRules  [][]driver.Value
+		globalRules   [][]driver.Value
+		wantThreshold []int
+	}{
+		{
+			name:          "package rules",
+			packageRules:  [][]driver.Value{{uuid.NewString(), packageID.String(), int64(14), "80"}},
+			globalRules:   [][]driver.Value{{uuid.NewString(), nil, int64(60), "100"}},
+			wantThreshold: []int{14},
+		},
+		{
+			name:          "global rules",
+			globalRules:   [][]driver.Value{{uuid.NewString(), nil, int64(60), "100"}, {uuid.NewString(), nil, int64(10), "25"}},
+			wantThreshold: []int{60, 10},
+		},
+		{
+			name:          "default policy",
+			wantThreshold: []int{30, 7, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := fakesql.New(t)
+			fake.On(`FROM "refund_policy_rules" WHERE package_id = `).Return(ruleColumns, tt.packageRules...)
+			fake.On(`FROM "refund_policy_rules" WHERE package_id IS NULL`).Return(ruleColumns, tt.globalRules...)
+
+			rules, err := (&CancellationService{DB: db}).RefundPolicy(packageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var thresholds []int
+			for _, rule := range rules {
+				thresholds = append(thresholds, rule.MinDaysBeforeDeparture)
+			}
+			if len(thresholds) != len(tt.wantThreshold) {
+				t.Fatalf("thresholds = %v, want %v", thresholds, tt.wantThreshold)
+			}
+			for i := range thresholds {
+				if thresholds[i] != tt.wantThreshold[i] {
+					t.Fatalf("thresholds = %v, want %v", thresholds, tt.wantThreshold)
+				}
+			}
+		})
+	}
+}
+
+func TestReplaceRefundPolicyValidatesRules(t *testing.T) {
+	rule := func(days int, percentage models.Decimal) models.RefundPolicyRule {
+		return models.RefundPolicyRule{MinDaysBeforeDeparture: days, RefundPercentage: percentage}
+	}
+	tests := []struct {
+		name    string
+		rules   []models.RefundPolicyRule
+		wantErr error
+	}{
+		{name: "valid", rules: []models.RefundPolicyRule{rule(7, models.NewDecimal(50)), rule(30, models.NewDecimal(100)), rule(0, 0)}},
+		{name: "empty falls back to the default", rules: nil},
+		{name: "negative days", rules: []models.RefundPolicyRule{rule(-1, models.NewDecimal(50))}, wantErr: ErrInvalidRefundPolicy},
+		{name: "negative percentage", rules: []models.RefundPolicyRule{rule(7, models.NewDecimal(-1))}, wantErr: ErrInvalidRefundPolicy},
+		{name: "over 100 percent", rules: []models.RefundPolicyRule{rule(7, models.NewDecimal(101))}, wantErr: ErrInvalidRefundPolicy},
+		{name: "duplicate days", rules: []models.RefundPolicyRule{rule(7, models.NewDecimal(50)), rule(7, models.NewDecimal(25))}, wantErr: ErrInvalidRefundPolicy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := fakesql.New(t)
+			saved, err := (&CancellationService{DB: db}).ReplaceRefundPolicy(uuid.New(), tt.rules)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			inserts := fake.Statements(`INSERT INTO "refund_policy_rules"`)
+			if tt.wantErr != nil {
+				if len(fake.Statements(`refund_policy_rules`)) != 0 {
+					t.Errorf("invalid rules touched the policy: %v", fake.Statements(`refund_policy_rules`))
+				}
+				return
+			}
+			if len(inserts) != len(tt.rules) {
+				t.Errorf("inserts = %d, want %d", len(inserts), len(tt.rules))
+			}
+			for i := 1; i < len(saved); i++ {
+				if saved[i-1].MinDaysBeforeDeparture < saved[i].MinDaysBeforeDeparture {
+					t.Errorf("saved rules %v are not most generous first", saved)
+				}
+			}
+		})
+	}
+}
+
+func TestCancellationQuoteUsesDaysBeforeDeparture(t *testing.T) {
+	now := time.Date(2026, 10, 17, 15, 30, 0, 0, time.UTC)
+	tests := []struct {
+		departure string
+		want      models.Decimal
+		wantErr   error
+	}{
+		{departure: "2026-11-16", want: models.NewDecimal(100)},
+		{departure: "2026-11-15", want: models.NewDecimal(50)},
+		{departure: "2026-10-24", want: models.NewDecimal(50)},
+		{departure: "2026-10-23", want: 0},
+		{departure: "2026-10-17", want: 0},
+		{departure: "2026-10-16", wantErr: ErrTripAlreadyDeparted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.departure, func(t *testing.T) {
+			db, fake := fakesql.New(t)
+			departureID := uuid.New()
+			fake.On(`FROM "package_departures"`).Return([]string{"id", "departure_date"}, []driver.Value{departureID.String(), tt.departure})
+			booking := models.Booking{
+				ID: uuid.New(), PackageID: uuid.New(), DepartureID: &departureID,
+				FinalAmount: models.NewMoney(100000, models.DefaultCurrency), Currency: models.DefaultCurrency,
+				ChargedAmount: models.NewDecimal(1000), PaymentStatus: models.PaymentStatusPaid,
+			}
+
+			quote, err := (&CancellationService{DB: db}).quote(db, booking, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if quote.RefundPercentage != tt.want {
+				t.Errorf("refund = %s%%, want %s%%", quote.RefundPercentage, tt.want)
+			}
+			if want := booking.FinalAmount.Percent(tt.want); quote.RefundAmount != want {
+				t.Errorf("refund amount = %s, want %s", quote.RefundAmount, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCommissionRuleNotFound = errors.New("commission rule not found")
+	ErrInvalidCommissionRule  = errors.New("invalid commission rule")
+	ErrCommissionRuleConflict = errors.New("an active commission rule already exists for this advertiser and package")
+)
+
+// CommissionInput describes a booking to compute a commission for.
+// Prospective is set when the booking has not been confirmed yet, so it is
+// counted on top of the advertiser's current usage and revenue.
+type CommissionInput struct {
+	AdvertiserID  uuid.UUID
+	PackageID     uuid.UUID
+	BookingAmount models.Money
+	At            time.Time
+	Prospective   bool
+}
+
+// CommissionQuote is what an advertiser earns on one booking and why.
+type CommissionQuote struct {
+	RuleID          *uuid.UUID     `json:"rule_id"`
+	RuleName        string         `json:"rule_name"`
+	RuleType        string         `json:"rule_type"`
+	Scope           string         `json:"scope"`
+	UsagePercentage models.Decimal `json:"usage_percentage"`
+	MonthlyRevenue  models.Money   `json:"monthly_revenue"`
+	Rate            models.Decimal `json:"rate"`
+	BookingAmount   models.Money   `json:"booking_amount"`
+	Amount          models.Money   `json:"amount"`
+}
+
+// CommissionService is the only place commissions are computed.
+type CommissionService struct {
+	DB *gorm.DB
+}
+
+// RuleFor returns the active rule that applies to an advertiser's bookings
+// on a package, preferring the most specific one. It returns nil when no
+// rule applies, in which case no commission is earned.
+func (s *CommissionService) RuleFor(advertiserID, packageID uuid.UUID) (*models.CommissionRule, error) {
+	var rule models.CommissionRule
+	err := s.DB.
+		Where("is_active AND (advertiser_id IS NULL OR advertiser_id = ?) AND (package_id IS NULL OR package_id = ?)", advertiserID, packageID).
+		Order("package_id IS NULL, advertiser_id IS NULL").
+		First(&rule).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Evaluate computes the commission on a booking under the rule that
+// applies to it.
+func (s *CommissionService) Evaluate(in CommissionInput) (*CommissionQuote, error) {
+	if in.At.IsZero() {
+		in.At = time.Now()
+	}
+	currency := in.BookingAmount.Currency()
+	quote := &CommissionQuote{BookingAmount: in.BookingAmount, Amount: models.NewMoney(0, currency)}
+
+	usage, err := s.usagePercentage(in)
+	if err != nil {
+		return nil, err
+	}
+	revenue, err := s.monthlyRevenue(in)
+	if err != nil {
+		return nil, err
+	}
+	quote.UsagePercentage = usage
+	quote.MonthlyRevenue = revenue
+
+	rule, err := s.RuleFor(in.AdvertiserID, in.PackageID)
+	if err != nil || rule == nil {
+		return quote, err
+	}
+	quote.RuleID = &rule.ID
+	quote.RuleName = rule.Name
+	quote.RuleType = rule.Type
+	quote.Scope = rule.Scope()
+
+	switch rule.Type {
+	case models.CommissionRuleFlatPercentage:
+		quote.Rate = rule.Rate
+	case models.CommissionRuleUsageTiers:
+		quote.Rate = rule.Tiers.TierRate(usage)
+	case models.CommissionRuleRevenueTiers:
+		quote.Rate = rule.Tiers.TierRate(revenue.Decimal())
+	case models.CommissionRuleFixedPerBooking:
+		quote.Amount = models.MoneyFromDecimal(rule.FixedAmount.Decimal(), currency)
+		return quote, nil
+	}
+	quote.Amount = in.BookingAmount.Percent(quote.Rate)
+	return quote, nil
+}
+
+// RecordForBooking creates the commission for a confirmed booking made with
+// an advertiser's discount code. Bookings without one, or whose rule earns
+// nothing, get no commission; a booking is only ever paid commission once.
+func (s *CommissionService) RecordForBooking(booking models.Booking) (*models.Commission, error) {
+	if booking.DiscountCodeID == nil {
+		return nil, nil
+	}
+
+	var discountCode models.DiscountCode
+	if err := s.DB.First(&discountCode, "id = ?", *booking.DiscountCodeID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var advertiser models.Profile
+	if err := s.DB.First(&advertiser, "id = ?", discountCode.AdvertiserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if advertiser.UserRole != models.RoleAdvertiser {
+		return nil, nil
+	}
+
+	var existing models.Commission
+	err := s.DB.Where("booking_id = ?", booking.ID).First(&existing).Error
+	if err == nil {
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	quote, err := s.Evaluate(CommissionInput{
+		AdvertiserID:  discountCode.AdvertiserID,
+		PackageID:     booking.PackageID,
+		BookingAmount: booking.FinalAmount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !quote.Amount.IsPositive() {
+		return nil, nil
+	}
+
+	commission := &models.Commission{
+		BookingID:            booking.ID,
+		AdvertiserID:         discountCode.AdvertiserID,
+		DiscountCodeID:       &discountCode.ID,
+		CommissionAmount:     quote.Amount,
+		CommissionPercentage: quote.Rate,
+		Status:               models.CommissionStatusPending,
+	}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(commission).Error; err != nil {
+			return err
+		}
+		ledger := &LedgerService{DB: tx}
+		return ledger.PostCommissionEarned(*commission)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return commission, nil
+}
+
+// ListRules returns every commission rule, the most specific first.
+func (s *CommissionService) ListRules() ([]models.CommissionRule, error) {
+	var rules []models.CommissionRule
+	err := s.DB.Order("is_active DESC, package_id IS NULL, advertiser_id IS NULL, created_at DESC").Find(&rules).Error
+	return rules, err
+}
+
+// SaveRule validates and stores a new or changed rule.
+func (s *CommissionService) SaveRule(rule *models.CommissionRule) error {
+	if err := s.validateRule(rule); err != nil {
+		return err
+	}
+
+	if rule.IsActive {
+		query := s.DB.Model(&models.CommissionRule{}).Where("is_active")
+		if rule.AdvertiserID == nil {
+			query = query.Where("advertiser_id IS NULL")
+		} else {
+			query = query.Where("advertiser_id = ?", *rule.AdvertiserID)
+		}
+		if rule.PackageID == nil {
+			query = query.Where("package_id IS NULL")
+		} else {
+			query = query.Where("package_id = ?", *rule.PackageID)
+		}
+		if rule.ID != uuid.Nil {
+			query = query.Where("id <> ?", rule.ID)
+		}
+		var conflicts int64
+		if err := query.Count(&conflicts).Error; err != nil {
+			return err
+		}
+		if conflicts > 0 {
+			return ErrCommissionRuleConflict
+		}
+	}
+
+	if rule.ID == uuid.Nil {
+		return s.DB.Create(rule).Error
+	}
+	return s.DB.Save(rule).Error
+}
+
+// GetRule loads one rule.
+func (s *CommissionService) GetRule(ruleID uuid.UUID) (*models.CommissionRule, error) {
+	var rule models.CommissionRule
+	if err := s.DB.First(&rule, "id = ?", ruleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommissionRuleNotFound
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// DeleteRule removes a rule. Commissions already recorded keep their amount.
+func (s *CommissionService) DeleteRule(ruleID uuid.UUID) (*models.CommissionRule, error) {
+	rule, err := s.GetRule(ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.Delete(rule).Error; err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *CommissionService) validateRule(rule *models.CommissionRule) error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidCommissionRule, fmt.Sprintf(format, args...))
+	}
+
+	switch rule.Type {
+	case models.CommissionRuleFlatPercentage:
+		if rule.Rate < 0 || rule.Rate > models.NewDecimal(100) {
+			return invalid("rate must be between 0 and 100")
+		}
+		rule.FixedAmount, rule.Tiers = models.Money{}, nil
+	case models.CommissionRuleFixedPerBooking:
+		if rule.FixedAmount.IsNegative() {
+			return invalid("fixed_amount cannot be negative")
+		}
+		rule.Rate, rule.Tiers = 0, nil
+	case models.CommissionRuleUsageTiers, models.CommissionRuleRevenueTiers:
+		if len(rule.Tiers) == 0 {
+			return invalid("tiers are required")
+		}
+		seen := make(map[models.Decimal]bool)
+		for _, tier := range rule.Tiers {
+			if tier.Threshold < 0 || tier.Rate < 0 || tier.Rate > models.NewDecimal(100) {
+				return invalid("tier thresholds cannot be negative and rates must be between 0 and 100")
+			}
+			if seen[tier.Threshold] {
+				return invalid("tier thresholds must be unique")
+			}
+			seen[tier.Threshold] = true
+		}
+		sort.Slice(rule.Tiers, func(i, j int) bool { return rule.Tiers[i].Threshold < rule.Tiers[j].Threshold })
+		rule.Rate, rule.FixedAmount = 0, models.Money{}
+	default:
+		return invalid("type must be one of %v", models.CommissionRuleTypes())
+	}
+
+	if rule.AdvertiserID != nil {
+		var advertiser models.Profile
+		if err := s.DB.First(&advertiser, "id = ?", *rule.AdvertiserID).Error; err != nil || advertiser.UserRole != models.RoleAdvertiser {
+			return invalid("advertiser not found")
+		}
+	}
+	if rule.PackageID != nil {
+		var count int64
+		if err := s.DB.Model(&models.TravelPackage{}).Where("id = ?", *rule.PackageID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return invalid("package not found")
+		}
+	}
+	return nil
+}
+
+// usagePercentage is how much of the advertiser's discount code for the
+// package has been used by confirmed bookings, against the code's MaxUses
+// or else the package's MaxGuests.
+func (s *CommissionService) usagePercentage(in CommissionInput) (models.Decimal, error) {
+	var discountCode models.DiscountCode
+	err := s.DB.Preload("Package").
+		Where("advertiser_id = ? AND package_id = ?", in.AdvertiserID, in.PackageID).
+		First(&discountCode).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	if err := s.DB.Model(&models.DiscountRedemption{}).
+		Where("discount_code_id = ? AND status = ?", discountCode.ID, models.RedemptionStatusRedeemed).
+		Count(&used).Error; err != nil {
+		return 0, err
+	}
+	if in.Prospective {
+		used++
+	}
+
+	capacity := int64(1)
+	if discountCode.MaxUses != nil && *discountCode.MaxUses > 0 {
+		capacity = int64(*discountCode.MaxUses)
+	} else if discountCode.Package.MaxGuests > 0 {
+		capacity = int64(discountCode.Package.MaxGuests)
+	}
+	return models.DecimalRatio(used, capacity), nil
+}
+
+// monthlyRevenue is what the advertiser's discount codes brought in from
+// confirmed bookings in the calendar month of in.At.
+func (s *CommissionService) monthlyRevenue(in CommissionInput) (models.Money, error) {
+	start := time.Date(in.At.Year(), in.At.Month(), 1, 0, 0, 0, 0, in.At.Location())
+	revenue := models.NewMoney(0, in.BookingAmount.Currency())
+	err := s.DB.Model(&models.Booking{}).
+		Select("COALESCE(SUM(final_amount), 0)").
+		Where("status IN ? AND created_at >= ? AND created_at < ?",
+			[]string{models.BookingStatusConfirmed, models.BookingStatusCompleted}, start, start.AddDate(0, 1, 0)).
+		Where("discount_code_id IN (SELECT id FROM discount_codes WHERE advertiser_id = ?)", in.AdvertiserID).
+		Row().Scan(&revenue)
+	if err != nil {
+		return revenue, err
+	}
+	if in.Prospective {
+		revenue = revenue.Add(in.BookingAmount)
+	}
+	return revenue, nil
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// CustomerBookingFilter narrows a customer's booking list. Dates are
+// YYYY-MM-DD and apply to the booking date; empty fields are ignored.
+type CustomerBookingFilter struct {
+	Statuses        []string
+	PaymentStatuses []string
+	From            string
+	To              string
+	Limit           int
+	Offset          int
+}
+
+type CustomerBookingService struct {
+	DB *gorm.DB
+}
+
+// ListBookings returns one page of a customer's bookings, newest first,
+// together with the total number of bookings matching the filter.
+func (s *CustomerBookingService) ListBookings(customerID uuid.UUID, filter CustomerBookingFilter) ([]models.Booking, int64, error) {
+	query := s.DB.Model(&models.Booking{}).Where("customer_id = ?", customerID)
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if len(filter.PaymentStatuses) > 0 {
+		query = query.Where("payment_status IN ?", filter.PaymentStatuses)
+	}
+	if filter.From != "" {
+		query = query.Where("booking_date >= ?", filter.From)
+	}
+	if filter.To != "" {
+		query = query.Where("booking_date <= ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var bookings []models.Booking
+	err := withBookingDetails(query).
+		Order("created_at DESC").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Find(&bookings).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return bookings, total, nil
+}
+
+// GetBooking returns one of the customer's bookings. Bookings belonging to
+// someone else are reported as not found.
+func (s *CustomerBookingService) GetBooking(customerID, bookingID uuid.UUID) (*models.Booking, error) {
+	var booking models.Booking
+	err := withBookingDetails(s.DB).
+		Where("id = ? AND customer_id = ?", bookingID, customerID).
+		First(&booking).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrBookingNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+func withBookingDetails(query *gorm.DB) *gorm.DB {
+	return query.
+		Preload("TravelPackages").
+		Preload("Departure").
+		Preload("DiscountCode").
+		Preload("GlobalCode").
+		Preload("Refunds")
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnsupportedCurrency  = errors.New("unsupported currency")
+	ErrExchangeRateNotFound = errors.New("no exchange rate for this currency")
+	ErrInvalidExchangeRate  = errors.New("invalid exchange rate")
+)
+
+// ExchangeRateService keeps the rates customers are charged at. Rates are
+// entered by managers or imported from CSV; there is no live feed.
+type ExchangeRateService struct {
+	DB *gorm.DB
+}
+
+// ExchangeRateInput is one rate to add. A zero EffectiveAt means now.
+type ExchangeRateInput struct {
+	Currency    string
+	Rate        models.Rate
+	EffectiveAt time.Time
+}
+
+// Conversion is an amount in the base currency and what it comes to in
+// another at the current rate. RateID and RateEffectiveAt are nil for the
+// base currency.
+type Conversion struct {
+	Amount          models.Money    `json:"amount"`
+	Currency        models.Currency `json:"currency"`
+	Rate            models.Rate     `json:"rate"`
+	RateID          *uuid.UUID      `json:"rate_id"`
+	RateEffectiveAt *time.Time      `json:"rate_effective_at"`
+	Converted       models.Money    `json:"converted"`
+}
+
+// Current returns the rate in effect now for a currency. The base
+// currency always converts at 1 and has no stored rate.
+func (s *ExchangeRateService) Current(currency models.Currency) (*models.ExchangeRate, error) {
+	currency, err := models.ParseCurrency(string(currency))
+	if err != nil {
+		return nil, ErrUnsupportedCurrency
+	}
+	if currency == models.DefaultCurrency {
+		return &models.ExchangeRate{BaseCurrency: models.DefaultCurrency, Currency: models.DefaultCurrency, Rate: models.RateOne}, nil
+	}
+
+	var rate models.ExchangeRate
+	err = s.DB.Where("base_currency = ? AND currency = ? AND effective_at <= ?", models.DefaultCurrency, currency, time.Now()).
+		Order("effective_at DESC, created_at DESC").
+		First(&rate).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrExchangeRateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// CurrentRates returns the rate in effect now for every currency that has
+// one, the base currency first.
+func (s *ExchangeRateService) CurrentRates() ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	err := s.DB.Raw(`SELECT DISTINCT ON (currency) * FROM exchange_rates
+		WHERE base_currency = ? AND effective_at <= ?
+		ORDER BY currency, effective_at DESC, created_at DESC`, models.DefaultCurrency, time.Now()).
+		Scan(&rates).Error
+	if err != nil {
+		return nil, err
+	}
+	base := models.ExchangeRate{BaseCurrency: models.DefaultCurrency, Currency: models.DefaultCurrency, Rate: models.RateOne}
+	return append([]models.ExchangeRate{base}, rates...), nil
+}
+
+// History returns the rates added for a currency, the latest first.
+func (s *ExchangeRateService) History(currency models.Currency, limit int) ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	err := s.DB.Where("base_currency = ? AND currency = ?", models.DefaultCurrency, currency).
+		Order("effective_at DESC, created_at DESC").
+		Limit(limit).
+		Find(&rates).Error
+	return rates, err
+}
+
+// Convert prices a base currency amount in another currency at the
+// current rate.
+func (s *ExchangeRateService) Convert(amount models.Money, currency models.Currency) (*Conversion, error) {
+	rate, err := s.Current(currency)
+	if err != nil {
+		return nil, err
+	}
+	conversion := conversionAt(rate, amount)
+	return &conversion, nil
+}
+
+// ConvertAll prices several base currency amounts in another currency at
+// the same current rate.
+func (s *ExchangeRateService) ConvertAll(amounts []models.Money, currency models.Currency) ([]Conversion, error) {
+	rate, err := s.Current(currency)
+	if err != nil {
+		return nil, err
+	}
+	conversions := make([]Conversion, len(amounts))
+	for i, amount := range amounts {
+		conversions[i] = conversionAt(rate, amount)
+	}
+	return conversions, nil
+}
+
+func conversionAt(rate *models.ExchangeRate, amount models.Money) Conversion {
+	conversion := Conversion{
+		Amount:    amount,
+		Currency:  rate.Currency,
+		Rate:      rate.Rate,
+		Converted: amount.Convert(rate.Rate, rate.Currency),
+	}
+	if rate.ID != uuid.Nil {
+		conversion.RateID = &rate.ID
+		conversion.RateEffectiveAt = &rate.EffectiveAt
+	}
+	return conversion
+}
+
+// AddRates validates and stores new rates together; if one is invalid none
+// are added.
+func (s *ExchangeRateService) AddRates(inputs []ExchangeRateInput, source string, createdBy *uuid.UUID) ([]models.ExchangeRate, error) {
+	now := time.Now()
+	rates := make([]models.ExchangeRate, 0, len(inputs))
+	for i, input := range inputs {
+		rate, err := newExchangeRate(input, source, createdBy, now)
+		if err != nil {
+			return nil, fmt.Errorf("%w: rate %d: %v", ErrInvalidExchangeRate, i+1, err)
+		}
+		rates = append(rates, rate)
+	}
+	return s.create(rates)
+}
+
+// ImportCSV adds the rates in a CSV file with the columns currency, rate
+// and an optional effective_at (YYYY-MM-DD or RFC 3339). A header row is
+// allowed. Nothing is imported unless every row is valid.
+func (s *ExchangeRateService) ImportCSV(file io.Reader, createdBy *uuid.UUID) ([]models.ExchangeRate, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	now := time.Now()
+	var rates []models.ExchangeRate
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidExchangeRate, line, err)
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "currency") {
+			continue
+		}
+		if len(record) < 2 || len(record) > 3 {
+			return nil, fmt.Errorf("%w: line %d: expected currency,rate[,effective_at]", ErrInvalidExchangeRate, line)
+		}
+
+		input := ExchangeRateInput{Currency: record[0]}
+		if input.Rate, err = models.ParseRate(record[1]); err != nil {
+			return nil, fmt.Errorf("%w: line %d: rate %q is not a number", ErrInvalidExchangeRate, line, record[1])
+		}
+		if len(record) == 3 && strings.TrimSpace(record[2]) != "" {
+			if input.EffectiveAt, err = parseEffectiveAt(record[2]); err != nil {
+				return nil, fmt.Errorf("%w: line %d: effective_at %q must be YYYY-MM-DD or RFC 3339", ErrInvalidExchangeRate, line, record[2])
+			}
+		}
+		rate, err := newExchangeRate(input, models.ExchangeRateSourceImport, createdBy, now)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidExchangeRate, line, err)
+		}
+		rates = append(rates, rate)
+	}
+	return s.create(rates)
+}
+
+func (s *ExchangeRateService) create(rates []models.ExchangeRate) ([]models.ExchangeRate, error) {
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("%w: no rates given", ErrInvalidExchangeRate)
+	}
+	if err := s.DB.Create(&rates).Error; err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func newExchangeRate(input ExchangeRateInput, source string, createdBy *uuid.UUID, now time.Time) (models.ExchangeRate, error) {
+	currency, err := models.ParseCurrency(input.Currency)
+	if err != nil || currency == models.DefaultCurrency {
+		return models.ExchangeRate{}, fmt.Errorf("%q is not a supported currency other than %s", input.Currency, models.DefaultCurrency)
+	}
+	if input.Rate <= 0 {
+		return models.ExchangeRate{}, fmt.Errorf("the %s rate must be positive", currency)
+	}
+	effectiveAt := input.EffectiveAt
+	if effectiveAt.IsZero() {
+		effectiveAt = now
+	}
+	return models.ExchangeRate{
+		BaseCurrency: models.DefaultCurrency,
+		Currency:     currency,
+		Rate:         input.Rate,
+		Source:       source,
+		EffectiveAt:  effectiveAt,
+		CreatedBy:    createdBy,
+	}, nil
+}
+
+func parseEffectiveAt(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const defaultImpersonationTTL = 15 * time.Minute
+
+var (
+	ErrImpersonationTargetNotFound = errors.New("user not found")
+	ErrCannotImpersonate           = errors.New("managers and your own account cannot be impersonated")
+)
+
+// ImpersonationTTL is how long an impersonation token works, set with
+// IMPERSONATION_TTL. It cannot be refreshed.
+func ImpersonationTTL() time.Duration {
+	return durationFromEnv("IMPERSONATION_TTL", defaultImpersonationTTL)
+}
+
+type ImpersonationService struct {
+	DB *gorm.DB
+}
+
+// Target loads the user a manager wants to impersonate. Other managers are
+// off limits, so impersonation never grants more than the manager has.
+func (s *ImpersonationService) Target(managerID, userID uuid.UUID) (*models.Profile, error) {
+	var profile models.Profile
+	if err := s.DB.First(&profile, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImpersonationTargetNotFound
+		}
+		return nil, err
+	}
+	if profile.ID == managerID || profile.UserRole == models.RoleManager {
+		return nil, ErrCannotImpersonate
+	}
+	return &profile, nil
+}
+
+// Log records one request made while impersonating.
+func (s *ImpersonationService) Log(entry *models.ImpersonationLog) error {
+	return s.DB.Create(entry).Error
+}
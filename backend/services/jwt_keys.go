@@ -0,0 +1,215 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKeys = errors.New("no JWT signing keys configured, set JWT_KEYS_DIR")
+	ErrUnknownKeyID  = errors.New("token signed with an unknown key")
+)
+
+// JWTKey is one key of the keyset. Retired keys only have a public half and
+// are kept so tokens they signed stay valid until they expire.
+type JWTKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// JWTKeySet signs access tokens with its active key and verifies them with
+// any of its keys, chosen by the kid header.
+type JWTKeySet struct {
+	active *JWTKey
+	keys   map[string]*JWTKey
+}
+
+// JWK is the public half of a key as served by /.well-known/jwks.json.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+var (
+	jwtKeysOnce sync.Once
+	jwtKeys     *JWTKeySet
+	jwtKeysErr  error
+)
+
+// InitJWTKeys loads the keyset from JWT_KEYS_DIR, which holds one PEM file
+// per key named <kid>.pem. RSA keys sign with RS256 and Ed25519 keys with
+// EdDSA. JWT_ACTIVE_KEY_ID picks the signing key when there are several
+// private keys. Without keys, requireKeys makes this an error; otherwise a
+// throwaway key is generated, so tokens do not survive a restart.
+func InitJWTKeys(requireKeys bool) (*JWTKeySet, error) {
+	jwtKeysOnce.Do(func() {
+		dir := os.Getenv("JWT_KEYS_DIR")
+		if dir != "" {
+			jwtKeys, jwtKeysErr = LoadJWTKeySet(dir, os.Getenv("JWT_ACTIVE_KEY_ID"))
+			return
+		}
+		if requireKeys {
+			jwtKeysErr = ErrNoSigningKeys
+			return
+		}
+		log.Println("JWT_KEYS_DIR not set, signing tokens with a temporary development key")
+		jwtKeys, jwtKeysErr = newDevelopmentKeySet()
+	})
+	return jwtKeys, jwtKeysErr
+}
+
+// JWTKeys returns the keyset loaded by InitJWTKeys, loading it in
+// development mode if that has not happened yet.
+func JWTKeys() (*JWTKeySet, error) {
+	return InitJWTKeys(false)
+}
+
+// LoadJWTKeySet reads every <kid>.pem file in dir.
+func LoadJWTKeySet(dir, activeKeyID string) (*JWTKeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	set := &JWTKeySet{keys: make(map[string]*JWTKey)}
+	var signers []*JWTKey
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseJWTKey(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		set.keys[key.ID] = key
+		if key.Private != nil {
+			signers = append(signers, key)
+		}
+	}
+
+	switch {
+	case activeKeyID != "":
+		key, ok := set.keys[activeKeyID]
+		if !ok || key.Private == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KEY_ID %q has no private key in %s", activeKeyID, dir)
+		}
+		set.active = key
+	case len(signers) == 1:
+		set.active = signers[0]
+	case len(signers) == 0:
+		return nil, ErrNoSigningKeys
+	default:
+		return nil, fmt.Errorf("%s has %d private keys, set JWT_ACTIVE_KEY_ID", dir, len(signers))
+	}
+	return set, nil
+}
+
+func parseJWTKey(id string, data []byte) (*JWTKey, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return &JWTKey{ID: id, Method: jwt.SigningMethodRS256, Private: rsaKey, Public: &rsaKey.PublicKey}, nil
+	}
+	if edKey, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		if signer, ok := edKey.(ed25519.PrivateKey); ok {
+			return &JWTKey{ID: id, Method: jwt.SigningMethodEdDSA, Private: signer, Public: signer.Public()}, nil
+		}
+	}
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return &JWTKey{ID: id, Method: jwt.SigningMethodRS256, Public: rsaKey}, nil
+	}
+	if edKey, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return &JWTKey{ID: id, Method: jwt.SigningMethodEdDSA, Public: edKey}, nil
+	}
+	return nil, errors.New("not an RSA or Ed25519 key in PEM format")
+}
+
+func newDevelopmentKeySet() (*JWTKeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &JWTKey{ID: "dev-" + randomKeySuffix(), Method: jwt.SigningMethodEdDSA, Private: private, Public: public}
+	return &JWTKeySet{active: key, keys: map[string]*JWTKey{key.ID: key}}, nil
+}
+
+func randomKeySuffix() string {
+	buf := make([]byte, 6)
+	_, _ = rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Sign signs claims with the active key and names it in the kid header.
+func (s *JWTKeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.active.Method, claims)
+	token.Header["kid"] = s.active.ID
+	return token.SignedString(s.active.Private)
+}
+
+// Keyfunc finds the verification key for a token. It is meant for
+// jwt.Parse together with ValidMethods.
+func (s *JWTKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %s does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// ValidMethods lists the algorithms tokens may be signed with.
+func (s *JWTKeySet) ValidMethods() []string {
+	return []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+}
+
+// JWKS returns the public keys in JSON Web Key Set form.
+func (s *JWTKeySet) JWKS() []JWK {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := make([]JWK, 0, len(ids))
+	for _, id := range ids {
+		key := s.keys[id]
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnbalancedEntry = errors.New("ledger entry does not balance")
+
+// LedgerService posts the money movements of bookings, refunds, commissions
+// and payouts to the double-entry ledger and reports on it. Posting methods
+// should be given the transaction that makes the change they record, so the
+// ledger can never disagree with the rows it describes.
+type LedgerService struct {
+	DB *gorm.DB
+}
+
+// AccountBalance is an account's totals on a trial balance. Balance is
+// positive when the account is on its normal side.
+type AccountBalance struct {
+	models.LedgerAccount
+	Debit   models.Money `json:"debit"`
+	Credit  models.Money `json:"credit"`
+	Balance models.Money `json:"balance"`
+}
+
+// TrialBalance lists every account's totals. The ledger is healthy when
+// Balanced is true and no entry is unbalanced on its own.
+type TrialBalance struct {
+	AsOf              time.Time        `json:"as_of"`
+	Accounts          []AccountBalance `json:"accounts"`
+	TotalDebit        models.Money     `json:"total_debit"`
+	TotalCredit       models.Money     `json:"total_credit"`
+	Balanced          bool             `json:"balanced"`
+	UnbalancedEntries int64            `json:"unbalanced_entries"`
+}
+
+// IncomeStatement is the platform's result over a period.
+type IncomeStatement struct {
+	From              time.Time    `json:"from"`
+	To                time.Time    `json:"to"`
+	GrossRevenue      models.Money `json:"gross_revenue"`
+	Discounts         models.Money `json:"discounts"`
+	Refunds           models.Money `json:"refunds"`
+	NetRevenue        models.Money `json:"net_revenue"`
+	CommissionExpense models.Money `json:"commission_expense"`
+	NetIncome         models.Money `json:"net_income"`
+}
+
+// LedgerEntryFilter narrows ListEntries. Zero values are ignored.
+type LedgerEntryFilter struct {
+	EventType   string
+	Account     string
+	ReferenceID *uuid.UUID
+	BookingID   *uuid.UUID
+	From        *time.Time
+	To          *time.Time
+	Limit       int
+	Offset      int
+}
+
+// PostBookingConfirmed recognises a paid booking: the amount the customer
+// paid and the discount they were given make up the package revenue.
+// Bookings are only confirmed once Stripe has taken the payment, so the
+// receivable is settled into cash straight away.
+func (s *LedgerService) PostBookingConfirmed(booking models.Booking) error {
+	gross := booking.FinalAmount.Add(booking.DiscountAmount)
+	lines := []models.LedgerLine{
+		{Account: models.LedgerAccountCustomerReceivables, Debit: booking.FinalAmount},
+		{Account: models.LedgerAccountPlatformRevenue, Credit: gross},
+	}
+	if booking.DiscountAmount.IsPositive() {
+		lines = append(lines, models.LedgerLine{Account: models.LedgerAccountDiscounts, Debit: booking.DiscountAmount})
+	}
+	if err := s.post(models.LedgerEntry{
+		EventType:   models.LedgerEventBookingConfirmed,
+		ReferenceID: booking.ID,
+		BookingID:   &booking.ID,
+		Description: "Booking confirmed",
+	}, lines); err != nil {
+		return err
+	}
+	return s.post(models.LedgerEntry{
+		EventType:   models.LedgerEventPaymentReceived,
+		ReferenceID: booking.ID,
+		BookingID:   &booking.ID,
+		Description: "Payment received",
+	}, []models.LedgerLine{
+		{Account: models.LedgerAccountCash, Debit: booking.FinalAmount},
+		{Account: models.LedgerAccountCustomerReceivables, Credit: booking.FinalAmount},
+	})
+}
+
+// PostRefund brings the booking's posted refunds up to refundedTotal, the
+// amount refunded so far according to the caller, paid back out of cash.
+// Customer cancellations and Stripe both report the same refund, so only
+// the difference is posted.
+func (s *LedgerService) PostRefund(bookingID uuid.UUID, refundedTotal models.Money) error {
+	var booking models.Booking
+	if err := s.DB.Clauses(clause.Locking{Strength: "UPDATE"}).First(&booking, "id = ?", bookingID).Error; err != nil {
+		return err
+	}
+
+	posted := models.NewMoney(0, refundedTotal.Currency())
+	if err := s.DB.Table("ledger_lines").
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_lines.entry_id").
+		Where("ledger_entries.event_type = ? AND ledger_entries.reference_id = ? AND ledger_lines.account = ?",
+			models.LedgerEventBookingRefunded, bookingID, models.LedgerAccountRefunds).
+		Select("COALESCE(SUM(ledger_lines.debit), 0)").
+		Row().Scan(&posted); err != nil {
+		return err
+	}
+
+	amount := refundedTotal.Sub(posted)
+	if !amount.IsPositive() {
+		return nil
+	}
+	return s.post(models.LedgerEntry{
+		EventType:   models.LedgerEventBookingRefunded,
+		ReferenceID: bookingID,
+		BookingID:   &bookingID,
+		Description: "Booking refunded",
+	}, []models.LedgerLine{
+		{Account: models.LedgerAccountRefunds, Debit: amount},
+		{Account: models.LedgerAccountCash, Credit: amount},
+	})
+}
+
+// PostCommissionEarned records what the platform owes an advertiser.
+func (s *LedgerService) PostCommissionEarned(commission models.Commission) error {
+	amount := commission.CommissionAmount
+	return s.post(models.LedgerEntry{
+		EventType:   models.LedgerEventCommissionEarned,
+		ReferenceID: commission.ID,
+		BookingID:   &commission.BookingID,
+		Description: "Commission earned",
+	}, []models.LedgerLine{
+		{Account: models.LedgerAccountCommissionExpense, Debit: amount},
+		{Account: models.LedgerAccountAdvertiserPayable, AdvertiserID: &commission.AdvertiserID, Credit: amount},
+	})
+}
+
+// PostCommissionReversed takes back a commission, whether it was still
+// unpaid or is clawed back from a later payout.
+func (s *LedgerService) PostCommissionReversed(commission models.Commission) error {
+	amount := commission.CommissionAmount.Abs()
+	return s.post(models.LedgerEntry{
+		EventType:   models.LedgerEventCommissionReversed,
+		ReferenceID: commission.ID,
+		BookingID:   &commission.BookingID,
+		Description: "Commission reversed",
+	}, []models.LedgerLine{
+		{Account: models.LedgerAccountAdvertiserPayable, AdvertiserID: &commission.AdvertiserID, Debit: amount},
+		{Account: models.LedgerAccountCommissionExpense, Credit: amount},
+	})
+}
+
+// PostPayoutPaid settles what was owed to an advertiser.
+func (s *LedgerService) PostPayoutPaid(payout models.Payout) error {
+	amount := payout.Amount
+	description := "Payout paid"
+	if payout.PaymentReference != nil {
+		description = fmt.Sprintf("Payout paid, reference %s", *payout.PaymentReference)
+	}
+	return s.post(models.LedgerEntry{
+		EventType:   models.LedgerEventPayoutPaid,
+		ReferenceID: payout.ID,
+		Description: description,
+	}, []models.LedgerLine{
+		{Account: models.LedgerAccountAdvertiserPayable, AdvertiserID: &payout.AdvertiserID, Debit: amount},
+		{Account: models.LedgerAccountCash, Credit: amount},
+	})
+}
+
+// post writes a balanced entry. Every event except refunds is posted once
+// per reference, so repeating a post is harmless.
+func (s *LedgerService) post(entry models.LedgerEntry, lines []models.LedgerLine) error {
+	var debits, credits models.Money
+	kept := lines[:0]
+	for _, line := range lines {
+		if line.Debit.IsNegative() || line.Credit.IsNegative() || (line.Debit.IsPositive() && line.Credit.IsPositive()) {
+			return ErrUnbalancedEntry
+		}
+		if line.Debit.IsZero() && line.Credit.IsZero() {
+			continue
+		}
+		debits = debits.Add(line.Debit)
+		credits = credits.Add(line.Credit)
+		kept = append(kept, line)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if debits.Cmp(credits) != 0 {
+		return fmt.Errorf("%w: %s %s debits %s, credits %s", ErrUnbalancedEntry, entry.EventType, entry.ReferenceID, debits, credits)
+	}
+
+	if entry.EventType != models.LedgerEventBookingRefunded {
+		var existing int64
+		if err := s.DB.Model(&models.LedgerEntry{}).
+			Where("event_type = ? AND reference_id = ?", entry.EventType, entry.ReferenceID).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+	}
+
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now()
+	}
+	entry.Lines = kept
+	return s.DB.Create(&entry).Error
+}
+
+// TrialBalance totals every account over the entries up to asOf.
+func (s *LedgerService) TrialBalance(asOf time.Time) (*TrialBalance, error) {
+	var rows []struct {
+		Account string
+		Debit   models.Money
+		Credit  models.Money
+	}
+	if err := s.DB.Table("ledger_lines").
+		Select("ledger_lines.account, COALESCE(SUM(ledger_lines.debit), 0) AS debit, COALESCE(SUM(ledger_lines.credit), 0) AS credit").
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_lines.entry_id").
+		Where("ledger_entries.occurred_at <= ?", asOf).
+		Group("ledger_lines.account").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	totals := make(map[string][2]models.Money, len(rows))
+	for _, row := range rows {
+		totals[row.Account] = [2]models.Money{row.Debit, row.Credit}
+	}
+
+	trial := &TrialBalance{AsOf: asOf}
+	for _, account := range models.LedgerAccounts() {
+		balance := AccountBalance{
+			LedgerAccount: account,
+			Debit:         totals[account.Code][0],
+			Credit:        totals[account.Code][1],
+		}
+		balance.Balance = balance.Credit.Sub(balance.Debit)
+		if account.DebitNormal {
+			balance.Balance = balance.Balance.Neg()
+		}
+		trial.TotalDebit = trial.TotalDebit.Add(balance.Debit)
+		trial.TotalCredit = trial.TotalCredit.Add(balance.Credit)
+		trial.Accounts = append(trial.Accounts, balance)
+	}
+
+	if err := s.DB.Raw(`SELECT COUNT(*) FROM (
+		SELECT ledger_lines.entry_id FROM ledger_lines
+		JOIN ledger_entries ON ledger_entries.id = ledger_lines.entry_id
+		WHERE ledger_entries.occurred_at <= ?
+		GROUP BY ledger_lines.entry_id HAVING SUM(ledger_lines.debit) <> SUM(ledger_lines.credit)
+	) unbalanced`, asOf).Scan(&trial.UnbalancedEntries).Error; err != nil {
+		return nil, err
+	}
+	trial.Balanced = trial.TotalDebit.Cmp(trial.TotalCredit) == 0 && trial.UnbalancedEntries == 0
+	return trial, nil
+}
+
+// IncomeStatement reports revenue and commission expense for entries
+// between from and to.
+func (s *LedgerService) IncomeStatement(from, to time.Time) (*IncomeStatement, error) {
+	net, err := s.accountTotals(from, to)
+	if err != nil {
+		return nil, err
+	}
+	statement := &IncomeStatement{
+		From:              from,
+		To:                to,
+		GrossRevenue:      net[models.LedgerAccountPlatformRevenue].Neg(),
+		Discounts:         net[models.LedgerAccountDiscounts],
+		Refunds:           net[models.LedgerAccountRefunds],
+		CommissionExpense: net[models.LedgerAccountCommissionExpense],
+	}
+	statement.NetRevenue = statement.GrossRevenue.Sub(statement.Discounts).Sub(statement.Refunds)
+	statement.NetIncome = statement.NetRevenue.Sub(statement.CommissionExpense)
+	return statement, nil
+}
+
+// NetRevenueByMonth is net revenue, after discounts and refunds, per
+// YYYY-MM month from since onwards.
+func (s *LedgerService) NetRevenueByMonth(since time.Time) (map[string]models.Money, error) {
+	var rows []struct {
+		Month   string
+		Revenue models.Money
+	}
+	if err := s.DB.Table("ledger_lines").
+		Select("TO_CHAR(ledger_entries.occurred_at, 'YYYY-MM') AS month, COALESCE(SUM(ledger_lines.credit - ledger_lines.debit), 0) AS revenue").
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_lines.entry_id").
+		Where("ledger_entries.occurred_at >= ? AND ledger_lines.account IN ?", since, revenueAccounts()).
+		Group("TO_CHAR(ledger_entries.occurred_at, 'YYYY-MM')").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	revenue := make(map[string]models.Money, len(rows))
+	for _, row := range rows {
+		revenue[row.Month] = row.Revenue
+	}
+	return revenue, nil
+}
+
+// TotalNetRevenue is net revenue, after discounts and refunds, over the
+// whole ledger.
+func (s *LedgerService) TotalNetRevenue() (models.Money, error) {
+	var revenue models.Money
+	err := s.DB.Model(&models.LedgerLine{}).
+		Select("COALESCE(SUM(credit - debit), 0)").
+		Where("account IN ?", revenueAccounts()).
+		Row().Scan(&revenue)
+	return revenue, err
+}
+
+// ListEntries returns entries with their lines, the latest first, and the
+// total number matching the filter.
+func (s *LedgerService) ListEntries(filter LedgerEntryFilter) ([]models.LedgerEntry, int64, error) {
+	query := s.DB.Model(&models.LedgerEntry{})
+	if filter.EventType != "" {
+		query = query.Where("event_type = ?", filter.EventType)
+	}
+	if filter.Account != "" {
+		query = query.Where("id IN (SELECT entry_id FROM ledger_lines WHERE account = ?)", filter.Account)
+	}
+	if filter.ReferenceID != nil {
+		query = query.Where("reference_id = ?", *filter.ReferenceID)
+	}
+	if filter.BookingID != nil {
+		query = query.Where("booking_id = ?", *filter.BookingID)
+	}
+	if filter.From != nil {
+		query = query.Where("occurred_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("occurred_at < ?", *filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []models.LedgerEntry
+	err := query.Preload("Lines").
+		Order("occurred_at DESC, created_at DESC").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Find(&entries).Error
+	return entries, total, err
+}
+
+// accountTotals is debits minus credits per account between from and to.
+func (s *LedgerService) accountTotals(from, to time.Time) (map[string]models.Money, error) {
+	var rows []struct {
+		Account string
+		Net     models.Money
+	}
+	if err := s.DB.Table("ledger_lines").
+		Select("ledger_lines.account, COALESCE(SUM(ledger_lines.debit - ledger_lines.credit), 0) AS net").
+		Joins("JOIN ledger_entries ON ledger_entries.id = ledger_lines.entry_id").
+		Where("ledger_entries.occurred_at >= ? AND ledger_entries.occurred_at < ?", from, to).
+		Group("ledger_lines.account").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	net := make(map[string]models.Money, len(rows))
+	for _, row := range rows {
+		net[row.Account] = row.Net
+	}
+	return net, nil
+}
+
+func revenueAccounts() []string {
+	return []string{models.LedgerAccountPlatformRevenue, models.LedgerAccountDiscounts, models.LedgerAccountRefunds}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// postedLines totals the debits and credits of the ledger lines inserted so
+// far, per account.
+func postedLines(t *testing.T, fake *fakesql.DB) map[string][2]models.Money {
+	t.Helper()
+	totals := make(map[string][2]models.Money)
+	for _, insert := range fake.Statements(`INSERT INTO "ledger_lines"`) {
+		// Each line is bound as entry_id, account, advertiser_id, debit, credit
+		for i := 0; i+5 <= len(insert.Args); i += 5 {
+			account := insert.Args[i+1].(string)
+			var debit, credit models.Money
+			if err := debit.Scan(insert.Args[i+3]); err != nil {
+				t.Fatal(err)
+			}
+			if err := credit.Scan(insert.Args[i+4]); err != nil {
+				t.Fatal(err)
+			}
+			total := totals[account]
+			totals[account] = [2]models.Money{total[0].Add(debit), total[1].Add(credit)}
+		}
+	}
+	return totals
+}
+
+func TestLedgerSettlesPaymentsAndRefundsInCash(t *testing.T) {
+	db, fake := fakesql.New(t)
+	ledger := &LedgerService{DB: db}
+	booking := models.Booking{
+		ID:             uuid.New(),
+		FinalAmount:    models.NewMoney(90000, models.DefaultCurrency),
+		DiscountAmount: models.NewMoney(10000, models.DefaultCurrency),
+	}
+	fake.On(`SELECT \* FROM "bookings"`).Return([]string{"id"}, []driver.Value{booking.ID.String()})
+	fake.On(`COALESCE\(SUM`).Return([]string{"sum"}, []driver.Value{"0"})
+
+	if err := ledger.PostBookingConfirmed(booking); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.PostRefund(booking.ID, models.NewMoney(30000, models.DefaultCurrency)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := postedLines(t, fake)
+	want := map[string][2]string{
+		models.LedgerAccountCash:                {"900.00", "300.00"},
+		models.LedgerAccountCustomerReceivables: {"900.00", "900.00"},
+		models.LedgerAccountPlatformRevenue:     {"0.00", "1000.00"},
+		models.LedgerAccountDiscounts:           {"100.00", "0.00"},
+		models.LedgerAccountRefunds:             {"300.00", "0.00"},
+	}
+	for account, amounts := range want {
+		got := lines[account]
+		if got[0].String() != amounts[0] || got[1].String() != amounts[1] {
+			t.Errorf("%s: debit %s, credit %s; want %s, %s", account, got[0], got[1], amounts[0], amounts[1])
+		}
+	}
+	if entries := fake.Statements(`INSERT INTO "ledger_entries"`); len(entries) != 3 {
+		t.Errorf("entries = %d, want confirmation, payment and refund", len(entries))
+	}
+}
+
+func TestTrialBalanceChecksEntriesUpToAsOf(t *testing.T) {
+	db, fake := fakesql.New(t)
+	asOf := time.Date(2026, 1, 31, 23, 59, 59, 0, time.UTC)
+
+	if _, err := (&LedgerService{DB: db}).TrialBalance(asOf); err != nil {
+		t.Fatal(err)
+	}
+	checks := fake.Statements(`HAVING SUM`)
+	if len(checks) != 1 || len(checks[0].Args) != 1 || checks[0].Args[0] != asOf {
+		t.Fatalf("unbalanced entry checks = %v, want one limited to %s", checks, asOf)
+	}
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"trip-trader-backend/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLoginThrottled = errors.New("too many failed attempts")
+
+// ThrottlePolicy says how failures on one scope are punished. The first
+// FreeAttempts failures cost nothing, each later one doubles the wait from
+// BaseDelay up to MaxDelay, and MaxAttempts failures lock the key for
+// Lockout. Failures older than Window are forgotten.
+type ThrottlePolicy struct {
+	FreeAttempts int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Lockout      time.Duration
+	Window       time.Duration
+}
+
+// LoginThrottlePolicy returns the policy of a scope. Emails and 2FA users
+// are configured with LOGIN_MAX_ATTEMPTS and LOGIN_LOCKOUT_DURATION; IPs
+// are shared by many users behind NAT, so they get more room.
+func LoginThrottlePolicy(scope string) ThrottlePolicy {
+	lockout := durationFromEnv("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	switch scope {
+	case models.ThrottleScopeIP:
+		return ThrottlePolicy{
+			FreeAttempts: 20,
+			MaxAttempts:  intFromEnv("LOGIN_MAX_ATTEMPTS_PER_IP", 100),
+			BaseDelay:    time.Second,
+			MaxDelay:     30 * time.Second,
+			Lockout:      lockout,
+			Window:       time.Hour,
+		}
+	case models.ThrottleScopeMFA:
+		return ThrottlePolicy{
+			FreeAttempts: 3,
+			MaxAttempts:  5,
+			BaseDelay:    2 * time.Second,
+			MaxDelay:     30 * time.Second,
+			Lockout:      lockout,
+			Window:       time.Hour,
+		}
+	default:
+		return ThrottlePolicy{
+			FreeAttempts: 3,
+			MaxAttempts:  intFromEnv("LOGIN_MAX_ATTEMPTS", 10),
+			BaseDelay:    time.Second,
+			MaxDelay:     60 * time.Second,
+			Lockout:      lockout,
+			Window:       time.Hour,
+		}
+	}
+}
+
+// ThrottleError tells the caller how long to wait before trying again.
+type ThrottleError struct {
+	Scope      string
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *ThrottleError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("too many failed attempts, locked for %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("too many failed attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottleError) Is(target error) bool {
+	return target == ErrLoginThrottled
+}
+
+type LoginThrottleService struct {
+	DB *gorm.DB
+}
+
+// Check returns a *ThrottleError if the key has to wait before its next
+// attempt.
+func (s *LoginThrottleService) Check(scope, key string) error {
+	var throttle models.LoginThrottle
+	err := s.DB.Where("scope = ? AND key = ?", scope, key).First(&throttle).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	policy := LoginThrottlePolicy(scope)
+	if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+		return &ThrottleError{Scope: scope, RetryAfter: throttle.LockedUntil.Sub(now), Locked: true}
+	}
+	if isStale(throttle, policy, now) {
+		return nil
+	}
+	if wait := throttle.LastFailedAt.Add(policy.delay(throttle.FailedCount)).Sub(now); wait > 0 {
+		return &ThrottleError{Scope: scope, RetryAfter: wait}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt. It returns the lock expiry when
+// this failure locked the key, and nil otherwise.
+func (s *LoginThrottleService) RecordFailure(scope, key string) (*time.Time, error) {
+	var lockedUntil *time.Time
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginThrottle{
+			Scope:        scope,
+			Key:          key,
+			LastFailedAt: now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var throttle models.LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("scope = ? AND key = ?", scope, key).
+			First(&throttle).Error; err != nil {
+			return err
+		}
+
+		policy := LoginThrottlePolicy(scope)
+		if isStale(throttle, policy, now) {
+			throttle.FailedCount = 0
+			throttle.LockedUntil = nil
+		}
+		throttle.FailedCount++
+		throttle.LastFailedAt = now
+
+		if throttle.FailedCount >= policy.MaxAttempts && throttle.LockedUntil == nil {
+			until := now.Add(policy.Lockout)
+			throttle.LockedUntil = &until
+			lockedUntil = &until
+		}
+
+		return tx.Model(&throttle).Updates(map[string]interface{}{
+			"failed_count":   throttle.FailedCount,
+			"last_failed_at": throttle.LastFailedAt,
+			"locked_until":   throttle.LockedUntil,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lockedUntil, nil
+}
+
+// Reset forgets the failures of a key, after a successful sign-in or when
+// a manager unlocks an account.
+func (s *LoginThrottleService) Reset(scope, key string) error {
+	return s.DB.Where("scope = ? AND key = ?", scope, key).Delete(&models.LoginThrottle{}).Error
+}
+
+// isStale reports whether earlier failures no longer count: the lockout
+// has run out, or the last failure is older than the window.
+func isStale(throttle models.LoginThrottle, policy ThrottlePolicy, now time.Time) bool {
+	if throttle.LockedUntil != nil {
+		return !now.Before(*throttle.LockedUntil)
+	}
+	return now.Sub(throttle.LastFailedAt) > policy.Window
+}
+
+func (p ThrottlePolicy) delay(failedCount int) time.Duration {
+	extra := failedCount - p.FreeAttempts
+	if extra < 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 0; i < extra && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
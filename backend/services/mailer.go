@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Email is a plain-text message to a single recipient.
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email.
+type Mailer interface {
+	Send(email Email) error
+}
+
+// SMTPMailer sends email through an SMTP relay with PLAIN auth.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(email Email) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{email.To}, formatEmail(m.From, email))
+}
+
+// FileMailer writes each email to its own .eml file in Dir, for local
+// development without a mail server.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(email Email) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102T150405.000000000"), sanitizeFileName(email.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), formatEmail(m.From, email), 0o644)
+}
+
+// MemoryMailer keeps sent email in memory so tests can inspect it.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+func (m *MemoryMailer) Send(email Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+// Sent returns a copy of every email sent so far.
+func (m *MemoryMailer) Sent() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Email(nil), m.sent...)
+}
+
+var (
+	mailerOnce    sync.Once
+	defaultMailer Mailer
+)
+
+// DefaultMailer returns the mailer configured by MAILER ("smtp", "file" or
+// "memory"). Without MAILER it uses SMTP when SMTP_HOST is set, else files
+// in MAIL_DIR.
+func DefaultMailer() Mailer {
+	mailerOnce.Do(func() {
+		if defaultMailer == nil {
+			defaultMailer = newMailerFromEnv()
+		}
+	})
+	return defaultMailer
+}
+
+// SetMailer replaces the default mailer, e.g. with a MemoryMailer in tests.
+func SetMailer(m Mailer) {
+	mailerOnce.Do(func() {})
+	defaultMailer = m
+}
+
+func newMailerFromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@trip-trader.local"
+	}
+
+	kind := os.Getenv("MAILER")
+	if kind == "" && os.Getenv("SMTP_HOST") != "" {
+		kind = "smtp"
+	}
+
+	switch kind {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	case "memory":
+		return &MemoryMailer{}
+	default:
+		dir := os.Getenv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail_outbox"
+		}
+		return &FileMailer{Dir: dir, From: from}
+	}
+}
+
+func formatEmail(from string, email Email) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(email.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(email.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// headerValue drops line breaks so user input cannot add headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+func sanitizeFileName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, value)
+}
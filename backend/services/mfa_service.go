@@ -0,0 +1,222 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	mfaIssuer         = "Trip Trader"
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("two-factor authentication is not set up")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+)
+
+type MFAService struct {
+	DB *gorm.DB
+}
+
+// Enabled reports whether a user has confirmed a TOTP authenticator.
+func (s *MFAService) Enabled(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.DB.Model(&models.MFACredential{}).
+		Where("user_id = ? AND enabled_at IS NOT NULL", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// BeginEnrollment creates a new TOTP secret and returns it with its
+// otpauth:// URI. It replaces any unconfirmed secret.
+func (s *MFAService) BeginEnrollment(profile models.Profile) (string, string, error) {
+	enabled, err := s.Enabled(profile.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+	encrypted, err := encryptSecret(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	credential := models.MFACredential{UserID: profile.ID, SecretEncrypted: encrypted}
+	err = s.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret_encrypted", "enabled_at", "last_used_step", "updated_at"}),
+	}).Create(&credential).Error
+	if err != nil {
+		return "", "", err
+	}
+	return secret, totpURI(mfaIssuer, profile.Email, secret), nil
+}
+
+// ConfirmEnrollment turns on 2FA once the user proves their authenticator
+// works, and returns a fresh set of recovery codes.
+func (s *MFAService) ConfirmEnrollment(userID uuid.UUID, code string) ([]string, error) {
+	var recoveryCodes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		credential, err := lockCredential(tx, userID)
+		if err != nil {
+			return err
+		}
+		if credential.EnabledAt != nil {
+			return ErrMFAAlreadyEnabled
+		}
+
+		step, err := matchCredentialCode(credential, code)
+		if err != nil {
+			return err
+		}
+		if step == 0 {
+			return ErrInvalidMFACode
+		}
+
+		if err := tx.Model(credential).Updates(map[string]interface{}{
+			"enabled_at":     time.Now(),
+			"last_used_step": step,
+		}).Error; err != nil {
+			return err
+		}
+
+		recoveryCodes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code. Each TOTP code and
+// each recovery code works only once.
+func (s *MFAService) Verify(userID uuid.UUID, code string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		credential, err := lockCredential(tx, userID)
+		if err != nil {
+			return err
+		}
+		if credential.EnabledAt == nil {
+			return ErrMFANotEnrolled
+		}
+
+		step, err := matchCredentialCode(credential, code)
+		if err != nil {
+			return err
+		}
+		if step > credential.LastUsedStep {
+			return tx.Model(credential).Update("last_used_step", step).Error
+		}
+
+		result := tx.Model(&models.MFARecoveryCode{}).
+			Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of a user with 2FA.
+func (s *MFAService) RegenerateRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	var recoveryCodes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		credential, err := lockCredential(tx, userID)
+		if err != nil {
+			return err
+		}
+		if credential.EnabledAt == nil {
+			return ErrMFANotEnrolled
+		}
+		recoveryCodes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// Reset removes a user's authenticator and recovery codes.
+func (s *MFAService) Reset(userID uuid.UUID) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.MFACredential{}).Error
+	})
+}
+
+func lockCredential(tx *gorm.DB, userID uuid.UUID) (*models.MFACredential, error) {
+	var credential models.MFACredential
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&credential, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func matchCredentialCode(credential *models.MFACredential, code string) (int64, error) {
+	secret, err := decryptSecret(credential.SecretEncrypted)
+	if err != nil {
+		return 0, err
+	}
+	return matchTOTP(secret, strings.ReplaceAll(strings.TrimSpace(code), " ", ""), time.Now())
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		record := models.MFARecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))}
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// randomRecoveryCode returns a code like "k3x9q-7mfa2".
+func randomRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
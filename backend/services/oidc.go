@@ -0,0 +1,369 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcMetadataTTL = time.Hour
+
+var ErrUnknownOIDCProvider = errors.New("unknown login provider")
+
+// OIDCProviderConfig is one provider from OIDC_PROVIDERS. Each name reads
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, and optionally _SCOPES
+// and _REDIRECT_URL.
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+}
+
+// OIDCClaims is what the ID token tells us about the user.
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// OIDCProvider runs the authorization code flow with PKCE against one
+// provider. Discovery metadata and signing keys are fetched on demand and
+// cached.
+type OIDCProvider struct {
+	Config OIDCProviderConfig
+	Client *http.Client
+
+	mu        sync.Mutex
+	metadata  *oidcMetadata
+	fetchedAt time.Time
+	keys      map[string]interface{}
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcIDTokenClaims struct {
+	Nonce         string      `json:"nonce"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+	jwt.RegisteredClaims
+}
+
+var (
+	oidcProvidersOnce sync.Once
+	oidcProviders     map[string]*OIDCProvider
+)
+
+// OIDCProviders returns the providers configured in the environment.
+func OIDCProviders() map[string]*OIDCProvider {
+	oidcProvidersOnce.Do(func() {
+		oidcProviders = make(map[string]*OIDCProvider)
+		for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			config := oidcProviderConfigFromEnv(name)
+			if config.Issuer == "" || config.ClientID == "" {
+				continue
+			}
+			oidcProviders[name] = NewOIDCProvider(config)
+		}
+	})
+	return oidcProviders
+}
+
+// OIDCProviderByName looks up a configured provider.
+func OIDCProviderByName(name string) (*OIDCProvider, error) {
+	provider, ok := OIDCProviders()[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+	return provider, nil
+}
+
+func oidcProviderConfigFromEnv(name string) OIDCProviderConfig {
+	prefix := "OIDC_" + strings.ToUpper(name) + "_"
+	scopes := strings.Fields(os.Getenv(prefix + "SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	redirectURL := os.Getenv(prefix + "REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = strings.TrimRight(os.Getenv("BACKEND_URL"), "/") + "/api/auth/oidc/" + name + "/callback"
+	}
+	return OIDCProviderConfig{
+		Name:         name,
+		Issuer:       strings.TrimRight(os.Getenv(prefix+"ISSUER"), "/"),
+		ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+		ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+		Scopes:       scopes,
+		RedirectURL:  redirectURL,
+	}
+}
+
+func NewOIDCProvider(config OIDCProviderConfig) *OIDCProvider {
+	return &OIDCProvider{
+		Config: config,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// AuthCodeURL is where the browser is sent to sign in. The code verifier
+// stays with us; only its S256 challenge is sent.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", p.Config.RedirectURL)
+	query.Set("scope", strings.Join(p.Config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for tokens and returns the claims
+// of the verified ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*OIDCClaims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.Config.RedirectURL)
+	form.Set("client_id", p.Config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.Config.ClientSecret != "" {
+		form.Set("client_secret", p.Config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("token request rejected: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.verifyIDToken(ctx, metadata, tokens.IDToken, nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, metadata *oidcMetadata, rawIDToken, nonce string) (*OIDCClaims, error) {
+	claims := &oidcIDTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			if strings.HasPrefix(token.Method.Alg(), "HS") {
+				// OIDC allows HMAC ID tokens keyed with the client secret
+				if p.Config.ClientSecret == "" {
+					return nil, errors.New("HMAC ID token without a client secret")
+				}
+				return []byte(p.Config.ClientSecret), nil
+			}
+			kid, _ := token.Header["kid"].(string)
+			return p.verificationKey(ctx, metadata, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "HS256"}),
+		jwt.WithIssuer(metadata.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %v", err)
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid id_token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid id_token: no subject")
+	}
+
+	// Some providers send email_verified as a string
+	verified := claims.EmailVerified == true || claims.EmailVerified == "true"
+	return &OIDCClaims{
+		Subject:       claims.Subject,
+		Email:         strings.TrimSpace(claims.Email),
+		EmailVerified: verified,
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil && time.Since(p.fetchedAt) < oidcMetadataTTL {
+		return p.metadata, nil
+	}
+
+	var metadata oidcMetadata
+	if err := p.getJSON(ctx, p.Config.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("discovery failed: %v", err)
+	}
+	if metadata.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("discovery returned issuer %q, expected %q", metadata.Issuer, p.Config.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p.metadata = &metadata
+	p.fetchedAt = time.Now()
+	p.keys = nil
+	return p.metadata, nil
+}
+
+// verificationKey finds a provider key by kid, fetching the key set again
+// once when the kid is new, since providers rotate their keys.
+func (p *OIDCProvider) verificationKey(ctx context.Context, metadata *oidcMetadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %v", err)
+	}
+	p.keys = make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if key, err := parseProviderJWK(jwk); err == nil {
+			kid, _ := jwk["kid"].(string)
+			p.keys[kid] = key
+		}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("provider key %q not found", kid)
+	}
+	return key, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func parseProviderJWK(jwk map[string]interface{}) (interface{}, error) {
+	field := func(name string) string {
+		value, _ := jwk[name].(string)
+		return value
+	}
+	decode := func(name string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(field(name))
+		if err != nil || len(data) == 0 {
+			return nil, fmt.Errorf("invalid %s", name)
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch field("kty") {
+	case "RSA":
+		n, err := decode("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch field("crv") {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", field("crv"))
+		}
+		x, err := decode("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode("y")
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", field("kty"))
+	}
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+var (
+	ErrInvalidOIDCState  = errors.New("login request expired or was already used, please try again")
+	ErrOIDCEmailRequired = errors.New("the provider did not share an email address")
+	ErrOIDCEmailInUse    = errors.New("an account with this email already exists, sign in with your password and link the provider from your profile")
+	ErrOIDCIdentityInUse = errors.New("this login is already linked to another account")
+)
+
+// OIDCLoginResult is the profile a provider login resolved to. Linked is
+// true when the login was started to link the provider to a signed-in user.
+type OIDCLoginResult struct {
+	Profile      models.Profile
+	RedirectPath string
+	Linked       bool
+}
+
+type OIDCLoginService struct {
+	DB *gorm.DB
+}
+
+// Begin stores a new authorization request and returns the provider URL
+// to send the browser to. linkUserID is set to link the provider to that
+// user instead of signing in.
+func (s *OIDCLoginService) Begin(ctx context.Context, provider *OIDCProvider, redirectPath string, linkUserID *uuid.UUID) (string, error) {
+	state, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		return "", err
+	}
+
+	loginState := models.OIDCLoginState{
+		State:        state,
+		Provider:     provider.Config.Name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RedirectPath: redirectPath,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(oidcLoginStateTTL),
+	}
+	if err := s.DB.Create(&loginState).Error; err != nil {
+		return "", err
+	}
+	return authURL, nil
+}
+
+// Complete handles the provider callback: it uses up the state, exchanges
+// the code and finds, links or creates the profile.
+func (s *OIDCLoginService) Complete(ctx context.Context, provider *OIDCProvider, state, code string) (*OIDCLoginResult, error) {
+	var loginState models.OIDCLoginState
+	result := s.DB.Clauses(clause.Returning{}).
+		Where("state = ? AND provider = ?", state, provider.Config.Name).
+		Delete(&loginState)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	claims, err := provider.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	login := &OIDCLoginResult{RedirectPath: loginState.RedirectPath, Linked: loginState.LinkUserID != nil}
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		profile, err := resolveOIDCProfile(tx, provider.Config.Name, claims, loginState.LinkUserID)
+		if err != nil {
+			return err
+		}
+		login.Profile = *profile
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return login, nil
+}
+
+// Identities lists the providers linked to a profile.
+func (s *OIDCLoginService) Identities(profileID uuid.UUID) ([]models.ProfileIdentity, error) {
+	var identities []models.ProfileIdentity
+	err := s.DB.Where("profile_id = ?", profileID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+// resolveOIDCProfile picks the profile for a provider identity. A known
+// identity signs in to its profile. A new one is linked to the signed-in
+// user when linking, to an existing profile when both sides have verified
+// the email, or else gets a new customer profile.
+func resolveOIDCProfile(tx *gorm.DB, providerName string, claims *OIDCClaims, linkUserID *uuid.UUID) (*models.Profile, error) {
+	now := time.Now()
+
+	var identity models.ProfileIdentity
+	err := tx.Where("provider = ? AND subject = ?", providerName, claims.Subject).First(&identity).Error
+	if err == nil {
+		if linkUserID != nil && identity.ProfileID != *linkUserID {
+			return nil, ErrOIDCIdentityInUse
+		}
+		if err := tx.Model(&identity).Updates(map[string]interface{}{"last_login_at": now, "email": claims.Email}).Error; err != nil {
+			return nil, err
+		}
+		var profile models.Profile
+		if err := tx.First(&profile, "id = ?", identity.ProfileID).Error; err != nil {
+			return nil, err
+		}
+		return &profile, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var profile models.Profile
+	switch {
+	case linkUserID != nil:
+		if err := tx.First(&profile, "id = ?", *linkUserID).Error; err != nil {
+			return nil, err
+		}
+	case claims.Email == "":
+		return nil, ErrOIDCEmailRequired
+	default:
+		err := tx.Where("LOWER(email) = LOWER(?)", claims.Email).First(&profile).Error
+		switch {
+		case err == nil:
+			// Linking to an unverified account would let whoever registered
+			// the address keep access with its password
+			if !claims.EmailVerified || profile.EmailVerifiedAt == nil {
+				return nil, ErrOIDCEmailInUse
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			created, err := createOIDCProfile(tx, claims, now)
+			if err != nil {
+				return nil, err
+			}
+			profile = *created
+		default:
+			return nil, err
+		}
+	}
+
+	identity = models.ProfileIdentity{
+		ProfileID:   profile.ID,
+		Provider:    providerName,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	}
+	if err := tx.Create(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// createOIDCProfile signs up a provider user as a customer. The password is
+// random and never shown, so the account can only use the provider until
+// the user sets one through password reset.
+func createOIDCProfile(tx *gorm.DB, claims *OIDCClaims, now time.Time) (*models.Profile, error) {
+	password, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := models.Profile{
+		Email:       strings.ToLower(claims.Email),
+		Password:    string(hashedPassword),
+		DisplayName: claims.Name,
+		UserRole:    models.RoleCustomer,
+	}
+	if claims.EmailVerified {
+		profile.EmailVerifiedAt = &now
+	}
+	if err := tx.Create(&profile).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
@@ -0,0 +1,335 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const mockOIDCClientID = "trip-trader"
+
+// mockOIDCServer is an OpenID provider with discovery, a key set and a token
+// endpoint that enforces PKCE. Codes are handed out by authorize, standing
+// in for the user signing in at the provider.
+type mockOIDCServer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]mockOIDCGrant
+}
+
+type mockOIDCGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockOIDCServer(t *testing.T) *mockOIDCServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDCServer{key: key, grants: make(map[string]mockOIDCGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock-key",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.mu.Lock()
+		grant, ok := m.grants[r.PostForm.Get("code")]
+		delete(m.grants, r.PostForm.Get("code"))
+		m.mu.Unlock()
+
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge || r.PostForm.Get("client_id") != mockOIDCClientID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = "mock-key"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken, "token_type": "Bearer"})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOIDCServer) provider() *OIDCProvider {
+	provider := NewOIDCProvider(OIDCProviderConfig{
+		Name:        "mock",
+		Issuer:      m.URL,
+		ClientID:    mockOIDCClientID,
+		Scopes:      []string{"openid", "email"},
+		RedirectURL: "http://localhost/api/auth/oidc/mock/callback",
+	})
+	provider.Client = m.Client()
+	return provider
+}
+
+// authorize signs the user in at the provider for the authorization
+// request in authURL and returns the state and code of the redirect back.
+// claims override the ID token's defaults.
+func (m *mockOIDCServer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (string, string) {
+	t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without a PKCE challenge: %s", authURL)
+	}
+
+	idClaims := jwt.MapClaims{
+		"iss":            m.URL,
+		"aud":            mockOIDCClientID,
+		"sub":            "mock-user-1",
+		"email":          "traveller@example.com",
+		"email_verified": true,
+		"nonce":          query.Get("nonce"),
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range claims {
+		idClaims[name] = value
+	}
+
+	code := uuid.NewString()
+	m.mu.Lock()
+	m.grants[code] = mockOIDCGrant{challenge: query.Get("code_challenge"), claims: idClaims}
+	m.mu.Unlock()
+	return query.Get("state"), code
+}
+
+type oidcLoginTest struct {
+	logins   *OIDCLoginService
+	fake     *fakesql.DB
+	server   *mockOIDCServer
+	provider *OIDCProvider
+}
+
+func newOIDCLoginTest(t *testing.T) *oidcLoginTest {
+	t.Helper()
+	db, fake := fakesql.New(t)
+	server := newMockOIDCServer(t)
+	return &oidcLoginTest{logins: &OIDCLoginService{DB: db}, fake: fake, server: server, provider: server.provider()}
+}
+
+var insertColumns = regexp.MustCompile(`\(([^)]*)\) VALUES`)
+
+// begin starts a login and makes the stored request answer the callback
+// for its state once, as the database would.
+func (o *oidcLoginTest) begin(t *testing.T) string {
+	t.Helper()
+	authURL, err := o.logins.Begin(context.Background(), o.provider, "/trips", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := o.fake.Statements(`INSERT INTO "oidc_login_states"`)
+	insert := inserts[len(inserts)-1]
+	match := insertColumns.FindStringSubmatch(insert.SQL)
+	if match == nil {
+		t.Fatalf("unexpected login state insert: %s", insert.SQL)
+	}
+	var columns []string
+	for _, column := range strings.Split(match[1], ",") {
+		columns = append(columns, strings.Trim(strings.TrimSpace(column), `"`))
+	}
+	state := insert.Args[0].(string)
+	o.fake.On(`DELETE FROM "oidc_login_states"`).
+		When(func(args []driver.Value) bool { return len(args) > 0 && args[0] == state }).
+		Return(columns, insert.Args)
+	return authURL
+}
+
+// useUp makes the stored request for state gone, as after a callback.
+func (o *oidcLoginTest) useUp(state string) {
+	o.fake.On(`DELETE FROM "oidc_login_states"`).
+		When(func(args []driver.Value) bool { return len(args) > 0 && args[0] == state }).
+		Return([]string{"state"})
+}
+
+func TestOIDCLoginCreatesCustomer(t *testing.T) {
+	o := newOIDCLoginTest(t)
+	state, code := o.server.authorize(t, o.begin(t), nil)
+
+	login, err := o.logins.Complete(context.Background(), o.provider, state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.Profile.Email != "traveller@example.com" || login.Profile.UserRole != models.RoleCustomer || login.RedirectPath != "/trips" {
+		t.Errorf("login = %+v, want a new customer sent back to /trips", login)
+	}
+	if got := o.fake.Statements(`INSERT INTO "profile_identities"`); len(got) != 1 {
+		t.Errorf("identity inserts = %d, want 1", len(got))
+	}
+}
+
+func TestOIDCLoginRejectsUnknownState(t *testing.T) {
+	o := newOIDCLoginTest(t)
+	_, code := o.server.authorize(t, o.begin(t), nil)
+
+	_, err := o.logins.Complete(context.Background(), o.provider, "forged-state", code)
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("err = %v, want %v", err, ErrInvalidOIDCState)
+	}
+}
+
+func TestOIDCLoginRejectsStateOfAnotherLogin(t *testing.T) {
+	o := newOIDCLoginTest(t)
+	// The attacker's code was issued for their own login request
+	_, attackerCode := o.server.authorize(t, o.begin(t), nil)
+	victimState, _ := o.server.authorize(t, o.begin(t), nil)
+
+	_, err := o.logins.Complete(context.Background(), o.provider, victimState, attackerCode)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("err = %v, want the code refused for the wrong PKCE verifier", err)
+	}
+}
+
+func TestOIDCLoginRejectsReplayedCallback(t *testing.T) {
+	o := newOIDCLoginTest(t)
+	state, code := o.server.authorize(t, o.begin(t), nil)
+	if _, err := o.logins.Complete(context.Background(), o.provider, state, code); err != nil {
+		t.Fatal(err)
+	}
+	o.useUp(state)
+
+	_, err := o.logins.Complete(context.Background(), o.provider, state, code)
+	if !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("err = %v, want %v", err, ErrInvalidOIDCState)
+	}
+}
+
+func TestOIDCLoginRejectsReplayedNonce(t *testing.T) {
+	o := newOIDCLoginTest(t)
+	// An ID token minted for an earlier login carries that login's nonce
+	earlier, err := url.Parse(o.begin(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, code := o.server.authorize(t, o.begin(t), jwt.MapClaims{"nonce": earlier.Query().Get("nonce")})
+
+	_, err = o.logins.Complete(context.Background(), o.provider, state, code)
+	if err == nil || !strings.Contains(err.Error(), "nonce mismatch") {
+		t.Fatalf("err = %v, want a nonce mismatch", err)
+	}
+}
+
+func TestOIDCLoginRejectsForeignIDToken(t *testing.T) {
+	for name, claims := range map[string]jwt.MapClaims{
+		"wrong audience": {"aud": "another-client"},
+		"wrong issuer":   {"iss": "https://attacker.example.com"},
+		"expired":        {"exp": time.Now().Add(-time.Hour).Unix()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := newOIDCLoginTest(t)
+			state, code := o.server.authorize(t, o.begin(t), claims)
+
+			_, err := o.logins.Complete(context.Background(), o.provider, state, code)
+			if err == nil || !strings.Contains(err.Error(), "invalid id_token") {
+				t.Fatalf("err = %v, want the ID token rejected", err)
+			}
+			if got := o.fake.Statements(`INSERT INTO "profile_identities"`); len(got) != 0 {
+				t.Errorf("identity linked from a rejected ID token: %v", got)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginLinksExistingEmailAccount(t *testing.T) {
+	existingID := uuid.New()
+	existing := func(o *oidcLoginTest, verifiedAt interface{}) {
+		o.fake.On(`FROM "public"."profiles" WHERE LOWER\(email\)`).Return(
+			[]string{"id", "email", "user_role", "email_verified_at"},
+			[]driver.Value{existingID.String(), "traveller@example.com", models.RoleCustomer, verifiedAt},
+		)
+	}
+
+	t.Run("both verified", func(t *testing.T) {
+		o := newOIDCLoginTest(t)
+		existing(o, time.Now())
+		state, code := o.server.authorize(t, o.begin(t), jwt.MapClaims{"email": "Traveller@Example.com"})
+
+		login, err := o.logins.Complete(context.Background(), o.provider, state, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if login.Profile.ID != existingID {
+			t.Errorf("signed in to %s, want the existing account %s", login.Profile.ID, existingID)
+		}
+		inserts := o.fake.Statements(`INSERT INTO "profile_identities"`)
+		if len(inserts) != 1 || !containsArg(inserts[0].Args, existingID.String()) {
+			t.Errorf("identity inserts = %v, want one for the existing account", inserts)
+		}
+		if got := o.fake.Statements(`INSERT INTO "public"."profiles"`); len(got) != 0 {
+			t.Errorf("created a second account: %v", got)
+		}
+	})
+
+	for name, setup := range map[string]struct {
+		accountVerifiedAt interface{}
+		providerVerified  bool
+	}{
+		"account unverified":  {nil, true},
+		"provider unverified": {time.Now(), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := newOIDCLoginTest(t)
+			existing(o, setup.accountVerifiedAt)
+			state, code := o.server.authorize(t, o.begin(t), jwt.MapClaims{"email_verified": setup.providerVerified})
+
+			_, err := o.logins.Complete(context.Background(), o.provider, state, code)
+			if !errors.Is(err, ErrOIDCEmailInUse) {
+				t.Fatalf("err = %v, want %v", err, ErrOIDCEmailInUse)
+			}
+			if got := o.fake.Statements(`INSERT INTO "profile_identities"`); len(got) != 0 {
+				t.Errorf("identity linked to an unproven account: %v", got)
+			}
+		})
+	}
+}
@@ -102,5 +102,10 @@ func (s *PackageStatsService) computeBasicStats(pkg *models.TravelPackage) {
 		}
 		pkg.AdvertiserNames = result
 	}
-	pkg.CurrentBookings = len(pkg.Bookings)
+	// current_bookings is kept in sync by ReservationService and counts
+	// guests, including unpaid holds, so it is left as loaded
+	reservations := &ReservationService{DB: s.DB}
+	if available, err := reservations.AvailableSeats(pkg.ID, nil); err == nil {
+		pkg.AvailableSeats = available
+	}
 }
\ No newline at end of file
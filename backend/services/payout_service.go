@@ -0,0 +1,518 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrPayoutBatchNotFound      = errors.New("payout batch not found")
+	ErrPayoutNotFound           = errors.New("payout not found")
+	ErrPayoutPeriodOpen         = errors.New("a month can only be settled once it has ended")
+	ErrPayoutBatchExists        = errors.New("a payout batch already exists for this month")
+	ErrNothingToSettle          = errors.New("there are no pending commissions to settle for this month")
+	ErrPayoutBatchStatus        = errors.New("payout batch cannot be changed in its current status")
+	ErrPayoutAlreadyPaid        = errors.New("payout has already been paid")
+	ErrPaymentReferenceRequired = errors.New("payment_reference is required")
+)
+
+// PayoutStatementLine is one commission on an advertiser's payout statement.
+type PayoutStatementLine struct {
+	CommissionID   uuid.UUID      `json:"commission_id"`
+	BookingID      uuid.UUID      `json:"booking_id"`
+	PackageTitle   string         `json:"package_title"`
+	BookingDate    string         `json:"booking_date"`
+	BookingAmount  models.Money   `json:"booking_amount"`
+	CommissionRate models.Decimal `json:"commission_rate"`
+	Amount         models.Money   `json:"amount"`
+	Status         string         `json:"status"`
+	ReversalOfID   *uuid.UUID     `json:"reversal_of_id"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// PayoutStatement itemises what an advertiser was paid in one payout.
+type PayoutStatement struct {
+	Payout      models.Payout         `json:"payout"`
+	PeriodStart string                `json:"period_start"`
+	PeriodEnd   string                `json:"period_end"`
+	Lines       []PayoutStatementLine `json:"lines"`
+	Earned      models.Money          `json:"earned"`
+	ClawedBack  models.Money          `json:"clawed_back"`
+	Total       models.Money          `json:"total"`
+}
+
+// PayoutService settles commissions into monthly payout batches. Amounts on
+// a batch and its payouts are always recomputed from their commissions, so
+// a reversal while a batch is under review keeps the totals right.
+type PayoutService struct {
+	DB *gorm.DB
+}
+
+// CreateBatch settles every pending commission created before the end of
+// the given month into a draft batch with one payout per advertiser.
+// Advertisers whose clawbacks outweigh their earnings are left out and
+// carried forward to the next month.
+func (s *PayoutService) CreateBatch(year int, month time.Month, createdBy *uuid.UUID) (*models.PayoutBatch, error) {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(0, 1, 0)
+	if time.Now().Before(end) {
+		return nil, ErrPayoutPeriodOpen
+	}
+
+	batch := &models.PayoutBatch{
+		PeriodStart: start.Format("2006-01-02"),
+		PeriodEnd:   end.AddDate(0, 0, -1).Format("2006-01-02"),
+		Status:      models.PayoutBatchStatusDraft,
+		CreatedBy:   createdBy,
+	}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var existing int64
+		if err := tx.Model(&models.PayoutBatch{}).
+			Where("period_start = ? AND status <> ?", batch.PeriodStart, models.PayoutBatchStatusCancelled).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return ErrPayoutBatchExists
+		}
+
+		var commissions []models.Commission
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ? AND payout_id IS NULL AND created_at < ?", models.CommissionStatusPending, end).
+			Order("advertiser_id, created_at").
+			Find(&commissions).Error; err != nil {
+			return err
+		}
+
+		totals := make(map[uuid.UUID]models.Money)
+		ids := make(map[uuid.UUID][]uuid.UUID)
+		var advertisers []uuid.UUID
+		for _, commission := range commissions {
+			if _, seen := ids[commission.AdvertiserID]; !seen {
+				advertisers = append(advertisers, commission.AdvertiserID)
+			}
+			totals[commission.AdvertiserID] = totals[commission.AdvertiserID].Add(commission.CommissionAmount)
+			ids[commission.AdvertiserID] = append(ids[commission.AdvertiserID], commission.ID)
+		}
+
+		if err := tx.Create(batch).Error; err != nil {
+			if strings.Contains(err.Error(), "idx_payout_batches_period") {
+				return ErrPayoutBatchExists
+			}
+			return err
+		}
+		for _, advertiserID := range advertisers {
+			amount := totals[advertiserID]
+			if !amount.IsPositive() {
+				continue
+			}
+			payout := models.Payout{
+				BatchID:         batch.ID,
+				AdvertiserID:    advertiserID,
+				Amount:          amount,
+				CommissionCount: len(ids[advertiserID]),
+				Status:          models.PayoutStatusPending,
+			}
+			if err := tx.Create(&payout).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Commission{}).Where("id IN ?", ids[advertiserID]).
+				Updates(map[string]interface{}{"payout_id": payout.ID, "updated_at": time.Now()}).Error; err != nil {
+				return err
+			}
+			batch.TotalAmount = batch.TotalAmount.Add(amount)
+			batch.PayoutCount++
+		}
+		if batch.PayoutCount == 0 {
+			return ErrNothingToSettle
+		}
+
+		return tx.Model(batch).Updates(map[string]interface{}{
+			"total_amount": batch.TotalAmount,
+			"payout_count": batch.PayoutCount,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBatch(batch.ID)
+}
+
+// ListBatches returns every batch, the latest month first.
+func (s *PayoutService) ListBatches() ([]models.PayoutBatch, error) {
+	var batches []models.PayoutBatch
+	err := s.DB.Order("period_start DESC, created_at DESC").Find(&batches).Error
+	return batches, err
+}
+
+// GetBatch loads a batch with its payouts and their advertisers.
+func (s *PayoutService) GetBatch(batchID uuid.UUID) (*models.PayoutBatch, error) {
+	var batch models.PayoutBatch
+	err := s.DB.Preload("Payouts", func(db *gorm.DB) *gorm.DB {
+		return db.Order("amount DESC")
+	}).Preload("Payouts.Advertiser").First(&batch, "id = ?", batchID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPayoutBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// Approve signs off a draft batch so its payouts can be paid.
+func (s *PayoutService) Approve(batchID uuid.UUID, approvedBy *uuid.UUID) (*models.PayoutBatch, error) {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		batch, err := lockPayoutBatch(tx, batchID)
+		if err != nil {
+			return err
+		}
+		if batch.Status != models.PayoutBatchStatusDraft {
+			return ErrPayoutBatchStatus
+		}
+
+		if err := tx.Model(batch).Updates(map[string]interface{}{
+			"status":      models.PayoutBatchStatusApproved,
+			"approved_by": approvedBy,
+			"approved_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Commission{}).
+			Where("payout_id IN (SELECT id FROM payouts WHERE batch_id = ?) AND status = ?", batchID, models.CommissionStatusPending).
+			Updates(map[string]interface{}{"status": models.CommissionStatusApproved, "updated_at": time.Now()}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetBatch(batchID)
+}
+
+// MarkPaid records that an advertiser's payout in an approved batch has
+// been transferred. The batch is paid once all of its payouts are.
+func (s *PayoutService) MarkPaid(batchID, payoutID uuid.UUID, reference string, paidBy *uuid.UUID) (*models.Payout, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" {
+		return nil, ErrPaymentReferenceRequired
+	}
+
+	var payout models.Payout
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		batch, err := lockPayoutBatch(tx, batchID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&payout, "id = ? AND batch_id = ?", payoutID, batchID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPayoutNotFound
+			}
+			return err
+		}
+		if payout.Status == models.PayoutStatusPaid {
+			return ErrPayoutAlreadyPaid
+		}
+		if batch.Status != models.PayoutBatchStatusApproved {
+			return ErrPayoutBatchStatus
+		}
+
+		now := time.Now()
+		if err := tx.Model(&payout).Updates(map[string]interface{}{
+			"status":            models.PayoutStatusPaid,
+			"payment_reference": reference,
+			"paid_by":           paidBy,
+			"paid_at":           now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Commission{}).
+			Where("payout_id = ? AND status = ?", payout.ID, models.CommissionStatusApproved).
+			Updates(map[string]interface{}{"status": models.CommissionStatusPaid, "paid_at": now, "updated_at": now}).Error; err != nil {
+			return err
+		}
+		payout.PaymentReference = &reference
+		ledger := &LedgerService{DB: tx}
+		if err := ledger.PostPayoutPaid(payout); err != nil {
+			return err
+		}
+		return refreshPayoutBatch(tx, batch.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.First(&payout, "id = ?", payoutID).Error; err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// CancelBatch abandons a batch nobody has been paid from yet. Its
+// commissions go back to pending for a new batch to pick up.
+func (s *PayoutService) CancelBatch(batchID uuid.UUID) (*models.PayoutBatch, error) {
+	var batch *models.PayoutBatch
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		batch, err = lockPayoutBatch(tx, batchID)
+		if err != nil {
+			return err
+		}
+		var paid int64
+		if err := tx.Model(&models.Payout{}).
+			Where("batch_id = ? AND status = ?", batchID, models.PayoutStatusPaid).
+			Count(&paid).Error; err != nil {
+			return err
+		}
+		if paid > 0 || (batch.Status != models.PayoutBatchStatusDraft && batch.Status != models.PayoutBatchStatusApproved) {
+			return ErrPayoutBatchStatus
+		}
+
+		if err := tx.Model(&models.Commission{}).
+			Where("payout_id IN (SELECT id FROM payouts WHERE batch_id = ?)", batchID).
+			Updates(map[string]interface{}{
+				"status":     models.CommissionStatusPending,
+				"payout_id":  nil,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("batch_id = ?", batchID).Delete(&models.Payout{}).Error; err != nil {
+			return err
+		}
+		batch.Status = models.PayoutBatchStatusCancelled
+		return tx.Model(batch).Update("status", batch.Status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// ListPayouts returns an advertiser's payouts, the latest first. Payouts
+// in cancelled batches no longer exist and those still in a draft batch are
+// left out until a manager has approved them.
+func (s *PayoutService) ListPayouts(advertiserID uuid.UUID) ([]models.Payout, error) {
+	var payouts []models.Payout
+	err := s.DB.Preload("Batch").
+		Joins("JOIN payout_batches ON payout_batches.id = payouts.batch_id").
+		Where("payouts.advertiser_id = ? AND payout_batches.status IN ?", advertiserID,
+			[]string{models.PayoutBatchStatusApproved, models.PayoutBatchStatusPaid}).
+		Order("payout_batches.period_start DESC").
+		Find(&payouts).Error
+	return payouts, err
+}
+
+// UnsettledBalance is what an advertiser has earned that is not in a
+// payout yet.
+func (s *PayoutService) UnsettledBalance(advertiserID uuid.UUID) (models.Money, error) {
+	var balance models.Money
+	err := s.DB.Model(&models.Commission{}).
+		Select("COALESCE(SUM(commission_amount), 0)").
+		Where("advertiser_id = ? AND status = ? AND payout_id IS NULL", advertiserID, models.CommissionStatusPending).
+		Row().Scan(&balance)
+	return balance, err
+}
+
+// Statement itemises one of an advertiser's payouts.
+func (s *PayoutService) Statement(advertiserID, payoutID uuid.UUID) (*PayoutStatement, error) {
+	payouts, err := s.ListPayouts(advertiserID)
+	if err != nil {
+		return nil, err
+	}
+	statement := &PayoutStatement{Lines: []PayoutStatementLine{}}
+	found := false
+	for _, payout := range payouts {
+		if payout.ID == payoutID {
+			statement.Payout = payout
+			found = true
+		}
+	}
+	if !found {
+		return nil, ErrPayoutNotFound
+	}
+	statement.PeriodStart = statement.Payout.Batch.PeriodStart
+	statement.PeriodEnd = statement.Payout.Batch.PeriodEnd
+
+	var commissions []models.Commission
+	if err := s.DB.Preload("Booking").Preload("Booking.TravelPackages").
+		Where("payout_id = ?", payoutID).
+		Order("created_at").
+		Find(&commissions).Error; err != nil {
+		return nil, err
+	}
+	for _, commission := range commissions {
+		line := PayoutStatementLine{
+			CommissionID:   commission.ID,
+			BookingID:      commission.BookingID,
+			BookingDate:    commission.Booking.BookingDate,
+			BookingAmount:  commission.Booking.FinalAmount,
+			CommissionRate: commission.CommissionPercentage,
+			Amount:         commission.CommissionAmount,
+			Status:         commission.Status,
+			ReversalOfID:   commission.ReversalOfID,
+			CreatedAt:      commission.CreatedAt,
+		}
+		if commission.Booking.TravelPackages != nil {
+			line.PackageTitle = commission.Booking.TravelPackages.Title
+		}
+		if line.Amount.IsNegative() {
+			statement.ClawedBack = statement.ClawedBack.Add(line.Amount)
+		} else {
+			statement.Earned = statement.Earned.Add(line.Amount)
+		}
+		statement.Lines = append(statement.Lines, line)
+	}
+	statement.Total = statement.Earned.Add(statement.ClawedBack)
+	return statement, nil
+}
+
+// ReverseForBooking takes back the commission on a booking that was
+// cancelled or refunded. Unpaid commissions are reversed and dropped from
+// any batch they are in; a commission that was already paid gets a negative
+// clawback commission that comes off the advertiser's next payout. Either
+// way the ledger gets a commission reversal. DB should be the transaction
+// that changes the booking.
+func (s *PayoutService) ReverseForBooking(bookingID uuid.UUID) error {
+	var commissions []models.Commission
+	if err := s.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("booking_id = ? AND reversal_of_id IS NULL AND status <> ?", bookingID, models.CommissionStatusReversed).
+		Find(&commissions).Error; err != nil {
+		return err
+	}
+
+	for _, commission := range commissions {
+		ledger := &LedgerService{DB: s.DB}
+		if commission.Status == models.CommissionStatusPaid {
+			if err := s.clawBack(commission); err != nil {
+				return err
+			}
+			if err := ledger.PostCommissionReversed(commission); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := s.DB.Model(&models.Commission{}).Where("id = ?", commission.ID).Updates(map[string]interface{}{
+			"status":     models.CommissionStatusReversed,
+			"payout_id":  nil,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := ledger.PostCommissionReversed(commission); err != nil {
+			return err
+		}
+		if commission.PayoutID != nil {
+			if err := refreshPayout(s.DB, *commission.PayoutID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PayoutService) clawBack(commission models.Commission) error {
+	var existing int64
+	if err := s.DB.Model(&models.Commission{}).Where("reversal_of_id = ?", commission.ID).Count(&existing).Error; err != nil {
+		return err
+	}
+	if existing > 0 {
+		return nil
+	}
+	return s.DB.Create(&models.Commission{
+		BookingID:            commission.BookingID,
+		AdvertiserID:         commission.AdvertiserID,
+		DiscountCodeID:       commission.DiscountCodeID,
+		CommissionAmount:     commission.CommissionAmount.Neg(),
+		CommissionPercentage: commission.CommissionPercentage,
+		Status:               models.CommissionStatusPending,
+		ReversalOfID:         &commission.ID,
+	}).Error
+}
+
+func lockPayoutBatch(tx *gorm.DB, batchID uuid.UUID) (*models.PayoutBatch, error) {
+	var batch models.PayoutBatch
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&batch, "id = ?", batchID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPayoutBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// refreshPayout recomputes an unpaid payout after one of its commissions
+// was reversed. A payout left with nothing to pay is dropped and its
+// remaining commissions carried forward.
+func refreshPayout(tx *gorm.DB, payoutID uuid.UUID) error {
+	var payout models.Payout
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, "id = ?", payoutID).Error; err != nil {
+		return err
+	}
+
+	var totals struct {
+		Amount models.Money
+		Count  int
+	}
+	if err := tx.Model(&models.Commission{}).
+		Select("COALESCE(SUM(commission_amount), 0) AS amount, COUNT(*) AS count").
+		Where("payout_id = ?", payoutID).
+		Scan(&totals).Error; err != nil {
+		return err
+	}
+
+	if totals.Count == 0 || !totals.Amount.IsPositive() {
+		if err := tx.Model(&models.Commission{}).Where("payout_id = ?", payoutID).Updates(map[string]interface{}{
+			"status":     models.CommissionStatusPending,
+			"payout_id":  nil,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&payout).Error; err != nil {
+			return err
+		}
+	} else if err := tx.Model(&payout).Updates(map[string]interface{}{
+		"amount":           totals.Amount,
+		"commission_count": totals.Count,
+	}).Error; err != nil {
+		return err
+	}
+	return refreshPayoutBatch(tx, payout.BatchID)
+}
+
+// refreshPayoutBatch recomputes a batch's totals from its payouts, marks it
+// paid once every payout is and cancels it if no payouts are left.
+func refreshPayoutBatch(tx *gorm.DB, batchID uuid.UUID) error {
+	var totals struct {
+		Amount models.Money
+		Count  int
+		Unpaid int
+	}
+	if err := tx.Model(&models.Payout{}).
+		Select("COALESCE(SUM(amount), 0) AS amount, COUNT(*) AS count, COUNT(*) FILTER (WHERE status <> ?) AS unpaid", models.PayoutStatusPaid).
+		Where("batch_id = ?", batchID).
+		Scan(&totals).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{
+		"total_amount": totals.Amount,
+		"payout_count": totals.Count,
+	}
+	if totals.Count == 0 {
+		updates["status"] = models.PayoutBatchStatusCancelled
+	} else if totals.Unpaid == 0 {
+		updates["status"] = models.PayoutBatchStatusPaid
+		updates["paid_at"] = time.Now()
+	}
+	return tx.Model(&models.PayoutBatch{}).
+		Where("id = ? AND status IN ?", batchID, []string{models.PayoutBatchStatusDraft, models.PayoutBatchStatusApproved}).
+		Updates(updates).Error
+}
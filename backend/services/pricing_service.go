@@ -0,0 +1,299 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPackageNotFound        = errors.New("package not found")
+	ErrPackageInactive        = errors.New("package is not available for booking")
+	ErrInvalidGuestCount      = errors.New("guest count must be at least 1")
+	ErrDiscountCodeNotFound   = errors.New("invalid discount code")
+	ErrDiscountCodeInactive   = errors.New("discount code is inactive")
+	ErrDiscountCodeNotAllowed = errors.New("this advertiser code is not valid for this package")
+	ErrMultipleDiscountCodes  = errors.New("only one discount code can be applied per booking")
+)
+
+type PricingService struct {
+	DB *gorm.DB
+}
+
+// QuoteRequest identifies a discount code either by its text (what the
+// customer typed) or by the ID returned from ValidateDiscountCode.
+// CustomerID, when known, checks the code's per-customer limit. Currency is
+// what the customer will be charged in, the base currency when empty.
+type QuoteRequest struct {
+	PackageID      uuid.UUID
+	DepartureID    *uuid.UUID
+	GuestCount     int
+	DiscountCode   string
+	DiscountCodeID *uuid.UUID
+	GlobalCodeID   *uuid.UUID
+	CustomerID     *uuid.UUID
+	Currency       models.Currency
+}
+
+type QuoteLineItem struct {
+	Type        string       `json:"type"`
+	Description string       `json:"description"`
+	Amount      models.Money `json:"amount"`
+}
+
+type BookingQuote struct {
+	PackageID       uuid.UUID       `json:"package_id"`
+	DepartureID     *uuid.UUID      `json:"departure_id"`
+	GuestCount      int             `json:"guest_count"`
+	UnitPrice       models.Money    `json:"unit_price"`
+	TotalAmount     models.Money    `json:"total_amount"`
+	PackageDiscount models.Money    `json:"package_discount"`
+	CodeDiscount    models.Money    `json:"code_discount"`
+	DiscountAmount  models.Money    `json:"discount_amount"`
+	FinalAmount     models.Money    `json:"final_amount"`
+	DiscountCodeID  *uuid.UUID      `json:"discount_code_id"`
+	GlobalCodeID    *uuid.UUID      `json:"global_code_id"`
+	LineItems       []QuoteLineItem `json:"line_items"`
+	// Amounts above are in the base currency; the customer is charged
+	// ChargeAmount, the final amount converted at ExchangeRate
+	Currency                models.Currency `json:"currency"`
+	ExchangeRate            models.Rate     `json:"exchange_rate"`
+	ExchangeRateID          *uuid.UUID      `json:"exchange_rate_id"`
+	ExchangeRateEffectiveAt *time.Time      `json:"exchange_rate_effective_at"`
+	ChargeAmount            models.Money    `json:"charge_amount"`
+}
+
+// Quote prices a booking purely from server-side data. Packages have no
+// currency of their own: every price, discount and minimum spend is in the
+// base currency, models.DefaultCurrency (THB), and only the final amount is
+// converted into the currency the customer pays in. The package discount
+// is applied first and a discount code is applied to what remains. Each
+// percentage discount is rounded to the satang on its own, so the line
+// items always add up to the final amount.
+func (s *PricingService) Quote(req QuoteRequest) (*BookingQuote, error) {
+	if req.GuestCount < 1 {
+		return nil, ErrInvalidGuestCount
+	}
+
+	var pkg models.TravelPackage
+	if err := s.DB.First(&pkg, "id = ?", req.PackageID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPackageNotFound
+		}
+		return nil, err
+	}
+	if pkg.IsActive != nil && !*pkg.IsActive {
+		return nil, ErrPackageInactive
+	}
+
+	unitPrice := pkg.Price
+	description := fmt.Sprintf("%s x %d", pkg.Title, req.GuestCount)
+	if req.DepartureID != nil {
+		var departure models.PackageDeparture
+		if err := s.DB.First(&departure, "id = ? AND package_id = ?", *req.DepartureID, pkg.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrDepartureNotFound
+			}
+			return nil, err
+		}
+		if departure.Status != models.DepartureStatusOpen {
+			return nil, ErrDepartureNotOpen
+		}
+		if departure.PriceOverride != nil {
+			unitPrice = *departure.PriceOverride
+		}
+		description = fmt.Sprintf("%s (%s) x %d", pkg.Title, departure.DepartureDate, req.GuestCount)
+	}
+
+	quote := &BookingQuote{
+		PackageID:   pkg.ID,
+		DepartureID: req.DepartureID,
+		GuestCount:  req.GuestCount,
+		UnitPrice:   unitPrice,
+	}
+	quote.TotalAmount = unitPrice.Mul(int64(req.GuestCount))
+	quote.LineItems = append(quote.LineItems, QuoteLineItem{
+		Type:        "base",
+		Description: description,
+		Amount:      quote.TotalAmount,
+	})
+
+	remaining := quote.TotalAmount
+	if pkg.DiscountPercentage > 0 {
+		quote.PackageDiscount = remaining.Percent(pkg.DiscountPercentage).Min(remaining)
+		remaining = remaining.Sub(quote.PackageDiscount)
+		quote.LineItems = append(quote.LineItems, QuoteLineItem{
+			Type:        "package_discount",
+			Description: fmt.Sprintf("Package discount %s%%", pkg.DiscountPercentage),
+			Amount:      quote.PackageDiscount.Neg(),
+		})
+	}
+
+	codeDiscount, err := s.applyDiscountCode(req, pkg, remaining, quote)
+	if err != nil {
+		return nil, err
+	}
+	quote.CodeDiscount = codeDiscount
+
+	quote.DiscountAmount = quote.PackageDiscount.Add(quote.CodeDiscount)
+	quote.FinalAmount = quote.TotalAmount.Sub(quote.DiscountAmount)
+
+	rates := &ExchangeRateService{DB: s.DB}
+	charge, err := rates.Convert(quote.FinalAmount, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+	quote.Currency = charge.Currency
+	quote.ExchangeRate = charge.Rate
+	quote.ExchangeRateID = charge.RateID
+	quote.ExchangeRateEffectiveAt = charge.RateEffectiveAt
+	quote.ChargeAmount = charge.Converted
+	return quote, nil
+}
+
+func (s *PricingService) applyDiscountCode(req QuoteRequest, pkg models.TravelPackage, remaining models.Money, quote *BookingQuote) (models.Money, error) {
+	none := models.NewMoney(0, remaining.Currency())
+	supplied := 0
+	if strings.TrimSpace(req.DiscountCode) != "" {
+		supplied++
+	}
+	if req.DiscountCodeID != nil {
+		supplied++
+	}
+	if req.GlobalCodeID != nil {
+		supplied++
+	}
+	if supplied == 0 {
+		return none, nil
+	}
+	if supplied > 1 {
+		return none, ErrMultipleDiscountCodes
+	}
+
+	var code string
+	var discountType string
+	var discountValue models.Decimal
+	var limits bookingCode
+
+	advertiserCode, globalCode, err := s.findDiscountCode(req)
+	if err != nil {
+		return none, err
+	}
+
+	if advertiserCode != nil {
+		if !advertiserCode.IsValidForUse() {
+			return none, ErrDiscountCodeInactive
+		}
+		if advertiserCode.IsUsedUp() {
+			return none, ErrDiscountCodeUsedUp
+		}
+		var count int64
+		err := s.DB.Table("package_advertisers").
+			Where("travel_package_id = ? AND advertiser_id = ?", pkg.ID, advertiserCode.AdvertiserID).
+			Count(&count).Error
+		if err != nil {
+			return none, err
+		}
+		if count == 0 {
+			return none, ErrDiscountCodeNotAllowed
+		}
+		quote.DiscountCodeID = &advertiserCode.ID
+		code, discountType, discountValue = advertiserCode.Code, advertiserCode.DiscountType, advertiserCode.DiscountValue
+		limits = bookingCode{column: "discount_code_id", id: advertiserCode.ID, minSpend: advertiserCode.MinSpend, maxUsesPerCustomer: advertiserCode.MaxUsesPerCustomer}
+	} else {
+		if !globalCode.IsValidForUse() {
+			return none, ErrDiscountCodeInactive
+		}
+		if globalCode.IsUsedUp() {
+			return none, ErrDiscountCodeUsedUp
+		}
+		quote.GlobalCodeID = &globalCode.ID
+		code, discountType, discountValue = globalCode.Code, globalCode.DiscountType, globalCode.DiscountValue
+		limits = bookingCode{column: "global_code_id", id: globalCode.ID, minSpend: globalCode.MinSpend, maxUsesPerCustomer: globalCode.MaxUsesPerCustomer}
+	}
+
+	if limits.minSpend != nil {
+		cmp, err := quote.TotalAmount.CheckedCmp(*limits.minSpend)
+		if err != nil {
+			return none, err
+		}
+		if cmp < 0 {
+			return none, ErrMinimumSpendNotMet
+		}
+	}
+	if limits.maxUsesPerCustomer != nil && req.CustomerID != nil {
+		uses, err := customerRedemptions(s.DB, limits.column, limits.id, *req.CustomerID)
+		if err != nil {
+			return none, err
+		}
+		if uses >= int64(*limits.maxUsesPerCustomer) {
+			return none, ErrDiscountCodeCustomerLimit
+		}
+	}
+
+	discount := discountFor(discountType, discountValue, remaining)
+	quote.LineItems = append(quote.LineItems, QuoteLineItem{
+		Type:        "code_discount",
+		Description: fmt.Sprintf("Discount code %s", code),
+		Amount:      discount.Neg(),
+	})
+	return discount, nil
+}
+
+func (s *PricingService) findDiscountCode(req QuoteRequest) (*models.DiscountCode, *models.GlobalDiscountCode, error) {
+	var advertiserCode models.DiscountCode
+	var globalCode models.GlobalDiscountCode
+
+	switch {
+	case req.DiscountCodeID != nil:
+		if err := s.DB.First(&advertiserCode, "id = ?", *req.DiscountCodeID).Error; err != nil {
+			return nil, nil, notFoundAsInvalidCode(err)
+		}
+		return &advertiserCode, nil, nil
+	case req.GlobalCodeID != nil:
+		if err := s.DB.First(&globalCode, "id = ?", *req.GlobalCodeID).Error; err != nil {
+			return nil, nil, notFoundAsInvalidCode(err)
+		}
+		return nil, &globalCode, nil
+	}
+
+	code := strings.TrimSpace(req.DiscountCode)
+	err := s.DB.Where("code = ?", code).First(&advertiserCode).Error
+	if err == nil {
+		return &advertiserCode, nil, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+	if err := s.DB.Where("code = ?", code).First(&globalCode).Error; err != nil {
+		return nil, nil, notFoundAsInvalidCode(err)
+	}
+	return nil, &globalCode, nil
+}
+
+func notFoundAsInvalidCode(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrDiscountCodeNotFound
+	}
+	return err
+}
+
+// discountFor is what a discount code takes off amount. Percentage codes
+// are rounded half away from zero to the minor unit; fixed codes are in the
+// amount's currency. A code can never make the booking cost less than
+// nothing.
+func discountFor(discountType string, value models.Decimal, amount models.Money) models.Money {
+	discount := models.MoneyFromDecimal(value, amount.Currency())
+	if discountType == "percentage" {
+		discount = amount.Percent(value)
+	}
+	if discount.IsNegative() {
+		return models.NewMoney(0, amount.Currency())
+	}
+	return discount.Min(amount)
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"time"
+	"trip-trader-backend/internal/fakesql"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+)
+
+// pricingFixture prices one package with a package discount and a global
+// code, both replaced between quotes.
+type pricingFixture struct {
+	pricing   *PricingService
+	fake      *fakesql.DB
+	packageID uuid.UUID
+	codeID    uuid.UUID
+}
+
+func newPricingFixture(t *testing.T) *pricingFixture {
+	t.Helper()
+	db, fake := fakesql.New(t)
+	return &pricingFixture{pricing: &PricingService{DB: db}, fake: fake, packageID: uuid.New(), codeID: uuid.New()}
+}
+
+func (p *pricingFixture) quote(price models.Money, packageDiscount models.Decimal, codeType string, codeValue models.Decimal, minSpend interface{}, guests int) (*BookingQuote, error) {
+	p.fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "title", "price", "discount_percentage"},
+		[]driver.Value{p.packageID.String(), "Pricing test", price.String(), packageDiscount.String()},
+	)
+	p.fake.On(`FROM "global_discount_codes"`).Return(
+		[]string{"id", "code", "discount_type", "discount_value", "is_active", "min_spend"},
+		[]driver.Value{p.codeID.String(), "SPLIT", codeType, codeValue.String(), true, minSpend},
+	)
+	return p.pricing.Quote(QuoteRequest{PackageID: p.packageID, GuestCount: guests, GlobalCodeID: &p.codeID})
+}
+
+func TestQuoteSplitsAddUpToTotal(t *testing.T) {
+	p := newPricingFixture(t)
+	property := func(priceMinor uint32, guests uint8, packageDiscount, codeValue uint16, fixed bool) bool {
+		price := models.NewMoney(int64(priceMinor%10000000), models.DefaultCurrency)
+		guestCount := int(guests%20) + 1
+		packageRate := models.Decimal(packageDiscount % 10001)
+		codeType, codeDiscount := "percentage", models.Decimal(codeValue%10001)
+		if fixed {
+			codeType, codeDiscount = "fixed", models.Decimal(codeValue)
+		}
+
+		quote, err := p.quote(price, packageRate, codeType, codeDiscount, nil, guestCount)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+
+		total := price.Mul(int64(guestCount))
+		lineTotal := models.NewMoney(0, models.DefaultCurrency)
+		for _, item := range quote.LineItems {
+			lineTotal = lineTotal.Add(item.Amount)
+		}
+		wantPackageDiscount := total.Percent(packageRate)
+		return quote.TotalAmount == total &&
+			quote.PackageDiscount.Add(quote.CodeDiscount).Add(quote.FinalAmount) == total &&
+			quote.DiscountAmount == quote.PackageDiscount.Add(quote.CodeDiscount) &&
+			lineTotal == quote.FinalAmount &&
+			!quote.FinalAmount.IsNegative() &&
+			quote.PackageDiscount == wantPackageDiscount.Min(total) &&
+			quote.ChargeAmount == quote.FinalAmount
+	}
+	config := &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(24))}
+	if err := quick.Check(property, config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuoteRoundsDiscountsHalfAwayFromZero(t *testing.T) {
+	p := newPricingFixture(t)
+	// 10% of 0.05 baht is half a satang, rounded up; the code then takes
+	// 33.33% of the 0.04 left, 1.3332 satang, rounded down
+	quote, err := p.quote(models.NewMoney(5, models.DefaultCurrency), models.NewDecimal(10), "percentage", 3333, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.PackageDiscount.Minor() != 1 || quote.CodeDiscount.Minor() != 1 || quote.FinalAmount.Minor() != 3 {
+		t.Errorf("discounts %s and %s leave %s, want 0.01, 0.01 and 0.03", quote.PackageDiscount, quote.CodeDiscount, quote.FinalAmount)
+	}
+}
+
+func TestQuoteComparesMinimumSpendInBaseCurrency(t *testing.T) {
+	p := newPricingFixture(t)
+
+	_, err := p.quote(models.NewMoney(50000, models.DefaultCurrency), 0, "percentage", models.NewDecimal(10), "1000.00", 1)
+	if !errors.Is(err, ErrMinimumSpendNotMet) {
+		t.Fatalf("err = %v, want %v", err, ErrMinimumSpendNotMet)
+	}
+	quote, err := p.quote(models.NewMoney(50000, models.DefaultCurrency), 0, "percentage", models.NewDecimal(10), "1000.00", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.CodeDiscount.Minor() != 10000 {
+		t.Errorf("code discount = %s, want 100.00", quote.CodeDiscount)
+	}
+}
+
+func TestQuoteRecordsRateUsedForCharge(t *testing.T) {
+	p := newPricingFixture(t)
+	rateID := uuid.New()
+	effectiveAt := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	p.fake.On(`FROM "exchange_rates"`).Return(
+		[]string{"id", "base_currency", "currency", "rate", "effective_at"},
+		[]driver.Value{rateID.String(), "THB", "USD", "0.0275", effectiveAt},
+	)
+	p.fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "title", "price"},
+		[]driver.Value{p.packageID.String(), "Pricing test", "1999.00"},
+	)
+
+	quote, err := p.pricing.Quote(QuoteRequest{PackageID: p.packageID, GuestCount: 1, Currency: "USD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.FinalAmount.Currency() != models.DefaultCurrency || quote.ChargeAmount.String() != "54.97" {
+		t.Errorf("final %s %s charged as %s, want 1999.00 THB charged as 54.97", quote.FinalAmount, quote.FinalAmount.Currency(), quote.ChargeAmount)
+	}
+	if quote.ExchangeRateID == nil || *quote.ExchangeRateID != rateID ||
+		quote.ExchangeRateEffectiveAt == nil || !quote.ExchangeRateEffectiveAt.Equal(effectiveAt) {
+		t.Errorf("rate %v effective %v, want %s effective %s", quote.ExchangeRateID, quote.ExchangeRateEffectiveAt, rateID, effectiveAt)
+	}
+}
+
+func TestQuoteReportsPackageAdvertiserLookupFailure(t *testing.T) {
+	p := newPricingFixture(t)
+	codeID := uuid.New()
+	p.fake.On(`FROM "travel_packages"`).Return(
+		[]string{"id", "title", "price"},
+		[]driver.Value{p.packageID.String(), "Pricing test", "1000.00"},
+	)
+	p.fake.On(`FROM "discount_codes"`).Return(
+		[]string{"id", "code", "advertiser_id", "discount_type", "discount_value", "is_active"},
+		[]driver.Value{codeID.String(), "ADV", uuid.NewString(), "percentage", "10", true},
+	)
+	lookupFailed := errors.New("connection reset")
+	p.fake.On(`FROM "package_advertisers"`).Fail(lookupFailed)
+
+	_, err := p.pricing.Quote(QuoteRequest{PackageID: p.packageID, GuestCount: 1, DiscountCodeID: &codeID})
+	if err == nil || errors.Is(err, ErrDiscountCodeNotAllowed) {
+		t.Fatalf("err = %v, want the lookup failure rather than %v", err, ErrDiscountCodeNotAllowed)
+	}
+}
+
+func TestQuoteTellsUsedUpCodesFromInactiveOnes(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		active bool
+		uses   int64
+		want   error
+	}{
+		{"used up", true, 5, ErrDiscountCodeUsedUp},
+		{"inactive", false, 0, ErrDiscountCodeInactive},
+		{"inactive and used up", false, 5, ErrDiscountCodeInactive},
+	} {
+		p := newPricingFixture(t)
+		p.fake.On(`FROM "travel_packages"`).Return(
+			[]string{"id", "title", "price"},
+			[]driver.Value{p.packageID.String(), "Pricing test", "1000.00"},
+		)
+		p.fake.On(`FROM "global_discount_codes"`).Return(
+			[]string{"id", "code", "discount_type", "discount_value", "is_active", "max_uses", "current_uses"},
+			[]driver.Value{p.codeID.String(), "LIMITED", "percentage", "10", tc.active, int64(5), tc.uses},
+		)
+
+		_, err := p.pricing.Quote(QuoteRequest{PackageID: p.packageID, GuestCount: 1, GlobalCodeID: &p.codeID})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
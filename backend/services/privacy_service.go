@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const anonymizedName = "Deleted user"
+
+var (
+	ErrAccountNotFound          = errors.New("account not found")
+	ErrAccountHasActiveBookings = errors.New("please cancel or complete your active bookings before deleting your account")
+)
+
+// DataExport is everything held about a user, for PDPA access requests.
+// Sections hold raw rows so new columns are exported without code changes.
+type DataExport struct {
+	GeneratedAt time.Time                           `json:"generated_at"`
+	Profile     models.Profile                      `json:"profile"`
+	Sections    map[string][]map[string]interface{} `json:"sections"`
+}
+
+// exportSection is one table of a data export and how to find the user's
+// rows in it. Omit lists secret columns that are never exported.
+type exportSection struct {
+	Name  string
+	Table string
+	Where string
+	Omit  []string
+}
+
+var exportSections = []exportSection{
+	{Name: "bookings", Table: "bookings", Where: "customer_id = ?"},
+	{Name: "booking_refunds", Table: "booking_refunds", Where: "booking_id IN (SELECT id FROM bookings WHERE customer_id = ?)"},
+	{Name: "booking_events", Table: "booking_events", Where: "booking_id IN (SELECT id FROM bookings WHERE customer_id = ?)"},
+	{Name: "discount_redemptions", Table: "discount_redemptions", Where: "customer_id = ?"},
+	{Name: "notifications", Table: "notifications", Where: "user_id = ?"},
+	{Name: "commissions", Table: "commissions", Where: "advertiser_id = ?"},
+	{Name: "payouts", Table: "payouts", Where: "advertiser_id = ?"},
+	{Name: "discount_codes", Table: "discount_codes", Where: "advertiser_id = ?"},
+	{Name: "sessions", Table: "sessions", Where: "user_id = ?", Omit: []string{"refresh_token_hash"}},
+	{Name: "linked_logins", Table: "profile_identities", Where: "profile_id = ?"},
+	{Name: "api_keys", Table: "api_keys", Where: "advertiser_id = ?", Omit: []string{"key_hash"}},
+}
+
+type PrivacyService struct {
+	DB *gorm.DB
+}
+
+// Export collects the user's profile and every row that belongs to them.
+func (s *PrivacyService) Export(userID uuid.UUID) (*DataExport, error) {
+	export := &DataExport{
+		GeneratedAt: time.Now(),
+		Sections:    make(map[string][]map[string]interface{}),
+	}
+	if err := s.DB.First(&export.Profile, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, err
+	}
+
+	for _, section := range exportSections {
+		rows := []map[string]interface{}{}
+		if err := s.DB.Table(section.Table).Where(section.Where, userID).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			for _, column := range section.Omit {
+				delete(row, column)
+			}
+		}
+		export.Sections[section.Name] = rows
+	}
+	return export, nil
+}
+
+// DeleteAccount anonymises a user under PDPA. Personal details on the
+// profile and on their bookings are wiped and everything that only served
+// the user is removed, while bookings, refunds and commissions stay for
+// accounting.
+func (s *PrivacyService) DeleteAccount(userID uuid.UUID) error {
+	password, err := randomToken()
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var profile models.Profile
+		if err := tx.First(&profile, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrAccountNotFound
+			}
+			return err
+		}
+
+		var active int64
+		if err := tx.Model(&models.Booking{}).
+			Where("customer_id = ? AND status IN ?", userID, []string{models.BookingStatusPending, models.BookingStatusConfirmed}).
+			Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrAccountHasActiveBookings
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.Profile{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"email":             "deleted-" + userID.String() + "@deleted.invalid",
+			"password":          string(hashedPassword),
+			"display_name":      anonymizedName,
+			"phone":             "",
+			"address":           "",
+			"email_verified_at": nil,
+			"anonymized_at":     now,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Booking{}).Where("customer_id = ?", userID).Updates(map[string]interface{}{
+			"contact_name":     anonymizedName,
+			"contact_phone":    "",
+			"contact_email":    "",
+			"special_requests": nil,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Codes stay for the commission history but can no longer be used
+		if err := tx.Model(&models.DiscountCode{}).Where("advertiser_id = ?", userID).
+			Update("is_active", false).Error; err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{
+			&models.Notification{},
+			&models.Session{},
+			&models.AccountToken{},
+			&models.MFARecoveryCode{},
+			&models.MFACredential{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("advertiser_id = ?", userID).Delete(&models.APIKey{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("profile_id = ?", userID).Delete(&models.ProfileIdentity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("link_user_id = ?", userID).Delete(&models.OIDCLoginState{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("scope = ? AND key = ?", models.ThrottleScopeEmail, strings.ToLower(strings.TrimSpace(profile.Email))).
+			Delete(&models.LoginThrottle{}).Error
+	})
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"errors"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDiscountCodeUsedUp        = errors.New("discount code has reached its usage limit")
+	ErrDiscountCodeCustomerLimit = errors.New("you have already used this discount code the maximum number of times")
+	ErrMinimumSpendNotMet        = errors.New("booking does not reach the minimum spend for this discount code")
+)
+
+// RedemptionService keeps discount code usage in step with bookings. DB
+// should be the transaction that creates or changes the booking, so a use is
+// only taken or given back together with it.
+type RedemptionService struct {
+	DB *gorm.DB
+}
+
+// bookingCode is the discount code a booking uses, whichever kind it is.
+type bookingCode struct {
+	model              interface{}
+	column             string
+	id                 uuid.UUID
+	usable             bool
+	minSpend           *models.Money
+	maxUsesPerCustomer *int
+}
+
+// Reserve takes one use of the booking's discount code. The code row is
+// locked and the use taken with a conditional update, so concurrent bookings
+// can never push a code past its limit.
+func (s *RedemptionService) Reserve(booking models.Booking) error {
+	code, err := lockBookingCode(s.DB, booking)
+	if err != nil || code == nil {
+		return err
+	}
+	if !code.usable {
+		return ErrDiscountCodeInactive
+	}
+	if code.minSpend != nil {
+		cmp, err := booking.TotalAmount.CheckedCmp(*code.minSpend)
+		if err != nil {
+			return err
+		}
+		if cmp < 0 {
+			return ErrMinimumSpendNotMet
+		}
+	}
+	if code.maxUsesPerCustomer != nil {
+		uses, err := customerRedemptions(s.DB, code.column, code.id, booking.CustomerID)
+		if err != nil {
+			return err
+		}
+		if uses >= int64(*code.maxUsesPerCustomer) {
+			return ErrDiscountCodeCustomerLimit
+		}
+	}
+
+	result := s.DB.Model(code.model).
+		Where("id = ? AND (max_uses IS NULL OR current_uses < max_uses)", code.id).
+		UpdateColumn("current_uses", gorm.Expr("current_uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrDiscountCodeUsedUp
+	}
+
+	return s.DB.Create(newRedemption(booking, models.RedemptionStatusReserved)).Error
+}
+
+// Redeem turns the booking's reserved use into a redeemed one once the
+// booking is confirmed. Bookings paid after their hold ran out get their
+// use recorded here, past any limit, since the customer has already paid
+// the discounted price.
+func (s *RedemptionService) Redeem(booking models.Booking) error {
+	redemption, err := s.lockActive(booking.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if redemption == nil {
+		code, err := lockBookingCode(s.DB, booking)
+		if errors.Is(err, ErrDiscountCodeNotFound) {
+			return nil
+		}
+		if err != nil || code == nil {
+			return err
+		}
+		if err := s.DB.Model(code.model).Where("id = ?", code.id).
+			UpdateColumn("current_uses", gorm.Expr("current_uses + 1")).Error; err != nil {
+			return err
+		}
+		redeemed := newRedemption(booking, models.RedemptionStatusRedeemed)
+		redeemed.RedeemedAt = &now
+		return s.DB.Create(redeemed).Error
+	}
+
+	if redemption.Status == models.RedemptionStatusRedeemed {
+		return nil
+	}
+	return s.DB.Model(redemption).Updates(map[string]interface{}{
+		"status":      models.RedemptionStatusRedeemed,
+		"redeemed_at": now,
+	}).Error
+}
+
+// Release gives the booking's use of its discount code back, after the
+// booking was cancelled, expired or had its code replaced.
+func (s *RedemptionService) Release(bookingID uuid.UUID) error {
+	redemption, err := s.lockActive(bookingID)
+	if err != nil || redemption == nil {
+		return err
+	}
+
+	var model interface{} = &models.DiscountCode{}
+	codeID := redemption.DiscountCodeID
+	if codeID == nil {
+		model, codeID = &models.GlobalDiscountCode{}, redemption.GlobalCodeID
+	}
+	// The code may have been deleted since
+	if codeID != nil {
+		if err := s.DB.Model(model).Where("id = ?", *codeID).
+			UpdateColumn("current_uses", gorm.Expr("GREATEST(current_uses - 1, 0)")).Error; err != nil {
+			return err
+		}
+	}
+
+	return s.DB.Model(redemption).Updates(map[string]interface{}{
+		"status":      models.RedemptionStatusReleased,
+		"released_at": time.Now(),
+	}).Error
+}
+
+func (s *RedemptionService) lockActive(bookingID uuid.UUID) (*models.DiscountRedemption, error) {
+	var redemption models.DiscountRedemption
+	err := s.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("booking_id = ? AND status <> ?", bookingID, models.RedemptionStatusReleased).
+		First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &redemption, nil
+}
+
+// lockBookingCode locks and loads the booking's discount code. It returns
+// nil when the booking has none.
+func lockBookingCode(tx *gorm.DB, booking models.Booking) (*bookingCode, error) {
+	locked := tx.Clauses(clause.Locking{Strength: "UPDATE"})
+	switch {
+	case booking.DiscountCodeID != nil:
+		var code models.DiscountCode
+		if err := locked.First(&code, "id = ?", *booking.DiscountCodeID).Error; err != nil {
+			return nil, notFoundAsInvalidCode(err)
+		}
+		return &bookingCode{
+			model:              &models.DiscountCode{},
+			column:             "discount_code_id",
+			id:                 code.ID,
+			usable:             code.IsValidForUse(),
+			minSpend:           code.MinSpend,
+			maxUsesPerCustomer: code.MaxUsesPerCustomer,
+		}, nil
+	case booking.GlobalCodeID != nil:
+		var code models.GlobalDiscountCode
+		if err := locked.First(&code, "id = ?", *booking.GlobalCodeID).Error; err != nil {
+			return nil, notFoundAsInvalidCode(err)
+		}
+		return &bookingCode{
+			model:              &models.GlobalDiscountCode{},
+			column:             "global_code_id",
+			id:                 code.ID,
+			usable:             code.IsValidForUse(),
+			minSpend:           code.MinSpend,
+			maxUsesPerCustomer: code.MaxUsesPerCustomer,
+		}, nil
+	}
+	return nil, nil
+}
+
+// customerRedemptions counts the uses a customer holds of a code; column
+// is discount_code_id or global_code_id.
+func customerRedemptions(db *gorm.DB, column string, codeID, customerID uuid.UUID) (int64, error) {
+	var uses int64
+	err := db.Model(&models.DiscountRedemption{}).
+		Where(column+" = ? AND customer_id = ? AND status <> ?", codeID, customerID, models.RedemptionStatusReleased).
+		Count(&uses).Error
+	return uses, err
+}
+
+func newRedemption(booking models.Booking, status string) *models.DiscountRedemption {
+	return &models.DiscountRedemption{
+		BookingID:      booking.ID,
+		CustomerID:     booking.CustomerID,
+		DiscountCodeID: booking.DiscountCodeID,
+		GlobalCodeID:   booking.GlobalCodeID,
+		Status:         status,
+	}
+}
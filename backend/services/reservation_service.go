@@ -0,0 +1,286 @@
+package services
+
+import (
+	"errors"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInsufficientCapacity = errors.New("not enough seats left for this package")
+	ErrDepartureNotFound    = errors.New("departure not found for this package")
+	ErrDepartureNotOpen     = errors.New("departure is not open for booking")
+	ErrDepartureRequired    = errors.New("this package must be booked on a specific departure")
+	ErrDepartureHasBookings = errors.New("cannot move a departure that already has bookings")
+	ErrCapacityBelowHeld    = errors.New("capacity cannot be lower than the seats already booked")
+)
+
+// heldSeatsCondition matches bookings that occupy seats: paid ones, plus
+// pending ones whose payment window has not run out yet.
+const heldSeatsCondition = "(status IN ('confirmed', 'completed') OR (status = 'pending' AND (expires_at IS NULL OR expires_at > ?)))"
+
+// ReservationService is the only place that decides whether a package has
+// room for a booking. Every check runs with the package (or departure) row
+// locked, so two concurrent bookings can never both take the last seats.
+//
+// Bookings on a departure count against that departure's capacity; bookings
+// without one count against the package's MaxGuests as before.
+type ReservationService struct {
+	DB *gorm.DB
+}
+
+// Reserve locks the package, checks capacity for the given guests and, if
+// there is room, runs create inside the same transaction to insert the
+// pending booking that holds those seats.
+func (s *ReservationService) Reserve(packageID uuid.UUID, departureID *uuid.UUID, guestCount int, create func(tx *gorm.DB) error) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		pkg, err := lockPackage(tx, packageID)
+		if err != nil {
+			return err
+		}
+
+		if departureID == nil {
+			var departures int64
+			if err := tx.Model(&models.PackageDeparture{}).
+				Where("package_id = ? AND status = ?", packageID, models.DepartureStatusOpen).
+				Count(&departures).Error; err != nil {
+				return err
+			}
+			if departures > 0 {
+				return ErrDepartureRequired
+			}
+
+			held, err := heldSeats(tx, packageID, nil)
+			if err != nil {
+				return err
+			}
+			if pkg.MaxGuests > 0 && held+guestCount > pkg.MaxGuests {
+				return ErrInsufficientCapacity
+			}
+		} else {
+			departure, err := lockDeparture(tx, packageID, *departureID)
+			if err != nil {
+				return err
+			}
+			if departure.Status != models.DepartureStatusOpen {
+				return ErrDepartureNotOpen
+			}
+
+			held, err := heldSeats(tx, packageID, departureID)
+			if err != nil {
+				return err
+			}
+			if held+guestCount > departure.Capacity {
+				return ErrInsufficientCapacity
+			}
+		}
+
+		if err := create(tx); err != nil {
+			return err
+		}
+
+		return syncHeldSeats(tx, packageID, departureID)
+	})
+}
+
+// Release gives seats back after a booking was cancelled, expired or
+// deleted. tx may be a transaction already in progress.
+func (s *ReservationService) Release(tx *gorm.DB, packageID uuid.UUID, departureID *uuid.UUID) error {
+	if tx == nil {
+		tx = s.DB
+	}
+	return tx.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockPackage(tx, packageID); err != nil {
+			return err
+		}
+		if departureID != nil {
+			if _, err := lockDeparture(tx, packageID, *departureID); err != nil {
+				return err
+			}
+		}
+		return syncHeldSeats(tx, packageID, departureID)
+	})
+}
+
+// UpdateDeparture locks a departure as Reserve does, lets change edit it and
+// saves it, so no booking can take a seat between counting the seats held
+// and lowering the capacity. Departures with seats held keep their date.
+// It returns the saved departure and the seats held on it, also alongside
+// ErrCapacityBelowHeld.
+func (s *ReservationService) UpdateDeparture(packageID, departureID uuid.UUID, change func(*models.PackageDeparture) error) (*models.PackageDeparture, int, error) {
+	var departure *models.PackageDeparture
+	held := 0
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		departure, err = lockDeparture(tx, packageID, departureID)
+		if err != nil {
+			return err
+		}
+		held, err = heldSeats(tx, packageID, &departureID)
+		if err != nil {
+			return err
+		}
+
+		date := departure.DepartureDate
+		if err := change(departure); err != nil {
+			return err
+		}
+		if departure.DepartureDate != date && held > 0 {
+			return ErrDepartureHasBookings
+		}
+		if departure.Capacity < held {
+			return ErrCapacityBelowHeld
+		}
+
+		err = tx.Model(&models.PackageDeparture{}).Where("id = ?", departureID).Updates(map[string]interface{}{
+			"departure_date": departure.DepartureDate,
+			"capacity":       departure.Capacity,
+			"price_override": departure.PriceOverride,
+			"status":         departure.Status,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(departure, "id = ?", departureID).Error
+	})
+	if err != nil {
+		return nil, held, err
+	}
+	return departure, held, nil
+}
+
+// ReleaseBooking is Release for the seats of a single booking.
+func (s *ReservationService) ReleaseBooking(tx *gorm.DB, booking models.Booking) error {
+	return s.Release(tx, booking.PackageID, booking.DepartureID)
+}
+
+// HeldSeats reports how many seats are currently taken on a departure, or on
+// the package itself when departureID is nil.
+func (s *ReservationService) HeldSeats(packageID uuid.UUID, departureID *uuid.UUID) (int, error) {
+	return heldSeats(s.DB, packageID, departureID)
+}
+
+// AvailableSeats reports how many guests can still book, counting seats the
+// same way Reserve does. With a departure it is that departure's free seats,
+// zero unless it is open. Without one, a package sold by departure reports
+// the free seats across its open departures, and any other package its
+// MaxGuests less the seats held; -1 means there is no limit.
+func (s *ReservationService) AvailableSeats(packageID uuid.UUID, departureID *uuid.UUID) (int, error) {
+	if departureID != nil {
+		var departure models.PackageDeparture
+		if err := s.DB.First(&departure, "id = ? AND package_id = ?", *departureID, packageID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, ErrDepartureNotFound
+			}
+			return 0, err
+		}
+		if departure.Status != models.DepartureStatusOpen {
+			return 0, nil
+		}
+		held, err := heldSeats(s.DB, packageID, departureID)
+		if err != nil {
+			return 0, err
+		}
+		return freeSeats(departure.Capacity, held), nil
+	}
+
+	var departures []models.PackageDeparture
+	if err := s.DB.Where("package_id = ? AND status = ?", packageID, models.DepartureStatusOpen).
+		Find(&departures).Error; err != nil {
+		return 0, err
+	}
+	if len(departures) > 0 {
+		available := 0
+		for _, departure := range departures {
+			held, err := heldSeats(s.DB, packageID, &departure.ID)
+			if err != nil {
+				return 0, err
+			}
+			available += freeSeats(departure.Capacity, held)
+		}
+		return available, nil
+	}
+
+	var pkg models.TravelPackage
+	if err := s.DB.First(&pkg, "id = ?", packageID).Error; err != nil {
+		return 0, err
+	}
+	if pkg.MaxGuests <= 0 {
+		return -1, nil
+	}
+	held, err := heldSeats(s.DB, packageID, nil)
+	if err != nil {
+		return 0, err
+	}
+	return freeSeats(pkg.MaxGuests, held), nil
+}
+
+func freeSeats(capacity, held int) int {
+	if held >= capacity {
+		return 0
+	}
+	return capacity - held
+}
+
+func lockPackage(tx *gorm.DB, packageID uuid.UUID) (*models.TravelPackage, error) {
+	var pkg models.TravelPackage
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "max_guests", "current_bookings").
+		First(&pkg, "id = ?", packageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPackageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+func lockDeparture(tx *gorm.DB, packageID, departureID uuid.UUID) (*models.PackageDeparture, error) {
+	var departure models.PackageDeparture
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&departure, "id = ? AND package_id = ?", departureID, packageID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDepartureNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &departure, nil
+}
+
+func heldSeats(tx *gorm.DB, packageID uuid.UUID, departureID *uuid.UUID) (int, error) {
+	var held int64
+	query := tx.Model(&models.Booking{}).
+		Select("COALESCE(SUM(guest_count), 0)").
+		Where("package_id = ?", packageID).
+		Where(heldSeatsCondition, time.Now())
+	if departureID != nil {
+		query = query.Where("departure_id = ?", *departureID)
+	} else {
+		query = query.Where("departure_id IS NULL")
+	}
+	err := query.Scan(&held).Error
+	return int(held), err
+}
+
+// syncHeldSeats keeps the denormalised current_bookings / booked_seats
+// columns equal to the seats actually held, instead of adjusting by deltas.
+func syncHeldSeats(tx *gorm.DB, packageID uuid.UUID, departureID *uuid.UUID) error {
+	held, err := heldSeats(tx, packageID, departureID)
+	if err != nil {
+		return err
+	}
+	if departureID != nil {
+		return tx.Model(&models.PackageDeparture{}).
+			Where("id = ?", *departureID).
+			Update("booked_seats", held).Error
+	}
+	return tx.Model(&models.TravelPackage{}).
+		Where("id = ?", packageID).
+		Update("current_bookings", held).Error
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrMissingServerSecrets = errors.New("server secrets not configured")
+
+// serverSecretKeys are the environment variables holding the secrets this
+// server keeps to itself. Each protects something different, so none of
+// them falls back to another.
+var serverSecretKeys = []string{"MFA_ENCRYPTION_KEY", "ACCOUNT_TOKEN_SECRET"}
+
+var (
+	serverSecretsOnce sync.Once
+	serverSecrets     map[string][]byte
+	serverSecretsErr  error
+)
+
+// InitServerSecrets loads MFA_ENCRYPTION_KEY, which encrypts MFA secrets,
+// and ACCOUNT_TOKEN_SECRET, which signs emailed account links. Without them,
+// requireSecrets makes this an error; otherwise throwaway secrets are
+// generated, so MFA enrolments and emailed links do not survive a restart.
+func InitServerSecrets(requireSecrets bool) error {
+	serverSecretsOnce.Do(func() {
+		secrets := make(map[string][]byte)
+		var missing []string
+		for _, key := range serverSecretKeys {
+			if secret := os.Getenv(key); secret != "" {
+				secrets[key] = []byte(secret)
+			} else {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) > 0 && requireSecrets {
+			serverSecretsErr = fmt.Errorf("%w, set %s", ErrMissingServerSecrets, strings.Join(missing, " and "))
+			return
+		}
+		for _, key := range missing {
+			log.Printf("%s not set, using a temporary development secret", key)
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				serverSecretsErr = err
+				return
+			}
+			secrets[key] = secret
+		}
+		serverSecrets = secrets
+	})
+	return serverSecretsErr
+}
+
+// serverSecret returns the secret loaded by InitServerSecrets, loading it in
+// development mode if that has not happened yet.
+func serverSecret(key string) ([]byte, error) {
+	if err := InitServerSecrets(false); err != nil {
+		return nil, err
+	}
+	return serverSecrets[key], nil
+}
+
+// encryptSecret seals plaintext with AES-256-GCM under a key derived from
+// MFA_ENCRYPTION_KEY.
+func encryptSecret(plaintext string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(ciphertext string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted secret is too short")
+	}
+	nonce, data := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	secret, err := serverSecret("MFA_ENCRYPTION_KEY")
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
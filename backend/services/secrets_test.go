@@ -0,0 +1,62 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// resetServerSecrets makes the next serverSecret call load them again.
+func resetServerSecrets(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		serverSecretsOnce = sync.Once{}
+		serverSecrets, serverSecretsErr = nil, nil
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestInitServerSecretsRequiresSecretsInRelease(t *testing.T) {
+	resetServerSecrets(t)
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("MFA_ENCRYPTION_KEY", "mfa-key")
+	t.Setenv("ACCOUNT_TOKEN_SECRET", "")
+
+	err := InitServerSecrets(true)
+	if !errors.Is(err, ErrMissingServerSecrets) || !strings.Contains(err.Error(), "ACCOUNT_TOKEN_SECRET") {
+		t.Fatalf("err = %v, want ACCOUNT_TOKEN_SECRET reported missing", err)
+	}
+	if _, err := serverSecret("MFA_ENCRYPTION_KEY"); err == nil {
+		t.Error("serverSecret succeeded after the secrets failed to load")
+	}
+}
+
+func TestServerSecretsDoNotFallBack(t *testing.T) {
+	resetServerSecrets(t)
+	t.Setenv("JWT_SECRET", "jwt-secret")
+	t.Setenv("MFA_ENCRYPTION_KEY", "")
+	t.Setenv("ACCOUNT_TOKEN_SECRET", "")
+
+	if err := InitServerSecrets(false); err != nil {
+		t.Fatal(err)
+	}
+	mfaKey, _ := serverSecret("MFA_ENCRYPTION_KEY")
+	tokenSecret, _ := serverSecret("ACCOUNT_TOKEN_SECRET")
+	if len(mfaKey) < 32 || len(tokenSecret) < 32 {
+		t.Fatalf("development secrets are %d and %d bytes, want at least 32", len(mfaKey), len(tokenSecret))
+	}
+	if bytes.Equal(mfaKey, tokenSecret) || bytes.Equal(mfaKey, []byte("jwt-secret")) || bytes.Equal(tokenSecret, []byte("jwt-secret")) {
+		t.Error("server secrets are shared with each other or with JWT_SECRET")
+	}
+
+	sealed, err := encryptSecret("JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := decryptSecret(sealed); err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("decryptSecret = %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+	"trip-trader-backend/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultAccessTokenTTL  = 15 * time.Minute
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, all sessions of this login have been revoked")
+)
+
+// AccessTokenTTL is the lifetime of a JWT access token, set with
+// ACCESS_TOKEN_TTL.
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", defaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is the lifetime of a refresh token, set with
+// REFRESH_TOKEN_TTL.
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+type SessionService struct {
+	DB *gorm.DB
+}
+
+// Create starts a new session family for a login and returns its first
+// refresh token. The plain token is never stored.
+func (s *SessionService) Create(userID uuid.UUID, userAgent, ipAddress string, mfaVerified bool) (*models.Session, string, error) {
+	return s.issue(s.DB, uuid.New(), userID, userAgent, ipAddress, mfaVerified)
+}
+
+// Rotate exchanges a refresh token for a new one in the same family.
+// Presenting a token that was already rotated means it leaked, so the whole
+// family is revoked and ErrRefreshTokenReused returned.
+func (s *SessionService) Rotate(refreshToken, userAgent, ipAddress string) (*models.Session, string, error) {
+	var next *models.Session
+	var nextToken string
+	var reused bool
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Session
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("refresh_token_hash = ?", hashToken(refreshToken)).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if current.RotatedAt != nil {
+			reused = true
+			return revokeSessions(tx.Where("family_id = ?", current.FamilyID), "refresh token reused")
+		}
+		if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		now := time.Now()
+		if err := tx.Model(&models.Session{}).Where("id = ?", current.ID).Update("rotated_at", now).Error; err != nil {
+			return err
+		}
+
+		next, nextToken, err = s.issue(tx, current.FamilyID, current.UserID, userAgent, ipAddress, current.MFAVerified)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		return nil, "", ErrRefreshTokenReused
+	}
+	return next, nextToken, nil
+}
+
+// IsActive reports whether a session family can still be used.
+func (s *SessionService) IsActive(familyID uuid.UUID) (bool, error) {
+	var count int64
+	err := s.DB.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL AND rotated_at IS NULL AND expires_at > ?", familyID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// MarkMFAVerified records that a login passed a second factor, so tokens
+// refreshed from it keep that status.
+func (s *SessionService) MarkMFAVerified(familyID uuid.UUID) error {
+	return s.DB.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("mfa_verified", true).Error
+}
+
+// Revoke ends one login, e.g. on logout.
+func (s *SessionService) Revoke(familyID uuid.UUID, reason string) error {
+	return revokeSessions(s.DB.Where("family_id = ?", familyID), reason)
+}
+
+// RevokeAllForUser ends every login of a user.
+func (s *SessionService) RevokeAllForUser(userID uuid.UUID, reason string) error {
+	return revokeSessions(s.DB.Where("user_id = ?", userID), reason)
+}
+
+func (s *SessionService) issue(db *gorm.DB, familyID, userID uuid.UUID, userAgent, ipAddress string, mfaVerified bool) (*models.Session, string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session := &models.Session{
+		FamilyID:         familyID,
+		UserID:           userID,
+		RefreshTokenHash: hashToken(token),
+		UserAgent:        userAgent,
+		IPAddress:        ipAddress,
+		ExpiresAt:        time.Now().Add(RefreshTokenTTL()),
+		MFAVerified:      mfaVerified,
+	}
+	if err := db.Create(session).Error; err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+func revokeSessions(query *gorm.DB, reason string) error {
+	return query.Model(&models.Session{}).
+		Where("revoked_at IS NULL").
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		}).Error
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
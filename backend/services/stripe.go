@@ -0,0 +1,16 @@
+package services
+
+import "os"
+
+// Placeholder key shipped in old .env examples, treated the same as unset
+const placeholderStripeKey = "sk_test_51234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef12"
+
+// StripeSecretKey returns the configured Stripe key, or false when payments
+// should run in mock mode.
+func StripeSecretKey() (string, bool) {
+	stripeKey := os.Getenv("STRIPE_SECRET_KEY")
+	if stripeKey == "" || stripeKey == placeholderStripeKey {
+		return "", false
+	}
+	return stripeKey, true
+}
@@ -21,10 +21,48 @@ export const getUserRole = (): string | null => {
   return localStorage.getItem("userRole") || sessionStorage.getItem("userRole");
 };
 
+const getRefreshToken = (): string | null => {
+  return (
+    localStorage.getItem("refreshToken") ||
+    sessionStorage.getItem("refreshToken")
+  );
+};
+
+// Refresh tokens are single use, so concurrent 401s must share one refresh
+let refreshInFlight: Promise<string | null> | null = null;
+
+const refreshSession = (): Promise<string | null> => {
+  if (!refreshInFlight) {
+    refreshInFlight = (async () => {
+      const refreshToken = getRefreshToken();
+      if (!refreshToken) return null;
+
+      const response = await fetch(`${API_BASE_URL}/api/token/refresh`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ refresh_token: refreshToken }),
+      });
+      if (!response.ok) return null;
+
+      const data = await response.json();
+      const storage = localStorage.getItem("refreshToken")
+        ? localStorage
+        : sessionStorage;
+      storage.setItem("authToken", data.access_token);
+      storage.setItem("refreshToken", data.refresh_token);
+      return data.access_token as string;
+    })().finally(() => {
+      refreshInFlight = null;
+    });
+  }
+  return refreshInFlight;
+};
+
 export const apiRequest = async (
   endpoint: string,
-  options: RequestInit = {}
-) => {
+  options: RequestInit = {},
+  retried = false
+): Promise<any> => {
   const url = `${API_BASE_URL}${endpoint}`;
 
   const token =
@@ -46,8 +84,13 @@ export const apiRequest = async (
   console.log("API response:", response.status, response.statusText);
 
   if (response.status === 401) {
+    if (!retried && token && (await refreshSession())) {
+      return apiRequest(endpoint, options, true);
+    }
     console.warn("Token expired, clearing auth data");
     localStorage.removeItem("authToken");
+    localStorage.removeItem("refreshToken");
+    sessionStorage.removeItem("refreshToken");
     localStorage.removeItem("userRole");
     sessionStorage.removeItem("userRole");
     throw new Error("Session expired. Please login again.");
@@ -83,6 +126,12 @@ export const profileAPI = {
       method: "PUT",
       body: JSON.stringify(profileData),
     }),
+  exportMyData: () => apiRequest("/api/me/export?format=json"),
+  deleteMyAccount: (password: string) =>
+    apiRequest("/api/me", {
+      method: "DELETE",
+      body: JSON.stringify({ password }),
+    }),
 };
 
 export const userAPI = {
@@ -92,6 +141,111 @@ export const userAPI = {
       method: "PUT",
       body: JSON.stringify({ role }),
     }),
+  unlock: (userId: string) =>
+    apiRequest(`/api/user/${userId}/lockout`, {
+      method: "DELETE",
+    }),
+  impersonate: (userId: string) =>
+    apiRequest(`/api/manager/impersonate/${userId}`, {
+      method: "POST",
+    }),
+};
+
+export const commissionRuleAPI = {
+  getAll: () => apiRequest("/api/manager/commission-rules"),
+  create: (data: any) =>
+    apiRequest("/api/manager/commission-rules", {
+      method: "POST",
+      body: JSON.stringify(data),
+    }),
+  update: (id: string, data: any) =>
+    apiRequest(`/api/manager/commission-rules/${id}`, {
+      method: "PUT",
+      body: JSON.stringify(data),
+    }),
+  delete: (id: string) =>
+    apiRequest(`/api/manager/commission-rules/${id}`, {
+      method: "DELETE",
+    }),
+};
+
+export const payoutAPI = {
+  getBatches: () => apiRequest("/api/manager/payout-batches"),
+  getBatch: (id: string) => apiRequest(`/api/manager/payout-batches/${id}`),
+  createBatch: (period: string) =>
+    apiRequest("/api/manager/payout-batches", {
+      method: "POST",
+      body: JSON.stringify({ period }),
+    }),
+  approveBatch: (id: string) =>
+    apiRequest(`/api/manager/payout-batches/${id}/approve`, {
+      method: "POST",
+    }),
+  markPaid: (batchId: string, payoutId: string, paymentReference: string) =>
+    apiRequest(`/api/manager/payout-batches/${batchId}/payouts/${payoutId}/paid`, {
+      method: "POST",
+      body: JSON.stringify({ payment_reference: paymentReference }),
+    }),
+  cancelBatch: (id: string) =>
+    apiRequest(`/api/manager/payout-batches/${id}`, {
+      method: "DELETE",
+    }),
+  getByAdvertiser: (advertiserId: string) =>
+    apiRequest(`/api/advertiser/${advertiserId}/payouts`),
+  getStatement: (advertiserId: string, payoutId: string) =>
+    apiRequest(`/api/advertiser/${advertiserId}/payouts/${payoutId}`),
+};
+
+export const apiKeyAPI = {
+  getAll: () => apiRequest("/api/advertiser/api-keys"),
+  create: (data: { name: string; scopes: string[]; expires_at?: string }) =>
+    apiRequest("/api/advertiser/api-keys", {
+      method: "POST",
+      body: JSON.stringify(data),
+    }),
+  revoke: (id: string) =>
+    apiRequest(`/api/advertiser/api-keys/${id}`, {
+      method: "DELETE",
+    }),
+};
+
+export const ledgerAPI = {
+  getEntries: (params: Record<string, string> = {}) =>
+    apiRequest(`/api/manager/ledger/entries?${new URLSearchParams(params)}`),
+  getTrialBalance: (asOf?: string) =>
+    apiRequest(
+      `/api/manager/ledger/trial-balance${asOf ? `?as_of=${asOf}` : ""}`
+    ),
+  getIncomeStatement: (params: { from?: string; to?: string } = {}) =>
+    apiRequest(
+      `/api/manager/ledger/income-statement?${new URLSearchParams(params)}`
+    ),
+};
+
+export const exchangeRateAPI = {
+  getCurrent: () => apiRequest("/api/exchange-rates"),
+  getHistory: (currency: string) =>
+    apiRequest(
+      `/api/manager/exchange-rates?${new URLSearchParams({ currency })}`
+    ),
+  addRates: (
+    rates: { currency: string; rate: number; effective_at?: string }[]
+  ) =>
+    apiRequest("/api/manager/exchange-rates", {
+      method: "POST",
+      body: JSON.stringify({ rates }),
+    }),
+  // csv is the file's text: currency,rate[,effective_at] per line
+  importCSV: (csv: string) =>
+    apiRequest("/api/manager/exchange-rates/import", {
+      method: "POST",
+      body: csv,
+    }),
+};
+
+export const auditLogAPI = {
+  list: (params: Record<string, string> = {}) =>
+    apiRequest(`/api/manager/audit-logs?${new URLSearchParams(params)}`),
 };
 
 export const authAPI = {
@@ -118,12 +272,79 @@ export const authAPI = {
       },
     }),
   refreshToken: (refreshToken: string) =>
-    apiRequest("/api/refresh-token", {
+    apiRequest("/api/token/refresh", {
+      method: "POST",
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    }),
+  logoutAll: () =>
+    apiRequest("/api/logout-all", {
       method: "POST",
-      body: JSON.stringify({ refreshToken }),
+    }),
+  forgotPassword: (email: string) =>
+    apiRequest("/api/password/forgot", {
+      method: "POST",
+      body: JSON.stringify({ email }),
+    }),
+  resetPassword: (token: string, password: string) =>
+    apiRequest("/api/password/reset", {
+      method: "POST",
+      body: JSON.stringify({ token, password }),
+    }),
+  verifyEmail: (token: string) =>
+    apiRequest("/api/email/verify", {
+      method: "POST",
+      body: JSON.stringify({ token }),
+    }),
+  resendVerificationEmail: () =>
+    apiRequest("/api/email/verify/resend", {
+      method: "POST",
+    }),
+  loginMfa: (mfaToken: string, code: string) =>
+    apiRequest("/api/login/mfa", {
+      method: "POST",
+      body: JSON.stringify({ mfa_token: mfaToken, code }),
+    }),
+  getMfaStatus: () => apiRequest("/api/mfa"),
+  beginMfaEnrollment: () =>
+    apiRequest("/api/mfa/enroll", {
+      method: "POST",
+    }),
+  confirmMfaEnrollment: (code: string) =>
+    apiRequest("/api/mfa/enroll/confirm", {
+      method: "POST",
+      body: JSON.stringify({ code }),
+    }),
+  disableMfa: (code: string) =>
+    apiRequest("/api/mfa/disable", {
+      method: "POST",
+      body: JSON.stringify({ code }),
+    }),
+  regenerateRecoveryCodes: (code: string) =>
+    apiRequest("/api/mfa/recovery-codes", {
+      method: "POST",
+      body: JSON.stringify({ code }),
+    }),
+  resetUserMfa: (userId: string) =>
+    apiRequest(`/api/user/${userId}/mfa`, {
+      method: "DELETE",
     }),
 };
 
+export const oidcAPI = {
+  getProviders: () => apiRequest("/api/auth/oidc/providers"),
+  // The browser navigates here; the backend redirects on to the provider
+  loginUrl: (provider: string, redirect = "/") =>
+    `${API_BASE_URL}/api/auth/oidc/${provider}/login?redirect=${encodeURIComponent(redirect)}`,
+  link: (provider: string, redirect = "/profile") =>
+    apiRequest(
+      `/api/auth/oidc/${provider}/link?redirect=${encodeURIComponent(redirect)}`,
+      {
+        method: "POST",
+      }
+    ),
+  getIdentities: () => apiRequest("/api/me/identities"),
+};
+
 export const bookingAPI = {
   createPayment: (data: any) =>
     apiRequest("/api/booking/payment", {
@@ -165,6 +386,9 @@ export const discountCodeAPI = {
     advertiser_id: string;
     discount_value: number;
     discount_type: "percentage" | "fixed";
+    max_uses?: number;
+    max_uses_per_customer?: number;
+    min_spend?: number;
   }) =>
     apiRequest("/api/discount-codes/advertiser", {
       method: "POST",
@@ -175,6 +399,8 @@ export const discountCodeAPI = {
     discount_value: number;
     discount_type: "percentage" | "fixed";
     max_uses?: number;
+    max_uses_per_customer?: number;
+    min_spend?: number;
     expires_at?: string;
   }) =>
     apiRequest("/api/global-discount-codes", {
@@ -212,6 +438,14 @@ export const discountCodeAPI = {
   getCommissionsByAdvertiser: (advertiserId: string) =>
     apiRequest(`/api/advertiser/${advertiserId}/commissions`),
 
+  previewCommission: (advertiserId: string, packageId: string, amount?: number) =>
+    apiRequest(
+      `/api/advertiser/${advertiserId}/commissions/preview?${new URLSearchParams({
+        package_id: packageId,
+        ...(amount !== undefined && { amount: String(amount) }),
+      })}`
+    ),
+
   validate: (code: string, packageId: string) =>
     apiRequest("/api/discount-codes/validate", {
       method: "POST",
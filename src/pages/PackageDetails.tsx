@@ -270,26 +270,24 @@ const PackageDetails = () => {
 
     setBookingLoading(true);
     try {
-      let totalAmount =
+      // Must match the backend pricing: package discount first, then the code
+      const totalAmount = packageData.price * guestCount;
+      let finalAmount =
         (packageData.finalPrice || packageData.price) * guestCount;
-      let discountAmount = 0;
-
-      if (packageData.discount_percentage) {
-        discountAmount += (totalAmount * packageData.discount_percentage) / 100;
-      }
 
       if (appliedDiscount) {
         if (appliedDiscount.discount_type === "percentage") {
-          discountAmount +=
-            (totalAmount * appliedDiscount.discount_value) / 100;
+          finalAmount =
+            finalAmount * (1 - appliedDiscount.discount_value / 100);
         } else {
           // fixed
-          discountAmount += appliedDiscount.discount_value;
+          finalAmount = Math.max(
+            finalAmount - appliedDiscount.discount_value,
+            0
+          );
         }
       }
 
-      const finalAmount = totalAmount - discountAmount;
-
       const bookingData: any = {
         packageId: packageData.id,
         guestCount,
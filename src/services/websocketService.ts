@@ -1,3 +1,5 @@
+import { getAuthToken } from "@/lib/api";
+
 interface NotificationMessage {
   id: string;
   type: "notification" | "system" | "existing_notification" | "unread_count";
@@ -46,7 +48,13 @@ class WebSocketService {
 
   connect(userID?: string): void {
     try {
-      const wsUrl = userID ? `${this.url}?userID=${userID}` : this.url;
+      // Browsers cannot send an Authorization header on the handshake
+      const token = getAuthToken();
+      const params = new URLSearchParams();
+      if (userID) params.set("userID", userID);
+      if (token) params.set("token", token);
+      const query = params.toString();
+      const wsUrl = query ? `${this.url}?${query}` : this.url;
 
       this.socket = new WebSocket(wsUrl);
 